@@ -0,0 +1,932 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The functions in this file are shared by the ssh commands and the web api
+// so both go through the same acl checks and event logging.
+
+// AclError is returned when the requester is not allowed to perform an
+// action.
+type AclError struct {
+	Msg string
+}
+
+func (e *AclError) Error() string {
+	return e.Msg
+}
+
+func errAcl(msg string) error {
+	return &AclError{Msg: msg}
+}
+
+// FindOrCreateRepo resolves a repo namespace for a new patch request.  The
+// repo is created when the requester is allowed to.
+func FindOrCreateRepo(be *Backend, pr GitPatchRequest, user *User, rawRepoNs string) (*Repo, error) {
+	repoUsername, repoName := be.SplitRepoNs(rawRepoNs)
+	var repo *Repo
+	if repoUsername == "" {
+		if be.Cfg.CreateRepo == "admin" {
+			// single tenant default user to admin
+			repo, _ = pr.GetRepoByName(nil, repoName)
+		} else {
+			// multi tenant default user to contributor
+			repo, _ = pr.GetRepoByName(user, repoName)
+		}
+	} else {
+		repoUser, err := pr.GetUserByName(repoUsername)
+		if err != nil {
+			return nil, err
+		}
+		repo, _ = pr.GetRepoByName(repoUser, repoName)
+	}
+
+	err := be.CanCreateRepo(repo, user)
+	if err != nil {
+		return nil, errAcl(err.Error())
+	}
+
+	if repo == nil {
+		return pr.CreateRepo(user, repoName)
+	}
+	return repo, nil
+}
+
+// CreatePatchRequest submits a new patch request, drafts stay out of the
+// open filters until they are marked ready.
+func CreatePatchRequest(be *Backend, pr GitPatchRequest, user *User, rawRepoNs string, draft bool, patchset io.Reader) (*PatchRequest, error) {
+	repo, err := FindOrCreateRepo(be, pr, user, rawRepoNs)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRepoArchived(repo)
+	if err != nil {
+		return nil, err
+	}
+	if draft {
+		return pr.SubmitDraftPatchRequest(repo.ID, user.ID, patchset)
+	}
+	return pr.SubmitPatchRequest(repo.ID, user.ID, patchset)
+}
+
+// errDraft is returned when accepting a draft patch request.
+func errDraft(prID int64) error {
+	return fmt.Errorf("PR is a draft, mark it ready for review with `pr ready %d` first", prID)
+}
+
+// MarkPatchRequestReady moves a draft patch request to open.
+func MarkPatchRequestReady(be *Backend, pr GitPatchRequest, user *User, prID int64) (*PatchRequest, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	if !acl.CanModify {
+		return nil, errAcl("you are not authorized to change PR status")
+	}
+	if prq.Status != StatusDraft {
+		return nil, fmt.Errorf("PR is not a draft: %s", prq.Status)
+	}
+
+	err = pr.MarkPatchRequestReady(prID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return prq, nil
+}
+
+// AddPatchset submits a patchset to a patch request.  Accepting or closing
+// the patch request along with the patchset requires the same permissions
+// as changing its status.
+func AddPatchset(be *Backend, pr GitPatchRequest, user *User, prID int64, op PatchsetOp, comment string, patchset io.Reader) ([]*Patch, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	if !acl.CanAddPatchset {
+		return nil, errAcl("you are not authorized to add patchsets to pr")
+	}
+	err = checkRepoArchived(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// new patchsets reopen a patch request but keep drafts as drafts
+	nextStatus := StatusOpen
+	if prq.Status == StatusDraft {
+		nextStatus = StatusDraft
+	}
+	switch op {
+	case OpReview:
+		if !acl.CanReview {
+			return nil, errAcl("you are not authorized to submit a review to pr")
+		}
+	case OpAccept:
+		if !acl.CanReview {
+			return nil, errAcl("you are not authorized to accept a PR")
+		}
+		if prq.Status == StatusDraft {
+			return nil, errDraft(prID)
+		}
+		err = checkRequiredApprovals(pr, repo, prID)
+		if err != nil {
+			return nil, err
+		}
+		err = checkDependencies(pr, prID)
+		if err != nil {
+			return nil, err
+		}
+		nextStatus = StatusAccepted
+	case OpClose:
+		if !acl.CanModify {
+			return nil, errAcl("you are not authorized to change PR status")
+		}
+		nextStatus = StatusClosed
+	}
+
+	patches, err := pr.SubmitPatchset(prID, user.ID, op, patchset)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(patches) == 0 {
+		return patches, nil
+	}
+
+	if prq.Status != nextStatus {
+		err = pr.UpdatePatchRequestStatus(prID, user.ID, nextStatus, comment)
+		if err != nil {
+			return patches, err
+		}
+	}
+
+	return patches, nil
+}
+
+// ChangePatchRequestStatus accepts, closes, or reopens a patch request.
+func ChangePatchRequestStatus(be *Backend, pr GitPatchRequest, user *User, prID int64, status Status, comment string) (*PatchRequest, error) {
+	return changePatchRequestStatus(be, pr, user, prID, status, comment, false)
+}
+
+// ForceAcceptPatchRequest accepts a patch request even though the patch
+// requests it depends on are still open, only admins can.
+func ForceAcceptPatchRequest(be *Backend, pr GitPatchRequest, user *User, prID int64, comment string) (*PatchRequest, error) {
+	pk, err := be.PubkeyToPublicKey(user.Pubkey)
+	if err != nil || !be.IsAdmin(pk) {
+		return nil, errAcl("only admins can accept a PR with open dependencies")
+	}
+	return changePatchRequestStatus(be, pr, user, prID, StatusAccepted, comment, true)
+}
+
+func changePatchRequestStatus(be *Backend, pr GitPatchRequest, user *User, prID int64, status Status, comment string, force bool) (*PatchRequest, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	switch status {
+	case StatusAccepted:
+		if !acl.CanReview {
+			return nil, errAcl("you are not authorized to accept a PR")
+		}
+		if prq.Status == StatusAccepted {
+			return nil, fmt.Errorf("PR has already been accepted")
+		}
+		if prq.Status == StatusDraft {
+			return nil, errDraft(prID)
+		}
+		err = checkRequiredApprovals(pr, repo, prID)
+		if err != nil {
+			return nil, err
+		}
+		if !force {
+			err = checkDependencies(pr, prID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case StatusClosed:
+		if !acl.CanModify {
+			return nil, errAcl("you are not authorized to change PR status")
+		}
+		if prq.Status == StatusClosed {
+			return nil, fmt.Errorf("PR has already been closed")
+		}
+	case StatusOpen:
+		if !acl.CanModify {
+			return nil, errAcl("you are not authorized to change PR status")
+		}
+		if prq.Status == StatusOpen {
+			return nil, fmt.Errorf("PR is already open")
+		}
+		if prq.Status == StatusDraft {
+			return nil, fmt.Errorf("PR is a draft, use `pr ready %d` to open it", prID)
+		}
+	default:
+		return nil, fmt.Errorf("invalid status: %s", status)
+	}
+
+	err = pr.UpdatePatchRequestStatus(prID, user.ID, status, comment)
+	if err != nil {
+		return nil, err
+	}
+	return prq, nil
+}
+
+func EditPatchRequest(be *Backend, pr GitPatchRequest, user *User, prID int64, title string) (*PatchRequest, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	if !acl.CanModify {
+		return nil, errAcl("you are not authorized to change PR")
+	}
+
+	if title == "" {
+		return nil, fmt.Errorf("must provide title")
+	}
+
+	err = pr.UpdatePatchRequestName(prID, user.ID, title)
+	if err != nil {
+		return nil, err
+	}
+	return prq, nil
+}
+
+// EditPatchRequestBody replaces the description of a patch request.  The
+// previous description is kept in the revision history.
+func EditPatchRequestBody(be *Backend, pr GitPatchRequest, user *User, prID int64, body string) (*PatchRequest, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	if !acl.CanModify {
+		return nil, errAcl("you are not authorized to change PR")
+	}
+
+	body = strings.TrimSpace(body)
+	if body == prq.Text {
+		return prq, nil
+	}
+
+	err = pr.UpdatePatchRequestText(prID, user.ID, body)
+	if err != nil {
+		return nil, err
+	}
+	return prq, nil
+}
+
+// FindRepo resolves a repo namespace, the owner defaults to the requester
+// or to the admin on single tenant servers.
+func FindRepo(be *Backend, pr GitPatchRequest, user *User, rawRepoNs string) (*Repo, error) {
+	repoUsername, repoName := be.SplitRepoNs(rawRepoNs)
+	if repoName == "" {
+		return nil, fmt.Errorf("must provide repo name")
+	}
+	if repoUsername == "" {
+		if be.Cfg.CreateRepo == "admin" {
+			return pr.GetRepoByName(nil, repoName)
+		}
+		return pr.GetRepoByName(user, repoName)
+	}
+	repoUser, err := pr.GetUserByName(repoUsername)
+	if err != nil {
+		return nil, fmt.Errorf("repo not found: %s", rawRepoNs)
+	}
+	return pr.GetRepoByName(repoUser, repoName)
+}
+
+// checkRepoArchived stops new patch requests and patchsets from landing in
+// an archived repo.
+func checkRepoArchived(repo *Repo) error {
+	if repo.Archived {
+		return fmt.Errorf("repo is archived: %s", repo.Name)
+	}
+	return nil
+}
+
+// RenameRepo changes the name of a repo, only the repo owner and admins can
+// rename it.
+func RenameRepo(be *Backend, pr GitPatchRequest, user *User, rawRepoNs, name string) (*Repo, error) {
+	repo, err := FindRepo(be, pr, user, rawRepoNs)
+	if err != nil {
+		return nil, err
+	}
+	err = be.CanModifyRepo(repo, user)
+	if err != nil {
+		return nil, errAcl(err.Error())
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, "/ ") {
+		return nil, fmt.Errorf("invalid repo name: %q", name)
+	}
+	if name == repo.Name {
+		return repo, nil
+	}
+
+	err = pr.RenameRepo(repo.ID, user.ID, name)
+	if err != nil {
+		return nil, err
+	}
+	return pr.GetRepoByID(repo.ID)
+}
+
+// TransferRepo offers the ownership of a repo to another user.
+func TransferRepo(be *Backend, pr GitPatchRequest, user *User, rawRepoNs, toUserName string) (*Repo, error) {
+	repo, err := FindRepo(be, pr, user, rawRepoNs)
+	if err != nil {
+		return nil, err
+	}
+	err = be.CanModifyRepo(repo, user)
+	if err != nil {
+		return nil, errAcl(err.Error())
+	}
+	recipient, err := pr.GetUserByName(toUserName)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %s", toUserName)
+	}
+	if recipient.ID == repo.UserID {
+		return nil, fmt.Errorf("%s already owns the repo", recipient.Name)
+	}
+
+	err = pr.RequestRepoTransfer(repo.ID, user.ID, recipient.ID)
+	if err != nil {
+		return nil, err
+	}
+	return pr.GetRepoByID(repo.ID)
+}
+
+// AcceptRepoTransfer takes ownership of a repo offered to the requester.
+func AcceptRepoTransfer(be *Backend, pr GitPatchRequest, user *User, rawRepoNs string) (*Repo, error) {
+	repo, err := FindRepo(be, pr, user, rawRepoNs)
+	if err != nil {
+		return nil, err
+	}
+	if repo.TransferToID.Int64 != user.ID {
+		return nil, errAcl("repo is not being transferred to you")
+	}
+
+	err = pr.AcceptRepoTransfer(repo.ID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return pr.GetRepoByID(repo.ID)
+}
+
+// CancelRepoTransfer lets the repo owner withdraw a transfer or the
+// recipient decline it.
+func CancelRepoTransfer(be *Backend, pr GitPatchRequest, user *User, rawRepoNs string) (*Repo, error) {
+	repo, err := FindRepo(be, pr, user, rawRepoNs)
+	if err != nil {
+		return nil, err
+	}
+	if repo.TransferToID.Int64 != user.ID {
+		err = be.CanModifyRepo(repo, user)
+		if err != nil {
+			return nil, errAcl(err.Error())
+		}
+	}
+
+	err = pr.CancelRepoTransfer(repo.ID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return pr.GetRepoByID(repo.ID)
+}
+
+// ArchiveRepo makes a repo read-only, or writable again with archived set
+// to false.  Archived repos stay browsable.
+func ArchiveRepo(be *Backend, pr GitPatchRequest, user *User, rawRepoNs string, archived bool) (*Repo, error) {
+	repo, err := FindRepo(be, pr, user, rawRepoNs)
+	if err != nil {
+		return nil, err
+	}
+	err = be.CanModifyRepo(repo, user)
+	if err != nil {
+		return nil, errAcl(err.Error())
+	}
+	if repo.Archived == archived {
+		return repo, nil
+	}
+
+	err = pr.ArchiveRepo(repo.ID, user.ID, archived)
+	if err != nil {
+		return nil, err
+	}
+	return pr.GetRepoByID(repo.ID)
+}
+
+// CreateRepoLabel adds a label to a repo, only the repo owner and admins
+// manage labels.
+func CreateRepoLabel(be *Backend, pr GitPatchRequest, user *User, rawRepoNs, name, color, description string) (*Label, error) {
+	repo, err := FindRepo(be, pr, user, rawRepoNs)
+	if err != nil {
+		return nil, err
+	}
+	err = be.CanModifyRepo(repo, user)
+	if err != nil {
+		return nil, errAcl(err.Error())
+	}
+	return pr.CreateLabel(repo.ID, user.ID, name, color, description)
+}
+
+// DeleteRepoLabel removes a label from a repo and its patch requests.
+func DeleteRepoLabel(be *Backend, pr GitPatchRequest, user *User, rawRepoNs, name string) error {
+	repo, err := FindRepo(be, pr, user, rawRepoNs)
+	if err != nil {
+		return err
+	}
+	err = be.CanModifyRepo(repo, user)
+	if err != nil {
+		return errAcl(err.Error())
+	}
+	return pr.DeleteLabel(repo.ID, user.ID, name)
+}
+
+// ChangePatchRequestLabels applies changes like `+bug -wip` to a patch
+// request.
+func ChangePatchRequestLabels(be *Backend, pr GitPatchRequest, user *User, prID int64, changes []string) (*PatchRequest, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	if !acl.CanModify {
+		return nil, errAcl("you are not authorized to change PR labels")
+	}
+
+	add, rm, err := parseLabelChanges(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pr.UpdatePatchRequestLabels(prID, user.ID, add, rm)
+	if err != nil {
+		return nil, err
+	}
+	return prq, nil
+}
+
+// RequestReview asks users to review a patch request.
+func RequestReview(be *Backend, pr GitPatchRequest, user *User, prID int64, names []string) (*PatchRequest, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	if !acl.CanModify {
+		return nil, errAcl("you are not authorized to request reviews for this PR")
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("must provide at least one reviewer")
+	}
+
+	reviewers := []*User{}
+	for _, name := range names {
+		reviewer, err := pr.GetUserByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("user %s not found", name)
+		}
+		reviewers = append(reviewers, reviewer)
+	}
+
+	err = pr.CreateReviewRequests(prID, user.ID, reviewers)
+	if err != nil {
+		return nil, err
+	}
+	return prq, nil
+}
+
+// QueueEntry is an open patch request awaiting a user's review.
+type QueueEntry struct {
+	PatchRequest *PatchRequest
+	Request      *ReviewRequest
+	RequestedBy  *User
+}
+
+// GetReviewQueue returns the open patch requests a user has been asked to
+// review, the longest waiting first.
+func GetReviewQueue(pr GitPatchRequest, user *User) ([]*QueueEntry, error) {
+	requests, err := pr.GetReviewRequestsByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := []*QueueEntry{}
+	for _, request := range requests {
+		prq, err := pr.GetPatchRequestByID(request.PatchRequestID)
+		if err != nil {
+			return nil, err
+		}
+		if prq.Status != StatusOpen {
+			continue
+		}
+		requestedBy, err := pr.GetUserByID(request.RequestedBy)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, &QueueEntry{
+			PatchRequest: prq,
+			Request:      request,
+			RequestedBy:  requestedBy,
+		})
+	}
+	return queue, nil
+}
+
+// ApprovalEntry is a user's approval of a patch request.
+type ApprovalEntry struct {
+	*Approval
+	User *User
+	// Stale approvals were given before the latest non-review patchset.
+	Stale bool
+}
+
+// ApprovalStatus is how many approvals a patch request has against how many
+// its repo requires.
+type ApprovalStatus struct {
+	Required  int
+	Approvals []*ApprovalEntry
+}
+
+// Count is the number of approvals that are not stale.
+func (s *ApprovalStatus) Count() int {
+	count := 0
+	for _, approval := range s.Approvals {
+		if !approval.Stale {
+			count += 1
+		}
+	}
+	return count
+}
+
+// Satisfied reports whether the patch request can be accepted.
+func (s *ApprovalStatus) Satisfied() bool {
+	return s.Count() >= s.Required
+}
+
+func GetApprovalStatus(pr GitPatchRequest, repo *Repo, prID int64) (*ApprovalStatus, error) {
+	approvals, err := pr.GetApprovalsByPrID(prID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := pr.GetCurrentPatchsetByPrID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ApprovalStatus{
+		Required:  repo.RequiredApprovals,
+		Approvals: []*ApprovalEntry{},
+	}
+	for _, approval := range approvals {
+		user, err := pr.GetUserByID(approval.UserID)
+		if err != nil {
+			return nil, err
+		}
+		status.Approvals = append(status.Approvals, &ApprovalEntry{
+			Approval: approval,
+			User:     user,
+			Stale:    approval.PatchsetID != current.ID,
+		})
+	}
+	return status, nil
+}
+
+// checkRequiredApprovals stops a patch request from being accepted until
+// its repo's required approvals are met.
+func checkRequiredApprovals(pr GitPatchRequest, repo *Repo, prID int64) error {
+	if repo.RequiredApprovals == 0 {
+		return nil
+	}
+	status, err := GetApprovalStatus(pr, repo, prID)
+	if err != nil {
+		return err
+	}
+	if !status.Satisfied() {
+		return fmt.Errorf(
+			"PR needs %d approvals before it can be accepted, it has %d",
+			status.Required, status.Count(),
+		)
+	}
+	return nil
+}
+
+// ApprovePatchRequest records that a user approves the current patchset of
+// a patch request.  Only reviewers can approve and never their own PR.
+func ApprovePatchRequest(be *Backend, pr GitPatchRequest, user *User, prID int64, comment string) (*PatchRequest, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+	if be.IsPrOwner(prq.UserID, user.ID) {
+		return nil, errAcl("you cannot approve your own PR")
+	}
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	if !acl.CanReview {
+		return nil, errAcl("you are not authorized to approve this PR")
+	}
+	if prq.Status != StatusOpen {
+		return nil, fmt.Errorf("PR is not open: %s", prq.Status)
+	}
+
+	err = pr.ApprovePatchRequest(prID, user.ID, comment)
+	if err != nil {
+		return nil, err
+	}
+	return prq, nil
+}
+
+// UnapprovePatchRequest withdraws a user's approval of a patch request.
+func UnapprovePatchRequest(be *Backend, pr GitPatchRequest, user *User, prID int64) (*PatchRequest, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+	if prq.Status != StatusOpen {
+		return nil, fmt.Errorf("PR is not open: %s", prq.Status)
+	}
+
+	err = pr.UnapprovePatchRequest(prID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return prq, nil
+}
+
+// checkDependencies stops a patch request from being accepted before the
+// patch requests it depends on.
+func checkDependencies(pr GitPatchRequest, prID int64) error {
+	deps, err := pr.GetPatchRequestDependencies(prID)
+	if err != nil {
+		return err
+	}
+	open := []string{}
+	for _, dep := range deps {
+		if dep.Status == StatusOpen || dep.Status == StatusDraft {
+			open = append(open, fmt.Sprintf("#%d", dep.ID))
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf(
+			"PR depends on open PRs %s, accept them first or have an admin use `pr accept --force %d`",
+			strings.Join(open, ", "), prID,
+		)
+	}
+	return nil
+}
+
+// ChangePatchRequestDependency adds or removes a patch request that has to
+// land before another.  Both have to be in the same repo.
+func ChangePatchRequestDependency(be *Backend, pr GitPatchRequest, user *User, prID, dependsOnID int64, remove bool) (*PatchRequest, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	if !acl.CanModify {
+		return nil, errAcl("you are not authorized to change PR dependencies")
+	}
+
+	if remove {
+		err = pr.RemovePatchRequestDependency(prID, dependsOnID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return prq, nil
+	}
+
+	dep, err := pr.GetPatchRequestByID(dependsOnID)
+	if err != nil {
+		return nil, fmt.Errorf("PR not found: %d", dependsOnID)
+	}
+	if dep.RepoID != prq.RepoID {
+		return nil, fmt.Errorf("PR %d is in a different repo", dependsOnID)
+	}
+
+	err = pr.AddPatchRequestDependency(prID, dependsOnID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return prq, nil
+}
+
+// GetPatchRequestStack returns the patch requests a patch request depends
+// on, followed by the patch request itself, in the order they apply.
+// Accepted patch requests have landed so they are left out.
+func GetPatchRequestStack(pr GitPatchRequest, prID int64) ([]*PatchRequest, error) {
+	stack := []*PatchRequest{}
+	seen := map[int64]bool{}
+	var visit func(prq *PatchRequest) error
+	visit = func(prq *PatchRequest) error {
+		if seen[prq.ID] {
+			return nil
+		}
+		seen[prq.ID] = true
+		deps, err := pr.GetPatchRequestDependencies(prq.ID)
+		if err != nil {
+			return err
+		}
+		for _, dep := range deps {
+			if dep.Status == StatusAccepted {
+				continue
+			}
+			err = visit(dep)
+			if err != nil {
+				return err
+			}
+		}
+		stack = append(stack, prq)
+		return nil
+	}
+
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+	err = visit(prq)
+	return stack, err
+}
+
+// MovePatchRequest transfers a patch request to another repo.  The
+// requester has to be able to change the patch request, the target repo
+// has to exist.
+func MovePatchRequest(be *Backend, pr GitPatchRequest, user *User, prID int64, rawRepoNs string) (*PatchRequest, *Repo, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	acl := be.GetPatchRequestAcl(repo, prq, user)
+	if !acl.CanModify {
+		return nil, nil, errAcl("you are not authorized to move PR")
+	}
+
+	target, err := FindRepo(be, pr, user, rawRepoNs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("repo not found: %s", rawRepoNs)
+	}
+	err = checkRepoArchived(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = pr.MovePatchRequest(prID, target.ID, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return prq, target, nil
+}
+
+// SupersedePatchRequest closes a patch request in favor of a newer one.
+// The requester has to be able to change both patch requests.
+func SupersedePatchRequest(be *Backend, pr GitPatchRequest, user *User, oldID, newID int64, fold bool) (*PatchRequest, error) {
+	for _, id := range []int64{oldID, newID} {
+		prq, err := pr.GetPatchRequestByID(id)
+		if err != nil {
+			return nil, fmt.Errorf("PR not found: %d", id)
+		}
+		if prq.Status != StatusOpen && prq.Status != StatusDraft {
+			return nil, fmt.Errorf("PR %d is not open: %s", id, prq.Status)
+		}
+
+		repo, err := pr.GetRepoByID(prq.RepoID)
+		if err != nil {
+			return nil, err
+		}
+
+		acl := be.GetPatchRequestAcl(repo, prq, user)
+		if !acl.CanModify {
+			return nil, errAcl(fmt.Sprintf("you are not authorized to change PR %d", id))
+		}
+		// folding copies the old patchsets into the new PR's repo
+		if fold && id == newID {
+			err = checkRepoArchived(repo)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err := pr.SupersedePatchRequest(oldID, newID, user.ID, fold)
+	if err != nil {
+		return nil, err
+	}
+	return pr.GetPatchRequestByID(oldID)
+}
+
+// CreateComment adds a discussion comment to a patch request.  Anyone with
+// an account can comment.
+func CreateComment(be *Backend, pr GitPatchRequest, user *User, prID, replyToID int64, text string) (*Comment, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("comment cannot be empty")
+	}
+	if _, err := pr.GetPatchRequestByID(prID); err != nil {
+		return nil, err
+	}
+	return pr.CreateComment(prID, user.ID, replyToID, text)
+}
+
+// EditComment replaces the text of a comment.  Only its author can edit it.
+func EditComment(be *Backend, pr GitPatchRequest, user *User, commentID int64, text string) (*Comment, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("comment cannot be empty")
+	}
+	comment, err := pr.GetCommentByID(commentID)
+	if err != nil {
+		return nil, fmt.Errorf("comment not found: %d", commentID)
+	}
+	if comment.UserID != user.ID {
+		return nil, errAcl("you are not authorized to edit this comment")
+	}
+
+	err = pr.UpdateComment(commentID, text)
+	if err != nil {
+		return nil, err
+	}
+	return pr.GetCommentByID(commentID)
+}
+
+// DeleteComment removes a comment.  Its author or an admin can delete it.
+func DeleteComment(be *Backend, pr GitPatchRequest, user *User, commentID int64) (*Comment, error) {
+	comment, err := pr.GetCommentByID(commentID)
+	if err != nil {
+		return nil, fmt.Errorf("comment not found: %d", commentID)
+	}
+	if comment.UserID != user.ID {
+		pk, err := be.PubkeyToPublicKey(user.Pubkey)
+		if err != nil || !be.IsAdmin(pk) {
+			return nil, errAcl("you are not authorized to delete this comment")
+		}
+	}
+
+	err = pr.DeleteComment(commentID)
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
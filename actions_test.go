@@ -0,0 +1,792 @@
+package git
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setupTestRepo creates the alice/test repo with a single patch request.
+func setupTestRepo(t testing.TB) (*PrCmd, *User, *PatchRequest) {
+	t.Helper()
+	pr := setupTestPr(t)
+	owner := createTestUser(t, pr, "alice")
+	repo, err := pr.CreateRepo(owner, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prq, err := pr.SubmitPatchRequest(repo.ID, owner.ID, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pr, owner, prq
+}
+
+func TestLabels(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bug, err := pr.CreateLabel(repo.ID, owner.ID, "bug", "D73A4A", "something is broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bug.Color != "#d73a4a" {
+		t.Fatalf("expected normalized color, found: %s", bug.Color)
+	}
+	_, err = pr.CreateLabel(repo.ID, owner.ID, "wip", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pr.CreateLabel(repo.ID, owner.ID, "bug", "", ""); err == nil {
+		t.Fatal("expected duplicate label to fail")
+	}
+	if _, err := pr.CreateLabel(repo.ID, owner.ID, "has space", "", ""); err == nil {
+		t.Fatal("expected invalid label name to fail")
+	}
+	if _, err := pr.CreateLabel(repo.ID, owner.ID, "red", "red", ""); err == nil {
+		t.Fatal("expected invalid label color to fail")
+	}
+	other := createTestUser(t, pr, "bob")
+	if _, err := CreateRepoLabel(pr.Backend, pr, other, "alice/test", "mine", "", ""); err == nil {
+		t.Fatal("expected other users to not create labels")
+	}
+
+	_, err = ChangePatchRequestLabels(pr.Backend, pr, owner, prq.ID, []string{"+bug", "+wip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no-op changes are not logged
+	_, err = ChangePatchRequestLabels(pr.Backend, pr, owner, prq.ID, []string{"+bug", "-wip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ChangePatchRequestLabels(pr.Backend, pr, owner, prq.ID, []string{"+nope"}); err == nil {
+		t.Fatal("expected unknown label to fail")
+	}
+	if _, err := ChangePatchRequestLabels(pr.Backend, pr, other, prq.ID, []string{"-bug"}); err == nil {
+		t.Fatal("expected other users to not change labels")
+	}
+
+	err = DeleteRepoLabel(pr.Backend, pr, owner, "alice/test", "bug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prLabels, err := pr.GetLabelsByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prLabels) != 0 {
+		t.Fatalf("expected deleted label to be removed from pr, found: %+v", prLabels)
+	}
+
+	logs, err := pr.GetEventLogsByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := []string{}
+	for _, eventLog := range logs {
+		if eventLog.Event == "pr_labels_changed" {
+			changes = append(changes, fmt.Sprintf("+%v -%v", eventLog.Data.LabelsAdded, eventLog.Data.LabelsRemoved))
+		}
+	}
+	if strings.Join(changes, " ") != "+[bug wip] -[] +[] -[wip] +[] -[bug]" {
+		t.Fatalf("unexpected label events: %v", changes)
+	}
+	repoLogs, err := pr.GetEventLogsByRepoID(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []string{}
+	for _, eventLog := range repoLogs {
+		if strings.HasPrefix(eventLog.Event, "repo_label") {
+			events = append(events, eventLog.Event)
+		}
+	}
+	if strings.Join(events, " ") != "repo_label_created repo_label_created repo_label_deleted" {
+		t.Fatalf("unexpected repo label events: %v", events)
+	}
+}
+
+func TestReviewRequests(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	bob := createTestUser(t, pr, "bob")
+	carol := createTestUser(t, pr, "carol")
+
+	if _, err := RequestReview(pr.Backend, pr, bob, prq.ID, []string{"carol"}); err == nil {
+		t.Fatal("expected other users to not request reviews")
+	}
+	if _, err := RequestReview(pr.Backend, pr, owner, prq.ID, []string{"nobody"}); err == nil {
+		t.Fatal("expected unknown reviewer to fail")
+	}
+	_, err := RequestReview(pr.Backend, pr, owner, prq.ID, []string{"bob", "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// requesting again keeps the original request and is not logged
+	_, err = RequestReview(pr.Backend, pr, owner, prq.ID, []string{"bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := pr.GetEventLogsByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requested := []string{}
+	for _, eventLog := range logs {
+		if eventLog.Event == "pr_review_requested" {
+			requested = append(requested, strings.Join(eventLog.Data.Reviewers, ","))
+		}
+	}
+	if strings.Join(requested, " ") != "bob,carol" {
+		t.Fatalf("unexpected review request events: %v", requested)
+	}
+
+	_, err = pr.SubmitPatchset(prq.ID, bob.ID, OpReview, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := GetReviewQueue(pr, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 0 {
+		t.Fatalf("expected review to clear bob's request, found: %d", len(queue))
+	}
+	queue, err = GetReviewQueue(pr, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 {
+		t.Fatalf("expected carol's request to remain, found: %d", len(queue))
+	}
+
+	err = pr.UpdatePatchRequestStatus(prq.ID, carol.ID, StatusAccepted, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err := pr.GetReviewRequestsByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("expected accept to clear carol's request, found: %d", len(requests))
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	pr, owner, _ := setupTestRepo(t)
+	repo, err := pr.GetRepoByName(owner, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := createTestUser(t, pr, "bob")
+	// carol is an admin so she can review, dave is not
+	carol := createTestUser(t, pr, "carol")
+	carolPk, err := pr.Backend.PubkeyToPublicKey(carol.Pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr.Backend.Cfg.Admins = append(pr.Backend.Cfg.Admins, carolPk)
+	dave := createTestUser(t, pr, "dave")
+	prq, err := pr.SubmitPatchRequest(repo.ID, bob.ID, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.RequiredApprovals = 2
+	err = pr.UpdateRepo(repo, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ApprovePatchRequest(pr.Backend, pr, bob, prq.ID, ""); err == nil {
+		t.Fatal("expected the author to not approve their own pr")
+	}
+	_, err = ApprovePatchRequest(pr.Backend, pr, owner, prq.ID, "lgtm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// approving the same patchset again is not logged
+	_, err = ApprovePatchRequest(pr.Backend, pr, owner, prq.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ChangePatchRequestStatus(pr.Backend, pr, owner, prq.ID, StatusAccepted, ""); err == nil {
+		t.Fatal("expected accept to require two approvals")
+	}
+	// approvals from users who cannot review do not count
+	if _, err := ApprovePatchRequest(pr.Backend, pr, dave, prq.ID, "lgtm"); err == nil {
+		t.Fatal("expected a non-reviewer to not approve")
+	}
+	if _, err := ChangePatchRequestStatus(pr.Backend, pr, owner, prq.ID, StatusAccepted, ""); err == nil {
+		t.Fatal("expected a non-reviewer approval to not unblock accept")
+	}
+
+	_, err = ApprovePatchRequest(pr.Backend, pr, carol, prq.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := GetApprovalStatus(pr, repo, prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Count() != 2 || status.Required != 2 {
+		t.Fatalf("unexpected approvals: %d/%d", status.Count(), status.Required)
+	}
+
+	// a new patchset makes the approvals stale
+	_, err = pr.SubmitPatchset(prq.ID, bob.ID, OpNormal, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = GetApprovalStatus(pr, repo, prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Count() != 0 || len(status.Approvals) != 2 || !status.Approvals[0].Stale {
+		t.Fatalf("expected stale approvals, found: %d", status.Count())
+	}
+	if _, err := AddPatchset(pr.Backend, pr, owner, prq.ID, OpAccept, "", strings.NewReader(singlePatch(t))); err == nil {
+		t.Fatal("expected add --accept to require two approvals")
+	}
+	// review patchsets do not make approvals stale
+	_, err = pr.SubmitPatchset(prq.ID, owner.ID, OpReview, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ApprovePatchRequest(pr.Backend, pr, owner, prq.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ApprovePatchRequest(pr.Backend, pr, carol, prq.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = UnapprovePatchRequest(pr.Backend, pr, carol, prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UnapprovePatchRequest(pr.Backend, pr, carol, prq.ID); err == nil {
+		t.Fatal("expected unapprove without an approval to fail")
+	}
+	if _, err := ChangePatchRequestStatus(pr.Backend, pr, owner, prq.ID, StatusAccepted, ""); err == nil {
+		t.Fatal("expected accept to require two approvals")
+	}
+	_, err = ApprovePatchRequest(pr.Backend, pr, carol, prq.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ChangePatchRequestStatus(pr.Backend, pr, owner, prq.ID, StatusAccepted, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := pr.GetEventLogsByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []string{}
+	for _, eventLog := range logs {
+		if eventLog.Event == "pr_approved" || eventLog.Event == "pr_unapproved" {
+			events = append(events, eventLog.Event)
+		}
+	}
+	expected := "pr_approved pr_approved pr_approved pr_approved pr_unapproved pr_approved"
+	if strings.Join(events, " ") != expected {
+		t.Fatalf("unexpected approval events: %v", events)
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	pr, owner, _ := setupTestRepo(t)
+	bob := createTestUser(t, pr, "bob")
+	carol := createTestUser(t, pr, "carol")
+
+	prq, err := CreatePatchRequest(pr.Backend, pr, bob, "alice/test", true, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prq.Status != StatusDraft {
+		t.Fatalf("expected draft, found: %s", prq.Status)
+	}
+
+	if _, err := ChangePatchRequestStatus(pr.Backend, pr, owner, prq.ID, StatusAccepted, ""); err == nil {
+		t.Fatal("expected drafts to not be accepted")
+	}
+	if _, err := AddPatchset(pr.Backend, pr, owner, prq.ID, OpAccept, "", strings.NewReader(singlePatch(t))); err == nil {
+		t.Fatal("expected drafts to not be accepted with a patchset")
+	}
+	if _, err := ChangePatchRequestStatus(pr.Backend, pr, owner, prq.ID, StatusOpen, ""); err == nil {
+		t.Fatal("expected drafts to be opened with pr ready")
+	}
+	_, err = AddPatchset(pr.Backend, pr, bob, prq.ID, OpNormal, "", strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prq, err = pr.GetPatchRequestByID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prq.Status != StatusDraft {
+		t.Fatalf("expected new patchsets to keep the draft, found: %s", prq.Status)
+	}
+
+	if _, err := MarkPatchRequestReady(pr.Backend, pr, carol, prq.ID); err == nil {
+		t.Fatal("expected other users to not mark the pr ready")
+	}
+	_, err = MarkPatchRequestReady(pr.Backend, pr, bob, prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarkPatchRequestReady(pr.Backend, pr, bob, prq.ID); err == nil {
+		t.Fatal("expected open prs to not be marked ready")
+	}
+
+	logs, err := pr.GetEventLogsByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs[0].Data.Status != StatusDraft || logs[len(logs)-1].Event != "pr_ready_for_review" {
+		t.Fatalf("unexpected events: %s %s", logs[0].Data.Status, logs[len(logs)-1].Event)
+	}
+	_, err = ChangePatchRequestStatus(pr.Backend, pr, owner, prq.ID, StatusAccepted, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	pr, owner, first := setupTestRepo(t)
+	bob := createTestUser(t, pr, "bob")
+	admin := createTestUser(t, pr, "root")
+	adminPk, err := pr.Backend.PubkeyToPublicKey(admin.Pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr.Backend.Cfg.Admins = append(pr.Backend.Cfg.Admins, adminPk)
+
+	second, err := CreatePatchRequest(pr.Backend, pr, bob, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := CreatePatchRequest(pr.Backend, pr, bob, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := CreatePatchRequest(pr.Backend, pr, bob, "bob/other", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ChangePatchRequestDependency(pr.Backend, pr, bob, second.ID, first.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ChangePatchRequestDependency(pr.Backend, pr, bob, third.ID, second.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ChangePatchRequestDependency(pr.Backend, pr, owner, first.ID, third.ID, false); err == nil {
+		t.Fatal("expected a dependency cycle to be rejected")
+	}
+	if _, err := ChangePatchRequestDependency(pr.Backend, pr, bob, third.ID, third.ID, false); err == nil {
+		t.Fatal("expected a pr to not depend on itself")
+	}
+	if _, err := ChangePatchRequestDependency(pr.Backend, pr, bob, third.ID, other.ID, false); err == nil {
+		t.Fatal("expected dependencies across repos to be rejected")
+	}
+	if _, err := ChangePatchRequestDependency(pr.Backend, pr, bob, first.ID, other.ID, false); err == nil {
+		t.Fatal("expected other users to not change dependencies")
+	}
+
+	stack, err := GetPatchRequestStack(pr, third.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, prq := range stack {
+		ids = append(ids, fmt.Sprint(prq.ID))
+	}
+	expected := fmt.Sprintf("%d %d %d", first.ID, second.ID, third.ID)
+	if strings.Join(ids, " ") != expected {
+		t.Fatalf("unexpected stack: %v", ids)
+	}
+
+	if _, err := ChangePatchRequestStatus(pr.Backend, pr, owner, second.ID, StatusAccepted, ""); err == nil {
+		t.Fatal("expected accept to wait for open dependencies")
+	}
+	if _, err := ForceAcceptPatchRequest(pr.Backend, pr, owner, second.ID, ""); err == nil {
+		t.Fatal("expected only admins to force accept")
+	}
+	_, err = ChangePatchRequestStatus(pr.Backend, pr, owner, first.ID, StatusAccepted, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack, err = GetPatchRequestStack(pr, third.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stack) != 2 || stack[0].ID != second.ID {
+		t.Fatalf("expected accepted prs to leave the stack, found: %d", len(stack))
+	}
+	_, err = ForceAcceptPatchRequest(pr.Backend, pr, admin, third.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ChangePatchRequestDependency(pr.Backend, pr, bob, third.ID, second.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ChangePatchRequestDependency(pr.Backend, pr, bob, third.ID, second.ID, true); err == nil {
+		t.Fatal("expected removing a missing dependency to fail")
+	}
+}
+
+func TestComments(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	bob := createTestUser(t, pr, "bob")
+
+	question, err := CreateComment(pr.Backend, pr, bob, prq.ID, 0, "does this handle unicode?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := CreateComment(pr.Backend, pr, owner, prq.ID, question.ID, "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateComment(pr.Backend, pr, bob, prq.ID, 0, "  \n"); err == nil {
+		t.Fatal("expected empty comments to be rejected")
+	}
+	if _, err := CreateComment(pr.Backend, pr, bob, prq.ID, 999, "lost"); err == nil {
+		t.Fatal("expected replies to a missing comment to be rejected")
+	}
+
+	if _, err := EditComment(pr.Backend, pr, owner, question.ID, "hijacked"); err == nil {
+		t.Fatal("expected only the author to edit a comment")
+	}
+	if _, err := DeleteComment(pr.Backend, pr, owner, question.ID); err == nil {
+		t.Fatal("expected only the author to delete a comment")
+	}
+	edited, err := EditComment(pr.Backend, pr, owner, answer.ID, "yes, see `utf8.go`")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !edited.UpdatedAt.Valid {
+		t.Fatal("expected edited comment to have updated_at")
+	}
+
+	_, err = DeleteComment(pr.Backend, pr, bob, question.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments, err := pr.GetCommentsByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || comments[0].ReplyToID.Valid {
+		t.Fatal("expected the reply to move up when its parent is deleted")
+	}
+}
+
+func TestEditBody(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	bob := createTestUser(t, pr, "bob")
+	original := prq.Text
+
+	if _, err := EditPatchRequestBody(pr.Backend, pr, bob, prq.ID, "mine now"); err == nil {
+		t.Fatal("expected other users to not edit the description")
+	}
+	_, err := EditPatchRequestBody(pr.Backend, pr, owner, prq.ID, "Fixes unicode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = EditPatchRequest(pr.Backend, pr, owner, prq.ID, "unicode fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revs, err := pr.GetPatchRequestRevisions(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 3 || revs[0].Text != original || revs[1].Name != prq.Name || revs[2].Name != "unicode fix" {
+		t.Fatalf("unexpected revisions: %+v", revs)
+	}
+}
+
+func TestMoveAndSupersede(t *testing.T) {
+	pr, owner, first := setupTestRepo(t)
+	bob := createTestUser(t, pr, "bob")
+	carol := createTestUser(t, pr, "carol")
+	next, err := pr.CreateRepo(owner, "next")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := CreatePatchRequest(pr.Backend, pr, bob, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repoNs := range []string{"alice/test", "alice/next"} {
+		if _, err := CreateRepoLabel(pr.Backend, pr, owner, repoNs, "bug", "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := CreateRepoLabel(pr.Backend, pr, owner, "alice/test", "wip", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ChangePatchRequestLabels(pr.Backend, pr, owner, second.ID, []string{"+bug", "+wip"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ChangePatchRequestDependency(pr.Backend, pr, bob, second.ID, first.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := MovePatchRequest(pr.Backend, pr, carol, second.ID, "alice/next"); err == nil {
+		t.Fatal("expected other users to not move the pr")
+	}
+	if _, _, err := MovePatchRequest(pr.Backend, pr, bob, second.ID, "alice/test"); err == nil {
+		t.Fatal("expected a move to the same repo to be rejected")
+	}
+	if _, _, err := MovePatchRequest(pr.Backend, pr, bob, second.ID, "alice/missing"); err == nil {
+		t.Fatal("expected a move to a missing repo to be rejected")
+	}
+	_, _, err = MovePatchRequest(pr.Backend, pr, bob, second.ID, "alice/next")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := pr.GetPatchRequestByID(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.RepoID != next.ID {
+		t.Fatalf("expected pr to be in the new repo, found: %d", moved.RepoID)
+	}
+	labels, err := pr.GetLabelsByPrID(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 || labels[0].Name != "bug" || labels[0].RepoID != next.ID {
+		t.Fatalf("expected only the labels of the new repo, found: %+v", labels)
+	}
+	deps, err := pr.GetPatchRequestDependencies(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 0 {
+		t.Fatal("expected dependencies across repos to be removed")
+	}
+	events, err := pr.GetEventLogsByRepoID(next.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := slices.IndexFunc(events, func(e *EventLog) bool { return e.Event == "pr_moved" })
+	if len(events) < 2 || idx == -1 {
+		t.Fatalf("expected the history to move along with the pr, found: %+v", events)
+	}
+	if data := events[idx].Data; data.FromRepo != "alice/test" || data.ToRepo != "alice/next" || len(data.LabelsRemoved) != 1 {
+		t.Fatalf("unexpected move event: %+v", data)
+	}
+
+	third, err := CreatePatchRequest(pr.Backend, pr, owner, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := pr.GetCurrentPatchsetByPrID(third.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dependent, err := CreatePatchRequest(pr.Backend, pr, owner, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ChangePatchRequestDependency(pr.Backend, pr, owner, dependent.ID, first.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SupersedePatchRequest(pr.Backend, pr, bob, first.ID, third.ID, false); err == nil {
+		t.Fatal("expected other users to not supersede the pr")
+	}
+	if _, err := SupersedePatchRequest(pr.Backend, pr, owner, first.ID, first.ID, false); err == nil {
+		t.Fatal("expected a pr to not supersede itself")
+	}
+	old, err := SupersedePatchRequest(pr.Backend, pr, owner, first.ID, third.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Status != StatusClosed || old.SupersededBy.Int64 != third.ID {
+		t.Fatalf("expected the old pr to be closed and linked, found: %+v", old)
+	}
+	if _, err := SupersedePatchRequest(pr.Backend, pr, owner, first.ID, third.ID, false); err == nil {
+		t.Fatal("expected a closed pr to not be superseded again")
+	}
+
+	patchsets, err := pr.GetPatchsetsByPrID(third.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patchsets) != 2 || patchsets[1].ID != current.ID {
+		t.Fatalf("expected the old patchsets before the new ones, found: %+v", patchsets)
+	}
+	patches, err := pr.GetPatchesByPatchsetID(patchsets[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 {
+		t.Fatalf("expected the patches to be copied, found: %d", len(patches))
+	}
+	latest, err := pr.GetCurrentPatchsetByPrID(third.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != current.ID {
+		t.Fatalf("expected the folded patchsets to not become current, found: %d", latest.ID)
+	}
+	deps, err = pr.GetPatchRequestDependencies(dependent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 1 || deps[0].ID != third.ID {
+		t.Fatalf("expected dependents to follow the new pr, found: %+v", deps)
+	}
+
+	summary, err := NewPrSummarySchema(pr.Backend, pr, third.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Supersedes) != 1 || summary.Supersedes[0] != first.ID {
+		t.Fatalf("unexpected supersedes: %+v", summary.Supersedes)
+	}
+}
+
+func TestSupersedeFoldArchivedRepo(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	_, err := pr.CreateRepo(owner, "next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, err := CreatePatchRequest(pr.Backend, pr, owner, "alice/next", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ArchiveRepo(pr.Backend, pr, owner, "alice/next", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = SupersedePatchRequest(pr.Backend, pr, owner, prq.ID, newer.ID, true)
+	if err == nil || !strings.Contains(err.Error(), "repo is archived") {
+		t.Fatalf("expected folding into an archived repo to fail, found: %v", err)
+	}
+	patchsets, err := pr.GetPatchsetsByPrID(newer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patchsets) != 1 {
+		t.Fatalf("expected no patchsets to be folded, found: %d", len(patchsets))
+	}
+	old, err := pr.GetPatchRequestByID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Status != prq.Status {
+		t.Fatalf("expected the old pr to stay %s, found: %s", prq.Status, old.Status)
+	}
+}
+
+func TestRepoManagement(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	bob := createTestUser(t, pr, "bob")
+	carol := createTestUser(t, pr, "carol")
+	if _, err := pr.CreateRepo(owner, "taken"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RenameRepo(pr.Backend, pr, bob, "alice/test", "mine"); err == nil {
+		t.Fatal("expected other users to not rename the repo")
+	}
+	if _, err := RenameRepo(pr.Backend, pr, owner, "alice/test", "taken"); err == nil {
+		t.Fatal("expected a rename over an existing repo to be rejected")
+	}
+	if _, err := RenameRepo(pr.Backend, pr, owner, "alice/test", "a/b"); err == nil {
+		t.Fatal("expected an invalid name to be rejected")
+	}
+	repo, err := RenameRepo(pr.Backend, pr, owner, "alice/test", "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Name != "renamed" {
+		t.Fatalf("expected repo to be renamed, found: %s", repo.Name)
+	}
+
+	if _, err := TransferRepo(pr.Backend, pr, bob, "alice/renamed", "bob"); err == nil {
+		t.Fatal("expected other users to not transfer the repo")
+	}
+	if _, err := TransferRepo(pr.Backend, pr, owner, "alice/renamed", "nobody"); err == nil {
+		t.Fatal("expected a transfer to a missing user to be rejected")
+	}
+	repo, err = TransferRepo(pr.Backend, pr, owner, "alice/renamed", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.UserID != owner.ID || repo.TransferToID.Int64 != bob.ID {
+		t.Fatalf("expected the transfer to wait on the recipient, found: %+v", repo)
+	}
+	transfers, err := pr.GetRepoTransfersByUserID(bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 {
+		t.Fatalf("expected a pending transfer, found: %d", len(transfers))
+	}
+	if _, err := AcceptRepoTransfer(pr.Backend, pr, carol, "alice/renamed"); err == nil {
+		t.Fatal("expected other users to not accept the transfer")
+	}
+	repo, err = AcceptRepoTransfer(pr.Backend, pr, bob, "alice/renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.UserID != bob.ID || repo.TransferToID.Valid {
+		t.Fatalf("expected bob to own the repo, found: %+v", repo)
+	}
+	if _, err := CancelRepoTransfer(pr.Backend, pr, bob, "bob/renamed"); err == nil {
+		t.Fatal("expected cancel without a pending transfer to fail")
+	}
+
+	if _, err := ArchiveRepo(pr.Backend, pr, owner, "bob/renamed", true); err == nil {
+		t.Fatal("expected the previous owner to not archive the repo")
+	}
+	if _, err := ArchiveRepo(pr.Backend, pr, bob, "bob/renamed", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreatePatchRequest(pr.Backend, pr, carol, "bob/renamed", false, strings.NewReader(singlePatch(t))); err == nil {
+		t.Fatal("expected an archived repo to reject new prs")
+	}
+	if _, err := AddPatchset(pr.Backend, pr, owner, prq.ID, OpNormal, "", strings.NewReader(singlePatch(t))); err == nil {
+		t.Fatal("expected an archived repo to reject new patchsets")
+	}
+	if _, err := ArchiveRepo(pr.Backend, pr, bob, "bob/renamed", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreatePatchRequest(pr.Backend, pr, carol, "bob/renamed", false, strings.NewReader(singlePatch(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := pr.GetEventLogsByRepoID(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"repo_renamed", "repo_transfer_requested", "repo_transferred", "repo_archived", "repo_unarchived"} {
+		if !slices.ContainsFunc(events, func(e *EventLog) bool { return e.Event == expected }) {
+			t.Fatalf("expected a %s event", expected)
+		}
+	}
+}
@@ -0,0 +1,897 @@
+package git
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	API_PREFIX         = "/api/v1"
+	API_PER_PAGE       = 30
+	API_MAX_PER_PAGE   = 100
+	API_MAX_BODY_SIZE  = int64(10 * 1024 * 1024)
+	apiPathParamRe     = regexp.MustCompile(`{([a-z_]+)}`)
+	errApiNotFound     = errors.New("not found")
+	errApiUnauthorized = errors.New("must provide a valid access token with `Authorization: Bearer {token}`")
+)
+
+// PageSchema is included in every paginated response.
+type PageSchema struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+}
+
+// RepoListSchema is a page of repos.
+type RepoListSchema struct {
+	PageSchema
+	Items []*RepoSchema `json:"items"`
+}
+
+// PrListSchema is a page of patch requests.
+type PrListSchema struct {
+	PageSchema
+	Items []*PatchRequestSchema `json:"items"`
+}
+
+// EventListSchema is a page of event logs, newest first.
+type EventListSchema struct {
+	PageSchema
+	Items []EventMessage `json:"items"`
+}
+
+// AccountSchema is the user that owns the access token.
+type AccountSchema struct {
+	UserSchema
+	Emails []*UserEmailSchema `json:"emails"`
+}
+
+// PrStatusRequest is the body for accepting, closing, or reopening a patch
+// request.
+type PrStatusRequest struct {
+	Comment string `json:"comment,omitempty"`
+}
+
+// PrEditRequest is the body for editing a patch request.  Fields left out
+// are not changed.
+type PrEditRequest struct {
+	Title string  `json:"title,omitempty"`
+	Body  *string `json:"body,omitempty"`
+}
+
+type apiParam struct {
+	Name string
+	Desc string
+}
+
+// apiRoute is a single endpoint.  Routes are used to register handlers and
+// to generate the OpenAPI document so they cannot drift.  Anonymous requests
+// are allowed unless the route is private, when a token is provided it must
+// have the route's scope.
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	Query   []apiParam
+	Scope   string
+	Private bool
+	// Request is the json body, RawBody is a patchset from `git format-patch`
+	Request  any
+	RawBody  bool
+	Response any
+	Handler  func(web *WebCtx, r *http.Request, user *User) (any, error)
+}
+
+func (route *apiRoute) method() string {
+	if route.Method == "" {
+		return http.MethodGet
+	}
+	return route.Method
+}
+
+// apiHttpError lets a handler pick the status code of an error.
+type apiHttpError struct {
+	Status int
+	Err    error
+}
+
+func (e *apiHttpError) Error() string {
+	return e.Err.Error()
+}
+
+func apiStatusError(status int, err error) error {
+	return &apiHttpError{Status: status, Err: err}
+}
+
+func apiNotFound(err error) error {
+	return apiStatusError(http.StatusNotFound, err)
+}
+
+func apiBadRequest(format string, args ...any) error {
+	return apiStatusError(http.StatusBadRequest, fmt.Errorf(format, args...))
+}
+
+var pageParams = []apiParam{
+	{Name: "page", Desc: "Page number starting at 1"},
+	{Name: "per_page", Desc: fmt.Sprintf("Number of items per page, max %d", API_MAX_PER_PAGE)},
+}
+
+var apiRoutes = []*apiRoute{
+	{
+		Path:     "/repos",
+		Scope:    "repo:read",
+		Summary:  "List repos",
+		Query:    pageParams,
+		Response: RepoListSchema{},
+		Handler:  apiRepoList,
+	},
+	{
+		Path:     "/repos/{user}/{repo}",
+		Scope:    "repo:read",
+		Summary:  "Get a repo",
+		Response: RepoSchema{},
+		Handler:  apiRepoDetail,
+	},
+	{
+		Path:     "/repos/{user}/{repo}/labels",
+		Scope:    "repo:read",
+		Summary:  "List the labels of a repo",
+		Response: []LabelSchema{},
+		Handler:  apiRepoLabels,
+	},
+	{
+		Path:    "/prs",
+		Scope:   "pr:read",
+		Summary: "List patch requests, newest first",
+		Query: append([]apiParam{
+			{Name: "status", Desc: "Filter by status: open, closed, accepted, reviewed or draft"},
+			{Name: "repo", Desc: "Filter by repo name"},
+			{Name: "user", Desc: "Filter by the user that created the patch request"},
+			{Name: "title", Desc: "Filter by text contained in the title"},
+			{Name: "label", Desc: "Filter by label, repeat to require every label"},
+		}, pageParams...),
+		Response: PrListSchema{},
+		Handler:  apiPrList,
+	},
+	{
+		Path:     "/prs/{id}",
+		Scope:    "pr:read",
+		Summary:  "Get a patch request with its patchsets and latest patches",
+		Response: PrSummarySchema{},
+		Handler:  apiPrDetail,
+	},
+	{
+		Path:     "/prs/{id}/patchsets",
+		Scope:    "pr:read",
+		Summary:  "List patchsets for a patch request",
+		Response: []PatchsetSchema{},
+		Handler:  apiPrPatchsets,
+	},
+	{
+		Path:     "/prs/{id}/events",
+		Scope:    "pr:read",
+		Summary:  "List event logs for a patch request",
+		Query:    pageParams,
+		Response: EventListSchema{},
+		Handler:  apiPrEvents,
+	},
+	{
+		Path:     "/patchsets/{id}",
+		Scope:    "pr:read",
+		Summary:  "Get a patchset",
+		Response: PatchsetSchema{},
+		Handler:  apiPatchsetDetail,
+	},
+	{
+		Path:     "/patchsets/{id}/patches",
+		Scope:    "pr:read",
+		Summary:  "List patches in a patchset with parsed files and diffstat",
+		Response: []PatchDetailSchema{},
+		Handler:  apiPatchsetPatches,
+	},
+	{
+		Path:    "/rangediff/{from}/{to}",
+		Scope:   "pr:read",
+		Summary: "Range diff between any two patchsets",
+		Query: []apiParam{
+			{Name: "normalize", Desc: "Only diff the +/- lines of each file, ignoring context and hunk headers"},
+			{Name: "creation_factor", Desc: "Percentage of a patch's size pairing it with another patch may cost before it is shown as removed and added"},
+			{Name: "cost_max", Desc: "Cost of pairing patches that cannot be matched"},
+			{Name: "message_only", Desc: "Only show changes to commit messages"},
+		},
+		Response: []RangeDiffSchema{},
+		Handler:  apiRangeDiff,
+	},
+	{
+		Path:    "/events",
+		Scope:   "pr:read",
+		Summary: "List event logs",
+		Query: append([]apiParam{
+			{Name: "repo", Desc: "Filter by repo name"},
+		}, pageParams...),
+		Response: EventListSchema{},
+		Handler:  apiEventList,
+	},
+	{
+		Path:     "/user",
+		Scope:    "user:read",
+		Summary:  "Get the user that owns the access token",
+		Private:  true,
+		Response: AccountSchema{},
+		Handler:  apiAccount,
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/repos/{user}/{repo}/prs",
+		Summary: "Create a patch request from the output of `git format-patch`",
+		Query: []apiParam{
+			{Name: "draft", Desc: "Set to true to create a draft patch request"},
+		},
+		Scope:    "pr:write",
+		Private:  true,
+		RawBody:  true,
+		Response: PrSummarySchema{},
+		Handler:  apiPrCreate,
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/prs/{id}/patchsets",
+		Summary: "Add a patchset from the output of `git format-patch`",
+		Query: []apiParam{
+			{Name: "op", Desc: "Mark the patchset as a review, or accept or close the patch request with it"},
+			{Name: "comment", Desc: "Comment for when the patch request status changes"},
+		},
+		Scope:    "pr:write",
+		Private:  true,
+		RawBody:  true,
+		Response: PrSummarySchema{},
+		Handler:  apiPatchsetCreate,
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/prs/{id}/accept",
+		Summary:  "Accept a patch request",
+		Scope:    "pr:write",
+		Private:  true,
+		Request:  PrStatusRequest{},
+		Response: PrSummarySchema{},
+		Handler:  apiPrStatusHandler(StatusAccepted),
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/prs/{id}/close",
+		Summary:  "Close a patch request",
+		Scope:    "pr:write",
+		Private:  true,
+		Request:  PrStatusRequest{},
+		Response: PrSummarySchema{},
+		Handler:  apiPrStatusHandler(StatusClosed),
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/prs/{id}/reopen",
+		Summary:  "Reopen a patch request",
+		Scope:    "pr:write",
+		Private:  true,
+		Request:  PrStatusRequest{},
+		Response: PrSummarySchema{},
+		Handler:  apiPrStatusHandler(StatusOpen),
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/prs/{id}/ready",
+		Summary:  "Mark a draft patch request as ready for review",
+		Scope:    "pr:write",
+		Private:  true,
+		Response: PrSummarySchema{},
+		Handler:  apiPrReady,
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/prs/{id}/approve",
+		Summary:  "Approve the latest patchset of a patch request",
+		Scope:    "pr:write",
+		Private:  true,
+		Request:  PrStatusRequest{},
+		Response: PrSummarySchema{},
+		Handler:  apiPrApprove,
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/prs/{id}/unapprove",
+		Summary:  "Withdraw an approval of a patch request",
+		Scope:    "pr:write",
+		Private:  true,
+		Response: PrSummarySchema{},
+		Handler:  apiPrUnapprove,
+	},
+	{
+		Method:   http.MethodPatch,
+		Path:     "/prs/{id}",
+		Summary:  "Edit a patch request",
+		Scope:    "pr:write",
+		Private:  true,
+		Request:  PrEditRequest{},
+		Response: PrSummarySchema{},
+		Handler:  apiPrEdit,
+	},
+}
+
+func apiJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, err error) {
+	apiJSON(w, status, ErrorSchema{Error: err.Error()})
+}
+
+// apiAuth returns the bearer token and its owner.  Requests without a token
+// are anonymous and return nil.
+func apiAuth(web *WebCtx, r *http.Request) (*User, *AccessToken, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil, nil
+	}
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		return nil, nil, errApiUnauthorized
+	}
+	accessToken, err := web.Pr.GetAccessToken(strings.TrimSpace(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, errApiUnauthorized
+	} else if err != nil {
+		return nil, nil, err
+	}
+	user, err := web.Pr.GetUserByID(accessToken.UserID)
+	if err != nil {
+		return nil, nil, errApiUnauthorized
+	}
+	return user, accessToken, nil
+}
+
+func apiHandler(route *apiRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		web, err := getWebCtx(r)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		user, accessToken, err := apiAuth(web, r)
+		if err != nil {
+			apiError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if route.Private && user == nil {
+			apiError(w, http.StatusUnauthorized, errApiUnauthorized)
+			return
+		}
+		if accessToken != nil && route.Scope != "" && !accessToken.HasScope(route.Scope) {
+			apiError(w, http.StatusForbidden, fmt.Errorf("access token is missing scope: %s", route.Scope))
+			return
+		}
+
+		data, err := route.Handler(web, r, user)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var httpErr *apiHttpError
+			var aclErr *AclError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Status
+			} else if errors.As(err, &aclErr) {
+				status = http.StatusForbidden
+			} else if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+				err = errApiNotFound
+			} else {
+				web.Logger.Error("api request failed", "path", r.URL.Path, "err", err)
+			}
+			apiError(w, status, err)
+			return
+		}
+		apiJSON(w, http.StatusOK, data)
+	}
+}
+
+func apiFallbackHandler(w http.ResponseWriter, r *http.Request) {
+	apiError(w, http.StatusNotFound, errApiNotFound)
+}
+
+func openapiHandler(w http.ResponseWriter, r *http.Request) {
+	web, err := getWebCtx(r)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	apiJSON(w, http.StatusOK, NewOpenAPI(web.Backend.Cfg, apiRoutes))
+}
+
+func registerApiRoutes(ctx context.Context, mux *http.ServeMux) {
+	for _, route := range apiRoutes {
+		mux.HandleFunc(route.method()+" "+API_PREFIX+route.Path, ctxMdw(ctx, apiHandler(route)))
+	}
+	mux.HandleFunc("GET "+API_PREFIX+"/openapi.json", ctxMdw(ctx, openapiHandler))
+	// a method-less pattern would conflict with the web routes on "GET /"
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		mux.HandleFunc(method+" "+API_PREFIX+"/", ctxMdw(ctx, apiFallbackHandler))
+	}
+}
+
+func apiPathID(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil {
+		return 0, apiBadRequest("%s must be an integer", name)
+	}
+	return id, nil
+}
+
+// apiPaginate returns the page of items requested by `page` and `per_page`.
+func apiPaginate[T any](r *http.Request, items []T) ([]T, PageSchema, error) {
+	query := r.URL.Query()
+	page := PageSchema{Page: 1, PerPage: API_PER_PAGE, Total: len(items)}
+	if p := query.Get("page"); p != "" {
+		num, err := strconv.Atoi(p)
+		if err != nil || num < 1 {
+			return nil, page, apiBadRequest("page must be a positive integer")
+		}
+		page.Page = num
+	}
+	if p := query.Get("per_page"); p != "" {
+		num, err := strconv.Atoi(p)
+		if err != nil || num < 1 || num > API_MAX_PER_PAGE {
+			return nil, page, apiBadRequest("per_page must be between 1 and %d", API_MAX_PER_PAGE)
+		}
+		page.PerPage = num
+	}
+
+	// pages past the end are empty, checked before multiplying so a huge
+	// page cannot overflow
+	start := len(items)
+	if page.Page-1 < len(items)/page.PerPage+1 {
+		start = min((page.Page-1)*page.PerPage, len(items))
+	}
+	end := min(start+page.PerPage, len(items))
+	return items[start:end], page, nil
+}
+
+func apiFindRepo(web *WebCtx, userName, repoName string) (*Repo, error) {
+	user, err := web.Pr.GetUserByName(userName)
+	if err != nil {
+		return nil, apiNotFound(fmt.Errorf("user not found: %s", userName))
+	}
+	repo, err := web.Pr.GetRepoByName(user, repoName)
+	if err != nil {
+		return nil, apiNotFound(err)
+	}
+	return repo, nil
+}
+
+func apiFindRepoNs(web *WebCtx, repoNs string) (*Repo, error) {
+	userName, repoName := web.Backend.SplitRepoNs(repoNs)
+	if userName == "" {
+		repo, err := web.Pr.GetRepoByName(nil, repoName)
+		if err != nil {
+			return nil, apiNotFound(err)
+		}
+		return repo, nil
+	}
+	return apiFindRepo(web, userName, repoName)
+}
+
+func apiRepoList(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	repos, err := web.Pr.GetRepos()
+	if err != nil {
+		// GetRepos returns an error when there are no repos
+		repos = []*Repo{}
+	}
+	repos, page, err := apiPaginate(r, repos)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &RepoListSchema{PageSchema: page, Items: []*RepoSchema{}}
+	for _, repo := range repos {
+		item, err := NewRepoSchema(web.Backend, web.Pr, repo)
+		if err != nil {
+			return nil, err
+		}
+		out.Items = append(out.Items, item)
+	}
+	return out, nil
+}
+
+func apiRepoDetail(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	repo, err := apiFindRepo(web, r.PathValue("user"), r.PathValue("repo"))
+	if err != nil {
+		return nil, err
+	}
+	return NewRepoSchema(web.Backend, web.Pr, repo)
+}
+
+func apiRepoLabels(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	repo, err := apiFindRepo(web, r.PathValue("user"), r.PathValue("repo"))
+	if err != nil {
+		return nil, err
+	}
+	labels, err := web.Pr.GetLabelsByRepoID(repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := []*LabelSchema{}
+	for _, label := range labels {
+		out = append(out, NewLabelSchema(label))
+	}
+	return out, nil
+}
+
+func apiPrList(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	query := r.URL.Query()
+	status := Status(strings.ToLower(query.Get("status")))
+	userName := strings.ToLower(query.Get("user"))
+	title := strings.ToLower(query.Get("title"))
+	labelFilters := query["label"]
+
+	var prs []*PatchRequest
+	var err error
+	if repoNs := query.Get("repo"); repoNs != "" {
+		repo, err := apiFindRepoNs(web, repoNs)
+		if err != nil {
+			return nil, err
+		}
+		prs, err = web.Pr.GetPatchRequestsByRepoID(repo.ID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		prs, err = web.Pr.GetPatchRequests()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	items := []*PatchRequestSchema{}
+	for _, prq := range prs {
+		if status != "" && prq.Status != status {
+			continue
+		}
+		if title != "" && !strings.Contains(strings.ToLower(prq.Name), title) {
+			continue
+		}
+		item, err := NewPatchRequestSchema(web.Backend, web.Pr, prq)
+		if err != nil {
+			web.Logger.Error("cannot build pr schema", "id", prq.ID, "err", err)
+			continue
+		}
+		if userName != "" && userName != strings.ToLower(item.User) {
+			continue
+		}
+		missingLabel := slices.ContainsFunc(labelFilters, func(name string) bool {
+			return !slices.Contains(item.Labels, name)
+		})
+		if missingLabel {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	items, page, err := apiPaginate(r, items)
+	if err != nil {
+		return nil, err
+	}
+	return &PrListSchema{PageSchema: page, Items: items}, nil
+}
+
+func apiPrDetail(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	prID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	return NewPrSummarySchema(web.Backend, web.Pr, prID)
+}
+
+func apiPrPatchsets(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	prID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := web.Pr.GetPatchsetsByPrID(prID)
+	if err != nil {
+		return nil, apiNotFound(err)
+	}
+	out := []*PatchsetSchema{}
+	for _, patchset := range patchsets {
+		ps, err := NewPatchsetSchema(web.Pr, patchset)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ps)
+	}
+	return out, nil
+}
+
+func apiEventListSchema(r *http.Request, eventLogs []*EventLog) (any, error) {
+	eventLogs, page, err := apiPaginate(r, eventLogs)
+	if err != nil {
+		return nil, err
+	}
+	out := &EventListSchema{PageSchema: page, Items: []EventMessage{}}
+	for _, eventLog := range eventLogs {
+		out.Items = append(out.Items, NewEventMessage(eventLog))
+	}
+	return out, nil
+}
+
+func apiPrEvents(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	prID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	_, err = web.Pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+	eventLogs, err := web.Pr.GetEventLogsByPrID(prID)
+	if err != nil {
+		return nil, err
+	}
+	return apiEventListSchema(r, eventLogs)
+}
+
+func apiEventList(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	var eventLogs []*EventLog
+	var err error
+	if repoNs := r.URL.Query().Get("repo"); repoNs != "" {
+		repo, err := apiFindRepoNs(web, repoNs)
+		if err != nil {
+			return nil, err
+		}
+		eventLogs, err = web.Pr.GetEventLogsByRepoID(repo.ID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		eventLogs, err = web.Pr.GetEventLogs()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return apiEventListSchema(r, eventLogs)
+}
+
+func apiPatchsetDetail(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	psID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	patchset, err := web.Pr.GetPatchsetByID(psID)
+	if err != nil {
+		return nil, err
+	}
+	return NewPatchsetSchema(web.Pr, patchset)
+}
+
+func apiPatchsetPatches(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	psID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	_, err = web.Pr.GetPatchsetByID(psID)
+	if err != nil {
+		return nil, err
+	}
+	patches, err := web.Pr.GetPatchesByPatchsetID(psID)
+	if err != nil {
+		return nil, err
+	}
+	out := []*PatchDetailSchema{}
+	for _, patch := range patches {
+		item, err := NewPatchDetailSchema(patch)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+	return out, nil
+}
+
+func apiRangeDiff(web *WebCtx, r *http.Request, _ *User) (any, error) {
+	fromID, err := apiPathID(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	toID, err := apiPathID(r, "to")
+	if err != nil {
+		return nil, err
+	}
+	from, err := web.Pr.GetPatchsetByID(fromID)
+	if err != nil {
+		return nil, apiNotFound(fmt.Errorf("patchset not found: %d", fromID))
+	}
+	to, err := web.Pr.GetPatchsetByID(toID)
+	if err != nil {
+		return nil, apiNotFound(fmt.Errorf("patchset not found: %d", toID))
+	}
+	opts, err := getPatchsetRangeDiffOpts(web.Pr, to)
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := web.Pr.DiffPatchsets(from, to, getRangeDiffOpts(r, opts))
+	if err != nil {
+		return nil, err
+	}
+	return NewRangeDiffSchemas(diffs), nil
+}
+
+func apiAccount(web *WebCtx, _ *http.Request, user *User) (any, error) {
+	emails, err := web.Pr.GetUserEmails(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := &AccountSchema{
+		UserSchema: *NewUserSchema(user),
+		Emails:     []*UserEmailSchema{},
+	}
+	for _, email := range emails {
+		out.Emails = append(out.Emails, NewUserEmailSchema(email))
+	}
+	return out, nil
+}
+
+// apiWriteError marks errors from write actions, like invalid patches, as
+// the client's fault.
+func apiWriteError(err error) error {
+	var aclErr *AclError
+	if errors.As(err, &aclErr) || errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return apiStatusError(http.StatusUnprocessableEntity, err)
+}
+
+func apiDecode(r *http.Request, v any) error {
+	body := http.MaxBytesReader(nil, r.Body, API_MAX_BODY_SIZE)
+	err := json.NewDecoder(body).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return apiBadRequest("invalid json body: %s", err)
+	}
+	return nil
+}
+
+func apiPrCreate(web *WebCtx, r *http.Request, user *User) (any, error) {
+	repoNs := fmt.Sprintf("%s/%s", r.PathValue("user"), r.PathValue("repo"))
+	body := http.MaxBytesReader(nil, r.Body, API_MAX_BODY_SIZE)
+	draft := r.URL.Query().Get("draft") == "true"
+	prq, err := CreatePatchRequest(web.Backend, web.Pr, user, repoNs, draft, body)
+	if err != nil {
+		return nil, apiWriteError(err)
+	}
+	return NewPrSummarySchema(web.Backend, web.Pr, prq.ID)
+}
+
+func apiPatchsetCreate(web *WebCtx, r *http.Request, user *User) (any, error) {
+	prID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	op := OpNormal
+	switch query.Get("op") {
+	case "":
+	case "review":
+		op = OpReview
+	case "accept":
+		op = OpAccept
+	case "close":
+		op = OpClose
+	default:
+		return nil, apiBadRequest("op must be one of: review, accept, close")
+	}
+
+	body := http.MaxBytesReader(nil, r.Body, API_MAX_BODY_SIZE)
+	_, err = AddPatchset(web.Backend, web.Pr, user, prID, op, query.Get("comment"), body)
+	if err != nil {
+		return nil, apiWriteError(err)
+	}
+	return NewPrSummarySchema(web.Backend, web.Pr, prID)
+}
+
+func apiPrStatusHandler(status Status) func(web *WebCtx, r *http.Request, user *User) (any, error) {
+	return func(web *WebCtx, r *http.Request, user *User) (any, error) {
+		prID, err := apiPathID(r, "id")
+		if err != nil {
+			return nil, err
+		}
+		var req PrStatusRequest
+		if err := apiDecode(r, &req); err != nil {
+			return nil, err
+		}
+		_, err = ChangePatchRequestStatus(web.Backend, web.Pr, user, prID, status, req.Comment)
+		if err != nil {
+			return nil, apiWriteError(err)
+		}
+		return NewPrSummarySchema(web.Backend, web.Pr, prID)
+	}
+}
+
+func apiPrReady(web *WebCtx, r *http.Request, user *User) (any, error) {
+	prID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	_, err = MarkPatchRequestReady(web.Backend, web.Pr, user, prID)
+	if err != nil {
+		return nil, apiWriteError(err)
+	}
+	return NewPrSummarySchema(web.Backend, web.Pr, prID)
+}
+
+func apiPrApprove(web *WebCtx, r *http.Request, user *User) (any, error) {
+	prID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	var req PrStatusRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	_, err = ApprovePatchRequest(web.Backend, web.Pr, user, prID, req.Comment)
+	if err != nil {
+		return nil, apiWriteError(err)
+	}
+	return NewPrSummarySchema(web.Backend, web.Pr, prID)
+}
+
+func apiPrUnapprove(web *WebCtx, r *http.Request, user *User) (any, error) {
+	prID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	_, err = UnapprovePatchRequest(web.Backend, web.Pr, user, prID)
+	if err != nil {
+		return nil, apiWriteError(err)
+	}
+	return NewPrSummarySchema(web.Backend, web.Pr, prID)
+}
+
+func apiPrEdit(web *WebCtx, r *http.Request, user *User) (any, error) {
+	prID, err := apiPathID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	var req PrEditRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	title := strings.TrimSpace(req.Title)
+	if title == "" && req.Body == nil {
+		return nil, apiStatusError(http.StatusUnprocessableEntity, fmt.Errorf("must provide title or body"))
+	}
+	if req.Body != nil {
+		_, err = EditPatchRequestBody(web.Backend, web.Pr, user, prID, *req.Body)
+		if err != nil {
+			return nil, apiWriteError(err)
+		}
+	}
+	if title != "" {
+		_, err = EditPatchRequest(web.Backend, web.Pr, user, prID, title)
+		if err != nil {
+			return nil, apiWriteError(err)
+		}
+	}
+	return NewPrSummarySchema(web.Backend, web.Pr, prID)
+}
@@ -0,0 +1,459 @@
+package git
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupTestApi(t testing.TB) (*PrCmd, *User, *PatchRequest, http.Handler) {
+	t.Helper()
+	pr, owner, prq := setupTestRepo(t)
+	web := &WebCtx{Pr: pr, Backend: pr.Backend, Logger: pr.Backend.Logger}
+	mux := http.NewServeMux()
+	registerApiRoutes(setWebCtx(context.Background(), web), mux)
+	return pr, owner, prq, mux
+}
+
+func apiGet(t *testing.T, handler http.Handler, path, token string, v any) int {
+	t.Helper()
+	return apiDo(t, handler, "GET", path, token, "", v)
+}
+
+func apiDo(t *testing.T, handler http.Handler, method, path, token, body string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s: wrong content type: %s", path, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+	return rec.Code
+}
+
+func TestApiPrList(t *testing.T) {
+	_, _, prq, handler := setupTestApi(t)
+
+	var prs PrListSchema
+	code := apiGet(t, handler, "/api/v1/prs?status=open&repo=alice/test", "", &prs)
+	if code != http.StatusOK {
+		t.Fatalf("wrong status: %d", code)
+	}
+	if prs.Total != 1 || len(prs.Items) != 1 || prs.Items[0].ID != prq.ID {
+		t.Fatalf("expected pr %d, found: %+v", prq.ID, prs)
+	}
+
+	code = apiGet(t, handler, "/api/v1/prs?status=closed", "", &prs)
+	if code != http.StatusOK || prs.Total != 0 || prs.Items == nil {
+		t.Fatalf("expected empty list, found: %+v", prs)
+	}
+
+	var apiErr ErrorSchema
+	code = apiGet(t, handler, "/api/v1/prs?per_page=1000", "", &apiErr)
+	if code != http.StatusBadRequest || apiErr.Error == "" {
+		t.Fatalf("expected bad request, found: %d %+v", code, apiErr)
+	}
+}
+
+func TestApiPagination(t *testing.T) {
+	_, _, prq, handler := setupTestApi(t)
+
+	for _, query := range []string{
+		"page=-1",
+		"page=0",
+		"per_page=-1",
+		"per_page=0",
+		"per_page=9223372036854775807",
+		"page=99999999999999999999",
+	} {
+		var apiErr ErrorSchema
+		code := apiGet(t, handler, "/api/v1/prs?"+query, "", &apiErr)
+		if code != http.StatusBadRequest || apiErr.Error == "" {
+			t.Fatalf("%s: expected bad request, found: %d %+v", query, code, apiErr)
+		}
+	}
+
+	// pages past the end are empty, even when they would overflow
+	for _, query := range []string{
+		"page=2",
+		"page=9223372036854775807",
+		"page=9223372036854775807&per_page=100",
+		"page=4611686018427387904&per_page=2",
+	} {
+		var prs PrListSchema
+		code := apiGet(t, handler, "/api/v1/prs?"+query, "", &prs)
+		if code != http.StatusOK || prs.Total != 1 || len(prs.Items) != 0 {
+			t.Fatalf("%s: expected empty page, found: %d %+v", query, code, prs)
+		}
+	}
+
+	var prs PrListSchema
+	code := apiGet(t, handler, "/api/v1/prs?page=1&per_page=1", "", &prs)
+	if code != http.StatusOK || len(prs.Items) != 1 || prs.Items[0].ID != prq.ID {
+		t.Fatalf("expected first page, found: %d %+v", code, prs)
+	}
+}
+
+func TestApiPatchsetPatches(t *testing.T) {
+	pr, _, prq, handler := setupTestApi(t)
+	patchset, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var patches []*PatchDetailSchema
+	code := apiGet(t, handler, fmt.Sprintf("/api/v1/patchsets/%d/patches", patchset.ID), "", &patches)
+	if code != http.StatusOK {
+		t.Fatalf("wrong status: %d", code)
+	}
+	if len(patches) != 1 {
+		t.Fatalf("expected 1 patch, found %d", len(patches))
+	}
+	stat := patches[0].Stat
+	if stat.Files != len(patches[0].Files) || stat.Files == 0 || stat.Additions == 0 {
+		t.Fatalf("wrong diffstat: %+v", stat)
+	}
+}
+
+func TestApiNotFound(t *testing.T) {
+	_, _, _, handler := setupTestApi(t)
+	for _, path := range []string{"/api/v1/prs/999", "/api/v1/repos/alice/nope", "/api/v1/nope"} {
+		var apiErr ErrorSchema
+		code := apiGet(t, handler, path, "", &apiErr)
+		if code != http.StatusNotFound || apiErr.Error == "" {
+			t.Fatalf("%s: expected not found, found: %d %+v", path, code, apiErr)
+		}
+	}
+}
+
+func TestApiPrivateRequiresToken(t *testing.T) {
+	pr, owner, _, handler := setupTestApi(t)
+	_, err := pr.AddUserEmail(owner.ID, "alice@pr.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var apiErr ErrorSchema
+	if code := apiGet(t, handler, "/api/v1/user", "", &apiErr); code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized without token, found: %d", code)
+	}
+	if code := apiGet(t, handler, "/api/v1/user", "gpr_nope", &apiErr); code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized with bad token, found: %d", code)
+	}
+
+	_, token, err := pr.CreateAccessToken(owner.ID, "editor", nil, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var account AccountSchema
+	if code := apiGet(t, handler, "/api/v1/user", token, &account); code != http.StatusOK {
+		t.Fatalf("wrong status: %d", code)
+	}
+	if account.Name != "alice" || len(account.Emails) != 1 {
+		t.Fatalf("wrong account: %+v", account)
+	}
+
+	tokens, err := pr.GetAccessTokens(owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokens[0].LastUsedAt.Valid {
+		t.Fatal("expected token last used to be recorded")
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	_, _, _, handler := setupTestApi(t)
+	var doc struct {
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if code := apiGet(t, handler, "/api/v1/openapi.json", "", &doc); code != http.StatusOK {
+		t.Fatalf("wrong status: %d", code)
+	}
+	numOps := 0
+	for _, ops := range doc.Paths {
+		numOps += len(ops)
+	}
+	if numOps != len(apiRoutes) {
+		t.Fatalf("expected %d operations, found %d", len(apiRoutes), numOps)
+	}
+	if _, ok := doc.Paths["/api/v1/prs/{id}"]["patch"]; !ok {
+		t.Fatal("missing edit operation")
+	}
+	prList, ok := doc.Components.Schemas["PrListSchema"]
+	if !ok {
+		t.Fatal("missing PrListSchema component")
+	}
+	// embedded structs are flattened
+	for _, prop := range []string{"page", "per_page", "total", "items"} {
+		if _, ok := prList.Properties[prop]; !ok {
+			t.Fatalf("PrListSchema missing property: %s", prop)
+		}
+	}
+}
+
+func TestAccessTokenHasScope(t *testing.T) {
+	token := &AccessToken{Scopes: "repo:read,pr:write"}
+	for scope, expected := range map[string]bool{
+		"repo:read": true,
+		"pr:write":  true,
+		"pr:read":   true,
+		"user:read": false,
+	} {
+		if token.HasScope(scope) != expected {
+			t.Fatalf("%s: expected %t", scope, expected)
+		}
+	}
+}
+
+func TestApiWriteScopes(t *testing.T) {
+	pr, owner, prq, handler := setupTestApi(t)
+	_, readToken, err := pr.CreateAccessToken(owner.ID, "read", nil, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiErr ErrorSchema
+	path := fmt.Sprintf("/api/v1/prs/%d/accept", prq.ID)
+	if code := apiDo(t, handler, "POST", path, readToken, "", &apiErr); code != http.StatusForbidden {
+		t.Fatalf("expected read token to be forbidden, found: %d", code)
+	}
+	if code := apiDo(t, handler, "POST", path, "", "", &apiErr); code != http.StatusUnauthorized {
+		t.Fatalf("expected anonymous write to be unauthorized, found: %d", code)
+	}
+
+	expired := sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}
+	_, expiredToken, err := pr.CreateAccessToken(owner.ID, "expired", []string{"pr:write"}, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := apiDo(t, handler, "POST", path, expiredToken, "", &apiErr); code != http.StatusUnauthorized {
+		t.Fatalf("expected expired token to be unauthorized, found: %d", code)
+	}
+
+	_, _, err = pr.CreateAccessToken(owner.ID, "bad", []string{"repo:write"}, sql.NullTime{})
+	if err == nil {
+		t.Fatal("expected unknown scope to be rejected")
+	}
+}
+
+func TestApiWriteActions(t *testing.T) {
+	pr, owner, _, handler := setupTestApi(t)
+	contrib := createTestUser(t, pr, "bob")
+	_, bobToken, err := pr.CreateAccessToken(contrib.ID, "ci", []string{"pr:write"}, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, aliceToken, err := pr.CreateAccessToken(owner.ID, "ide", []string{"pr:write"}, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary PrSummarySchema
+	code := apiDo(t, handler, "POST", "/api/v1/repos/alice/test/prs", bobToken, singlePatch(t), &summary)
+	if code != http.StatusOK {
+		t.Fatalf("wrong status: %d", code)
+	}
+	if summary.User != "bob" || summary.Status != StatusOpen {
+		t.Fatalf("wrong pr: %+v", summary)
+	}
+	prPath := fmt.Sprintf("/api/v1/prs/%d", summary.ID)
+
+	var apiErr ErrorSchema
+	code = apiDo(t, handler, "POST", "/api/v1/repos/alice/test/prs", bobToken, "not a patch", &apiErr)
+	if code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected invalid patch to be rejected, found: %d %+v", code, apiErr)
+	}
+
+	code = apiDo(t, handler, "PATCH", prPath, bobToken, `{"title": "better title"}`, &summary)
+	if code != http.StatusOK || summary.Name != "better title" {
+		t.Fatalf("expected title to be edited, found: %d %s", code, summary.Name)
+	}
+
+	// same acl as ssh: contributors cannot accept their own pr
+	code = apiDo(t, handler, "POST", prPath+"/accept", bobToken, "", &apiErr)
+	if code != http.StatusForbidden {
+		t.Fatalf("expected contributor accept to be forbidden, found: %d", code)
+	}
+	code = apiDo(t, handler, "POST", prPath+"/patchsets?op=accept", bobToken, singlePatch(t), &apiErr)
+	if code != http.StatusForbidden {
+		t.Fatalf("expected contributor accept with patchset to be forbidden, found: %d", code)
+	}
+
+	code = apiDo(t, handler, "POST", prPath+"/accept", aliceToken, `{"comment": "lgtm"}`, &summary)
+	if code != http.StatusOK || summary.Status != StatusAccepted {
+		t.Fatalf("expected pr to be accepted, found: %d %s", code, summary.Status)
+	}
+
+	eventLogs, err := pr.GetEventLogsByPrID(summary.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepted *EventLog
+	for _, eventLog := range eventLogs {
+		if eventLog.Event == "pr_status_changed" && eventLog.Data.Status == StatusAccepted {
+			accepted = eventLog
+		}
+	}
+	if accepted == nil || accepted.UserID != owner.ID || accepted.Data.Comment != "lgtm" {
+		t.Fatalf("expected accept event log from owner, found: %+v", accepted)
+	}
+
+	code = apiDo(t, handler, "POST", prPath+"/reopen", bobToken, "", &summary)
+	if code != http.StatusOK || summary.Status != StatusOpen {
+		t.Fatalf("expected pr to be reopened, found: %d %s", code, summary.Status)
+	}
+}
+
+func TestApiLabels(t *testing.T) {
+	pr, owner, prq, handler := setupTestApi(t)
+	for _, name := range []string{"bug", "wip"} {
+		if _, err := CreateRepoLabel(pr.Backend, pr, owner, "alice/test", name, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := ChangePatchRequestLabels(pr.Backend, pr, owner, prq.ID, []string{"+bug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prs PrListSchema
+	apiGet(t, handler, "/api/v1/prs?label=bug", "", &prs)
+	if prs.Total != 1 || strings.Join(prs.Items[0].Labels, ",") != "bug" {
+		t.Fatalf("expected pr with bug label, found: %+v", prs)
+	}
+	apiGet(t, handler, "/api/v1/prs?label=bug&label=wip", "", &prs)
+	if prs.Total != 0 {
+		t.Fatalf("expected every label to be required, found: %+v", prs)
+	}
+	var labels []LabelSchema
+	code := apiGet(t, handler, "/api/v1/repos/alice/test/labels", "", &labels)
+	if code != http.StatusOK || len(labels) != 2 || labels[0].Name != "bug" {
+		t.Fatalf("expected repo labels, found: %d %+v", code, labels)
+	}
+}
+
+func TestApiApprove(t *testing.T) {
+	pr, owner, _, handler := setupTestApi(t)
+	repo, err := pr.GetRepoByName(owner, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := createTestUser(t, pr, "bob")
+	prq, err := pr.SubmitPatchRequest(repo.ID, bob.ID, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.RequiredApprovals = 1
+	err = pr.UpdateRepo(repo, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary PrSummarySchema
+	path := fmt.Sprintf("/api/v1/prs/%d/approve", prq.ID)
+	if code := apiDo(t, handler, "POST", path, "", "", &summary); code != http.StatusUnauthorized {
+		t.Fatalf("expected anonymous approvals to be rejected, found: %d", code)
+	}
+	_, bobToken, err := pr.CreateAccessToken(bob.ID, "ci", []string{"pr:write"}, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := apiDo(t, handler, "POST", path, bobToken, "", &summary); code != http.StatusForbidden {
+		t.Fatalf("expected the author to not approve, found: %d", code)
+	}
+	_, token, err := pr.CreateAccessToken(owner.ID, "ci", []string{"pr:write"}, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := apiDo(t, handler, "POST", path, token, "", &summary); code != http.StatusOK {
+		t.Fatalf("wrong status: %d", code)
+	}
+	if summary.Approvals.Count != 1 || summary.Approvals.Required != 1 {
+		t.Fatalf("unexpected approvals: %+v", summary.Approvals)
+	}
+}
+
+func TestApiDependencies(t *testing.T) {
+	pr, _, first, handler := setupTestApi(t)
+	bob := createTestUser(t, pr, "bob")
+	second, err := CreatePatchRequest(pr.Backend, pr, bob, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := CreatePatchRequest(pr.Backend, pr, bob, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ChangePatchRequestDependency(pr.Backend, pr, bob, second.ID, first.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ChangePatchRequestDependency(pr.Backend, pr, bob, third.ID, second.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary PrSummarySchema
+	apiGet(t, handler, fmt.Sprintf("/api/v1/prs/%d", second.ID), "", &summary)
+	if fmt.Sprint(summary.DependsOn, summary.RequiredBy) != fmt.Sprintf("[%d] [%d]", first.ID, third.ID) {
+		t.Fatalf("unexpected dependencies: %v %v", summary.DependsOn, summary.RequiredBy)
+	}
+}
+
+func TestApiComments(t *testing.T) {
+	pr, owner, prq, handler := setupTestApi(t)
+	bob := createTestUser(t, pr, "bob")
+	question, err := CreateComment(pr.Backend, pr, bob, prq.ID, 0, "does this handle unicode?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateComment(pr.Backend, pr, owner, prq.ID, question.ID, "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary PrSummarySchema
+	apiGet(t, handler, fmt.Sprintf("/api/v1/prs/%d", prq.ID), "", &summary)
+	if len(summary.Comments) != 2 || summary.Comments[1].ReplyTo != question.ID {
+		t.Fatalf("unexpected comments: %+v", summary.Comments)
+	}
+}
+
+func TestApiEditBody(t *testing.T) {
+	pr, owner, prq, handler := setupTestApi(t)
+	_, err := EditPatchRequest(pr.Backend, pr, owner, prq.ID, "unicode fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary PrSummarySchema
+	code := apiDo(t, handler, "PATCH", fmt.Sprintf("/api/v1/prs/%d", prq.ID), "", `{"body": "api"}`, &summary)
+	if code != http.StatusUnauthorized {
+		t.Fatalf("expected anonymous edits to be rejected, found: %d", code)
+	}
+	_, token, err := pr.CreateAccessToken(owner.ID, "ci", []string{"pr:write"}, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code = apiDo(t, handler, "PATCH", fmt.Sprintf("/api/v1/prs/%d", prq.ID), token, `{"body": "from the api"}`, &summary)
+	if code != http.StatusOK || summary.Text != "from the api" || summary.Name != "unicode fix" {
+		t.Fatalf("expected only the body to change, found: %d %+v", code, summary.PatchRequestSchema)
+	}
+}
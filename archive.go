@@ -0,0 +1,450 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var mboxFromRe = regexp.MustCompile(`^>*From `)
+
+// archiveMessage is a single email inside a repo archive.
+type archiveMessage struct {
+	From      *mail.Address
+	To        string
+	ListID    string
+	Date      time.Time
+	Subject   string
+	MessageID string
+	InReplyTo string
+	URL       string
+	Body      string
+}
+
+// writeMbox writes the message in mboxrd format.
+func (m *archiveMessage) writeMbox(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintf(bw, "From %s %s\n", m.From.Address, m.Date.UTC().Format(time.ANSIC))
+	_, _ = fmt.Fprintf(bw, "From: %s\n", m.From.String())
+	_, _ = fmt.Fprintf(bw, "To: %s\n", m.To)
+	_, _ = fmt.Fprintf(bw, "Subject: %s\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	_, _ = fmt.Fprintf(bw, "Date: %s\n", m.Date.Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(bw, "Message-Id: <%s>\n", m.MessageID)
+	if m.InReplyTo != "" {
+		_, _ = fmt.Fprintf(bw, "In-Reply-To: <%s>\n", m.InReplyTo)
+		_, _ = fmt.Fprintf(bw, "References: <%s>\n", m.InReplyTo)
+	}
+	_, _ = fmt.Fprintf(bw, "List-Id: <%s>\n", m.ListID)
+	_, _ = fmt.Fprintf(bw, "Archived-At: <%s>\n", m.URL)
+	_, _ = bw.WriteString("MIME-Version: 1.0\n")
+	_, _ = bw.WriteString("Content-Type: text/plain; charset=utf-8\n")
+	_, _ = bw.WriteString("Content-Transfer-Encoding: 8bit\n\n")
+
+	body := strings.TrimRight(m.Body, "\n")
+	for _, line := range strings.Split(body, "\n") {
+		if mboxFromRe.MatchString(line) {
+			_, _ = bw.WriteString(">")
+		}
+		_, _ = bw.WriteString(line)
+		_, _ = bw.WriteString("\n")
+	}
+	_, _ = bw.WriteString("\n")
+	return bw.Flush()
+}
+
+// patchMessageBody strips the email headers from a patch's raw text.
+func patchMessageBody(raw string) string {
+	_, body, found := strings.Cut(raw, "\n\n")
+	if !found {
+		return raw
+	}
+	return body
+}
+
+func archiveMessageID(domain, format string, args ...any) string {
+	return fmt.Sprintf(format, args...) + "@" + domain
+}
+
+func archiveAddress(domain string, user *User) *mail.Address {
+	return &mail.Address{Name: user.Name, Address: fmt.Sprintf("%s@%s", user.Name, domain)}
+}
+
+// archiveEventBody describes an event log as an email reply.  Events that
+// are already represented by patches return an empty string.
+func archiveEventBody(user *User, eventLog *EventLog) string {
+	body := ""
+	switch eventLog.Event {
+	case "pr_status_changed":
+		body = fmt.Sprintf("%s changed the status to [%s]\n", user.Name, eventLog.Data.Status)
+	case "pr_name_changed":
+		body = fmt.Sprintf("%s changed the title to %q\n", user.Name, eventLog.Data.Name)
+	case "pr_body_changed":
+		body = fmt.Sprintf("%s edited the description\n", user.Name)
+	case "pr_labels_changed":
+		changes := []string{}
+		if len(eventLog.Data.LabelsAdded) > 0 {
+			changes = append(changes, "added labels "+strings.Join(eventLog.Data.LabelsAdded, ", "))
+		}
+		if len(eventLog.Data.LabelsRemoved) > 0 {
+			changes = append(changes, "removed labels "+strings.Join(eventLog.Data.LabelsRemoved, ", "))
+		}
+		body = fmt.Sprintf("%s %s\n", user.Name, strings.Join(changes, " and "))
+	case "pr_dependency_added":
+		body = fmt.Sprintf("%s marked the PR as depending on #%d\n", user.Name, eventLog.Data.DependsOn)
+	case "pr_dependency_removed":
+		body = fmt.Sprintf("%s removed the dependency on #%d\n", user.Name, eventLog.Data.DependsOn)
+	case "pr_moved":
+		body = fmt.Sprintf("%s moved the PR from %s to %s\n", user.Name, eventLog.Data.FromRepo, eventLog.Data.ToRepo)
+	case "pr_superseded":
+		body = fmt.Sprintf("%s closed the PR, it is superseded by #%d\n", user.Name, eventLog.Data.SupersededBy)
+	case "pr_supersedes":
+		body = fmt.Sprintf("%s marked the PR as superseding #%d\n", user.Name, eventLog.Data.Supersedes)
+	case "pr_ready_for_review":
+		body = fmt.Sprintf("%s marked the PR ready for review\n", user.Name)
+	case "pr_approved":
+		body = fmt.Sprintf("%s approved %s\n", user.Name, getFormattedPatchsetID(eventLog.PatchsetID.Int64))
+	case "pr_unapproved":
+		body = fmt.Sprintf("%s withdrew their approval\n", user.Name)
+	case "pr_review_requested":
+		body = fmt.Sprintf("%s requested a review from %s\n", user.Name, strings.Join(eventLog.Data.Reviewers, ", "))
+	default:
+		return ""
+	}
+	if eventLog.Data.Comment != "" {
+		body += "\n" + eventLog.Data.Comment + "\n"
+	}
+	return body
+}
+
+// archiveThread holds the headers shared by every message about a patch
+// request.
+type archiveThread struct {
+	Domain string
+	To     string
+	ListID string
+	RootID string
+	URL    string
+}
+
+func newArchiveThread(be *Backend, repoNs string, prq *PatchRequest) *archiveThread {
+	domain := be.Cfg.SmtpDomain
+	return &archiveThread{
+		Domain: domain,
+		To:     fmt.Sprintf("%s@%s", repoNs, domain),
+		ListID: fmt.Sprintf("%s.%s", strings.ReplaceAll(repoNs, "/", "."), domain),
+		RootID: archiveMessageID(domain, "pr-%d", prq.ID),
+		URL:    fmt.Sprintf("https://%s/prs/%d", be.Cfg.Url, prq.ID),
+	}
+}
+
+// patchsetMessages replies to the thread root with every patch in a
+// patchset.  The version is the position of the patchset in its patch
+// request.
+func (t *archiveThread) patchsetMessages(be *Backend, pr GitPatchRequest, version int, patchset *Patchset) ([]*archiveMessage, error) {
+	patches, err := pr.GetPatchesByPatchsetID(patchset.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []*archiveMessage{}
+	for pidx, patch := range patches {
+		from := &mail.Address{Name: patch.AuthorName, Address: patch.AuthorEmail}
+		if patch.AuthorEmail == "" {
+			submitter, err := pr.GetUserByID(patch.UserID)
+			if err != nil {
+				return nil, err
+			}
+			from = archiveAddress(t.Domain, submitter)
+		}
+		msgs = append(msgs, &archiveMessage{
+			From:      from,
+			To:        t.To,
+			ListID:    t.ListID,
+			Date:      patchset.CreatedAt,
+			Subject:   fmt.Sprintf("[PATCH v%d %d/%d] %s", version, pidx+1, len(patches), patch.Title),
+			MessageID: archiveMessageID(t.Domain, "ps-%d-%d", patchset.ID, pidx+1),
+			InReplyTo: t.RootID,
+			URL:       fmt.Sprintf("https://%s/ps/%d", be.Cfg.Url, patchset.ID),
+			Body:      patchMessageBody(patch.RawText),
+		})
+	}
+	return msgs, nil
+}
+
+// commentMessage replies with a discussion comment, threaded under the
+// comment it replies to.
+func (t *archiveThread) commentMessage(user *User, eventLog *EventLog, comment *Comment, subject string) *archiveMessage {
+	inReplyTo := t.RootID
+	if comment.ReplyToID.Valid {
+		inReplyTo = archiveMessageID(t.Domain, "comment-%d", comment.ReplyToID.Int64)
+	}
+	return &archiveMessage{
+		From:      archiveAddress(t.Domain, user),
+		To:        t.To,
+		ListID:    t.ListID,
+		Date:      eventLog.CreatedAt,
+		Subject:   "Re: " + subject,
+		MessageID: archiveMessageID(t.Domain, "comment-%d", comment.ID),
+		InReplyTo: inReplyTo,
+		URL:       fmt.Sprintf("%s#comment-%d", t.URL, comment.ID),
+		Body:      comment.Text,
+	}
+}
+
+// prArchiveMessages builds the email thread for a patch request.  The cover
+// letter is the thread root with each patchset and event as replies.
+func prArchiveMessages(be *Backend, pr GitPatchRequest, repoNs string, prq *PatchRequest, eventLogs []*EventLog) ([]*archiveMessage, error) {
+	thread := newArchiveThread(be, repoNs, prq)
+
+	author, err := pr.GetUserByID(prq.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	patchsets, err := pr.GetPatchsetsByPrID(prq.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []*archiveMessage{}
+	numPatches := 0
+	for idx, patchset := range patchsets {
+		psMsgs, err := thread.patchsetMessages(be, pr, idx+1, patchset)
+		if err != nil {
+			return nil, err
+		}
+		if idx == 0 {
+			numPatches = len(psMsgs)
+		}
+		msgs = append(msgs, psMsgs...)
+	}
+
+	subject := fmt.Sprintf("[PATCH 0/%d] %s", numPatches, prq.Name)
+	root := &archiveMessage{
+		From:      archiveAddress(thread.Domain, author),
+		To:        thread.To,
+		ListID:    thread.ListID,
+		Date:      prq.CreatedAt,
+		Subject:   subject,
+		MessageID: thread.RootID,
+		URL:       thread.URL,
+		Body:      fmt.Sprintf("%s\n\n%s\n", prq.Text, thread.URL),
+	}
+	msgs = append([]*archiveMessage{root}, msgs...)
+
+	comments, err := pr.GetCommentsByPrID(prq.ID)
+	if err != nil {
+		return nil, err
+	}
+	commentMap := map[int64]*Comment{}
+	for _, comment := range comments {
+		commentMap[comment.ID] = comment
+	}
+
+	for _, eventLog := range eventLogs {
+		if eventLog.PatchRequestID.Int64 != prq.ID {
+			continue
+		}
+		user, err := pr.GetUserByID(eventLog.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if eventLog.Event == "pr_commented" {
+			comment, ok := commentMap[eventLog.Data.CommentID]
+			if !ok {
+				continue
+			}
+			msgs = append(msgs, thread.commentMessage(user, eventLog, comment, subject))
+			continue
+		}
+		body := archiveEventBody(user, eventLog)
+		if body == "" {
+			continue
+		}
+		msgs = append(msgs, &archiveMessage{
+			From:      archiveAddress(thread.Domain, user),
+			To:        thread.To,
+			ListID:    thread.ListID,
+			Date:      eventLog.CreatedAt,
+			Subject:   "Re: " + subject,
+			MessageID: archiveMessageID(thread.Domain, "event-%d", eventLog.ID),
+			InReplyTo: thread.RootID,
+			URL:       thread.URL,
+			Body:      body,
+		})
+	}
+
+	return msgs, nil
+}
+
+func writeArchiveMessages(w io.Writer, msgs []*archiveMessage) error {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Date.Before(msgs[j].Date)
+	})
+
+	for _, msg := range msgs {
+		err := msg.writeMbox(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func prRepoNs(be *Backend, pr GitPatchRequest, prq *PatchRequest) (string, error) {
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return "", err
+	}
+	repoUser, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return "", err
+	}
+	return be.CreateRepoNs(repoUser.Name, repo.Name), nil
+}
+
+// WritePatchRequestMbox writes the email thread of a single patch request.
+func WritePatchRequestMbox(w io.Writer, be *Backend, pr GitPatchRequest, prq *PatchRequest) error {
+	repoNs, err := prRepoNs(be, pr, prq)
+	if err != nil {
+		return err
+	}
+	eventLogs, err := pr.GetEventLogsByPrID(prq.ID)
+	if err != nil {
+		return err
+	}
+	msgs, err := prArchiveMessages(be, pr, repoNs, prq, eventLogs)
+	if err != nil {
+		return err
+	}
+	return writeArchiveMessages(w, msgs)
+}
+
+// WritePatchsetMbox writes the patches of a patchset as replies to their
+// patch request thread.
+func WritePatchsetMbox(w io.Writer, be *Backend, pr GitPatchRequest, patchset *Patchset) error {
+	prq, err := pr.GetPatchRequestByID(patchset.PatchRequestID)
+	if err != nil {
+		return err
+	}
+	repoNs, err := prRepoNs(be, pr, prq)
+	if err != nil {
+		return err
+	}
+	patchsets, err := pr.GetPatchsetsByPrID(prq.ID)
+	if err != nil {
+		return err
+	}
+	version := 1
+	for idx, ps := range patchsets {
+		if ps.ID == patchset.ID {
+			version = idx + 1
+		}
+	}
+
+	thread := newArchiveThread(be, repoNs, prq)
+	msgs, err := thread.patchsetMessages(be, pr, version, patchset)
+	if err != nil {
+		return err
+	}
+	return writeArchiveMessages(w, msgs)
+}
+
+// WriteRepoArchive writes every patch request in a repo as a threaded mbox
+// that can be imported by mail clients and public-inbox.
+func WriteRepoArchive(w io.Writer, be *Backend, pr GitPatchRequest, repo *Repo) error {
+	repoUser, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return err
+	}
+	repoNs := be.CreateRepoNs(repoUser.Name, repo.Name)
+
+	prs, err := pr.GetPatchRequestsByRepoID(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	eventLogs, err := pr.GetEventLogsByRepoID(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	msgs := []*archiveMessage{}
+	for _, prq := range prs {
+		prMsgs, err := prArchiveMessages(be, pr, repoNs, prq, eventLogs)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, prMsgs...)
+	}
+
+	return writeArchiveMessages(w, msgs)
+}
+
+// ExportRepoArchives writes an mbox archive for every repo into outDir.
+func ExportRepoArchives(be *Backend, pr GitPatchRequest, outDir string) ([]string, error) {
+	fpaths := []string{}
+	repos, err := pr.GetRepos()
+	if err != nil {
+		return fpaths, err
+	}
+
+	for _, repo := range repos {
+		repoUser, err := pr.GetUserByID(repo.UserID)
+		if err != nil {
+			return fpaths, err
+		}
+		fpath := filepath.Join(outDir, be.CreateRepoNs(repoUser.Name, repo.Name)+".mbox")
+		err = os.MkdirAll(filepath.Dir(fpath), 0755)
+		if err != nil {
+			return fpaths, err
+		}
+
+		fp, err := os.Create(fpath)
+		if err != nil {
+			return fpaths, err
+		}
+		err = WriteRepoArchive(fp, be, pr, repo)
+		_ = fp.Close()
+		if err != nil {
+			return fpaths, err
+		}
+		fpaths = append(fpaths, fpath)
+	}
+
+	return fpaths, nil
+}
+
+func GitArchiveExport(cfg *GitCfg, outDir string) error {
+	dbpath := filepath.Join(cfg.DataDir, "pr.db?_fk=on")
+	dbh, err := SqliteOpen("file:"+dbpath, cfg.Logger)
+	if err != nil {
+		return fmt.Errorf("cannot find database file, check folder and perms: %s: %w", dbpath, err)
+	}
+	defer func() {
+		_ = dbh.Close()
+	}()
+
+	be := &Backend{
+		DB:     dbh,
+		Logger: cfg.Logger,
+		Cfg:    cfg,
+	}
+	prCmd := &PrCmd{
+		Backend: be,
+	}
+
+	fpaths, err := ExportRepoArchives(be, prCmd, outDir)
+	for _, fpath := range fpaths {
+		cfg.Logger.Info("exported archive", "fpath", fpath)
+	}
+	return err
+}
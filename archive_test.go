@@ -0,0 +1,124 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriteRepoArchive(t *testing.T) {
+	pr := setupTestPr(t)
+	owner := createTestUser(t, pr, "alice")
+	repo, err := pr.CreateRepo(owner, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open("fixtures/with-cover.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	prq, err := pr.SubmitPatchRequest(repo.ID, owner.ID, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pr.SubmitPatchset(prq.ID, owner.ID, OpReview, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pr.UpdatePatchRequestStatus(prq.ID, owner.ID, StatusAccepted, "From the top, lgtm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteRepoArchive(&buf, pr.Backend, pr, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := strings.Split(buf.String(), "\nFrom ")
+	// cover letter + 3 patches in v1 + 1 patch in v2 + status change
+	if len(raw) != 6 {
+		t.Fatalf("expected 6 messages, found %d", len(raw))
+	}
+
+	subjects := []string{}
+	for idx, msgRaw := range raw {
+		if idx == 0 {
+			msgRaw = strings.TrimPrefix(msgRaw, "From ")
+		}
+		_, msgRaw, _ = strings.Cut(msgRaw, "\n")
+		msg, err := mail.ReadMessage(strings.NewReader(msgRaw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		subjects = append(subjects, msg.Header.Get("Subject"))
+
+		msgID := msg.Header.Get("Message-Id")
+		if idx == 0 {
+			if msgID != "<pr-1@pr.test>" {
+				t.Fatalf("wrong root message id: %s", msgID)
+			}
+			continue
+		}
+		if msg.Header.Get("In-Reply-To") != "<pr-1@pr.test>" {
+			t.Fatalf("message not threaded: %s", msgID)
+		}
+	}
+
+	expected := []string{
+		"[PATCH 0/3] Add torch deps",
+		"[PATCH v1 1/3] Add torch deps",
+		"[PATCH v1 2/3] feat: lets build an rnn",
+		"[PATCH v1 3/3] chore: add torch to requirements",
+		"[PATCH v2 1/1] feat: lets build an rnn",
+		"Re: [PATCH 0/3] Add torch deps",
+	}
+	for idx, subject := range subjects {
+		if subject != expected[idx] {
+			t.Fatalf("wrong subject (expected:%s, actual:%s)", expected[idx], subject)
+		}
+	}
+
+	if !strings.Contains(buf.String(), "\n>From the top, lgtm\n") {
+		t.Fatal("expected From lines in the body to be escaped")
+	}
+}
+
+func singlePatch(t testing.TB) string {
+	t.Helper()
+	by, err := os.ReadFile("fixtures/single.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(by)
+}
+
+func TestPatchRequestMboxComments(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	bob := createTestUser(t, pr, "bob")
+	question, err := CreateComment(pr.Backend, pr, bob, prq.ID, 0, "does this handle unicode?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateComment(pr.Backend, pr, owner, prq.ID, question.ID, "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mbox strings.Builder
+	err = WritePatchRequestMbox(&mbox, pr.Backend, pr, prq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mbox.String(), fmt.Sprintf("In-Reply-To: <comment-%d@", question.ID)) {
+		t.Fatal("expected the reply to be threaded under the question in the archive")
+	}
+}
@@ -69,6 +69,21 @@ func (be *Backend) CanCreateRepo(repo *Repo, requester *User) error {
 	return fmt.Errorf("you are not authorized to create repo")
 }
 
+// CanModifyRepo allows the repo owner and admins to change a repo.
+func (be *Backend) CanModifyRepo(repo *Repo, requester *User) error {
+	if repo.UserID == requester.ID {
+		return nil
+	}
+	pubkey, err := be.PubkeyToPublicKey(requester.Pubkey)
+	if err != nil {
+		return err
+	}
+	if be.IsAdmin(pubkey) {
+		return nil
+	}
+	return fmt.Errorf("you are not authorized to change repo")
+}
+
 func (be *Backend) Pubkey(pk ssh.PublicKey) string {
 	return be.KeyForKeyText(pk)
 }
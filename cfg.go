@@ -3,6 +3,7 @@ package git
 import (
 	"fmt"
 	"log/slog"
+	"net"
 	"path/filepath"
 	"strings"
 	"time"
@@ -17,19 +18,26 @@ import (
 var k = koanf.New(".")
 
 type GitCfg struct {
-	DataDir    string          `koanf:"data_dir"`
-	Url        string          `koanf:"url"`
-	Host       string          `koanf:"host"`
-	SshPort    string          `koanf:"ssh_port"`
-	WebPort    string          `koanf:"web_port"`
-	PromPort   string          `koanf:"prom_port"`
-	AdminsStr  []string        `koanf:"admins"`
-	Admins     []ssh.PublicKey `koanf:"admins_pk"`
-	CreateRepo string          `koanf:"create_repo"`
-	Theme      string          `koanf:"theme"`
-	TimeFormat string          `koanf:"time_format"`
-	Desc       string          `koanf:"desc"`
-	Logger     *slog.Logger
+	DataDir       string          `koanf:"data_dir"`
+	Url           string          `koanf:"url"`
+	Host          string          `koanf:"host"`
+	SshPort       string          `koanf:"ssh_port"`
+	WebPort       string          `koanf:"web_port"`
+	PromPort      string          `koanf:"prom_port"`
+	AdminsStr     []string        `koanf:"admins"`
+	Admins        []ssh.PublicKey `koanf:"admins_pk"`
+	CreateRepo    string          `koanf:"create_repo"`
+	Theme         string          `koanf:"theme"`
+	TimeFormat    string          `koanf:"time_format"`
+	Desc          string          `koanf:"desc"`
+	SmtpPort      string          `koanf:"smtp_port"`
+	SmtpDomain    string          `koanf:"smtp_domain"`
+	SmtpFrom      string          `koanf:"smtp_from"`
+	SmtpRelay     string          `koanf:"smtp_relay"`
+	SmtpRelayUser string          `koanf:"smtp_relay_user"`
+	SmtpRelayPass string          `koanf:"smtp_relay_pass"`
+	Logger        *slog.Logger
+	Bus           *EventBus
 }
 
 func LoadConfigFile(fpath string, logger *slog.Logger) {
@@ -105,6 +113,17 @@ func NewGitCfg(logger *slog.Logger) *GitCfg {
 		out.CreateRepo = "admin"
 	}
 
+	if out.SmtpDomain == "" {
+		out.SmtpDomain = out.Url
+		if host, _, err := net.SplitHostPort(out.Url); err == nil {
+			out.SmtpDomain = host
+		}
+	}
+
+	if out.SmtpFrom == "" {
+		out.SmtpFrom = fmt.Sprintf("git-pr@%s", out.SmtpDomain)
+	}
+
 	logger.Info(
 		"config",
 		"url", out.Url,
@@ -116,6 +135,10 @@ func NewGitCfg(logger *slog.Logger) *GitCfg {
 		"time_format", out.TimeFormat,
 		"create_repo", out.CreateRepo,
 		"desc", out.Desc,
+		"smtp_port", out.SmtpPort,
+		"smtp_domain", out.SmtpDomain,
+		"smtp_from", out.SmtpFrom,
+		"smtp_relay", out.SmtpRelay,
 	)
 
 	for _, pubkey := range out.AdminsStr {
@@ -123,5 +146,6 @@ func NewGitCfg(logger *slog.Logger) *GitCfg {
 	}
 
 	out.Logger = logger
+	out.Bus = NewEventBus(logger)
 	return &out
 }
@@ -1,12 +1,15 @@
 package git
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/picosh/pico/pkg/pssh"
 	"github.com/urfave/cli/v2"
@@ -45,16 +48,15 @@ func getPatchsetFromOpt(patchsets []*Patchset, optPatchsetID string) (*Patchset,
 }
 
 func printPatches(sesh *pssh.SSHServerConnSession, patches []*Patch) {
-	if len(patches) == 1 {
-		sesh.Println(patches[0].RawText)
-		return
-	}
+	writePatches(sesh, patches)
+}
 
-	opatches := patches
-	for idx, patch := range opatches {
-		sesh.Println(patch.RawText)
+// writePatches outputs patches in a format that can be piped to `git am`.
+func writePatches(w io.Writer, patches []*Patch) {
+	for idx, patch := range patches {
+		_, _ = fmt.Fprintln(w, patch.RawText)
 		if idx < len(patches)-1 {
-			sesh.Printf("\n\n\n")
+			_, _ = fmt.Fprintf(w, "\n\n\n")
 		}
 	}
 }
@@ -77,7 +79,53 @@ func prSummary(be *Backend, pr GitPatchRequest, sesh *pssh.SSHServerConnSession,
 
 	sesh.Printf("Info\n====\n")
 	sesh.Printf("URL: https://%s/prs/%d\n", be.Cfg.Url, prID)
-	sesh.Printf("Repo: %s\n\n", be.CreateRepoNs(repoUser.Name, repo.Name))
+	sesh.Printf("Repo: %s\n", be.CreateRepoNs(repoUser.Name, repo.Name))
+	labels, err := pr.GetLabelsByPrID(prID)
+	if err != nil {
+		return err
+	}
+	if len(labels) > 0 {
+		sesh.Printf("Labels: %s\n", labelNames(labels))
+	}
+	reviewers, err := getReviewerNames(pr, prID)
+	if err != nil {
+		return err
+	}
+	if len(reviewers) > 0 {
+		sesh.Printf("Review requested: %s\n", strings.Join(reviewers, ", "))
+	}
+	approvals, err := GetApprovalStatus(pr, repo, prID)
+	if err != nil {
+		return err
+	}
+	if approvals.Required > 0 || len(approvals.Approvals) > 0 {
+		sesh.Printf("Approvals: %s\n", approvalSummary(approvals))
+	}
+	deps, err := pr.GetPatchRequestDependencies(prID)
+	if err != nil {
+		return err
+	}
+	if len(deps) > 0 {
+		sesh.Printf("Depends on: %s\n", prStatusList(deps))
+	}
+	dependents, err := pr.GetPatchRequestDependents(prID)
+	if err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		sesh.Printf("Required by: %s\n", prStatusList(dependents))
+	}
+	if request.SupersededBy.Valid {
+		sesh.Printf("Superseded by: #%d\n", request.SupersededBy.Int64)
+	}
+	superseded, err := pr.GetSupersededPatchRequests(prID)
+	if err != nil {
+		return err
+	}
+	if len(superseded) > 0 {
+		sesh.Printf("Supersedes: %s\n", prStatusList(superseded))
+	}
+	sesh.Printf("\n")
 
 	writer := NewTabWriter(sesh)
 	_, _ = fmt.Fprintln(writer, "ID\tName\tStatus\tDate")
@@ -148,19 +196,203 @@ func prSummary(be *Backend, pr GitPatchRequest, sesh *pssh.SSHServerConnSession,
 		)
 	}
 	_ = w.Flush()
+
+	stat, err := NewDiffStat(patches)
+	if err != nil {
+		return err
+	}
+	sesh.Printf("\nDiffstat\n====\n")
+	sesh.Print(stat.String())
+
+	comments, err := pr.GetCommentsByPrID(prID)
+	if err != nil {
+		return err
+	}
+	if len(comments) > 0 {
+		sesh.Printf("\nComments\n====\n")
+		printComments(be, pr, sesh, comments)
+	}
+	return nil
+}
+
+// printComments prints each comment under a header naming its author and
+// the comment it replies to.
+func printComments(be *Backend, pr GitPatchRequest, sesh *pssh.SSHServerConnSession, comments []*Comment) {
+	for idx, comment := range comments {
+		user, err := pr.GetUserByID(comment.UserID)
+		if err != nil {
+			be.Logger.Error("cannot find user for comment", "err", err)
+			continue
+		}
+		if idx > 0 {
+			sesh.Printf("\n")
+		}
+		header := fmt.Sprintf("[%d] %s on %s", comment.ID, user.Name, comment.CreatedAt.Format(be.Cfg.TimeFormat))
+		if comment.ReplyToID.Valid {
+			header += fmt.Sprintf(", reply to [%d]", comment.ReplyToID.Int64)
+		}
+		if comment.UpdatedAt.Valid {
+			header += " (edited)"
+		}
+		sesh.Printf("%s\n", header)
+		for _, line := range strings.Split(strings.TrimRight(comment.Text, "\n"), "\n") {
+			sesh.Printf("  %s\n", line)
+		}
+	}
+}
+
+// printContributionGuide points a contributor at the repo guidelines after
+// they submitted a patch request.
+func printContributionGuide(be *Backend, pr GitPatchRequest, sesh *pssh.SSHServerConnSession, prq *PatchRequest) error {
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return err
+	}
+	owner, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return err
+	}
+	repoNs := be.CreateRepoNs(owner.Name, repo.Name)
+
+	if repo.Contributing != "" {
+		sesh.Printf("\nContributing to %s\n====\n", repoNs)
+		sesh.Printf("%s\n", strings.TrimRight(repo.Contributing, "\n"))
+	}
+	if repo.PrTemplate != "" {
+		sesh.Printf("\nPR template\n====\n")
+		sesh.Printf("%s uses a template for PR descriptions, fill it in with:\n", repoNs)
+		sesh.Printf("  ssh %s repo show %s pr_template > pr.md\n", be.Cfg.Url, repoNs)
+		sesh.Printf("  cat pr.md | ssh %s pr edit --body %d\n", be.Cfg.Url, prq.ID)
+	}
+	return nil
+}
+
+// printPrSummary prints prSummary or its JSON schema.
+func printPrSummary(be *Backend, pr GitPatchRequest, sesh *pssh.SSHServerConnSession, format outputFormat, prID int64) error {
+	if format.IsJSON() {
+		summary, err := NewPrSummarySchema(be, pr, prID)
+		if err != nil {
+			return err
+		}
+		return writeJSON(sesh, format, summary)
+	}
+	return prSummary(be, pr, sesh, prID)
+}
+
+func printEventLog(writer io.Writer, be *Backend, pr GitPatchRequest, eventLog *EventLog) {
+	repo, err := pr.GetRepoByID(eventLog.RepoID.Int64)
+	if err != nil {
+		be.Logger.Error("repo not found", "repo", repo, "err", err)
+		return
+	}
+	repoUser, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		be.Logger.Error("repo user not found", "repo", repo, "err", err)
+		return
+	}
+	_, _ = fmt.Fprintf(
+		writer,
+		"%s\t%d\t%s\t%s\t%s\t%s\n",
+		be.CreateRepoNs(repoUser.Name, repo.Name),
+		eventLog.PatchRequestID.Int64,
+		getFormattedPatchsetID(eventLog.PatchsetID.Int64),
+		eventLog.Event,
+		eventLog.CreatedAt.Format(be.Cfg.TimeFormat),
+		eventLog.Data,
+	)
+}
+
+// repoSummary prints a repo with its settings, long markdown settings
+// get their own section.
+func repoSummary(be *Backend, sesh *pssh.SSHServerConnSession, name string, repo *RepoSchema) error {
+	sesh.Printf("Info\n====\n")
+	sesh.Printf("URL: https://%s/r/%s/%s\n", be.Cfg.Url, repo.User, name)
+	sesh.Printf("Repo: %s\n", repo.Name)
+	if repo.Description != "" {
+		sesh.Printf("Description: %s\n", repo.Description)
+	}
+	if repo.Homepage != "" {
+		sesh.Printf("Homepage: %s\n", repo.Homepage)
+	}
+	if repo.CloneURL != "" {
+		sesh.Printf("Clone URL: %s\n", repo.CloneURL)
+	}
+	sesh.Printf("Default branch: %s\n", repo.DefaultBranch)
+	if repo.Archived {
+		sesh.Printf("Archived: true\n")
+	}
+	if repo.TransferTo != "" {
+		sesh.Printf("Transfer waiting on: %s\n", repo.TransferTo)
+	}
+
+	sesh.Printf("\nSettings\n====\n")
+	writer := NewTabWriter(sesh)
+	_, _ = fmt.Fprintf(writer, "range_diff_creation_factor\t%d\n", repo.RangeDiffCreationFactor)
+	_, _ = fmt.Fprintf(writer, "range_diff_normalize\t%t\n", repo.RangeDiffNormalize)
+	_, _ = fmt.Fprintf(writer, "required_approvals\t%d\n", repo.RequiredApprovals)
+	_ = writer.Flush()
+
+	if repo.Contributing != "" {
+		sesh.Printf("\nContributing\n====\n%s\n", strings.TrimRight(repo.Contributing, "\n"))
+	}
+	if repo.PrTemplate != "" {
+		sesh.Printf("\nPR template\n====\n%s\n", strings.TrimRight(repo.PrTemplate, "\n"))
+	}
+	return nil
+}
+
+// printRepo writes a repo as json or a message followed by the repo
+// namespace.
+func printRepo(be *Backend, pr GitPatchRequest, sesh *pssh.SSHServerConnSession, format outputFormat, repo *Repo, msg string) error {
+	out, err := NewRepoSchema(be, pr, repo)
+	if err != nil {
+		return err
+	}
+	if format.IsJSON() {
+		return writeJSON(sesh, format, out)
+	}
+	sesh.Printf("%s: %s\n", msg, out.Name)
 	return nil
 }
 
-func printPatchsetFromID(sesh *pssh.SSHServerConnSession, pr GitPatchRequest, psID int64) error {
+// isUserEvent mirrors the GetEventLogsByUserID query for streamed events.
+func isUserEvent(pr GitPatchRequest, user *User, eventLog *EventLog) bool {
+	if eventLog.UserID == user.ID {
+		return true
+	}
+	if !eventLog.PatchRequestID.Valid {
+		return false
+	}
+	prq, err := pr.GetPatchRequestByID(eventLog.PatchRequestID.Int64)
+	if err != nil {
+		return false
+	}
+	return prq.UserID == user.ID
+}
+
+func printPatchesJSON(sesh *pssh.SSHServerConnSession, format outputFormat, patches []*Patch) error {
+	out := []*PatchSchema{}
+	for _, patch := range patches {
+		ps := NewPatchSchema(patch)
+		ps.Raw = patch.RawText
+		out = append(out, ps)
+	}
+	return writeJSONList(sesh, format, out)
+}
+
+func printPatchsetFromID(sesh *pssh.SSHServerConnSession, pr GitPatchRequest, format outputFormat, psID int64) error {
 	patches, err := pr.GetPatchesByPatchsetID(psID)
 	if err != nil {
 		return err
 	}
+	if format.IsJSON() {
+		return printPatchesJSON(sesh, format, patches)
+	}
 	printPatches(sesh, patches)
 	return nil
 }
 
-func printPatchsetFromPrID(sesh *pssh.SSHServerConnSession, pr GitPatchRequest, prID int64) error {
+func printPatchsetFromPrID(sesh *pssh.SSHServerConnSession, pr GitPatchRequest, format outputFormat, prID int64) error {
 	patchsets, err := pr.GetPatchsetsByPrID(prID)
 	if err != nil {
 		return err
@@ -171,6 +403,36 @@ func printPatchsetFromPrID(sesh *pssh.SSHServerConnSession, pr GitPatchRequest,
 		return err
 	}
 
+	if format.IsJSON() {
+		return printPatchesJSON(sesh, format, patches)
+	}
+	printPatches(sesh, patches)
+	return nil
+}
+
+// printStackFromPrID prints the latest patchset of every PR in a stack so
+// the whole stack applies with a single `git am`.
+func printStackFromPrID(sesh *pssh.SSHServerConnSession, pr GitPatchRequest, format outputFormat, prID int64) error {
+	stack, err := GetPatchRequestStack(pr, prID)
+	if err != nil {
+		return err
+	}
+	patches := []*Patch{}
+	for _, prq := range stack {
+		ps, err := pr.GetLatestPatchsetByPrID(prq.ID)
+		if err != nil {
+			return err
+		}
+		psPatches, err := pr.GetPatchesByPatchsetID(ps.ID)
+		if err != nil {
+			return err
+		}
+		patches = append(patches, psPatches...)
+	}
+
+	if format.IsJSON() {
+		return printPatchesJSON(sesh, format, patches)
+	}
 	printPatches(sesh, patches)
 	return nil
 }
@@ -206,13 +468,31 @@ To get started, submit a new patch request:
 		Usage:       "Collaborate with contributors for your git project",
 		Writer:      sesh,
 		ErrWriter:   sesh,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "output as JSON",
+			},
+			&cli.BoolFlag{
+				Name:  "jsonl",
+				Usage: "output as JSON, one object per line",
+			},
+		},
 		ExitErrHandler: func(cCtx *cli.Context, err error) {
 			if err != nil {
+				if getOutputFormat(cCtx).IsJSON() {
+					sesh.Fatal(jsonError(err))
+					return
+				}
 				sesh.Fatal(fmt.Errorf("err: %w", err))
 			}
 		},
 		OnUsageError: func(cCtx *cli.Context, err error, isSubcommand bool) error {
 			if err != nil {
+				if getOutputFormat(cCtx).IsJSON() {
+					sesh.Fatal(jsonError(err))
+					return nil
+				}
 				sesh.Fatal(fmt.Errorf("err: %w", err))
 			}
 			return nil
@@ -235,6 +515,10 @@ To get started, submit a new patch request:
 						Name:  "repo",
 						Usage: "show all events related to a repo",
 					},
+					&cli.BoolFlag{
+						Name:  "follow",
+						Usage: "stream new events until disconnect",
+					},
 				},
 				Action: func(cCtx *cli.Context) error {
 					pubkey := be.Pubkey(sesh.PublicKey())
@@ -245,19 +529,46 @@ To get started, submit a new patch request:
 					isPubkey := cCtx.Bool("pubkey")
 					prID := cCtx.Int64("pr")
 					repoNs := cCtx.String("repo")
-					var eventLogs []*EventLog
-					if isPubkey {
-						eventLogs, err = pr.GetEventLogsByUserID(user.ID)
-					} else if prID != 0 {
-						eventLogs, err = pr.GetEventLogsByPrID(prID)
-					} else if repoNs != "" {
+					follow := cCtx.Bool("follow")
+					format := getOutputFormat(cCtx)
+					if follow && format == FormatJSON {
+						// a stream cannot be a single json array
+						format = FormatJSONL
+					}
+
+					filter := EventFilter{}
+					var repo *Repo
+					if !isPubkey && prID == 0 && repoNs != "" {
 						repoUsername, repoName := be.SplitRepoNs(repoNs)
 						var repoUser *User
 						repoUser, err = pr.GetUserByName(repoUsername)
 						if err != nil {
 							return nil
 						}
-						eventLogs, err = pr.GetEventLogsByRepoName(repoUser, repoName)
+						repo, err = pr.GetRepoByName(repoUser, repoName)
+						if err != nil {
+							return err
+						}
+						filter.RepoID = repo.ID
+					} else if !isPubkey && prID != 0 {
+						filter.PrID = prID
+					}
+
+					// subscribe before reading history so we do not miss any
+					// events created in between
+					var sub *EventSub
+					if follow {
+						sub = be.Cfg.Bus.Subscribe(filter)
+						defer sub.Close()
+					}
+
+					var eventLogs []*EventLog
+					if isPubkey {
+						eventLogs, err = pr.GetEventLogsByUserID(user.ID)
+					} else if prID != 0 {
+						eventLogs, err = pr.GetEventLogsByPrID(prID)
+					} else if repo != nil {
+						eventLogs, err = pr.GetEventLogsByRepoID(repo.ID)
 					} else {
 						eventLogs, err = pr.GetEventLogs()
 					}
@@ -265,32 +576,55 @@ To get started, submit a new patch request:
 						return err
 					}
 
-					writer := NewTabWriter(sesh)
-					_, _ = fmt.Fprintln(writer, "RepoID\tPrID\tPatchsetID\tEvent\tCreated\tData")
+					var lastID int64
 					for _, eventLog := range eventLogs {
-						repo, err := pr.GetRepoByID(eventLog.RepoID.Int64)
-						if err != nil {
-							be.Logger.Error("repo not found", "repo", repo, "err", err)
-							continue
+						lastID = max(lastID, eventLog.ID)
+					}
+
+					writer := NewTabWriter(sesh)
+					if format.IsJSON() {
+						msgs := []EventMessage{}
+						for _, eventLog := range eventLogs {
+							msgs = append(msgs, NewEventMessage(eventLog))
 						}
-						repoUser, err := pr.GetUserByID(repo.UserID)
+						err = writeJSONList(sesh, format, msgs)
 						if err != nil {
-							be.Logger.Error("repo user not found", "repo", repo, "err", err)
-							continue
+							return err
+						}
+					} else {
+						_, _ = fmt.Fprintln(writer, "RepoID\tPrID\tPatchsetID\tEvent\tCreated\tData")
+						for _, eventLog := range eventLogs {
+							printEventLog(writer, be, pr, eventLog)
+						}
+						_ = writer.Flush()
+					}
+
+					if !follow {
+						return nil
+					}
+
+					for {
+						select {
+						case <-sesh.Context().Done():
+							return nil
+						case eventLog, ok := <-sub.C:
+							if !ok {
+								return sub.Err()
+							}
+							if eventLog.ID <= lastID {
+								continue
+							}
+							if isPubkey && !isUserEvent(pr, user, eventLog) {
+								continue
+							}
+							if format.IsJSON() {
+								_ = writeJSON(sesh, format, NewEventMessage(eventLog))
+								continue
+							}
+							printEventLog(writer, be, pr, eventLog)
+							_ = writer.Flush()
 						}
-						_, _ = fmt.Fprintf(
-							writer,
-							"%s\t%d\t%s\t%s\t%s\t%s\n",
-							be.CreateRepoNs(repoUser.Name, repo.Name),
-							eventLog.PatchRequestID.Int64,
-							getFormattedPatchsetID(eventLog.PatchsetID.Int64),
-							eventLog.Event,
-							eventLog.CreatedAt.Format(be.Cfg.TimeFormat),
-							eventLog.Data,
-						)
 					}
-					_ = writer.Flush()
-					return nil
 				},
 			},
 			{
@@ -304,66 +638,105 @@ To get started, submit a new patch request:
 					if err != nil {
 						return err
 					}
+					if format := getOutputFormat(cCtx); format.IsJSON() {
+						return writeJSON(sesh, format, NewUserSchema(user))
+					}
 					sesh.Printf("User created successfully!\nUser: %s\nPubkey: %s\n", user.Name, pubkey)
 					return nil
 				},
 			},
 			{
-				Name:  "ps",
-				Usage: "Mange patchsets",
+				Name:  "email",
+				Usage: "Manage email addresses used with `git send-email`",
 				Subcommands: []*cli.Command{
 					{
-						Name:      "rm",
-						Usage:     "Remove a patchset with its patches",
-						Args:      true,
-						ArgsUsage: "[patchsetID]",
+						Name:      "ls",
+						Usage:     "List your email addresses",
+						Args:      false,
+						ArgsUsage: "",
 						Action: func(cCtx *cli.Context) error {
-							args := cCtx.Args()
-							if !args.Present() {
-								return fmt.Errorf("must provide a patchset ID")
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
 							}
 
-							patchsetID, err := getPatchsetID(args.First())
+							emails, err := pr.GetUserEmails(user.ID)
 							if err != nil {
 								return err
 							}
 
-							patchset, err := pr.GetPatchsetByID(patchsetID)
-							if err != nil {
-								return err
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								out := []*UserEmailSchema{}
+								for _, email := range emails {
+									out = append(out, NewUserEmailSchema(email))
+								}
+								return writeJSONList(sesh, format, out)
 							}
 
-							user, err := pr.GetUserByID(patchset.UserID)
+							writer := NewTabWriter(sesh)
+							_, _ = fmt.Fprintln(writer, "Email\tVerified\tDate")
+							for _, email := range emails {
+								_, _ = fmt.Fprintf(
+									writer,
+									"%s\t%t\t%s\n",
+									email.Email,
+									email.Verified,
+									email.CreatedAt.Format(be.Cfg.TimeFormat),
+								)
+							}
+							return writer.Flush()
+						},
+					},
+					{
+						Name:      "add",
+						Usage:     "Add an email address and send a verification code to it",
+						Args:      true,
+						ArgsUsage: "[email]",
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
 							if err != nil {
-								return err
+								return errNotExist(be.Cfg.Host, pubkey)
 							}
 
-							pk := sesh.PublicKey()
-							isAdmin := be.IsAdmin(pk)
-							isContrib := pubkey == user.Pubkey
-							if !isAdmin && !isContrib {
-								return fmt.Errorf("you are not authorized to delete a patchset")
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide an email address")
 							}
 
-							err = pr.DeletePatchsetByID(user.ID, patchset.PatchRequestID, patchsetID)
+							if be.Cfg.SmtpRelay == "" {
+								return fmt.Errorf("email is not enabled on this server")
+							}
+
+							email, err := pr.AddUserEmail(user.ID, args.First())
 							if err != nil {
 								return err
 							}
-							sesh.Printf("successfully removed patchset: %d\n", patchsetID)
+
+							body := fmt.Sprintf(
+								"Verify your email address with git-pr by running:\n\n    ssh %s email verify %s\n",
+								be.Cfg.Url,
+								email.Token,
+							)
+							msg := composeEmail(be.Cfg.SmtpFrom, email.Email, "git-pr email verification", "", body)
+							err = be.SendMail(email.Email, msg)
+							if err != nil {
+								be.Logger.Error("could not send verification email", "err", err)
+								return fmt.Errorf("could not send verification email to %s", email.Email)
+							}
+
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return writeJSON(sesh, format, NewUserEmailSchema(email))
+							}
+							sesh.Printf("Verification code sent to %s\n", email.Email)
+							sesh.Printf("Run `ssh %s email verify {code}` to finish\n", be.Cfg.Url)
 							return nil
 						},
 					},
-				},
-			},
-			{
-				Name:  "repo",
-				Usage: "Manage repos",
-				Subcommands: []*cli.Command{
 					{
-						Name:      "create",
-						Usage:     "Create a new repo",
+						Name:      "verify",
+						Usage:     "Verify an email address with the code we sent to it",
 						Args:      true,
-						ArgsUsage: "[repoName]",
+						ArgsUsage: "[code]",
 						Action: func(cCtx *cli.Context) error {
 							user, err := pr.GetUserByPubkey(pubkey)
 							if err != nil {
@@ -372,37 +745,31 @@ To get started, submit a new patch request:
 
 							args := cCtx.Args()
 							if !args.Present() {
-								return fmt.Errorf("need repo name argument")
+								return fmt.Errorf("must provide a verification code")
 							}
-							repoName := args.First()
-							repo, _ := pr.GetRepoByName(user, repoName)
-							err = be.CanCreateRepo(repo, user)
+
+							email, err := pr.VerifyUserEmail(user.ID, args.First())
 							if err != nil {
 								return err
 							}
 
-							if repo == nil {
-								repo, err = pr.CreateRepo(user, repoName)
-								if err != nil {
-									return err
-								}
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return writeJSON(sesh, format, NewUserEmailSchema(email))
 							}
-
-							sesh.Printf("repo created: %s/%s\n", user.Name, repo.Name)
+							sesh.Printf("email verified: %s\n", email.Email)
+							sesh.Printf(
+								"Send patches with `git send-email --to=%s@%s`\n",
+								be.CreateRepoNs(user.Name, "{repo}"),
+								be.Cfg.SmtpDomain,
+							)
 							return nil
 						},
 					},
 					{
 						Name:      "rm",
-						Usage:     "Delete repo and associated patch requests",
+						Usage:     "Remove an email address",
 						Args:      true,
-						ArgsUsage: "[repoName]",
-						Flags: []cli.Flag{
-							&cli.BoolFlag{
-								Name:  "write",
-								Usage: "Are you sure you want to delete the repo and all patch requests?",
-							},
-						},
+						ArgsUsage: "[email]",
 						Action: func(cCtx *cli.Context) error {
 							user, err := pr.GetUserByPubkey(pubkey)
 							if err != nil {
@@ -411,183 +778,222 @@ To get started, submit a new patch request:
 
 							args := cCtx.Args()
 							if !args.Present() {
-								return fmt.Errorf("need repo name argument")
+								return fmt.Errorf("must provide an email address")
 							}
-							rawRepoNs := args.First()
-							_, repoName := be.SplitRepoNs(rawRepoNs)
-							repo, _ := pr.GetRepoByName(user, repoName)
-							if repo == nil {
-								return fmt.Errorf("repo does not exist: %s/%s", user.Name, repoName)
-							}
-							err = be.CanCreateRepo(repo, user)
+
+							err = pr.DeleteUserEmail(user.ID, args.First())
 							if err != nil {
 								return err
 							}
 
-							if cCtx.Bool("write") {
-								err = pr.DeleteRepo(user, repoName)
-								if err != nil {
-									return err
-								}
-							} else {
-								sesh.Println("Must provide `--write` flag to persist changes")
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return writeJSON(sesh, format, &UserEmailSchema{Email: args.First()})
 							}
 
-							sesh.Printf("repo deleted: %s/%s\n", user.Name, repo.Name)
+							sesh.Printf("email removed: %s\n", args.First())
 							return nil
 						},
 					},
 				},
 			},
 			{
-				Name:      "print",
-				Usage:     "Print patches in a patchset",
-				Args:      true,
-				ArgsUsage: "[pr-X] or [ps-X]",
-				Action: func(cCtx *cli.Context) error {
-					args := cCtx.Args()
-					raw := args.First()
-					split := strings.Split(raw, "-")
-					if len(split) < 2 {
-						return fmt.Errorf("must provide ID in format: pr-X, ps-X")
-					}
+				Name:  "token",
+				Usage: "Manage access tokens for the web api",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "ls",
+						Usage:     "List your access tokens",
+						Args:      false,
+						ArgsUsage: "",
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
 
-					prefix := split[0]
-					id, err := strToInt(split[1])
-					if err != nil {
-						return err
-					}
+							tokens, err := pr.GetAccessTokens(user.ID)
+							if err != nil {
+								return err
+							}
 
-					switch prefix {
-					case "pr":
-						err = printPatchsetFromPrID(sesh, pr, id)
-					case "ps":
-						err = printPatchsetFromID(sesh, pr, id)
-					}
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								out := []*AccessTokenSchema{}
+								for _, token := range tokens {
+									out = append(out, NewAccessTokenSchema(token))
+								}
+								return writeJSONList(sesh, format, out)
+							}
 
-					return err
-				},
-			},
-			{
-				Name:  "pr",
-				Usage: "Manage Patch Requests (PR)",
-				Subcommands: []*cli.Command{
+							writer := NewTabWriter(sesh)
+							_, _ = fmt.Fprintln(writer, "ID\tName\tScopes\tDate\tLast Used\tExpires")
+							for _, token := range tokens {
+								lastUsed := "never"
+								if token.LastUsedAt.Valid {
+									lastUsed = token.LastUsedAt.Time.Format(be.Cfg.TimeFormat)
+								}
+								expires := "never"
+								if token.ExpiresAt.Valid {
+									expires = token.ExpiresAt.Time.Format(be.Cfg.TimeFormat)
+								}
+								_, _ = fmt.Fprintf(
+									writer,
+									"%d\t%s\t%s\t%s\t%s\t%s\n",
+									token.ID,
+									token.Name,
+									token.Scopes,
+									token.CreatedAt.Format(be.Cfg.TimeFormat),
+									lastUsed,
+									expires,
+								)
+							}
+							return writer.Flush()
+						},
+					},
 					{
-						Name:      "ls",
-						Usage:     "List all PRs",
+						Name:      "create",
+						Usage:     "Create an access token, it is only displayed once",
 						Args:      true,
-						ArgsUsage: "[repoName]",
+						ArgsUsage: "[name]",
 						Flags: []cli.Flag{
-							&cli.BoolFlag{
-								Name:  "open",
-								Usage: "only show open PRs",
-							},
-							&cli.BoolFlag{
-								Name:  "closed",
-								Usage: "only show closed PRs",
-							},
-							&cli.BoolFlag{
-								Name:  "accepted",
-								Usage: "only show accepted PRs",
+							&cli.StringFlag{
+								Name:  "scope",
+								Usage: fmt.Sprintf("comma separated scopes: %s", strings.Join(TOKEN_SCOPES, ",")),
+								Value: strings.Join(DEFAULT_TOKEN_SCOPES, ","),
 							},
-							&cli.BoolFlag{
-								Name:  "mine",
-								Usage: "only show your own PRs",
+							&cli.StringFlag{
+								Name:  "expires",
+								Usage: "expire the token after a duration like 30d or 12h",
 							},
 						},
 						Action: func(cCtx *cli.Context) error {
-							args := cCtx.Args()
-							rawRepoNs := args.First()
-							userName, repoName := be.SplitRepoNs(rawRepoNs)
-							var prs []*PatchRequest
-							var err error
-							if repoName == "" {
-								prs, err = pr.GetPatchRequests()
-								if err != nil {
-									return err
-								}
-							} else {
-								user, err := pr.GetUserByName(userName)
-								if err != nil {
-									return err
-								}
-								repo, err := pr.GetRepoByName(user, repoName)
-								if err != nil {
-									return err
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							scopes := []string{}
+							for _, scope := range strings.Split(cCtx.String("scope"), ",") {
+								if scope = strings.TrimSpace(scope); scope != "" {
+									scopes = append(scopes, scope)
 								}
-								prs, err = pr.GetPatchRequestsByRepoID(repo.ID)
+							}
+
+							expiresAt := sql.NullTime{}
+							if expires := cCtx.String("expires"); expires != "" {
+								dur, err := parseExpires(expires)
 								if err != nil {
 									return err
 								}
+								expiresAt = sql.NullTime{Time: time.Now().Add(dur), Valid: true}
 							}
 
-							onlyOpen := cCtx.Bool("open")
-							onlyAccepted := cCtx.Bool("accepted")
-							onlyClosed := cCtx.Bool("closed")
-							onlyMine := cCtx.Bool("mine")
+							accessToken, token, err := pr.CreateAccessToken(user.ID, cCtx.Args().First(), scopes, expiresAt)
+							if err != nil {
+								return err
+							}
 
-							writer := NewTabWriter(sesh)
-							_, _ = fmt.Fprintln(writer, "ID\tRepoID\tName\tStatus\tPatchsets\tUser\tDate")
-							for _, req := range prs {
-								if onlyAccepted && req.Status != StatusAccepted {
-									continue
-								}
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								out := NewAccessTokenSchema(accessToken)
+								out.Token = token
+								return writeJSON(sesh, format, out)
+							}
+							sesh.Printf("Access token created (ID: %d)\n\n%s\n\n", accessToken.ID, token)
+							sesh.Println("Copy it now, we cannot show it again.")
+							sesh.Printf("Send it with `Authorization: Bearer {token}` to https://%s%s\n", be.Cfg.Url, API_PREFIX)
+							return nil
+						},
+					},
+					{
+						Name:      "rm",
+						Usage:     "Remove an access token",
+						Args:      true,
+						ArgsUsage: "[id]",
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
 
-								if onlyClosed && req.Status != StatusClosed {
-									continue
-								}
+							tokenID, err := strconv.ParseInt(cCtx.Args().First(), 10, 64)
+							if err != nil {
+								return fmt.Errorf("must provide a token id")
+							}
 
-								if onlyOpen && req.Status != StatusOpen {
-									continue
-								}
+							err = pr.DeleteAccessToken(user.ID, tokenID)
+							if err != nil {
+								return err
+							}
 
-								user, err := pr.GetUserByID(req.UserID)
-								if err != nil {
-									be.Logger.Error("could not get user for pr", "err", err)
-									continue
-								}
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return writeJSON(sesh, format, &AccessTokenSchema{ID: tokenID})
+							}
+							sesh.Printf("access token removed: %d\n", tokenID)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "ps",
+				Usage: "Mange patchsets",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "rm",
+						Usage:     "Remove a patchset with its patches",
+						Args:      true,
+						ArgsUsage: "[patchsetID]",
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide a patchset ID")
+							}
 
-								if onlyMine && user.Name != userName {
-									continue
-								}
+							patchsetID, err := getPatchsetID(args.First())
+							if err != nil {
+								return err
+							}
 
-								patchsets, err := pr.GetPatchsetsByPrID(req.ID)
-								if err != nil {
-									be.Logger.Error("could not get patchsets for pr", "err", err)
-									continue
-								}
+							patchset, err := pr.GetPatchsetByID(patchsetID)
+							if err != nil {
+								return err
+							}
 
-								repo, err := pr.GetRepoByID(req.RepoID)
-								if err != nil {
-									be.Logger.Error("could not get repo for pr", "err", err)
-									continue
-								}
+							user, err := pr.GetUserByID(patchset.UserID)
+							if err != nil {
+								return err
+							}
 
-								repoUser, err := pr.GetUserByID(repo.UserID)
+							pk := sesh.PublicKey()
+							isAdmin := be.IsAdmin(pk)
+							isContrib := pubkey == user.Pubkey
+							if !isAdmin && !isContrib {
+								return fmt.Errorf("you are not authorized to delete a patchset")
+							}
+
+							err = pr.DeletePatchsetByID(user.ID, patchset.PatchRequestID, patchsetID)
+							if err != nil {
+								return err
+							}
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								ps, err := NewPatchsetSchema(pr, patchset)
 								if err != nil {
-									be.Logger.Error("could not get repo user for pr", "err", err)
-									continue
+									return err
 								}
-
-								_, _ = fmt.Fprintf(
-									writer,
-									"%d\t%s\t%s\t[%s]\t%d\t%s\t%s\n",
-									req.ID,
-									be.CreateRepoNs(repoUser.Name, repo.Name),
-									req.Name,
-									req.Status,
-									len(patchsets),
-									user.Name,
-									req.CreatedAt.Format(be.Cfg.TimeFormat),
-								)
+								return writeJSON(sesh, format, ps)
 							}
-							_ = writer.Flush()
+							sesh.Printf("successfully removed patchset: %d\n", patchsetID)
 							return nil
 						},
 					},
+				},
+			},
+			{
+				Name:  "repo",
+				Usage: "Manage repos",
+				Subcommands: []*cli.Command{
 					{
 						Name:      "create",
-						Usage:     "Submit a new PR",
+						Usage:     "Create a new repo",
 						Args:      true,
 						ArgsUsage: "[repoName]",
 						Action: func(cCtx *cli.Context) error {
@@ -597,54 +1003,1325 @@ To get started, submit a new patch request:
 							}
 
 							args := cCtx.Args()
-							rawRepoNs := "bin"
-							if args.Present() {
-								rawRepoNs = args.First()
+							if !args.Present() {
+								return fmt.Errorf("need repo name argument")
 							}
-							repoUsername, repoName := be.SplitRepoNs(rawRepoNs)
-							var repo *Repo
-							if repoUsername == "" {
-								if be.Cfg.CreateRepo == "admin" {
-									// single tenant default user to admin
-									repo, _ = pr.GetRepoByName(nil, repoName)
-								} else {
-									// multi tenant default user to contributor
-									repo, _ = pr.GetRepoByName(user, repoName)
+							repoName := args.First()
+							repo, _ := pr.GetRepoByName(user, repoName)
+							err = be.CanCreateRepo(repo, user)
+							if err != nil {
+								return err
+							}
+
+							if repo == nil {
+								repo, err = pr.CreateRepo(user, repoName)
+								if err != nil {
+									return err
 								}
-							} else {
-								repoUser, err := pr.GetUserByName(repoUsername)
+							}
+
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								out, err := NewRepoSchema(be, pr, repo)
 								if err != nil {
 									return err
 								}
-								repo, _ = pr.GetRepoByName(repoUser, repoName)
+								return writeJSON(sesh, format, out)
+							}
+							sesh.Printf("repo created: %s/%s\n", user.Name, repo.Name)
+							return nil
+						},
+					},
+					{
+						Name:      "rm",
+						Usage:     "Delete repo and associated patch requests",
+						Args:      true,
+						ArgsUsage: "[repoName]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "write",
+								Usage: "Are you sure you want to delete the repo and all patch requests?",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("need repo name argument")
+							}
+							rawRepoNs := args.First()
+							_, repoName := be.SplitRepoNs(rawRepoNs)
+							repo, _ := pr.GetRepoByName(user, repoName)
+							if repo == nil {
+								return fmt.Errorf("repo does not exist: %s/%s", user.Name, repoName)
+							}
+							err = be.CanCreateRepo(repo, user)
+							if err != nil {
+								return err
+							}
+
+							format := getOutputFormat(cCtx)
+							if format.IsJSON() && !cCtx.Bool("write") {
+								return fmt.Errorf("must provide `--write` flag to persist changes")
+							}
+
+							if cCtx.Bool("write") {
+								out, err := NewRepoSchema(be, pr, repo)
+								if err != nil {
+									return err
+								}
+								err = pr.DeleteRepo(user, repoName)
+								if err != nil {
+									return err
+								}
+								if format.IsJSON() {
+									return writeJSON(sesh, format, out)
+								}
+							} else {
+								sesh.Println("Must provide `--write` flag to persist changes")
+							}
+
+							sesh.Printf("repo deleted: %s/%s\n", user.Name, repo.Name)
+							return nil
+						},
+					},
+					{
+						Name:      "set",
+						Usage:     "Change a repo setting",
+						Args:      true,
+						ArgsUsage: "[owner/repoName] [key] [value]",
+						Description: "Without a value it is read from stdin.  Available settings:\n" +
+							"   range_diff_creation_factor  default `--creation-factor` for range-diffs, 0 uses the server default\n" +
+							"   range_diff_normalize        only diff the +/- lines in range-diffs by default\n" +
+							"   required_approvals          approvals needed before a PR can be accepted\n" +
+							"   description                 short summary shown on the repo page\n" +
+							"   homepage                    link to the project website\n" +
+							"   clone_url                   upstream git remote contributors clone\n" +
+							"   default_branch              branch patches are based on, defaults to main\n" +
+							"   contributing                markdown guide printed after `pr create`\n" +
+							"   pr_template                 markdown outline for PR descriptions",
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							args := cCtx.Args()
+							if args.Len() < 2 {
+								return fmt.Errorf("need repo name, key, and value arguments")
+							}
+							value := strings.Join(args.Slice()[2:], " ")
+							if args.Len() == 2 {
+								text, err := io.ReadAll(sesh)
+								if err != nil {
+									return fmt.Errorf("when no value is provided it is read from stdin")
+								}
+								value = string(text)
+							}
+							repo, err := FindRepo(be, pr, user, args.Get(0))
+							if err != nil {
+								return err
+							}
+							owner, err := pr.GetUserByID(repo.UserID)
+							if err != nil {
+								return err
+							}
+							err = be.CanModifyRepo(repo, user)
+							if err != nil {
+								return err
+							}
+
+							err = repo.Set(args.Get(1), value)
+							if err != nil {
+								return err
+							}
+							err = pr.UpdateRepo(repo, user.ID)
+							if err != nil {
+								return err
+							}
+
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								repo, err = pr.GetRepoByID(repo.ID)
+								if err != nil {
+									return err
+								}
+								out, err := NewRepoSchema(be, pr, repo)
+								if err != nil {
+									return err
+								}
+								return writeJSON(sesh, format, out)
+							}
+							if strings.Contains(value, "\n") {
+								sesh.Printf("repo updated: %s/%s %s\n", owner.Name, repo.Name, args.Get(1))
+								return nil
+							}
+							sesh.Printf("repo updated: %s/%s %s=%s\n", owner.Name, repo.Name, args.Get(1), value)
+							return nil
+						},
+					},
+					{
+						Name:      "show",
+						Usage:     "Display a repo and its settings",
+						Args:      true,
+						ArgsUsage: "[owner/repoName] [key]",
+						Description: "With a key only the raw value is printed, e.g.\n" +
+							"   `repo show alice/test pr_template > pr.md`",
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("need repo name argument")
+							}
+							repo, err := FindRepo(be, pr, user, args.First())
+							if err != nil {
+								return err
+							}
+
+							if args.Len() > 1 {
+								value, err := repo.Get(args.Get(1))
+								if err != nil {
+									return err
+								}
+								sesh.Printf("%s\n", value)
+								return nil
+							}
+
+							format := getOutputFormat(cCtx)
+							out, err := NewRepoSchema(be, pr, repo)
+							if err != nil {
+								return err
+							}
+							if format.IsJSON() {
+								return writeJSON(sesh, format, out)
+							}
+							return repoSummary(be, sesh, repo.Name, out)
+						},
+					},
+					{
+						Name:      "rename",
+						Usage:     "Rename a repo, the old name redirects to the new one",
+						Args:      true,
+						ArgsUsage: "[owner/repoName] [newName]",
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							args := cCtx.Args()
+							if args.Len() != 2 {
+								return fmt.Errorf("need repo name and new name arguments")
+							}
+							repo, err := RenameRepo(be, pr, user, args.Get(0), args.Get(1))
+							if err != nil {
+								return err
+							}
+							return printRepo(be, pr, sesh, getOutputFormat(cCtx), repo, "repo renamed")
+						},
+					},
+					{
+						Name:      "transfer",
+						Usage:     "Transfer the ownership of a repo, the new owner has to accept it",
+						Args:      true,
+						ArgsUsage: "[owner/repoName] [newOwner]",
+						Description: "Without arguments lists the repos waiting on you to accept them.\n" +
+							"   The new owner runs `repo transfer --accept owner/repoName` to take over the repo,\n" +
+							"   either side can call it off with `--cancel`.",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "accept",
+								Usage: "accept the ownership of a repo transferred to you",
+							},
+							&cli.BoolFlag{
+								Name:  "cancel",
+								Usage: "withdraw or decline a pending transfer",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							format := getOutputFormat(cCtx)
+							args := cCtx.Args()
+							if !args.Present() {
+								repos, err := pr.GetRepoTransfersByUserID(user.ID)
+								if err != nil {
+									return err
+								}
+								if format.IsJSON() {
+									out := []*RepoSchema{}
+									for _, repo := range repos {
+										rs, err := NewRepoSchema(be, pr, repo)
+										if err != nil {
+											return err
+										}
+										out = append(out, rs)
+									}
+									return writeJSONList(sesh, format, out)
+								}
+								if len(repos) == 0 {
+									sesh.Println("no pending repo transfers")
+									return nil
+								}
+								for _, repo := range repos {
+									owner, err := pr.GetUserByID(repo.UserID)
+									if err != nil {
+										return err
+									}
+									sesh.Println(be.CreateRepoNs(owner.Name, repo.Name))
+								}
+								return nil
+							}
+
+							var repo *Repo
+							var msg string
+							if cCtx.Bool("accept") {
+								repo, err = AcceptRepoTransfer(be, pr, user, args.First())
+								msg = "repo transferred"
+							} else if cCtx.Bool("cancel") {
+								repo, err = CancelRepoTransfer(be, pr, user, args.First())
+								msg = "repo transfer canceled"
+							} else {
+								if args.Len() != 2 {
+									return fmt.Errorf("need repo name and new owner arguments")
+								}
+								repo, err = TransferRepo(be, pr, user, args.First(), args.Get(1))
+								msg = fmt.Sprintf("repo transfer waiting on %s to accept", args.Get(1))
+							}
+							if err != nil {
+								return err
+							}
+							return printRepo(be, pr, sesh, format, repo, msg)
+						},
+					},
+					{
+						Name:      "archive",
+						Usage:     "Archive a repo, it stays browsable but rejects new PRs and patchsets",
+						Args:      true,
+						ArgsUsage: "[owner/repoName]",
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("need repo name argument")
+							}
+							repo, err := ArchiveRepo(be, pr, user, args.First(), true)
+							if err != nil {
+								return err
+							}
+							return printRepo(be, pr, sesh, getOutputFormat(cCtx), repo, "repo archived")
+						},
+					},
+					{
+						Name:      "unarchive",
+						Usage:     "Accept new PRs and patchsets in an archived repo again",
+						Args:      true,
+						ArgsUsage: "[owner/repoName]",
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("need repo name argument")
+							}
+							repo, err := ArchiveRepo(be, pr, user, args.First(), false)
+							if err != nil {
+								return err
+							}
+							return printRepo(be, pr, sesh, getOutputFormat(cCtx), repo, "repo unarchived")
+						},
+					},
+					{
+						Name:  "label",
+						Usage: "Manage the labels PRs in a repo can have",
+						Subcommands: []*cli.Command{
+							{
+								Name:      "ls",
+								Usage:     "List repo labels",
+								Args:      true,
+								ArgsUsage: "[owner/repoName]",
+								Action: func(cCtx *cli.Context) error {
+									user, err := pr.GetUserByPubkey(pubkey)
+									if err != nil {
+										return errNotExist(be.Cfg.Host, pubkey)
+									}
+
+									args := cCtx.Args()
+									if !args.Present() {
+										return fmt.Errorf("need repo name argument")
+									}
+									repo, err := FindRepo(be, pr, user, args.First())
+									if err != nil {
+										return err
+									}
+									labels, err := pr.GetLabelsByRepoID(repo.ID)
+									if err != nil {
+										return err
+									}
+
+									if format := getOutputFormat(cCtx); format.IsJSON() {
+										out := []*LabelSchema{}
+										for _, label := range labels {
+											out = append(out, NewLabelSchema(label))
+										}
+										return writeJSONList(sesh, format, out)
+									}
+									writer := NewTabWriter(sesh)
+									_, _ = fmt.Fprintln(writer, "Name\tColor\tDescription")
+									for _, label := range labels {
+										_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\n", label.Name, label.Color, label.Description)
+									}
+									_ = writer.Flush()
+									return nil
+								},
+							},
+							{
+								Name:      "add",
+								Usage:     "Create a repo label",
+								Args:      true,
+								ArgsUsage: "[owner/repoName] [name]",
+								Flags: []cli.Flag{
+									&cli.StringFlag{
+										Name:  "color",
+										Usage: "hex color of the label, e.g. #d73a4a",
+									},
+									&cli.StringFlag{
+										Name:  "description",
+										Usage: "what the label is used for",
+									},
+								},
+								Action: func(cCtx *cli.Context) error {
+									user, err := pr.GetUserByPubkey(pubkey)
+									if err != nil {
+										return errNotExist(be.Cfg.Host, pubkey)
+									}
+
+									args := cCtx.Args()
+									if args.Len() != 2 {
+										return fmt.Errorf("need repo name and label name arguments")
+									}
+									label, err := CreateRepoLabel(
+										be, pr, user, args.Get(0), args.Get(1),
+										cCtx.String("color"), cCtx.String("description"),
+									)
+									if err != nil {
+										return err
+									}
+
+									if format := getOutputFormat(cCtx); format.IsJSON() {
+										return writeJSON(sesh, format, NewLabelSchema(label))
+									}
+									sesh.Printf("label created: %s (%s)\n", label.Name, label.Color)
+									return nil
+								},
+							},
+							{
+								Name:      "rm",
+								Usage:     "Delete a repo label and remove it from every PR",
+								Args:      true,
+								ArgsUsage: "[owner/repoName] [name]",
+								Action: func(cCtx *cli.Context) error {
+									user, err := pr.GetUserByPubkey(pubkey)
+									if err != nil {
+										return errNotExist(be.Cfg.Host, pubkey)
+									}
+
+									args := cCtx.Args()
+									if args.Len() != 2 {
+										return fmt.Errorf("need repo name and label name arguments")
+									}
+									err = DeleteRepoLabel(be, pr, user, args.Get(0), args.Get(1))
+									if err != nil {
+										return err
+									}
+
+									if format := getOutputFormat(cCtx); format.IsJSON() {
+										return writeJSON(sesh, format, map[string]string{"deleted": args.Get(1)})
+									}
+									sesh.Printf("label deleted: %s\n", args.Get(1))
+									return nil
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:      "print",
+				Usage:     "Print patches in a patchset",
+				Args:      true,
+				ArgsUsage: "[pr-X] or [ps-X]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "stack",
+						Usage: "with pr-X, also print the open PRs it depends on in the order they apply",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					args := cCtx.Args()
+					raw := args.First()
+					split := strings.Split(raw, "-")
+					if len(split) < 2 {
+						return fmt.Errorf("must provide ID in format: pr-X, ps-X")
+					}
+
+					prefix := split[0]
+					id, err := strToInt(split[1])
+					if err != nil {
+						return err
+					}
+
+					format := getOutputFormat(cCtx)
+					switch prefix {
+					case "pr":
+						if cCtx.Bool("stack") {
+							err = printStackFromPrID(sesh, pr, format, id)
+						} else {
+							err = printPatchsetFromPrID(sesh, pr, format, id)
+						}
+					case "ps":
+						err = printPatchsetFromID(sesh, pr, format, id)
+					}
+
+					return err
+				},
+			},
+			{
+				Name:  "pr",
+				Usage: "Manage Patch Requests (PR)",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "ls",
+						Usage:     "List all PRs",
+						Args:      true,
+						ArgsUsage: "[repoName] [label:name]...",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "open",
+								Usage: "only show open PRs",
+							},
+							&cli.BoolFlag{
+								Name:  "closed",
+								Usage: "only show closed PRs",
+							},
+							&cli.BoolFlag{
+								Name:  "accepted",
+								Usage: "only show accepted PRs",
+							},
+							&cli.BoolFlag{
+								Name:  "mine",
+								Usage: "only show your own PRs",
+							},
+							&cli.BoolFlag{
+								Name:  "draft",
+								Usage: "only show draft PRs, hidden otherwise",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							labelFilters, args := parseLabelFilters(cCtx.Args().Slice())
+							rawRepoNs := ""
+							if len(args) > 0 {
+								rawRepoNs = args[0]
+							}
+							userName, repoName := be.SplitRepoNs(rawRepoNs)
+							var prs []*PatchRequest
+							var err error
+							if repoName == "" {
+								prs, err = pr.GetPatchRequests()
+								if err != nil {
+									return err
+								}
+							} else {
+								user, err := pr.GetUserByName(userName)
+								if err != nil {
+									return err
+								}
+								repo, err := pr.GetRepoByName(user, repoName)
+								if err != nil {
+									return err
+								}
+								prs, err = pr.GetPatchRequestsByRepoID(repo.ID)
+								if err != nil {
+									return err
+								}
+							}
+
+							onlyOpen := cCtx.Bool("open")
+							onlyAccepted := cCtx.Bool("accepted")
+							onlyClosed := cCtx.Bool("closed")
+							onlyMine := cCtx.Bool("mine")
+							onlyDraft := cCtx.Bool("draft")
+							format := getOutputFormat(cCtx)
+							out := []*PatchRequestSchema{}
+
+							writer := NewTabWriter(sesh)
+							_, _ = fmt.Fprintln(writer, "ID\tRepoID\tName\tStatus\tPatchsets\tUser\tDate")
+							for _, req := range prs {
+								if onlyAccepted && req.Status != StatusAccepted {
+									continue
+								}
+
+								if onlyClosed && req.Status != StatusClosed {
+									continue
+								}
+
+								if onlyOpen && req.Status != StatusOpen {
+									continue
+								}
+
+								if onlyDraft != (req.Status == StatusDraft) {
+									continue
+								}
+
+								if len(labelFilters) > 0 {
+									labels, err := pr.GetLabelsByPrID(req.ID)
+									if err != nil {
+										be.Logger.Error("could not get labels for pr", "err", err)
+										continue
+									}
+									if !hasLabels(labels, labelFilters) {
+										continue
+									}
+								}
+
+								user, err := pr.GetUserByID(req.UserID)
+								if err != nil {
+									be.Logger.Error("could not get user for pr", "err", err)
+									continue
+								}
+
+								if onlyMine && user.Name != userName {
+									continue
+								}
+
+								patchsets, err := pr.GetPatchsetsByPrID(req.ID)
+								if err != nil {
+									be.Logger.Error("could not get patchsets for pr", "err", err)
+									continue
+								}
+
+								repo, err := pr.GetRepoByID(req.RepoID)
+								if err != nil {
+									be.Logger.Error("could not get repo for pr", "err", err)
+									continue
+								}
+
+								repoUser, err := pr.GetUserByID(repo.UserID)
+								if err != nil {
+									be.Logger.Error("could not get repo user for pr", "err", err)
+									continue
+								}
+
+								if format.IsJSON() {
+									prSchema, err := NewPatchRequestSchema(be, pr, req)
+									if err != nil {
+										be.Logger.Error("could not build pr schema", "err", err)
+										continue
+									}
+									out = append(out, prSchema)
+									continue
+								}
+
+								_, _ = fmt.Fprintf(
+									writer,
+									"%d\t%s\t%s\t[%s]\t%d\t%s\t%s\n",
+									req.ID,
+									be.CreateRepoNs(repoUser.Name, repo.Name),
+									req.Name,
+									req.Status,
+									len(patchsets),
+									user.Name,
+									req.CreatedAt.Format(be.Cfg.TimeFormat),
+								)
+							}
+							if format.IsJSON() {
+								return writeJSONList(sesh, format, out)
+							}
+							_ = writer.Flush()
+							return nil
+						},
+					},
+					{
+						Name:      "create",
+						Usage:     "Submit a new PR",
+						Args:      true,
+						ArgsUsage: "[repoName]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "draft",
+								Usage: "submit the PR as a draft, kept out of the open filters until `pr ready`",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							args := cCtx.Args()
+							rawRepoNs := "bin"
+							if args.Present() {
+								rawRepoNs = args.First()
+							}
+							draft := cCtx.Bool("draft")
+							prq, err := CreatePatchRequest(be, pr, user, rawRepoNs, draft, sesh)
+							if err != nil {
+								return err
+							}
+
+							format := getOutputFormat(cCtx)
+							if !format.IsJSON() {
+								sesh.Println(
+									"PR submitted! Use the ID for interacting with this PR.",
+								)
+								if draft {
+									sesh.Printf("PR is a draft, run `pr ready %d` when it is ready for review.\n", prq.ID)
+								}
+							}
+
+							err = printPrSummary(be, pr, sesh, format, prq.ID)
+							if err != nil || format.IsJSON() {
+								return err
+							}
+							return printContributionGuide(be, pr, sesh, prq)
+						},
+					},
+					{
+						Name:      "summary",
+						Usage:     "Display metadata related to a PR",
+						Args:      true,
+						ArgsUsage: "[prID]",
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide a patch request ID")
+							}
+
+							prID, err := strToInt(args.First())
+							if err != nil {
+								return err
+							}
+							return printPrSummary(be, pr, sesh, getOutputFormat(cCtx), prID)
+						},
+					},
+					{
+						Name:      "rangediff",
+						Usage:     "Range-diff between any two patchsets",
+						Args:      true,
+						ArgsUsage: "[ps-X] [ps-Y]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "normalize",
+								Usage: "only diff the +/- lines of each file, ignoring context and hunk headers",
+							},
+							&cli.IntFlag{
+								Name:  "creation-factor",
+								Usage: "percentage of a patch's size pairing it with another patch may cost before it is shown as removed and added",
+							},
+							&cli.IntFlag{
+								Name:  "cost-max",
+								Usage: "cost of pairing patches that cannot be matched",
+							},
+							&cli.BoolFlag{
+								Name:  "message-only",
+								Usage: "only show changes to commit messages",
+							},
+							&cli.BoolFlag{
+								Name:  "no-dual-color",
+								Usage: "plain output without colors",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							var fromID, toID int64
+							var err error
+							switch args.Len() {
+							case 1:
+								fromID, toID, err = parsePatchsetRange(args.First())
+							case 2:
+								fromID, toID, err = parsePatchsetRange(args.Get(0) + ".." + args.Get(1))
+							default:
+								err = fmt.Errorf("must provide two patchset IDs: ps-X ps-Y")
+							}
+							if err != nil {
+								return err
+							}
+
+							from, err := pr.GetPatchsetByID(fromID)
+							if err != nil {
+								return fmt.Errorf("patchset not found: %d", fromID)
+							}
+							to, err := pr.GetPatchsetByID(toID)
+							if err != nil {
+								return fmt.Errorf("patchset not found: %d", toID)
+							}
+							opts, err := getPatchsetRangeDiffOpts(pr, to)
+							if err != nil {
+								return err
+							}
+							if cCtx.IsSet("normalize") {
+								opts.Normalize = cCtx.Bool("normalize")
+							}
+							if cCtx.IsSet("creation-factor") {
+								opts.CreationFactor = cCtx.Int("creation-factor")
+							}
+							opts.CostMax = cCtx.Int("cost-max")
+							opts.MessageOnly = cCtx.Bool("message-only")
+							diffs, err := pr.DiffPatchsets(from, to, opts)
+							if err != nil {
+								return err
+							}
+
+							format := getOutputFormat(cCtx)
+							if format.IsJSON() {
+								return writeJSONList(sesh, format, NewRangeDiffSchemas(diffs))
+							}
+							if _, _, isPty := sesh.Pty(); isPty && !cCtx.Bool("no-dual-color") {
+								sesh.Print(RangeDiffToColorStr(diffs))
+							} else {
+								sesh.Print(RangeDiffToStr(diffs))
+							}
+							return nil
+						},
+					},
+					{
+						Name:      "accept",
+						Usage:     "Accept a PR",
+						Args:      true,
+						ArgsUsage: "[prID], [prID]...",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "comment",
+								Usage: "If this flag is provided, pass comment through stdin",
+							},
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "accept even though PRs it depends on are open, admin only",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide at least one patch request ID")
+							}
+
+							prIDs := args.Tail()
+							prIDs = append(prIDs, args.First())
+
+							format := getOutputFormat(cCtx)
+							summaries := []*PrSummarySchema{}
+							var errs error
+							for _, prIDStr := range prIDs {
+								prID, err := strToInt(prIDStr)
+								if err != nil {
+									sesh.Errorln(err)
+									continue
+								}
+
+								user, err := pr.GetUserByPubkey(pubkey)
+								if err != nil {
+									return errNotExist(be.Cfg.Host, pubkey)
+								}
+
+								comment := cCtx.Bool("comment")
+								var commentTxt []byte
+								if comment {
+									commentTxt, err = io.ReadAll(sesh)
+									if err != nil {
+										return fmt.Errorf("when comment flag enabled must provide it from stdin")
+									}
+								}
+
+								var prq *PatchRequest
+								if cCtx.Bool("force") {
+									prq, err = ForceAcceptPatchRequest(be, pr, user, prID, string(commentTxt))
+								} else {
+									prq, err = ChangePatchRequestStatus(be, pr, user, prID, StatusAccepted, string(commentTxt))
+								}
+								if err != nil {
+									return err
+								}
+								if format.IsJSON() {
+									summary, err := NewPrSummarySchema(be, pr, prID)
+									if err != nil {
+										errs = errors.Join(errs, err)
+										continue
+									}
+									summaries = append(summaries, summary)
+									continue
+								}
+								sesh.Printf("Accepted PR %s (#%d)\n", prq.Name, prq.ID)
+								err = prSummary(be, pr, sesh, prID)
+								if err != nil {
+									errs = errors.Join(errs, err)
+								}
+								sesh.Printf("\n\n")
+							}
+
+							if format.IsJSON() {
+								errs = errors.Join(errs, writeJSONList(sesh, format, summaries))
+							}
+							return errs
+						},
+					},
+					{
+						Name:      "close",
+						Usage:     "Close a PR",
+						Args:      true,
+						ArgsUsage: "[prID], [prID]...",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "comment",
+								Usage: "If this flag is provided, pass comment through stdin",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide a patch request ID")
+							}
+
+							prIDs := args.Tail()
+							prIDs = append(prIDs, args.First())
+
+							format := getOutputFormat(cCtx)
+							summaries := []*PrSummarySchema{}
+							var errs error
+							for _, prIDStr := range prIDs {
+								prID, err := strToInt(prIDStr)
+								if err != nil {
+									sesh.Errorln(err)
+									continue
+								}
+
+								user, err := pr.GetUserByPubkey(pubkey)
+								if err != nil {
+									return errNotExist(be.Cfg.Host, pubkey)
+								}
+
+								comment := cCtx.Bool("comment")
+								var commentTxt []byte
+								if comment {
+									commentTxt, err = io.ReadAll(sesh)
+									if err != nil {
+										return fmt.Errorf("when comment flag enabled must provide it from stdin")
+									}
+								}
+
+								prq, err := ChangePatchRequestStatus(be, pr, user, prID, StatusClosed, string(commentTxt))
+								if err != nil {
+									return err
+								}
+								if format.IsJSON() {
+									summary, err := NewPrSummarySchema(be, pr, prID)
+									if err != nil {
+										errs = errors.Join(errs, err)
+										continue
+									}
+									summaries = append(summaries, summary)
+									continue
+								}
+								sesh.Printf("Closed PR %s (#%d)\n", prq.Name, prq.ID)
+								err = prSummary(be, pr, sesh, prID)
+								if err != nil {
+									errs = errors.Join(errs, err)
+								}
+								sesh.Printf("\n\n")
+							}
+							if format.IsJSON() {
+								errs = errors.Join(errs, writeJSONList(sesh, format, summaries))
+							}
+							return errs
+						},
+					},
+					{
+						Name:      "reopen",
+						Usage:     "Reopen a PR",
+						Args:      true,
+						ArgsUsage: "[prID]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "comment",
+								Usage: "If this flag is provided, pass comment through stdin",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide a patch request ID")
+							}
+
+							prID, err := strToInt(args.First())
+							if err != nil {
+								return err
+							}
+
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							comment := cCtx.Bool("comment")
+							var commentTxt []byte
+							if comment {
+								commentTxt, err = io.ReadAll(sesh)
+								if err != nil {
+									return fmt.Errorf("when comment flag enabled must provide it from stdin")
+								}
+							}
+							format := getOutputFormat(cCtx)
+							prq, err := ChangePatchRequestStatus(be, pr, user, prID, StatusOpen, string(commentTxt))
+							if err != nil {
+								return err
+							}
+							if !format.IsJSON() {
+								sesh.Printf("Reopened PR %s (#%d)\n", prq.Name, prq.ID)
+							}
+							return printPrSummary(be, pr, sesh, format, prID)
+						},
+					},
+					{
+						Name:      "edit",
+						Usage:     "Edit PR title or description",
+						Args:      true,
+						ArgsUsage: "[prID] [title]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "body",
+								Usage: "If this flag is provided, pass the description through stdin",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide a patch request ID")
+							}
+
+							prID, err := strToInt(args.First())
+							if err != nil {
+								return err
+							}
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							tail := cCtx.Args().Tail()
+							title := strings.Join(tail, " ")
+							format := getOutputFormat(cCtx)
+							if cCtx.Bool("body") {
+								body, err := io.ReadAll(sesh)
+								if err != nil {
+									return fmt.Errorf("when body flag enabled must provide it from stdin")
+								}
+								prq, err := EditPatchRequestBody(be, pr, user, prID, string(body))
+								if err != nil {
+									return err
+								}
+								if title == "" {
+									if format.IsJSON() {
+										return printPrSummary(be, pr, sesh, format, prID)
+									}
+									sesh.Printf("New description: %s (%d)\n", prq.Name, prq.ID)
+									return nil
+								}
+							}
+
+							prq, err := EditPatchRequest(be, pr, user, prID, title)
+							if err != nil {
+								return err
+							}
+
+							if format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
+							}
+							sesh.Printf("New title: %s (%d)\n", title, prq.ID)
+							return nil
+						},
+					},
+					{
+						Name:      "label",
+						Usage:     "Add or remove PR labels",
+						Args:      true,
+						ArgsUsage: "[prID] [+label] [-label]...",
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide a patch request ID")
+							}
+
+							prID, err := strToInt(args.First())
+							if err != nil {
+								return err
+							}
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							_, err = ChangePatchRequestLabels(be, pr, user, prID, args.Tail())
+							if err != nil {
+								return err
+							}
+
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
+							}
+							labels, err := pr.GetLabelsByPrID(prID)
+							if err != nil {
+								return err
+							}
+							sesh.Printf("Labels: %s (%d)\n", labelNames(labels), prID)
+							return nil
+						},
+					},
+					{
+						Name:      "depends",
+						Usage:     "Mark a PR as depending on another PR that has to land first",
+						Args:      true,
+						ArgsUsage: "[prID] --on [prID]",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "on",
+								Usage: "ID of the PR that has to be accepted first",
+							},
+							&cli.StringFlag{
+								Name:  "rm",
+								Usage: "ID of a PR to no longer depend on",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							on, args := cutTrailingFlag(cCtx.Args().Slice(), "on")
+							rm, args := cutTrailingFlag(args, "rm")
+							if on == "" {
+								on = cCtx.String("on")
+							}
+							if rm == "" {
+								rm = cCtx.String("rm")
+							}
+							if len(args) == 0 {
+								return fmt.Errorf("must provide a patch request ID")
+							}
+							if (on == "") == (rm == "") {
+								return fmt.Errorf("must provide either --on or --rm")
+							}
+
+							prID, err := strToInt(args[0])
+							if err != nil {
+								return err
+							}
+							remove := rm != ""
+							depID, err := strToInt(on + rm)
+							if err != nil {
+								return err
+							}
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							_, err = ChangePatchRequestDependency(be, pr, user, prID, depID, remove)
+							if err != nil {
+								return err
+							}
+
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
+							}
+							deps, err := pr.GetPatchRequestDependencies(prID)
+							if err != nil {
+								return err
+							}
+							sesh.Printf("Depends on: %s (%d)\n", prStatusList(deps), prID)
+							return nil
+						},
+					},
+					{
+						Name:      "move",
+						Usage:     "Transfer a PR along with its history to another repo",
+						Args:      true,
+						ArgsUsage: "[prID] [owner/repo]",
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if args.Len() < 2 {
+								return fmt.Errorf("must provide a patch request ID and a repo")
+							}
+
+							prID, err := strToInt(args.First())
+							if err != nil {
+								return err
+							}
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							prq, _, err := MovePatchRequest(be, pr, user, prID, args.Get(1))
+							if err != nil {
+								return err
+							}
+
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
+							}
+							sesh.Printf("Moved PR %s (#%d) to %s\n", prq.Name, prq.ID, args.Get(1))
+							return nil
+						},
+					},
+					{
+						Name:      "supersede",
+						Usage:     "Close a PR in favor of a newer PR",
+						Args:      true,
+						ArgsUsage: "[prID] --by [prID]",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "by",
+								Usage: "ID of the PR replacing it",
+							},
+							&cli.BoolFlag{
+								Name:  "fold",
+								Usage: "copy the patchsets into the history of the new PR",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							by, args := cutTrailingFlag(cCtx.Args().Slice(), "by")
+							if by == "" {
+								by = cCtx.String("by")
+							}
+							fold := cCtx.Bool("fold")
+							if idx := slices.Index(args, "--fold"); idx != -1 {
+								fold = true
+								args = slices.Delete(args, idx, idx+1)
+							}
+							if len(args) == 0 {
+								return fmt.Errorf("must provide a patch request ID")
+							}
+							if by == "" {
+								return fmt.Errorf("must provide --by")
+							}
+
+							prID, err := strToInt(args[0])
+							if err != nil {
+								return err
+							}
+							byID, err := strToInt(by)
+							if err != nil {
+								return err
+							}
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							prq, err := SupersedePatchRequest(be, pr, user, prID, byID, fold)
+							if err != nil {
+								return err
+							}
+
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
+							}
+							sesh.Printf("Superseded PR %s (#%d) by #%d\n", prq.Name, prq.ID, byID)
+							return nil
+						},
+					},
+					{
+						Name:      "ready",
+						Usage:     "Mark a draft PR as ready for review",
+						Args:      true,
+						ArgsUsage: "[prID]",
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide a patch request ID")
+							}
+
+							prID, err := strToInt(args.First())
+							if err != nil {
+								return err
+							}
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							prq, err := MarkPatchRequestReady(be, pr, user, prID)
+							if err != nil {
+								return err
+							}
+
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
+							}
+							sesh.Printf("PR %s (#%d) is ready for review\n", prq.Name, prq.ID)
+							return nil
+						},
+					},
+					{
+						Name:      "approve",
+						Usage:     "Approve the latest patchset of a PR",
+						Args:      true,
+						ArgsUsage: "[prID]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "comment",
+								Usage: "If this flag is provided, pass comment through stdin",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							args := cCtx.Args()
+							if !args.Present() {
+								return fmt.Errorf("must provide a patch request ID")
 							}
 
-							err = be.CanCreateRepo(repo, user)
+							prID, err := strToInt(args.First())
 							if err != nil {
 								return err
 							}
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
 
-							if repo == nil {
-								repo, err = pr.CreateRepo(user, repoName)
+							var commentTxt []byte
+							if cCtx.Bool("comment") {
+								commentTxt, err = io.ReadAll(sesh)
 								if err != nil {
-									return err
+									return fmt.Errorf("when comment flag enabled must provide it from stdin")
 								}
 							}
 
-							prq, err := pr.SubmitPatchRequest(repo.ID, user.ID, sesh)
+							prq, err := ApprovePatchRequest(be, pr, user, prID, string(commentTxt))
 							if err != nil {
 								return err
 							}
-							sesh.Println(
-								"PR submitted! Use the ID for interacting with this PR.",
-							)
 
-							return prSummary(be, pr, sesh, prq.ID)
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
+							}
+							sesh.Printf("Approved PR %s (#%d)\n", prq.Name, prq.ID)
+							return nil
 						},
 					},
 					{
-						Name:      "summary",
-						Usage:     "Display metadata related to a PR",
+						Name:      "unapprove",
+						Usage:     "Withdraw your approval of a PR",
 						Args:      true,
 						ArgsUsage: "[prID]",
 						Action: func(cCtx *cli.Context) error {
@@ -657,176 +2334,121 @@ To get started, submit a new patch request:
 							if err != nil {
 								return err
 							}
-							return prSummary(be, pr, sesh, prID)
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+
+							prq, err := UnapprovePatchRequest(be, pr, user, prID)
+							if err != nil {
+								return err
+							}
+
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
+							}
+							sesh.Printf("Withdrew approval of PR %s (#%d)\n", prq.Name, prq.ID)
+							return nil
 						},
 					},
 					{
-						Name:      "accept",
-						Usage:     "Accept a PR",
+						Name:      "comment",
+						Usage:     "Comment on a PR, pass the markdown through stdin",
 						Args:      true,
-						ArgsUsage: "[prID], [prID]...",
+						ArgsUsage: "[prID]",
 						Flags: []cli.Flag{
-							&cli.BoolFlag{
-								Name:  "comment",
-								Usage: "If this flag is provided, pass comment through stdin",
+							&cli.StringFlag{
+								Name:  "reply-to",
+								Usage: "ID of the comment to reply to",
+							},
+							&cli.StringFlag{
+								Name:  "edit",
+								Usage: "ID of your comment to replace with stdin",
+							},
+							&cli.StringFlag{
+								Name:  "rm",
+								Usage: "ID of your comment to delete",
 							},
 						},
 						Action: func(cCtx *cli.Context) error {
-							args := cCtx.Args()
-							if !args.Present() {
-								return fmt.Errorf("must provide at least one patch request ID")
+							replyTo, args := cutTrailingFlag(cCtx.Args().Slice(), "reply-to")
+							if replyTo == "" {
+								replyTo = cCtx.String("reply-to")
 							}
+							user, err := pr.GetUserByPubkey(pubkey)
+							if err != nil {
+								return errNotExist(be.Cfg.Host, pubkey)
+							}
+							format := getOutputFormat(cCtx)
 
-							prIDs := args.Tail()
-							prIDs = append(prIDs, args.First())
-
-							var errs error
-							for _, prIDStr := range prIDs {
-								prID, err := strToInt(prIDStr)
-								if err != nil {
-									sesh.Errorln(err)
-									continue
-								}
-
-								prq, err := pr.GetPatchRequestByID(prID)
-								if err != nil {
-									return err
-								}
-
-								user, err := pr.GetUserByPubkey(pubkey)
-								if err != nil {
-									return errNotExist(be.Cfg.Host, pubkey)
-								}
-
-								repo, err := pr.GetRepoByID(prq.RepoID)
+							if rm := cCtx.String("rm"); rm != "" {
+								commentID, err := strToInt(rm)
 								if err != nil {
 									return err
 								}
-
-								acl := be.GetPatchRequestAcl(repo, prq, user)
-								if !acl.CanReview {
-									return fmt.Errorf("you are not authorized to accept a PR")
-								}
-
-								if prq.Status == StatusAccepted {
-									return fmt.Errorf("PR has already been accepted")
-								}
-
-								comment := cCtx.Bool("comment")
-								var commentTxt []byte
-								if comment {
-									commentTxt, err = io.ReadAll(sesh)
-									if err != nil {
-										return fmt.Errorf("when comment flag enabled must provide it from stdin")
-									}
-								}
-
-								err = pr.UpdatePatchRequestStatus(prID, user.ID, StatusAccepted, string(commentTxt))
+								comment, err := DeleteComment(be, pr, user, commentID)
 								if err != nil {
 									return err
 								}
-								sesh.Printf("Accepted PR %s (#%d)\n", prq.Name, prq.ID)
-								err = prSummary(be, pr, sesh, prID)
-								if err != nil {
-									errs = errors.Join(errs, err)
+								if format.IsJSON() {
+									return printPrSummary(be, pr, sesh, format, comment.PatchRequestID)
 								}
-								sesh.Printf("\n\n")
+								sesh.Printf("Deleted comment %d (%d)\n", comment.ID, comment.PatchRequestID)
+								return nil
 							}
 
-							return errs
-						},
-					},
-					{
-						Name:      "close",
-						Usage:     "Close a PR",
-						Args:      true,
-						ArgsUsage: "[prID], [prID]...",
-						Flags: []cli.Flag{
-							&cli.BoolFlag{
-								Name:  "comment",
-								Usage: "If this flag is provided, pass comment through stdin",
-							},
-						},
-						Action: func(cCtx *cli.Context) error {
-							args := cCtx.Args()
-							if !args.Present() {
-								return fmt.Errorf("must provide a patch request ID")
+							text, err := io.ReadAll(sesh)
+							if err != nil {
+								return fmt.Errorf("must provide the comment from stdin")
 							}
 
-							prIDs := args.Tail()
-							prIDs = append(prIDs, args.First())
-
-							var errs error
-							for _, prIDStr := range prIDs {
-								prID, err := strToInt(prIDStr)
-								if err != nil {
-									sesh.Errorln(err)
-									continue
-								}
-
-								prq, err := pr.GetPatchRequestByID(prID)
-								if err != nil {
-									return err
-								}
-
-								patchUser, err := pr.GetUserByID(prq.UserID)
+							if edit := cCtx.String("edit"); edit != "" {
+								commentID, err := strToInt(edit)
 								if err != nil {
 									return err
 								}
-
-								repo, err := pr.GetRepoByID(prq.RepoID)
+								comment, err := EditComment(be, pr, user, commentID, string(text))
 								if err != nil {
 									return err
 								}
-
-								acl := be.GetPatchRequestAcl(repo, prq, patchUser)
-								if !acl.CanModify {
-									return fmt.Errorf("you are not authorized to change PR status")
-								}
-
-								if prq.Status == StatusClosed {
-									return fmt.Errorf("PR has already been closed")
-								}
-
-								user, err := pr.GetUserByPubkey(pubkey)
-								if err != nil {
-									return errNotExist(be.Cfg.Host, pubkey)
-								}
-
-								comment := cCtx.Bool("comment")
-								var commentTxt []byte
-								if comment {
-									commentTxt, err = io.ReadAll(sesh)
-									if err != nil {
-										return fmt.Errorf("when comment flag enabled must provide it from stdin")
-									}
+								if format.IsJSON() {
+									return printPrSummary(be, pr, sesh, format, comment.PatchRequestID)
 								}
+								sesh.Printf("Edited comment %d (%d)\n", comment.ID, comment.PatchRequestID)
+								return nil
+							}
 
-								err = pr.UpdatePatchRequestStatus(prID, user.ID, StatusClosed, string(commentTxt))
+							if len(args) == 0 {
+								return fmt.Errorf("must provide a patch request ID")
+							}
+							prID, err := strToInt(args[0])
+							if err != nil {
+								return err
+							}
+							var replyToID int64
+							if replyTo != "" {
+								replyToID, err = strToInt(replyTo)
 								if err != nil {
 									return err
 								}
-								sesh.Printf("Closed PR %s (#%d)\n", prq.Name, prq.ID)
-								err = prSummary(be, pr, sesh, prID)
-								if err != nil {
-									errs = errors.Join(errs, err)
-								}
-								sesh.Printf("\n\n")
 							}
-							return errs
+
+							comment, err := CreateComment(be, pr, user, prID, replyToID, string(text))
+							if err != nil {
+								return err
+							}
+							if format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
+							}
+							sesh.Printf("Added comment %d (%d)\n", comment.ID, prID)
+							return nil
 						},
 					},
 					{
-						Name:      "reopen",
-						Usage:     "Reopen a PR",
+						Name:      "request-review",
+						Usage:     "Ask users to review a PR",
 						Args:      true,
-						ArgsUsage: "[prID]",
-						Flags: []cli.Flag{
-							&cli.BoolFlag{
-								Name:  "comment",
-								Usage: "If this flag is provided, pass comment through stdin",
-							},
-						},
+						ArgsUsage: "[prID] [user]...",
 						Action: func(cCtx *cli.Context) error {
 							args := cCtx.Args()
 							if !args.Present() {
@@ -837,102 +2459,79 @@ To get started, submit a new patch request:
 							if err != nil {
 								return err
 							}
-
-							prq, err := pr.GetPatchRequestByID(prID)
-							if err != nil {
-								return err
-							}
-
-							patchUser, err := pr.GetUserByID(prq.UserID)
+							user, err := pr.GetUserByPubkey(pubkey)
 							if err != nil {
-								return err
+								return errNotExist(be.Cfg.Host, pubkey)
 							}
 
-							repo, err := pr.GetRepoByID(prq.RepoID)
+							_, err = RequestReview(be, pr, user, prID, args.Tail())
 							if err != nil {
 								return err
 							}
 
-							acl := be.GetPatchRequestAcl(repo, prq, patchUser)
-							if !acl.CanModify {
-								return fmt.Errorf("you are not authorized to change PR status")
-							}
-
-							if prq.Status == StatusOpen {
-								return fmt.Errorf("PR is already open")
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								return printPrSummary(be, pr, sesh, format, prID)
 							}
-
-							user, err := pr.GetUserByPubkey(pubkey)
+							reviewers, err := getReviewerNames(pr, prID)
 							if err != nil {
-								return errNotExist(be.Cfg.Host, pubkey)
-							}
-
-							comment := cCtx.Bool("comment")
-							var commentTxt []byte
-							if comment {
-								commentTxt, err = io.ReadAll(sesh)
-								if err != nil {
-									return fmt.Errorf("when comment flag enabled must provide it from stdin")
-								}
-							}
-							err = pr.UpdatePatchRequestStatus(prID, user.ID, StatusOpen, string(commentTxt))
-							if err == nil {
-								sesh.Printf("Reopened PR %s (#%d)\n", prq.Name, prq.ID)
+								return err
 							}
-							return prSummary(be, pr, sesh, prID)
+							sesh.Printf("Review requested: %s (%d)\n", strings.Join(reviewers, ", "), prID)
+							return nil
 						},
 					},
 					{
-						Name:      "edit",
-						Usage:     "Edit PR title",
-						Args:      true,
-						ArgsUsage: "[prID] [title]",
+						Name:  "queue",
+						Usage: "List open PRs awaiting your review, longest waiting first",
 						Action: func(cCtx *cli.Context) error {
-							args := cCtx.Args()
-							if !args.Present() {
-								return fmt.Errorf("must provide a patch request ID")
-							}
-
-							prID, err := strToInt(args.First())
-							if err != nil {
-								return err
-							}
-							prq, err := pr.GetPatchRequestByID(prID)
-							if err != nil {
-								return err
-							}
-
 							user, err := pr.GetUserByPubkey(pubkey)
 							if err != nil {
 								return errNotExist(be.Cfg.Host, pubkey)
 							}
 
-							repo, err := pr.GetRepoByID(prq.RepoID)
+							queue, err := GetReviewQueue(pr, user)
 							if err != nil {
 								return err
 							}
 
-							acl := be.GetPatchRequestAcl(repo, prq, user)
-							if !acl.CanModify {
-								return fmt.Errorf("you are not authorized to change PR")
-							}
-
-							tail := cCtx.Args().Tail()
-							title := strings.Join(tail, " ")
-							if title == "" {
-								return fmt.Errorf("must provide title")
+							if format := getOutputFormat(cCtx); format.IsJSON() {
+								out := []*ReviewQueueSchema{}
+								for _, entry := range queue {
+									schema, err := NewReviewQueueSchema(be, pr, entry)
+									if err != nil {
+										return err
+									}
+									out = append(out, schema)
+								}
+								return writeJSONList(sesh, format, out)
 							}
 
-							err = pr.UpdatePatchRequestName(
-								prID,
-								user.ID,
-								title,
-							)
-							if err == nil {
-								sesh.Printf("New title: %s (%d)\n", title, prq.ID)
+							writer := NewTabWriter(sesh)
+							_, _ = fmt.Fprintln(writer, "ID\tRepo\tName\tRequested by\tWaiting\tDate")
+							for _, entry := range queue {
+								repo, err := pr.GetRepoByID(entry.PatchRequest.RepoID)
+								if err != nil {
+									be.Logger.Error("could not get repo for pr", "err", err)
+									continue
+								}
+								repoUser, err := pr.GetUserByID(repo.UserID)
+								if err != nil {
+									be.Logger.Error("could not get repo user for pr", "err", err)
+									continue
+								}
+								_, _ = fmt.Fprintf(
+									writer,
+									"%d\t%s\t%s\t%s\t%s\t%s\n",
+									entry.PatchRequest.ID,
+									be.CreateRepoNs(repoUser.Name, repo.Name),
+									entry.PatchRequest.Name,
+									entry.RequestedBy.Name,
+									formatWaiting(time.Since(entry.Request.CreatedAt)),
+									entry.Request.CreatedAt.Format(be.Cfg.TimeFormat),
+								)
 							}
-
-							return err
+							_ = writer.Flush()
+							return nil
 						},
 					},
 					{
@@ -968,68 +2567,45 @@ To get started, submit a new patch request:
 							if err != nil {
 								return err
 							}
-							prq, err := pr.GetPatchRequestByID(prID)
-							if err != nil {
-								return err
-							}
-
 							user, err := pr.GetUserByPubkey(pubkey)
 							if err != nil {
 								return errNotExist(be.Cfg.Host, pubkey)
 							}
 
-							isReview := cCtx.Bool("review")
-							isAccept := cCtx.Bool("accept")
-							isClose := cCtx.Bool("close")
-
-							repo, err := pr.GetRepoByID(prq.RepoID)
-							if err != nil {
-								return err
-							}
-
-							acl := be.GetPatchRequestAcl(repo, prq, user)
-							if !acl.CanAddPatchset {
-								return fmt.Errorf("you are not authorized to add patchsets to pr")
-							}
-
-							if isReview && !acl.CanReview {
-								return fmt.Errorf("you are not authorized to submit a review to pr")
+							format := getOutputFormat(cCtx)
+							info := func(msg string) {
+								if !format.IsJSON() {
+									sesh.Println(msg)
+								}
 							}
 
 							op := OpNormal
-							nextStatus := StatusOpen
-							if isReview {
-								sesh.Println("Marking patchset as a review")
+							if cCtx.Bool("review") {
+								info("Marking patchset as a review")
 								op = OpReview
-							} else if isAccept {
-								sesh.Println("Marking PR as accepted")
-								nextStatus = StatusAccepted
+							} else if cCtx.Bool("accept") {
+								info("Marking PR as accepted")
 								op = OpAccept
-							} else if isClose {
-								sesh.Println("Marking PR as closed")
-								nextStatus = StatusClosed
+							} else if cCtx.Bool("close") {
+								info("Marking PR as closed")
 								op = OpClose
 							}
 
-							patches, err := pr.SubmitPatchset(prID, user.ID, op, sesh)
+							patches, err := AddPatchset(be, pr, user, prID, op, cCtx.String("comment"), sesh)
 							if err != nil {
 								return err
 							}
 
 							if len(patches) == 0 {
+								if format.IsJSON() {
+									return printPrSummary(be, pr, sesh, format, prID)
+								}
 								sesh.Println("Patches submitted! However none were saved, probably because they already exist in the system")
 								return nil
 							}
 
-							if prq.Status != nextStatus {
-								err = pr.UpdatePatchRequestStatus(prID, user.ID, nextStatus, cCtx.String("comment"))
-								if err != nil {
-									return err
-								}
-							}
-
-							sesh.Println("Patches submitted!")
-							return prSummary(be, pr, sesh, prID)
+							info("Patches submitted!")
+							return printPrSummary(be, pr, sesh, format, prID)
 						},
 					},
 				},
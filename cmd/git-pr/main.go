@@ -25,6 +25,16 @@ func main() {
 	git.LoadConfigFile(*fpath, logger)
 	cfg := git.NewGitCfg(logger)
 
+	if flag.Arg(0) == "archive" {
+		archive(cfg, flag.Args()[1:])
+		return
+	}
+
+	if flag.Arg(0) == "static" {
+		static(cfg, flag.Args()[1:])
+		return
+	}
+
 	// Web Server
 	addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.WebPort)
 	web := git.GitWebServer(cfg)
@@ -40,6 +50,17 @@ func main() {
 	// SSH Server
 	ssh := git.GitSshServer(ctx, cfg)
 
+	// SMTP Server
+	if cfg.SmtpPort != "" {
+		smtp := git.GitSmtpServer(cfg)
+		logger.Info("starting SMTP server", "addr", fmt.Sprintf("%s:%s", cfg.Host, cfg.SmtpPort))
+		go func() {
+			if err := smtp.ListenAndServe(ctx); err != nil {
+				logger.Error("smtp", "err", err.Error())
+			}
+		}()
+	}
+
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	logger.Info("starting SSH server", "addr", ssh.Config.ListenAddr)
@@ -58,3 +79,34 @@ func main() {
 	<-done
 	exit()
 }
+
+// archive exports an mbox archive for every repo to disk.
+func archive(cfg *git.GitCfg, args []string) {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	out := fs.String("out", "./archive", "directory to write mbox archives")
+	_ = fs.Parse(args)
+
+	if err := git.GitArchiveExport(cfg, *out); err != nil {
+		cfg.Logger.Error("archive", "err", err)
+		os.Exit(1)
+	}
+}
+
+// static renders the web pages to html files so the site can be served
+// without running the web server.
+func static(cfg *git.GitCfg, args []string) {
+	if len(args) == 0 || args[0] != "build" {
+		_, _ = fmt.Fprintln(os.Stderr, "usage: git-pr static build [-out dir] [-incremental]")
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("static build", flag.ExitOnError)
+	out := fs.String("out", "./public", "directory to write the static site")
+	incremental := fs.Bool("incremental", false, "only render pages affected by new events")
+	_ = fs.Parse(args[1:])
+
+	if err := git.GitStaticBuild(cfg, *out, *incremental); err != nil {
+		cfg.Logger.Error("static", "err", err)
+		os.Exit(1)
+	}
+}
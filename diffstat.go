@@ -0,0 +1,242 @@
+package git
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DIFFSTAT_GRAPH_WIDTH is the most +/- characters shown for a file in the
+// text diffstat, larger changes are scaled down like git does.
+var DIFFSTAT_GRAPH_WIDTH = 50
+
+// DiffStatFile is the lines changed in a file summed across every patch of a
+// patchset.  Patches are the IDs of the patches touching the file.
+type DiffStatFile struct {
+	Path    string
+	OldPath string
+	Adds    int64
+	Dels    int64
+	Binary  bool
+	Patches []int64
+}
+
+// Name is the path shown for the file, renames show both paths.
+func (f *DiffStatFile) Name() string {
+	if f.OldPath != "" && f.OldPath != f.Path {
+		return fmt.Sprintf("%s => %s", f.OldPath, f.Path)
+	}
+	return f.Path
+}
+
+// DiffStat is the aggregated diffstat of a patchset.
+type DiffStat struct {
+	Files []*DiffStatFile
+	Adds  int64
+	Dels  int64
+}
+
+// NewDiffStat parses every patch and sums the lines changed per file.
+// Files are sorted by path.
+func NewDiffStat(patches []*Patch) (*DiffStat, error) {
+	stat := &DiffStat{Files: []*DiffStatFile{}}
+	byPath := map[string]*DiffStatFile{}
+	for _, patch := range patches {
+		diffFiles, _, err := ParsePatch(patch.RawText)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range diffFiles {
+			path := file.NewName
+			if file.IsDelete || path == "" {
+				path = file.OldName
+			}
+
+			entry, ok := byPath[path]
+			// a file renamed by an earlier patch keeps its entry
+			if !ok && file.IsRename {
+				entry, ok = byPath[file.OldName]
+				if ok {
+					delete(byPath, file.OldName)
+					entry.Path = path
+					byPath[path] = entry
+				}
+			}
+			if !ok {
+				entry = &DiffStatFile{Path: path, Patches: []int64{}}
+				if file.IsRename {
+					entry.OldPath = file.OldName
+				}
+				byPath[path] = entry
+				stat.Files = append(stat.Files, entry)
+			}
+
+			if file.IsBinary {
+				entry.Binary = true
+			}
+			for _, frag := range file.TextFragments {
+				entry.Adds += frag.LinesAdded
+				entry.Dels += frag.LinesDeleted
+				stat.Adds += frag.LinesAdded
+				stat.Dels += frag.LinesDeleted
+			}
+			if !slices.Contains(entry.Patches, patch.ID) {
+				entry.Patches = append(entry.Patches, patch.ID)
+			}
+		}
+	}
+
+	slices.SortFunc(stat.Files, func(a, b *DiffStatFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return stat, nil
+}
+
+// File returns the entry for a path or nil when no patch touches it.
+func (s *DiffStat) File(path string) *DiffStatFile {
+	for _, file := range s.Files {
+		if file.Path == path || file.OldPath == path {
+			return file
+		}
+	}
+	return nil
+}
+
+func plural(num int64, one, many string) string {
+	if num == 1 {
+		return fmt.Sprintf("%d %s", num, one)
+	}
+	return fmt.Sprintf("%d %s", num, many)
+}
+
+// scaleGraph shrinks a change count to fit the graph width, any change is
+// at least one character.
+func scaleGraph(num, maxChange int64) int {
+	width := int64(DIFFSTAT_GRAPH_WIDTH)
+	if maxChange <= width || num == 0 {
+		return int(num)
+	}
+	return int(1 + num*(width-1)/maxChange)
+}
+
+// String formats the diffstat like `git format-patch --stat`.
+func (s *DiffStat) String() string {
+	nameWidth := 0
+	countWidth := 0
+	var maxChange int64 = 0
+	for _, file := range s.Files {
+		nameWidth = max(nameWidth, len(file.Name()))
+		countWidth = max(countWidth, len(fmt.Sprint(file.Adds+file.Dels)))
+		maxChange = max(maxChange, file.Adds+file.Dels)
+	}
+
+	var out strings.Builder
+	for _, file := range s.Files {
+		if file.Binary {
+			_, _ = fmt.Fprintf(&out, " %-*s | Bin\n", nameWidth, file.Name())
+			continue
+		}
+		total := file.Adds + file.Dels
+		adds := scaleGraph(file.Adds, maxChange)
+		dels := scaleGraph(total, maxChange) - adds
+		_, _ = fmt.Fprintf(
+			&out,
+			" %-*s | %*d %s%s\n",
+			nameWidth, file.Name(),
+			countWidth, total,
+			strings.Repeat("+", adds),
+			strings.Repeat("-", max(dels, 0)),
+		)
+	}
+
+	summary := " " + plural(int64(len(s.Files)), "file changed", "files changed")
+	if s.Adds > 0 || s.Dels == 0 {
+		summary += ", " + plural(s.Adds, "insertion(+)", "insertions(+)")
+	}
+	if s.Dels > 0 || s.Adds == 0 {
+		summary += ", " + plural(s.Dels, "deletion(-)", "deletions(-)")
+	}
+	_, _ = out.WriteString(summary + "\n")
+	return out.String()
+}
+
+// FileTreeNode is a directory or file in the file tree of a diffstat.
+// Directories sum the changes of every file below them.
+type FileTreeNode struct {
+	Name     string
+	Path     string
+	Adds     int64
+	Dels     int64
+	File     *DiffStatFile
+	Children []*FileTreeNode
+	// Url filters the patchset view down to this file.
+	Url      string
+	Selected bool
+}
+
+func (n *FileTreeNode) child(name, path string) *FileTreeNode {
+	for _, child := range n.Children {
+		if child.Name == name && child.File == nil {
+			return child
+		}
+	}
+	child := &FileTreeNode{Name: name, Path: path}
+	n.Children = append(n.Children, child)
+	return child
+}
+
+func (n *FileTreeNode) sort() {
+	slices.SortFunc(n.Children, func(a, b *FileTreeNode) int {
+		// directories first
+		if (a.File == nil) != (b.File == nil) {
+			if a.File == nil {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, child := range n.Children {
+		child.sort()
+	}
+}
+
+// compact merges directories that only contain a single directory so deep
+// paths do not waste a level each.
+func (n *FileTreeNode) compact() {
+	for _, child := range n.Children {
+		for child.File == nil && len(child.Children) == 1 && child.Children[0].File == nil {
+			grandchild := child.Children[0]
+			child.Name = child.Name + "/" + grandchild.Name
+			child.Path = grandchild.Path
+			child.Children = grandchild.Children
+		}
+		child.compact()
+	}
+}
+
+// FileTree groups the files of a diffstat by directory.
+func (s *DiffStat) FileTree() []*FileTreeNode {
+	root := &FileTreeNode{}
+	for _, file := range s.Files {
+		parts := strings.Split(file.Path, "/")
+		node := root
+		for idx, part := range parts[:len(parts)-1] {
+			node.Adds += file.Adds
+			node.Dels += file.Dels
+			node = node.child(part, strings.Join(parts[:idx+1], "/"))
+		}
+		node.Adds += file.Adds
+		node.Dels += file.Dels
+		node.Children = append(node.Children, &FileTreeNode{
+			Name: parts[len(parts)-1],
+			Path: file.Path,
+			Adds: file.Adds,
+			Dels: file.Dels,
+			File: file,
+		})
+	}
+	root.compact()
+	root.sort()
+	return root.Children
+}
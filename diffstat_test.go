@@ -0,0 +1,110 @@
+package git
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffStat(t *testing.T) {
+	by, err := os.ReadFile("fixtures/a_c_renamed_file.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := ParsePatchset(strings.NewReader(string(by)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, patch := range patches {
+		patch.ID = int64(idx + 1)
+	}
+
+	stat, err := NewDiffStat(patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ` README.md => DOCS.md | 4 +++-
+ requirements.txt     | 1 +
+ train.py             | 3 +++
+ 3 files changed, 7 insertions(+), 1 deletion(-)
+`
+	if stat.String() != expected {
+		t.Fatal(fail(expected, stat.String()))
+	}
+
+	file := stat.File("README.md")
+	if file == nil || file.Path != "DOCS.md" {
+		t.Fatal("expected renamed file to be found by its old path")
+	}
+	if !slices.Equal(file.Patches, []int64{2}) {
+		t.Fatalf("expected renamed file to be touched by patch 2, got %v", file.Patches)
+	}
+}
+
+func TestDiffStatScalesGraph(t *testing.T) {
+	stat := &DiffStat{
+		Files: []*DiffStatFile{
+			{Path: "big.go", Adds: 150, Dels: 50},
+			{Path: "small.go", Adds: 1},
+			{Path: "logo.png", Binary: true},
+		},
+		Adds: 151,
+		Dels: 50,
+	}
+	lines := strings.Split(stat.String(), "\n")
+	big := " big.go   | 200 " + strings.Repeat("+", 37) + strings.Repeat("-", 13)
+	if lines[0] != big {
+		t.Fatalf("expected %q, got %q", big, lines[0])
+	}
+	if lines[1] != " small.go |   1 +" {
+		t.Fatalf("expected small change to keep one char, got %q", lines[1])
+	}
+	if lines[2] != " logo.png | Bin" {
+		t.Fatalf("expected binary file, got %q", lines[2])
+	}
+}
+
+func TestDiffStatFileTree(t *testing.T) {
+	stat := &DiffStat{
+		Files: []*DiffStatFile{
+			{Path: "a/b/c.go", Adds: 1},
+			{Path: "a/b/d.go", Dels: 2},
+			{Path: "a/e.go", Adds: 3},
+			{Path: "f.go", Adds: 4},
+			{Path: "x/y/z.go", Adds: 5},
+		},
+	}
+
+	var flatten func(nodes []*FileTreeNode, depth int) []string
+	flatten = func(nodes []*FileTreeNode, depth int) []string {
+		out := []string{}
+		for _, node := range nodes {
+			out = append(out, strings.Repeat(" ", depth)+node.Name)
+			out = append(out, flatten(node.Children, depth+1)...)
+		}
+		return out
+	}
+
+	tree := stat.FileTree()
+	expected := []string{
+		"a",
+		" b",
+		"  c.go",
+		"  d.go",
+		" e.go",
+		"x/y",
+		" z.go",
+		"f.go",
+	}
+	actual := flatten(tree, 0)
+	if !slices.Equal(expected, actual) {
+		t.Fatal(fail(strings.Join(expected, "\n"), strings.Join(actual, "\n")))
+	}
+	if tree[0].Adds != 4 || tree[0].Dels != 2 {
+		t.Fatalf("expected directory to sum its files, got +%d -%d", tree[0].Adds, tree[0].Dels)
+	}
+	if tree[1].Path != "x/y" {
+		t.Fatalf("expected compacted directory path, got %q", tree[1].Path)
+	}
+}
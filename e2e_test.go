@@ -4,6 +4,7 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -98,10 +99,69 @@ func testMultiTenantE2E(t *testing.T) {
 	suite.userKey.MustCmd(nil, "pr edit 4 Reviewed patch")
 	suite.adminKey.MustCmd(suite.otherPatch, "pr add --review 4")
 
+	t.Log("Range-diff between patchsets")
+	actual, err := suite.userKey.Cmd(nil, "pr rangediff ps-4 ps-5")
+	bail(err)
+	if actual == "" || strings.Contains(actual, "\033[") {
+		t.Fatalf("expected plain range-diff output, found: %q", actual)
+	}
+
+	t.Log("Repo range-diff defaults")
+	suite.adminKey.MustCmd(nil, "repo set test range_diff_creation_factor 80")
+	_, err = suite.adminKey.Cmd(nil, "repo set test range_diff_creation_factor many")
+	if err == nil {
+		t.Fatal("repo set should validate the value")
+	}
+	_, err = suite.userKey.Cmd(nil, "repo set admin/test range_diff_normalize true")
+	if err == nil {
+		t.Fatal("contrib should not be able to change admin repo settings")
+	}
+	suite.userKey.MustCmd(nil, "pr rangediff --message-only --creation-factor 20 ps-4 ps-5")
+
+	t.Log("Repo labels")
+	suite.adminKey.MustCmd(nil, "repo label add --color d73a4a --description broken test bug")
+	suite.adminKey.MustCmd(nil, "repo label add test wip")
+	_, err = suite.userKey.Cmd(nil, "repo label add admin/test chore")
+	if err == nil {
+		t.Fatal("contrib should not be able to add labels to admin repo")
+	}
+	suite.userKey.MustCmd(nil, "pr label 1 +bug +wip")
+	suite.userKey.MustCmd(nil, "pr label 1 -wip")
+	_, err = suite.userKey.Cmd(nil, "pr label 1 +nope")
+	if err == nil {
+		t.Fatal("pr label should reject labels the repo does not have")
+	}
+	actual, err = suite.userKey.Cmd(nil, "pr ls admin/test label:bug")
+	bail(err)
+	if !strings.Contains(actual, "Accepted patch") || strings.Contains(actual, "Closed patch") {
+		t.Fatalf("expected only labeled prs, found: %q", actual)
+	}
+
 	t.Log("Accepted pr with review")
 	suite.userKey.MustCmd(suite.patch, "pr create test")
 	suite.userKey.MustCmd(nil, "pr edit 5 Accepted patch with review")
+	suite.userKey.MustCmd(nil, "pr request-review 5 admin")
+	actual, err = suite.adminKey.Cmd(nil, "pr queue")
+	bail(err)
+	if !strings.Contains(actual, "Accepted patch with review") {
+		t.Fatalf("expected pr in review queue, found: %q", actual)
+	}
+	suite.userKey.MustCmd(nil, "repo set test required_approvals 1")
+	_, err = suite.adminKey.Cmd(suite.otherPatch, "pr add --accept 5")
+	if err == nil {
+		t.Fatal("pr add --accept should require an approval")
+	}
+	_, err = suite.userKey.Cmd(nil, "pr approve 5")
+	if err == nil {
+		t.Fatal("contrib should not be able to approve their own PR")
+	}
+	suite.adminKey.MustCmd(nil, "pr approve 5")
 	suite.adminKey.MustCmd(suite.otherPatch, "pr add --accept 5")
+	actual, err = suite.adminKey.Cmd(nil, "pr queue")
+	bail(err)
+	if strings.Contains(actual, "Accepted patch with review") {
+		t.Fatalf("expected accept to clear the review request, found: %q", actual)
+	}
 
 	t.Log("Closed pr with review")
 	suite.userKey.MustCmd(suite.patch, "pr create test")
@@ -124,7 +184,7 @@ func testMultiTenantE2E(t *testing.T) {
 	suite.adminKey.MustCmd([]byte("nice work"), "pr accept --comment 9")
 
 	t.Log("Create pr with default `bin` repo")
-	actual, err := suite.userKey.Cmd(suite.patch, "pr create")
+	actual, err = suite.userKey.Cmd(suite.patch, "pr create")
 	bail(err)
 	snaps.MatchSnapshot(t, actual)
 
@@ -145,6 +205,144 @@ func testMultiTenantE2E(t *testing.T) {
 	actual, err = suite.userKey.Cmd(nil, "pr ls")
 	bail(err)
 	snaps.MatchSnapshot(t, actual)
+
+	t.Log("Draft pr")
+	suite.userKey.MustCmd(suite.patch, "pr create --draft admin/test")
+	suite.userKey.MustCmd(nil, "pr edit 11 Draft patch")
+	actual, err = suite.userKey.Cmd(nil, "pr ls admin/test")
+	bail(err)
+	if strings.Contains(actual, "Draft patch") {
+		t.Fatalf("expected drafts to be hidden from pr ls, found: %q", actual)
+	}
+	actual, err = suite.userKey.Cmd(nil, "pr ls --draft admin/test")
+	bail(err)
+	if !strings.Contains(actual, "Draft patch") {
+		t.Fatalf("expected pr ls --draft to show drafts, found: %q", actual)
+	}
+	_, err = suite.adminKey.Cmd(nil, "pr accept 11")
+	if err == nil {
+		t.Fatal("drafts should not be accepted")
+	}
+	suite.userKey.MustCmd(nil, "pr ready 11")
+	suite.adminKey.MustCmd(nil, "pr accept 11")
+
+	t.Log("Stacked prs")
+	suite.userKey.MustCmd(suite.patch, "pr create admin/test")
+	suite.userKey.MustCmd(suite.otherPatch, "pr create admin/test")
+	suite.userKey.MustCmd(nil, "pr depends 13 --on 12")
+	_, err = suite.userKey.Cmd(nil, "pr depends 12 --on 13")
+	if err == nil {
+		t.Fatal("pr depends should reject cycles")
+	}
+	actual, err = suite.userKey.Cmd(nil, "print --stack pr-13")
+	bail(err)
+	dep := strings.Index(actual, "Subject: [PATCH] feat")
+	if dep == -1 || dep > strings.Index(actual, "Subject: [PATCH 2/2]") {
+		t.Fatalf("expected the stack to print the dependency first, found: %q", actual)
+	}
+	_, err = suite.adminKey.Cmd(nil, "pr accept 13")
+	if err == nil {
+		t.Fatal("pr accept should wait for open dependencies")
+	}
+	suite.adminKey.MustCmd(nil, "pr accept --force 13")
+
+	t.Log("Comments")
+	suite.adminKey.MustCmd([]byte("why *torch*?"), "pr comment 12")
+	suite.userKey.MustCmd([]byte("for the rnn"), "pr comment 12 --reply-to 1")
+	_, err = suite.userKey.Cmd([]byte("hijacked"), "pr comment --edit 1")
+	if err == nil {
+		t.Fatal("pr comment --edit should be limited to the author")
+	}
+	suite.userKey.MustCmd([]byte("for the new rnn"), "pr comment --edit 2")
+	actual, err = suite.userKey.Cmd(nil, "pr summary 12")
+	bail(err)
+	if !strings.Contains(actual, "reply to [1] (edited)\n  for the new rnn") {
+		t.Fatalf("expected the comments in pr summary, found: %q", actual)
+	}
+	suite.adminKey.MustCmd(nil, "pr comment --rm 1")
+
+	t.Log("Edit pr description")
+	suite.userKey.MustCmd([]byte("Adds torch for the rnn."), "pr edit --body 12")
+	actual, err = suite.userKey.Cmd(nil, "--json pr summary 12")
+	bail(err)
+	if !strings.Contains(actual, `"text": "Adds torch for the rnn."`) {
+		t.Fatalf("expected the new description in pr summary, found: %q", actual)
+	}
+
+	t.Log("Move pr to another repo")
+	suite.userKey.MustCmd(nil, "repo create next")
+	suite.userKey.MustCmd(suite.patch, "pr create admin/test")
+	actual = suite.userKey.MustCmd(nil, "pr move 14 contributor/next")
+	if !strings.Contains(actual, "Moved PR") {
+		t.Fatalf("expected pr to be moved, found: %q", actual)
+	}
+	actual = suite.userKey.MustCmd(nil, "pr ls contributor/next")
+	if !strings.Contains(actual, "14") {
+		t.Fatalf("expected moved pr in the new repo, found: %q", actual)
+	}
+
+	t.Log("Supersede pr")
+	suite.userKey.MustCmd(suite.otherPatch, "pr create admin/test")
+	suite.userKey.MustCmd(nil, "pr supersede 12 --by 15 --fold")
+	actual, err = suite.userKey.Cmd(nil, "--json pr summary 12")
+	bail(err)
+	if !strings.Contains(actual, `"superseded_by": 15`) || !strings.Contains(actual, `"status": "closed"`) {
+		t.Fatalf("expected pr to be superseded, found: %q", actual)
+	}
+	actual = suite.userKey.MustCmd(nil, "pr summary 15")
+	if !strings.Contains(actual, "Supersedes: #12 [closed]") {
+		t.Fatalf("expected superseded pr in pr summary, found: %q", actual)
+	}
+
+	t.Log("Rename, transfer, and archive repo")
+	actual = suite.userKey.MustCmd(nil, "repo rename next moved")
+	if !strings.Contains(actual, "repo renamed: contributor/moved") {
+		t.Fatalf("expected repo to be renamed, found: %q", actual)
+	}
+	suite.userKey.MustCmd(nil, "repo transfer moved admin")
+	actual = suite.adminKey.MustCmd(nil, "repo transfer")
+	if !strings.Contains(actual, "contributor/moved") {
+		t.Fatalf("expected a pending transfer, found: %q", actual)
+	}
+	_, err = suite.userKey.Cmd(nil, "repo transfer --accept contributor/moved")
+	if err == nil {
+		t.Fatal("only the recipient should accept a transfer")
+	}
+	suite.adminKey.MustCmd(nil, "repo transfer --accept contributor/moved")
+	suite.adminKey.MustCmd(nil, "repo archive moved")
+	_, err = suite.userKey.Cmd(suite.patch, "pr create admin/moved")
+	if err == nil {
+		t.Fatal("archived repos should reject new prs")
+	}
+	suite.adminKey.MustCmd(nil, "repo unarchive moved")
+	actual, err = suite.adminKey.Cmd(nil, "logs --repo admin/moved")
+	bail(err)
+	for _, event := range []string{"repo_renamed", "repo_transferred", "repo_archived", "repo_unarchived"} {
+		if !strings.Contains(actual, event) {
+			t.Fatalf("expected %s in repo logs, found: %q", event, actual)
+		}
+	}
+
+	t.Log("Repo metadata and contribution guide")
+	suite.adminKey.MustCmd(nil, "repo set test description a tiny rnn")
+	suite.adminKey.MustCmd(nil, "repo set test default_branch trunk")
+	suite.adminKey.MustCmd([]byte("Run make test before sending patches."), "repo set test contributing")
+	_, err = suite.adminKey.Cmd(nil, "repo set test homepage ftp://pico.sh")
+	if err == nil {
+		t.Fatal("repo set should reject a homepage that is not http")
+	}
+	actual = suite.userKey.MustCmd(nil, "repo show admin/test")
+	if !strings.Contains(actual, "Description: a tiny rnn") || !strings.Contains(actual, "Default branch: trunk") {
+		t.Fatalf("expected repo metadata in repo show, found: %q", actual)
+	}
+	actual = suite.userKey.MustCmd(nil, "repo show admin/test contributing")
+	if actual != "Run make test before sending patches.\n" {
+		t.Fatalf("expected the raw value from repo show, found: %q", actual)
+	}
+	actual = suite.userKey.MustCmd(suite.patch, "pr create admin/test")
+	if !strings.Contains(actual, "Contributing to admin/test\n====\nRun make test before sending patches.") {
+		t.Fatalf("expected the contribution guide after pr create, found: %q", actual)
+	}
 }
 
 type TestSuite struct {
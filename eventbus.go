@@ -0,0 +1,196 @@
+package git
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// EVENT_SUB_BUFFER_SIZE is the number of events a subscriber can fall behind
+// before it is dropped from the bus.
+var EVENT_SUB_BUFFER_SIZE = 64
+
+var ErrSubscriberLagged = errors.New("subscriber fell too far behind and was dropped")
+
+// EventFilter narrows a subscription down to a repo or patch request.
+// Zero values match everything.
+type EventFilter struct {
+	RepoID int64
+	PrID   int64
+}
+
+func (f EventFilter) Match(eventLog *EventLog) bool {
+	if f.RepoID != 0 && eventLog.RepoID.Int64 != f.RepoID {
+		return false
+	}
+	if f.PrID != 0 && eventLog.PatchRequestID.Int64 != f.PrID {
+		return false
+	}
+	return true
+}
+
+// EventSub is a single subscription to the event bus.  Events are delivered
+// in the order they were published.  When the subscriber cannot keep up its
+// channel is closed and Err returns ErrSubscriberLagged.
+type EventSub struct {
+	C      <-chan *EventLog
+	ch     chan *EventLog
+	id     int64
+	filter EventFilter
+	err    error
+	bus    *EventBus
+}
+
+func (s *EventSub) Err() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.err
+}
+
+func (s *EventSub) Close() {
+	s.bus.unsubscribe(s, nil)
+}
+
+// EventBus is an in-process pub/sub for event logs.  It is fed by
+// CreateEventLog and only publishes events once their transaction commits.
+type EventBus struct {
+	Logger  *slog.Logger
+	mu      sync.Mutex
+	nextID  int64
+	subs    map[int64]*EventSub
+	pending map[*sqlx.Tx][]*EventLog
+}
+
+func NewEventBus(logger *slog.Logger) *EventBus {
+	return &EventBus{
+		Logger:  logger,
+		subs:    map[int64]*EventSub{},
+		pending: map[*sqlx.Tx][]*EventLog{},
+	}
+}
+
+func (b *EventBus) Subscribe(filter EventFilter) *EventSub {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID += 1
+	ch := make(chan *EventLog, EVENT_SUB_BUFFER_SIZE)
+	sub := &EventSub{
+		C:      ch,
+		ch:     ch,
+		id:     b.nextID,
+		filter: filter,
+		bus:    b,
+	}
+	b.subs[sub.id] = sub
+	return sub
+}
+
+func (b *EventBus) unsubscribe(sub *EventSub, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(sub, err)
+}
+
+// remove must be called while holding the lock.
+func (b *EventBus) remove(sub *EventSub, err error) {
+	if _, ok := b.subs[sub.id]; !ok {
+		return
+	}
+	delete(b.subs, sub.id)
+	sub.err = err
+	close(sub.ch)
+}
+
+// Publish delivers event logs to every matching subscriber without blocking.
+func (b *EventBus) Publish(eventLogs ...*EventLog) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.publish(eventLogs)
+}
+
+// publish must be called while holding the lock.
+func (b *EventBus) publish(eventLogs []*EventLog) {
+	for _, eventLog := range eventLogs {
+		for _, sub := range b.subs {
+			if !sub.filter.Match(eventLog) {
+				continue
+			}
+			select {
+			case sub.ch <- eventLog:
+			default:
+				b.Logger.Info("dropping lagged event subscriber", "sub", sub.id)
+				b.remove(sub, ErrSubscriberLagged)
+			}
+		}
+	}
+}
+
+// stage holds onto an event log until its transaction is either committed
+// or rolled back.
+func (b *EventBus) stage(tx *sqlx.Tx, eventLog *EventLog) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[tx] = append(b.pending[tx], eventLog)
+}
+
+// commit calls commitTx and publishes the event logs staged for the
+// transaction without releasing the lock in between, so subscribers receive
+// events in the order their transactions committed.
+func (b *EventBus) commit(tx *sqlx.Tx, commitTx func() error) error {
+	if b == nil {
+		return commitTx()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	eventLogs := b.pending[tx]
+	delete(b.pending, tx)
+	err := commitTx()
+	if err != nil {
+		return err
+	}
+	b.publish(eventLogs)
+	return nil
+}
+
+func (b *EventBus) discard(tx *sqlx.Tx) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.pending, tx)
+}
+
+// EventMessage is the wire format for streamed event logs.
+type EventMessage struct {
+	ID             int64     `json:"id"`
+	UserID         int64     `json:"user_id"`
+	RepoID         int64     `json:"repo_id,omitempty"`
+	PatchRequestID int64     `json:"pr_id,omitempty"`
+	PatchsetID     int64     `json:"patchset_id,omitempty"`
+	Event          string    `json:"event"`
+	CreatedAt      time.Time `json:"created_at"`
+	Data           EventData `json:"data"`
+}
+
+func NewEventMessage(eventLog *EventLog) EventMessage {
+	return EventMessage{
+		ID:             eventLog.ID,
+		UserID:         eventLog.UserID,
+		RepoID:         eventLog.RepoID.Int64,
+		PatchRequestID: eventLog.PatchRequestID.Int64,
+		PatchsetID:     eventLog.PatchsetID.Int64,
+		Event:          eventLog.Event,
+		CreatedAt:      eventLog.CreatedAt,
+		Data:           eventLog.Data,
+	}
+}
@@ -0,0 +1,157 @@
+package git
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func newTestBus() *EventBus {
+	return NewEventBus(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+}
+
+func testEvent(id, repoID, prID int64) *EventLog {
+	return &EventLog{
+		ID:             id,
+		RepoID:         sql.NullInt64{Int64: repoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_patchset_added",
+	}
+}
+
+func TestEventBusOrderedDelivery(t *testing.T) {
+	bus := newTestBus()
+	sub := bus.Subscribe(EventFilter{})
+	defer sub.Close()
+
+	for i := int64(1); i <= 10; i++ {
+		bus.Publish(testEvent(i, 1, 1))
+	}
+
+	for i := int64(1); i <= 10; i++ {
+		eventLog := <-sub.C
+		if eventLog.ID != i {
+			t.Fatalf("events out of order (expected:%d, actual:%d)", i, eventLog.ID)
+		}
+	}
+}
+
+func TestEventBusFilter(t *testing.T) {
+	bus := newTestBus()
+	prSub := bus.Subscribe(EventFilter{PrID: 2})
+	defer prSub.Close()
+	repoSub := bus.Subscribe(EventFilter{RepoID: 1})
+	defer repoSub.Close()
+
+	bus.Publish(testEvent(1, 1, 1), testEvent(2, 2, 2), testEvent(3, 1, 3))
+
+	if len(prSub.C) != 1 {
+		t.Fatalf("pr subscriber should receive 1 event, received %d", len(prSub.C))
+	}
+	if ev := <-prSub.C; ev.ID != 2 {
+		t.Fatalf("pr subscriber received wrong event: %d", ev.ID)
+	}
+	if len(repoSub.C) != 2 {
+		t.Fatalf("repo subscriber should receive 2 events, received %d", len(repoSub.C))
+	}
+}
+
+func TestEventBusDropsLaggedSubscriber(t *testing.T) {
+	bus := newTestBus()
+	sub := bus.Subscribe(EventFilter{})
+	for i := 0; i <= EVENT_SUB_BUFFER_SIZE; i++ {
+		bus.Publish(testEvent(int64(i+1), 1, 1))
+	}
+
+	count := 0
+	for range sub.C {
+		count += 1
+	}
+	if count != EVENT_SUB_BUFFER_SIZE {
+		t.Fatalf("expected buffered events to drain (expected:%d, actual:%d)", EVENT_SUB_BUFFER_SIZE, count)
+	}
+	if !errors.Is(sub.Err(), ErrSubscriberLagged) {
+		t.Fatalf("expected lagged error, got: %v", sub.Err())
+	}
+	// closing a dropped subscriber is a no-op
+	sub.Close()
+}
+
+func TestEventBusStagedUntilCommit(t *testing.T) {
+	bus := newTestBus()
+	sub := bus.Subscribe(EventFilter{})
+	defer sub.Close()
+
+	committed := &sqlx.Tx{}
+	rolledBack := &sqlx.Tx{}
+	bus.stage(committed, testEvent(1, 1, 1))
+	bus.stage(rolledBack, testEvent(2, 1, 1))
+	if len(sub.C) != 0 {
+		t.Fatal("staged events should not be published before commit")
+	}
+
+	bus.discard(rolledBack)
+	err := bus.commit(committed, func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.C) != 1 {
+		t.Fatalf("expected only committed event, received %d", len(sub.C))
+	}
+	if ev := <-sub.C; ev.ID != 1 {
+		t.Fatalf("received wrong event: %d", ev.ID)
+	}
+}
+
+func TestEventBusFailedCommit(t *testing.T) {
+	bus := newTestBus()
+	sub := bus.Subscribe(EventFilter{})
+	defer sub.Close()
+
+	tx := &sqlx.Tx{}
+	bus.stage(tx, testEvent(1, 1, 1))
+	err := bus.commit(tx, func() error { return errors.New("disk full") })
+	if err == nil {
+		t.Fatal("expected commit error")
+	}
+	if len(sub.C) != 0 || len(bus.pending) != 0 {
+		t.Fatal("expected events of a failed commit to be dropped")
+	}
+}
+
+func TestEventBusCommitOrder(t *testing.T) {
+	bus := newTestBus()
+	sub := bus.Subscribe(EventFilter{})
+	defer sub.Close()
+
+	var mu sync.Mutex
+	committed := []int64{}
+	var wg sync.WaitGroup
+	for i := int64(1); i <= int64(EVENT_SUB_BUFFER_SIZE); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx := &sqlx.Tx{}
+			bus.stage(tx, testEvent(i, 1, 1))
+			_ = bus.commit(tx, func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				committed = append(committed, i)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range committed {
+		eventLog := <-sub.C
+		if eventLog.ID != id {
+			t.Fatalf("events not in commit order (expected:%d, actual:%d)", id, eventLog.ID)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// mailResolver is the subset of net.Resolver we need to authenticate
+// senders, tests swap it out for canned records.
+type mailResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// SPF_MAX_LOOKUPS caps the DNS queries a single SPF check can trigger
+// (RFC 7208 section 4.6.4).
+var SPF_MAX_LOOKUPS = 10
+
+type spfResult string
+
+var (
+	spfPass     spfResult = "pass"
+	spfFail     spfResult = "fail"
+	spfSoftFail spfResult = "softfail"
+	spfNeutral  spfResult = "neutral"
+	spfNone     spfResult = "none"
+)
+
+var errSpfLookups = errors.New("spf: too many dns lookups")
+
+type spfChecker struct {
+	resolver mailResolver
+	ip       net.IP
+	lookups  int
+}
+
+func (c *spfChecker) lookup() error {
+	c.lookups += 1
+	if c.lookups > SPF_MAX_LOOKUPS {
+		return errSpfLookups
+	}
+	return nil
+}
+
+func (c *spfChecker) record(ctx context.Context, domain string) (string, error) {
+	txts, err := c.resolver.LookupTXT(ctx, domain)
+	if err != nil {
+		dnsErr := &net.DNSError{}
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	record := ""
+	for _, txt := range txts {
+		if txt == "v=spf1" || strings.HasPrefix(strings.ToLower(txt), "v=spf1 ") {
+			if record != "" {
+				return "", fmt.Errorf("spf: multiple records for %s", domain)
+			}
+			record = txt
+		}
+	}
+	return record, nil
+}
+
+// matchIPs reports whether the sender is in any of the addresses when
+// masked with the cidr suffix of the mechanism.
+func (c *spfChecker) matchIPs(ips []net.IP, cidr4, cidr6 int) bool {
+	for _, ip := range ips {
+		bits, ones := 128, cidr6
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits, ones = 32, cidr4
+		}
+		network := &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, bits)), Mask: net.CIDRMask(ones, bits)}
+		if (ip.To4() != nil) == (c.ip.To4() != nil) && network.Contains(c.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *spfChecker) hostIPs(ctx context.Context, host string) ([]net.IP, error) {
+	ips, err := c.resolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		dnsErr := &net.DNSError{}
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, nil
+		}
+	}
+	return ips, err
+}
+
+// parseCidr splits the optional "/24//64" suffix off a mechanism.
+func parseCidr(value string) (string, int, int, error) {
+	cidr4, cidr6 := 32, 128
+	value, v6, found := strings.Cut(value, "//")
+	if found {
+		if _, err := fmt.Sscanf(v6, "%d", &cidr6); err != nil || cidr6 > 128 {
+			return "", 0, 0, fmt.Errorf("spf: invalid cidr: %s", v6)
+		}
+	}
+	value, v4, found := strings.Cut(value, "/")
+	if found {
+		if _, err := fmt.Sscanf(v4, "%d", &cidr4); err != nil || cidr4 > 32 {
+			return "", 0, 0, fmt.Errorf("spf: invalid cidr: %s", v4)
+		}
+	}
+	return value, cidr4, cidr6, nil
+}
+
+// check evaluates the SPF policy of domain for the sender ip.  Macros,
+// "ptr" and "exists" are not supported and never match.
+func (c *spfChecker) check(ctx context.Context, domain string) (spfResult, error) {
+	record, err := c.record(ctx, domain)
+	if err != nil {
+		return spfNone, err
+	}
+	if record == "" {
+		return spfNone, nil
+	}
+
+	redirect := ""
+	for _, term := range strings.Fields(record)[1:] {
+		if value, found := strings.CutPrefix(strings.ToLower(term), "redirect="); found {
+			redirect = value
+			continue
+		}
+		if strings.Contains(term, "=") {
+			// unknown modifier
+			continue
+		}
+
+		result := spfPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			result, term = spfFail, term[1:]
+		case '~':
+			result, term = spfSoftFail, term[1:]
+		case '?':
+			result, term = spfNeutral, term[1:]
+		}
+
+		name, value, _ := strings.Cut(term, ":")
+		name, cidr, _ := strings.Cut(name, "/")
+		if cidr != "" {
+			value = value + "/" + cidr
+		}
+
+		matched := false
+		switch strings.ToLower(name) {
+		case "all":
+			matched = true
+		case "ip4", "ip6":
+			if !strings.Contains(value, "/") {
+				if strings.ToLower(name) == "ip4" {
+					value += "/32"
+				} else {
+					value += "/128"
+				}
+			}
+			_, network, err := net.ParseCIDR(value)
+			if err != nil {
+				return spfNone, fmt.Errorf("spf: invalid %s: %s", name, value)
+			}
+			matched = network.Contains(c.ip)
+		case "a", "mx":
+			host, cidr4, cidr6, err := parseCidr(value)
+			if err != nil {
+				return spfNone, err
+			}
+			if host == "" {
+				host = domain
+			}
+			if err := c.lookup(); err != nil {
+				return spfNone, err
+			}
+			hosts := []string{host}
+			if strings.ToLower(name) == "mx" {
+				mxs, err := c.resolver.LookupMX(ctx, host)
+				if err != nil {
+					dnsErr := &net.DNSError{}
+					if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+						return spfNone, err
+					}
+				}
+				hosts = []string{}
+				for _, mx := range mxs {
+					hosts = append(hosts, mx.Host)
+				}
+			}
+			for _, host := range hosts {
+				ips, err := c.hostIPs(ctx, host)
+				if err != nil {
+					return spfNone, err
+				}
+				if c.matchIPs(ips, cidr4, cidr6) {
+					matched = true
+					break
+				}
+			}
+		case "include":
+			if err := c.lookup(); err != nil {
+				return spfNone, err
+			}
+			res, err := c.check(ctx, value)
+			if err != nil {
+				return spfNone, err
+			}
+			if res == spfNone {
+				return spfNone, fmt.Errorf("spf: include has no record: %s", value)
+			}
+			matched = res == spfPass
+		case "ptr", "exists":
+			if err := c.lookup(); err != nil {
+				return spfNone, err
+			}
+		default:
+			return spfNone, fmt.Errorf("spf: unknown mechanism: %s", term)
+		}
+
+		if matched {
+			return result, nil
+		}
+	}
+
+	if redirect != "" {
+		if err := c.lookup(); err != nil {
+			return spfNone, err
+		}
+		res, err := c.check(ctx, redirect)
+		if err != nil {
+			return spfNone, err
+		}
+		if res == spfNone {
+			return spfNone, fmt.Errorf("spf: redirect has no record: %s", redirect)
+		}
+		return res, nil
+	}
+
+	return spfNeutral, nil
+}
+
+// checkSPF reports whether ip is allowed to send mail for domain.
+func checkSPF(ctx context.Context, resolver mailResolver, ip net.IP, domain string) (spfResult, error) {
+	checker := &spfChecker{resolver: resolver, ip: ip}
+	return checker.check(ctx, domain)
+}
+
+// headerField is a raw header line including any folded continuation lines
+// and the trailing CRLF.
+type headerField struct {
+	Name string
+	Raw  string
+}
+
+// splitMessage normalizes line endings to CRLF and splits the raw message
+// into header fields and body.
+func splitMessage(data []byte) ([]headerField, []byte) {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+
+	head, body, found := bytes.Cut(data, []byte("\r\n\r\n"))
+	if !found {
+		head, body = bytes.TrimSuffix(data, []byte("\r\n")), nil
+	}
+
+	fields := []headerField{}
+	for _, line := range strings.SplitAfter(string(head)+"\r\n", "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].Raw += line
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{Name: strings.TrimSpace(name), Raw: line})
+	}
+	return fields, body
+}
+
+var wspRe = regexp.MustCompile(`[ \t]+`)
+
+func canonicalHeader(field string, relaxed bool) string {
+	if !relaxed {
+		return field
+	}
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = wspRe.ReplaceAllString(value, " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(value) + "\r\n"
+}
+
+func canonicalBody(body []byte, relaxed bool) []byte {
+	if relaxed {
+		lines := bytes.Split(body, []byte("\r\n"))
+		for idx, line := range lines {
+			line = wspRe.ReplaceAll(line, []byte(" "))
+			lines[idx] = bytes.TrimRight(line, " ")
+		}
+		body = bytes.Join(lines, []byte("\r\n"))
+	}
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = bytes.TrimSuffix(body, []byte("\r\n"))
+	}
+	if len(body) == 0 && relaxed {
+		return body
+	}
+	return append(body, '\r', '\n')
+}
+
+func parseTags(value string) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range strings.Split(value, ";") {
+		key, val, found := strings.Cut(tag, "=")
+		if !found {
+			continue
+		}
+		tags[strings.TrimSpace(key)] = strings.Join(strings.Fields(val), "")
+	}
+	return tags
+}
+
+var dkimSigRe = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+
+// dkimHashes computes the body hash and the hash signed by "b=" for a
+// DKIM-Signature header.
+func dkimHashes(fields []headerField, body []byte, sig headerField, tags map[string]string) ([]byte, []byte, error) {
+	headerCanon, bodyCanon, _ := strings.Cut(tags["c"], "/")
+	relaxedHeader := headerCanon == "relaxed"
+	relaxedBody := bodyCanon == "relaxed"
+
+	bodyHash := sha256.Sum256(canonicalBody(body, relaxedBody))
+
+	h := sha256.New()
+	// each header listed in "h=" is consumed from the bottom up
+	used := map[int]bool{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		for idx := len(fields) - 1; idx >= 0; idx-- {
+			if used[idx] || !strings.EqualFold(fields[idx].Name, name) {
+				continue
+			}
+			used[idx] = true
+			_, _ = h.Write([]byte(canonicalHeader(fields[idx].Raw, relaxedHeader)))
+			break
+		}
+	}
+	name, value, _ := strings.Cut(sig.Raw, ":")
+	value = dkimSigRe.ReplaceAllString(value, "$1$2")
+	unsigned := canonicalHeader(name+":"+value, relaxedHeader)
+	_, _ = h.Write([]byte(strings.TrimSuffix(unsigned, "\r\n")))
+
+	return bodyHash[:], h.Sum(nil), nil
+}
+
+func dkimPublicKey(ctx context.Context, resolver mailResolver, selector, domain string) (crypto.PublicKey, error) {
+	txts, err := resolver.LookupTXT(ctx, fmt.Sprintf("%s._domainkey.%s", selector, domain))
+	if err != nil {
+		return nil, err
+	}
+	tags := parseTags(strings.Join(txts, ""))
+	raw, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("dkim: key revoked or invalid")
+	}
+	switch tags["k"] {
+	case "", "rsa":
+		key, err := x509.ParsePKIXPublicKey(raw)
+		if err != nil {
+			key, err = x509.ParsePKCS1PublicKey(raw)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	case "ed25519":
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("dkim: invalid ed25519 key")
+		}
+		return ed25519.PublicKey(raw), nil
+	}
+	return nil, fmt.Errorf("dkim: unsupported key type: %s", tags["k"])
+}
+
+// verifyDKIMSignature checks a single DKIM-Signature header.
+func verifyDKIMSignature(ctx context.Context, resolver mailResolver, fields []headerField, body []byte, sig headerField) (string, error) {
+	_, value, _ := strings.Cut(sig.Raw, ":")
+	tags := parseTags(value)
+	if tags["v"] != "1" || tags["d"] == "" || tags["s"] == "" {
+		return "", fmt.Errorf("dkim: malformed signature")
+	}
+	if !strings.Contains(":"+strings.ToLower(tags["h"])+":", ":from:") {
+		return "", fmt.Errorf("dkim: From header is not signed")
+	}
+	// anything could be appended after the signed part of the body
+	if _, ok := tags["l"]; ok {
+		return "", fmt.Errorf("dkim: body length limits are not supported")
+	}
+
+	bodyHash, hash, err := dkimHashes(fields, body, sig, tags)
+	if err != nil {
+		return "", err
+	}
+	expected, err := base64.StdEncoding.DecodeString(tags["bh"])
+	if err != nil || !bytes.Equal(expected, bodyHash) {
+		return "", fmt.Errorf("dkim: body hash does not match")
+	}
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return "", fmt.Errorf("dkim: invalid signature encoding")
+	}
+
+	key, err := dkimPublicKey(ctx, resolver, tags["s"], tags["d"])
+	if err != nil {
+		return "", err
+	}
+	switch tags["a"] {
+	case "rsa-sha256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("dkim: key does not match algorithm")
+		}
+		err = rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hash, signature)
+		if err != nil {
+			return "", fmt.Errorf("dkim: %w", err)
+		}
+	case "ed25519-sha256":
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("dkim: key does not match algorithm")
+		}
+		if !ed25519.Verify(edKey, hash, signature) {
+			return "", fmt.Errorf("dkim: signature does not match")
+		}
+	default:
+		return "", fmt.Errorf("dkim: unsupported algorithm: %s", tags["a"])
+	}
+	return strings.ToLower(tags["d"]), nil
+}
+
+// checkDKIM reports whether the message carries a valid DKIM signature from
+// domain or one of its parent domains.
+func checkDKIM(ctx context.Context, resolver mailResolver, data []byte, domain string) error {
+	fields, body := splitMessage(data)
+	// a second From header would be verified while the first one is used
+	// (RFC 6376 section 8.15)
+	froms := 0
+	for _, field := range fields {
+		if strings.EqualFold(field.Name, "From") {
+			froms += 1
+		}
+	}
+	if froms != 1 {
+		return fmt.Errorf("dkim: message must have exactly one From header")
+	}
+	domain = strings.ToLower(domain)
+	err := fmt.Errorf("dkim: message is not signed")
+	for _, field := range fields {
+		if !strings.EqualFold(field.Name, "DKIM-Signature") {
+			continue
+		}
+		signer, sigErr := verifyDKIMSignature(ctx, resolver, fields, body, field)
+		if sigErr != nil {
+			err = sigErr
+			continue
+		}
+		if signer == domain || strings.HasSuffix(domain, "."+signer) {
+			return nil
+		}
+		err = fmt.Errorf("dkim: signed by %s instead of %s", signer, domain)
+	}
+	return err
+}
@@ -5,6 +5,11 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bluekeyes/go-gitdiff/gitdiff"
@@ -17,6 +22,9 @@ const (
 	StatusClosed   Status = "closed"
 	StatusAccepted Status = "accepted"
 	StatusReviewed Status = "reviewed"
+	// StatusDraft is work in progress shared for early feedback, it is kept
+	// out of the open filters until the author marks it ready.
+	StatusDraft Status = "draft"
 )
 
 // User is a db model for users.
@@ -37,13 +45,182 @@ type Acl struct {
 	CreatedAt  time.Time      `db:"created_at"`
 }
 
-// Repo is a container for patch requests.
-type Repo struct {
+// UserEmail is an email address claimed by a user.  Only verified
+// addresses are used to map inbound email to users.
+type UserEmail struct {
 	ID        int64     `db:"id"`
-	Name      string    `db:"name"`
 	UserID    int64     `db:"user_id"`
+	Email     string    `db:"email"`
+	Verified  bool      `db:"verified"`
+	Token     string    `db:"token"`
 	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// AccessToken authenticates requests to the web api.  Only a hash of the
+// token is stored.
+type AccessToken struct {
+	ID         int64        `db:"id"`
+	UserID     int64        `db:"user_id"`
+	Name       string       `db:"name"`
+	TokenHash  string       `db:"token_hash"`
+	Scopes     string       `db:"scopes"`
+	CreatedAt  time.Time    `db:"created_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+	ExpiresAt  sql.NullTime `db:"expires_at"`
+}
+
+// HasScope reports whether the token grants the scope.  A write scope also
+// grants read access to the same resource.
+func (t *AccessToken) HasScope(scope string) bool {
+	resource, _, _ := strings.Cut(scope, ":")
+	for _, s := range strings.Split(t.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+		if strings.HasSuffix(scope, ":read") && s == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+// Repo is a container for patch requests.
+type Repo struct {
+	ID                      int64  `db:"id"`
+	Name                    string `db:"name"`
+	UserID                  int64  `db:"user_id"`
+	RangeDiffCreationFactor int    `db:"range_diff_creation_factor"`
+	RangeDiffNormalize      bool   `db:"range_diff_normalize"`
+	RequiredApprovals       int    `db:"required_approvals"`
+	// Archived repos are read-only, they reject new PRs and patchsets.
+	Archived bool `db:"archived"`
+	// TransferToID is the user a pending ownership transfer waits on.
+	TransferToID  sql.NullInt64 `db:"transfer_to_id"`
+	Description   string        `db:"description"`
+	Homepage      string        `db:"homepage"`
+	CloneURL      string        `db:"clone_url"`
+	DefaultBranch string        `db:"default_branch"`
+	// Contributing is a markdown guide printed after `pr create`,
+	// PrTemplate the outline contributors fill in as the PR description.
+	Contributing string    `db:"contributing"`
+	PrTemplate   string    `db:"pr_template"`
+	CreatedAt    time.Time `db:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+}
+
+// REPO_SETTINGS are the keys accepted by `repo set`.
+var REPO_SETTINGS = []string{
+	"range_diff_creation_factor",
+	"range_diff_normalize",
+	"required_approvals",
+	"description",
+	"homepage",
+	"clone_url",
+	"default_branch",
+	"contributing",
+	"pr_template",
+}
+
+// scpLikeUrl matches clone urls like git@github.com:picosh/git-pr.git.
+var scpLikeUrl = regexp.MustCompile(`^[\w.-]+@[\w.-]+:[^/]`)
+
+// Set changes a repo setting from its string value.
+func (r *Repo) Set(key, value string) error {
+	switch key {
+	case "range_diff_creation_factor":
+		factor, err := strconv.Atoi(value)
+		if err != nil || factor < 0 {
+			return fmt.Errorf("%s must be a positive number: %s", key, value)
+		}
+		r.RangeDiffCreationFactor = factor
+	case "range_diff_normalize":
+		normalize, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false: %s", key, value)
+		}
+		r.RangeDiffNormalize = normalize
+	case "required_approvals":
+		required, err := strconv.Atoi(value)
+		if err != nil || required < 0 {
+			return fmt.Errorf("%s must be a positive number: %s", key, value)
+		}
+		r.RequiredApprovals = required
+	case "description":
+		r.Description = strings.TrimSpace(value)
+	case "homepage":
+		value = strings.TrimSpace(value)
+		if value != "" {
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%s must be an http or https url: %s", key, value)
+			}
+		}
+		r.Homepage = value
+	case "clone_url":
+		value = strings.TrimSpace(value)
+		if value != "" && !scpLikeUrl.MatchString(value) {
+			u, err := url.Parse(value)
+			if err != nil || u.Host == "" || !slices.Contains([]string{"http", "https", "git", "ssh"}, u.Scheme) {
+				return fmt.Errorf("%s must be a git remote url: %s", key, value)
+			}
+		}
+		r.CloneURL = value
+	case "default_branch":
+		value = strings.TrimSpace(value)
+		if value == "" {
+			value = "main"
+		}
+		if strings.ContainsAny(value, " ~^:?*[\\") || strings.Contains(value, "..") || strings.HasPrefix(value, "-") {
+			return fmt.Errorf("%s must be a valid branch name: %s", key, value)
+		}
+		r.DefaultBranch = value
+	case "contributing":
+		r.Contributing = strings.TrimSpace(value)
+	case "pr_template":
+		r.PrTemplate = strings.TrimSpace(value)
+	default:
+		return fmt.Errorf(
+			"unknown repo setting %q, expected one of: %s",
+			key, strings.Join(REPO_SETTINGS, ", "),
+		)
+	}
+	return nil
+}
+
+// Get returns a repo setting as the string `Set` accepts.
+func (r *Repo) Get(key string) (string, error) {
+	switch key {
+	case "range_diff_creation_factor":
+		return strconv.Itoa(r.RangeDiffCreationFactor), nil
+	case "range_diff_normalize":
+		return strconv.FormatBool(r.RangeDiffNormalize), nil
+	case "required_approvals":
+		return strconv.Itoa(r.RequiredApprovals), nil
+	case "description":
+		return r.Description, nil
+	case "homepage":
+		return r.Homepage, nil
+	case "clone_url":
+		return r.CloneURL, nil
+	case "default_branch":
+		return r.DefaultBranch, nil
+	case "contributing":
+		return r.Contributing, nil
+	case "pr_template":
+		return r.PrTemplate, nil
+	}
+	return "", fmt.Errorf(
+		"unknown repo setting %q, expected one of: %s",
+		key, strings.Join(REPO_SETTINGS, ", "),
+	)
+}
+
+// RangeDiffOpts are the range-diff defaults for patch requests in the repo.
+func (r *Repo) RangeDiffOpts() RangeDiffOpts {
+	return RangeDiffOpts{
+		CreationFactor: r.RangeDiffCreationFactor,
+		Normalize:      r.RangeDiffNormalize,
+	}
 }
 
 // PatchRequest is a database model for patches submitted to a Repo.
@@ -56,6 +233,8 @@ type PatchRequest struct {
 	Status    Status    `db:"status"`
 	CreatedAt time.Time `db:"created_at"`
 	UpdatedAt time.Time `db:"updated_at"`
+	// SupersededBy is the patch request that replaced this one.
+	SupersededBy sql.NullInt64 `db:"superseded_by"`
 	// only used for aggregate queries
 	LastUpdated string `db:"last_updated"`
 }
@@ -92,6 +271,111 @@ func (p *Patch) CalcDiff() string {
 	return p.RawText
 }
 
+// RangeDiffCache is a computed range-diff between two patchsets.  Patchsets
+// never change so results only go stale when the algorithm or the options
+// used to compute them do.
+type RangeDiffCache struct {
+	ID               int64     `db:"id"`
+	PatchsetAID      int64     `db:"patchset_a_id"`
+	PatchsetBID      int64     `db:"patchset_b_id"`
+	AlgorithmVersion int       `db:"algorithm_version"`
+	Opts             string    `db:"opts"`
+	Data             string    `db:"data"`
+	CreatedAt        time.Time `db:"created_at"`
+}
+
+// Label categorizes patch requests within a repo.
+type Label struct {
+	ID          int64     `db:"id"`
+	RepoID      int64     `db:"repo_id"`
+	Name        string    `db:"name"`
+	Color       string    `db:"color"`
+	Description string    `db:"description"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// LABEL_DEFAULT_COLOR is used for labels created without a color.
+var LABEL_DEFAULT_COLOR = "#6e7781"
+
+var (
+	labelNameRe  = regexp.MustCompile(`^[\w.:/-]+$`)
+	labelColorRe = regexp.MustCompile(`^#?([0-9a-fA-F]{6})$`)
+)
+
+// validateLabelName makes sure a label can be used with `pr label +name` and
+// the `label:name` filter.
+func validateLabelName(name string) error {
+	if !labelNameRe.MatchString(name) || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid label name %q, use letters, numbers, and . _ : / -", name)
+	}
+	return nil
+}
+
+// normalizeLabelColor turns a hex color into `#rrggbb`.
+func normalizeLabelColor(color string) (string, error) {
+	if color == "" {
+		return LABEL_DEFAULT_COLOR, nil
+	}
+	match := labelColorRe.FindStringSubmatch(color)
+	if match == nil {
+		return "", fmt.Errorf("invalid label color %q, expected a hex color like #d73a4a", color)
+	}
+	return "#" + strings.ToLower(match[1]), nil
+}
+
+// ReviewRequest asks a user to review a patch request.  It is cleared once
+// the user submits a review or accepts the patch request.
+type ReviewRequest struct {
+	ID             int64     `db:"id"`
+	PatchRequestID int64     `db:"patch_request_id"`
+	UserID         int64     `db:"user_id"`
+	RequestedBy    int64     `db:"requested_by"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// Approval is a user saying a patchset looks good without submitting a
+// review patchset.  It goes stale once a newer non-review patchset is added.
+type Approval struct {
+	ID             int64     `db:"id"`
+	PatchRequestID int64     `db:"patch_request_id"`
+	PatchsetID     int64     `db:"patchset_id"`
+	UserID         int64     `db:"user_id"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// PatchRequestRevision is the title and description of a patch request
+// after an edit.  The first revision is the original.
+type PatchRequestRevision struct {
+	ID             int64     `db:"id"`
+	PatchRequestID int64     `db:"patch_request_id"`
+	UserID         int64     `db:"user_id"`
+	Name           string    `db:"name"`
+	Text           string    `db:"text"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// Comment is a discussion comment on a patch request written in markdown.
+// ReplyToID threads it under another comment on the same patch request.
+type Comment struct {
+	ID             int64         `db:"id"`
+	PatchRequestID int64         `db:"patch_request_id"`
+	UserID         int64         `db:"user_id"`
+	ReplyToID      sql.NullInt64 `db:"reply_to_id"`
+	Text           string        `db:"text"`
+	CreatedAt      time.Time     `db:"created_at"`
+	// UpdatedAt is set once the comment is edited.
+	UpdatedAt sql.NullTime `db:"updated_at"`
+}
+
+// EmailMessage maps the Message-Id of an inbound email to the patch request
+// it was submitted to so replies can be threaded.
+type EmailMessage struct {
+	MessageID      string        `db:"message_id"`
+	PatchRequestID int64         `db:"patch_request_id"`
+	PatchsetID     sql.NullInt64 `db:"patchset_id"`
+	CreatedAt      time.Time     `db:"created_at"`
+}
+
 // EventLog is a event log for RSS or other notification systems.
 type EventLog struct {
 	ID             int64         `db:"id"`
@@ -105,9 +389,18 @@ type EventLog struct {
 }
 
 type EventData struct {
-	Name    string `json:"name,omitempty"`
-	Status  Status `json:"status,omitempty"`
-	Comment string `json:"comment,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	Status        Status   `json:"status,omitempty"`
+	Comment       string   `json:"comment,omitempty"`
+	LabelsAdded   []string `json:"labels_added,omitempty"`
+	LabelsRemoved []string `json:"labels_removed,omitempty"`
+	Reviewers     []string `json:"reviewers,omitempty"`
+	DependsOn     int64    `json:"depends_on,omitempty"`
+	CommentID     int64    `json:"comment_id,omitempty"`
+	FromRepo      string   `json:"from_repo,omitempty"`
+	ToRepo        string   `json:"to_repo,omitempty"`
+	SupersededBy  int64    `json:"superseded_by,omitempty"`
+	Supersedes    int64    `json:"supersedes,omitempty"`
 }
 
 func (e EventData) String() string {
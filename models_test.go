@@ -0,0 +1,43 @@
+package git
+
+import "testing"
+
+func TestRepoSet(t *testing.T) {
+	repo := &Repo{}
+	for key, value := range map[string]string{
+		"range_diff_creation_factor": "80",
+		"range_diff_normalize":       "true",
+		"description":                "a tiny rnn",
+		"homepage":                   "https://pico.sh",
+		"clone_url":                  "git@github.com:picosh/test.git",
+		"default_branch":             "trunk",
+		"contributing":               "Run **make test** first.",
+		"pr_template":                "## Why\n\n## How",
+	} {
+		if err := repo.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if actual, _ := repo.Get(key); actual != value {
+			t.Fatalf("%s: expected %q, found %q", key, value, actual)
+		}
+	}
+
+	for key, value := range map[string]string{
+		"range_diff_creation_factor": "-1",
+		"range_diff_normalize":       "maybe",
+		"homepage":                   "javascript:alert(1)",
+		"clone_url":                  "not a url",
+		"default_branch":             "a..b",
+		"unknown":                    "1",
+	} {
+		if err := repo.Set(key, value); err == nil {
+			t.Fatalf("%s: expected %q to be rejected", key, value)
+		}
+	}
+	if err := repo.Set("clone_url", "https://github.com/picosh/test.git"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get("unknown"); err == nil {
+		t.Fatal("expected unknown setting to be rejected")
+	}
+}
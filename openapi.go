@@ -0,0 +1,189 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// openapiGen converts Go types into OpenAPI schemas using their json tags.
+// Named structs are stored as components and referenced.
+type openapiGen struct {
+	schemas map[string]any
+}
+
+func (g *openapiGen) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.schemas[name]; !ok {
+			// reserve the name first in case the type references itself
+			g.schemas[name] = nil
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func (g *openapiGen) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	required := []string{}
+	g.addFields(t, props, &required)
+	out := map[string]any{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+// addFields mirrors encoding/json, embedded structs are flattened.
+func (g *openapiGen) addFields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			g.addFields(ft, props, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		props[name] = g.schema(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func jsonResponse(desc string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": desc,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": schema},
+		},
+	}
+}
+
+// NewOpenAPI builds the OpenAPI document for the api routes.
+func NewOpenAPI(cfg *GitCfg, routes []*apiRoute) map[string]any {
+	gen := &openapiGen{schemas: map[string]any{}}
+	errRef := gen.schema(reflect.TypeOf(ErrorSchema{}))
+
+	paths := map[string]map[string]any{}
+	for _, route := range routes {
+		params := []any{}
+		for _, match := range apiPathParamRe.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]any{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		for _, param := range route.Query {
+			params = append(params, map[string]any{
+				"name":        param.Name,
+				"in":          "query",
+				"description": param.Desc,
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+
+		responses := map[string]any{
+			"200": jsonResponse("OK", gen.schema(reflect.TypeOf(route.Response))),
+			"400": jsonResponse("Bad request", errRef),
+			"401": jsonResponse("Invalid access token", errRef),
+			"403": jsonResponse("Not authorized", errRef),
+			"404": jsonResponse("Not found", errRef),
+		}
+		if route.method() != http.MethodGet {
+			responses["422"] = jsonResponse("Request could not be processed", errRef)
+		}
+		op := map[string]any{
+			"summary":    route.Summary,
+			"parameters": params,
+			"responses":  responses,
+		}
+		if route.Scope != "" {
+			op["description"] = fmt.Sprintf("Requires the `%s` scope when using an access token.", route.Scope)
+		}
+		if route.Private {
+			op["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+		}
+		if route.RawBody {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+				},
+			}
+		} else if route.Request != nil {
+			op["requestBody"] = map[string]any{
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": gen.schema(reflect.TypeOf(route.Request))},
+				},
+			}
+		}
+
+		path := API_PREFIX + route.Path
+		if _, ok := paths[path]; !ok {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.method())] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "git-pr",
+			"version":     "v1",
+			"description": fmt.Sprintf("Create an access token with `ssh %s token create --scope pr:read,pr:write {name}`", cfg.Url),
+		},
+		"servers": []any{
+			map[string]any{"url": fmt.Sprintf("https://%s", cfg.Url)},
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": gen.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
@@ -2,9 +2,12 @@ package git
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/mail"
+	"slices"
 	"strings"
 	"time"
 
@@ -32,9 +35,30 @@ type GitPatchRequest interface {
 	GetRepoByName(user *User, repoName string) (*Repo, error)
 	CreateRepo(user *User, repoName string) (*Repo, error)
 	DeleteRepo(user *User, repoName string) error
+	UpdateRepo(repo *Repo, userID int64) error
+	RenameRepo(repoID, userID int64, name string) error
+	RequestRepoTransfer(repoID, userID, toUserID int64) error
+	CancelRepoTransfer(repoID, userID int64) error
+	AcceptRepoTransfer(repoID, userID int64) error
+	ArchiveRepo(repoID, userID int64, archived bool) error
+	GetRepoRedirect(user *User, repoName string) (*Repo, error)
+	GetRepoTransfersByUserID(userID int64) ([]*Repo, error)
 	RegisterUser(pubkey, name string) (*User, error)
 	IsBanned(pubkey, ipAddress string) error
+	AddUserEmail(userID int64, email string) (*UserEmail, error)
+	VerifyUserEmail(userID int64, token string) (*UserEmail, error)
+	GetUserEmails(userID int64) ([]*UserEmail, error)
+	DeleteUserEmail(userID int64, email string) error
+	GetUserByEmail(email string) (*User, error)
+	CreateEmailMessages(prID, patchsetID int64, messageIDs []string) error
+	GetPatchRequestIDByMessageIDs(messageIDs []string) (int64, error)
+	CreateAccessToken(userID int64, name string, scopes []string, expiresAt sql.NullTime) (*AccessToken, string, error)
+	GetAccessTokens(userID int64) ([]*AccessToken, error)
+	DeleteAccessToken(userID, tokenID int64) error
+	GetAccessToken(token string) (*AccessToken, error)
 	SubmitPatchRequest(repoID int64, userID int64, patchset io.Reader) (*PatchRequest, error)
+	SubmitDraftPatchRequest(repoID int64, userID int64, patchset io.Reader) (*PatchRequest, error)
+	MarkPatchRequestReady(prID, userID int64) error
 	SubmitPatchset(prID, userID int64, op PatchsetOp, patchset io.Reader) ([]*Patch, error)
 	GetPatchRequestByID(prID int64) (*PatchRequest, error)
 	GetPatchRequests() ([]*PatchRequest, error)
@@ -44,15 +68,43 @@ type GitPatchRequest interface {
 	GetPatchsetByID(patchsetID int64) (*Patchset, error)
 	GetLatestPatchsetByPrID(prID int64) (*Patchset, error)
 	GetPatchesByPatchsetID(prID int64) ([]*Patch, error)
+	GetPatchByID(patchID int64) (*Patch, error)
 	UpdatePatchRequestStatus(prID, userID int64, status Status, comment string) error
 	UpdatePatchRequestName(prID, userID int64, name string) error
+	UpdatePatchRequestText(prID, userID int64, text string) error
+	GetPatchRequestRevisions(prID int64) ([]*PatchRequestRevision, error)
 	DeletePatchsetByID(userID, prID int64, patchsetID int64) error
 	CreateEventLog(tx *sqlx.Tx, eventLog EventLog) error
 	GetEventLogs() ([]*EventLog, error)
 	GetEventLogsByRepoName(user *User, repoName string) ([]*EventLog, error)
+	GetEventLogsByRepoID(repoID int64) ([]*EventLog, error)
 	GetEventLogsByPrID(prID int64) ([]*EventLog, error)
 	GetEventLogsByUserID(userID int64) ([]*EventLog, error)
-	DiffPatchsets(aset *Patchset, bset *Patchset) ([]*RangeDiffOutput, error)
+	DiffPatchsets(aset *Patchset, bset *Patchset, opts RangeDiffOpts) ([]*RangeDiffOutput, error)
+	CreateLabel(repoID, userID int64, name, color, description string) (*Label, error)
+	DeleteLabel(repoID, userID int64, name string) error
+	GetLabelsByRepoID(repoID int64) ([]*Label, error)
+	GetLabelsByPrID(prID int64) ([]*Label, error)
+	UpdatePatchRequestLabels(prID, userID int64, add, rm []string) error
+	CreateReviewRequests(prID, userID int64, reviewers []*User) error
+	GetReviewRequestsByPrID(prID int64) ([]*ReviewRequest, error)
+	GetReviewRequestsByUserID(userID int64) ([]*ReviewRequest, error)
+	ApprovePatchRequest(prID, userID int64, comment string) error
+	UnapprovePatchRequest(prID, userID int64) error
+	GetApprovalsByPrID(prID int64) ([]*Approval, error)
+	GetCurrentPatchsetByPrID(prID int64) (*Patchset, error)
+	AddPatchRequestDependency(prID, dependsOnID, userID int64) error
+	RemovePatchRequestDependency(prID, dependsOnID, userID int64) error
+	GetPatchRequestDependencies(prID int64) ([]*PatchRequest, error)
+	GetPatchRequestDependents(prID int64) ([]*PatchRequest, error)
+	MovePatchRequest(prID, repoID, userID int64) error
+	SupersedePatchRequest(oldID, newID, userID int64, fold bool) error
+	GetSupersededPatchRequests(prID int64) ([]*PatchRequest, error)
+	CreateComment(prID, userID, replyToID int64, text string) (*Comment, error)
+	GetCommentByID(commentID int64) (*Comment, error)
+	GetCommentsByPrID(prID int64) ([]*Comment, error)
+	UpdateComment(commentID int64, text string) error
+	DeleteComment(commentID int64) error
 }
 
 type PrCmd struct {
@@ -128,14 +180,390 @@ func (pr PrCmd) CreateRepo(user *User, repoName string) (*Repo, error) {
 }
 
 func (pr PrCmd) DeleteRepo(user *User, repoName string) error {
-	_, err := pr.Backend.DB.Exec(
-		"DELETE FROM repos WHERE user_id=? AND name=?",
-		user.ID,
-		repoName,
+	repo, err := pr.GetRepoByName(user, repoName)
+	if err != nil {
+		return err
+	}
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	// foreign keys are not enforced so labels, review requests, approvals,
+	// dependencies, comments, revisions, and redirects are removed by hand
+	_, err = tx.Exec(
+		"DELETE FROM pr_labels WHERE label_id IN (SELECT id FROM labels WHERE repo_id=?)",
+		repo.ID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM labels WHERE repo_id=?", repo.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"DELETE FROM review_requests WHERE patch_request_id IN (SELECT id FROM patch_requests WHERE repo_id=?)",
+		repo.ID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"DELETE FROM approvals WHERE patch_request_id IN (SELECT id FROM patch_requests WHERE repo_id=?)",
+		repo.ID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`DELETE FROM pr_dependencies
+		WHERE patch_request_id IN (SELECT id FROM patch_requests WHERE repo_id=?)
+		OR depends_on_id IN (SELECT id FROM patch_requests WHERE repo_id=?)`,
+		repo.ID, repo.ID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"DELETE FROM comments WHERE patch_request_id IN (SELECT id FROM patch_requests WHERE repo_id=?)",
+		repo.ID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"DELETE FROM patch_request_revisions WHERE patch_request_id IN (SELECT id FROM patch_requests WHERE repo_id=?)",
+		repo.ID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM repo_redirects WHERE repo_id=?", repo.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM repos WHERE id=?", repo.ID)
+	if err != nil {
+		return err
+	}
+	return pr.commit(tx)
+}
+
+// UpdateRepo saves the repo settings.
+func (pr PrCmd) UpdateRepo(repo *Repo, userID int64) error {
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	_, err = tx.Exec(
+		`UPDATE repos SET
+			range_diff_creation_factor=?,
+			range_diff_normalize=?,
+			required_approvals=?,
+			description=?,
+			homepage=?,
+			clone_url=?,
+			default_branch=?,
+			contributing=?,
+			pr_template=?,
+			updated_at=?
+		WHERE id=?`,
+		repo.RangeDiffCreationFactor,
+		repo.RangeDiffNormalize,
+		repo.RequiredApprovals,
+		repo.Description,
+		repo.Homepage,
+		repo.CloneURL,
+		repo.DefaultBranch,
+		repo.Contributing,
+		repo.PrTemplate,
+		time.Now(),
+		repo.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID: userID,
+		RepoID: sql.NullInt64{Int64: repo.ID, Valid: true},
+		Event:  "repo_updated",
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// moveRepo changes the owner and name of a repo.  The old location keeps
+// redirecting to the repo until another repo takes its place.
+func (pr PrCmd) moveRepo(tx *sqlx.Tx, repo *Repo, ownerID int64, name string) error {
+	var count int
+	err := tx.Get(
+		&count,
+		"SELECT COUNT(*) FROM repos WHERE user_id=? AND name=?",
+		ownerID, name,
+	)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("repo already exists: %s", name)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO repo_redirects (user_id, name, repo_id) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, name) DO UPDATE SET repo_id=excluded.repo_id, created_at=CURRENT_TIMESTAMP`,
+		repo.UserID, repo.Name, repo.ID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"DELETE FROM repo_redirects WHERE user_id=? AND name=?",
+		ownerID, name,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE repos SET user_id=?, name=?, transfer_to_id=NULL, updated_at=? WHERE id=?",
+		ownerID, name, time.Now(), repo.ID,
 	)
 	return err
 }
 
+// RenameRepo changes the name of a repo.
+func (pr PrCmd) RenameRepo(repoID, userID int64, name string) error {
+	repo, err := pr.GetRepoByID(repoID)
+	if err != nil {
+		return err
+	}
+	owner, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	err = pr.moveRepo(tx, repo, repo.UserID, name)
+	if err != nil {
+		return err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID: userID,
+		RepoID: sql.NullInt64{Int64: repoID, Valid: true},
+		Event:  "repo_renamed",
+		Data: EventData{
+			FromRepo: pr.Backend.CreateRepoNs(owner.Name, repo.Name),
+			ToRepo:   pr.Backend.CreateRepoNs(owner.Name, name),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// RequestRepoTransfer offers the ownership of a repo to another user, the
+// repo only changes hands once they accept.
+func (pr PrCmd) RequestRepoTransfer(repoID, userID, toUserID int64) error {
+	repo, err := pr.GetRepoByID(repoID)
+	if err != nil {
+		return err
+	}
+	owner, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return err
+	}
+	recipient, err := pr.GetUserByID(toUserID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	_, err = tx.Exec(
+		"UPDATE repos SET transfer_to_id=?, updated_at=? WHERE id=?",
+		toUserID, time.Now(), repoID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID: userID,
+		RepoID: sql.NullInt64{Int64: repoID, Valid: true},
+		Event:  "repo_transfer_requested",
+		Data: EventData{
+			FromRepo: pr.Backend.CreateRepoNs(owner.Name, repo.Name),
+			ToRepo:   pr.Backend.CreateRepoNs(recipient.Name, repo.Name),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// CancelRepoTransfer withdraws or declines a pending ownership transfer.
+func (pr PrCmd) CancelRepoTransfer(repoID, userID int64) error {
+	repo, err := pr.GetRepoByID(repoID)
+	if err != nil {
+		return err
+	}
+	if !repo.TransferToID.Valid {
+		return fmt.Errorf("repo has no pending transfer")
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	_, err = tx.Exec(
+		"UPDATE repos SET transfer_to_id=NULL, updated_at=? WHERE id=?",
+		time.Now(), repoID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID: userID,
+		RepoID: sql.NullInt64{Int64: repoID, Valid: true},
+		Event:  "repo_transfer_canceled",
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// AcceptRepoTransfer moves a repo to the user its pending transfer waits on.
+func (pr PrCmd) AcceptRepoTransfer(repoID, userID int64) error {
+	repo, err := pr.GetRepoByID(repoID)
+	if err != nil {
+		return err
+	}
+	if !repo.TransferToID.Valid {
+		return fmt.Errorf("repo has no pending transfer")
+	}
+	owner, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return err
+	}
+	recipient, err := pr.GetUserByID(repo.TransferToID.Int64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	err = pr.moveRepo(tx, repo, recipient.ID, repo.Name)
+	if err != nil {
+		return err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID: userID,
+		RepoID: sql.NullInt64{Int64: repoID, Valid: true},
+		Event:  "repo_transferred",
+		Data: EventData{
+			FromRepo: pr.Backend.CreateRepoNs(owner.Name, repo.Name),
+			ToRepo:   pr.Backend.CreateRepoNs(recipient.Name, repo.Name),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// ArchiveRepo marks a repo as read-only or makes it writable again.
+func (pr PrCmd) ArchiveRepo(repoID, userID int64, archived bool) error {
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	_, err = tx.Exec(
+		"UPDATE repos SET archived=?, updated_at=? WHERE id=?",
+		archived, time.Now(), repoID,
+	)
+	if err != nil {
+		return err
+	}
+
+	event := "repo_archived"
+	if !archived {
+		event = "repo_unarchived"
+	}
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID: userID,
+		RepoID: sql.NullInt64{Int64: repoID, Valid: true},
+		Event:  event,
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// GetRepoRedirect returns the repo that used to be found at a user's repo
+// name before it was renamed or transferred.
+func (pr PrCmd) GetRepoRedirect(user *User, repoName string) (*Repo, error) {
+	var repo Repo
+	err := pr.Backend.DB.Get(
+		&repo,
+		`SELECT repos.* FROM repos
+		INNER JOIN repo_redirects ON repo_redirects.repo_id=repos.id
+		WHERE repo_redirects.user_id=? AND repo_redirects.name=?`,
+		user.ID, repoName,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("repo not found: %s", repoName)
+	}
+	return &repo, nil
+}
+
+// GetRepoTransfersByUserID returns the repos waiting on a user to accept
+// their ownership.
+func (pr PrCmd) GetRepoTransfersByUserID(userID int64) ([]*Repo, error) {
+	repos := []*Repo{}
+	err := pr.Backend.DB.Select(
+		&repos,
+		"SELECT * FROM repos WHERE transfer_to_id=? ORDER BY id ASC",
+		userID,
+	)
+	return repos, err
+}
+
 func (pr PrCmd) GetRepoByID(repoID int64) (*Repo, error) {
 	var repo Repo
 	err := pr.Backend.DB.Get(&repo, "SELECT * FROM repos WHERE id=?", repoID)
@@ -167,60 +595,1226 @@ func (pr PrCmd) GetRepoByName(user *User, repoName string) (*Repo, error) {
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("repo not found: %s", repoName)
+		return nil, fmt.Errorf("repo not found: %s", repoName)
+	}
+
+	return &repo, nil
+}
+
+func (pr PrCmd) createUser(pubkey, name string) (*User, error) {
+	if pubkey == "" {
+		return nil, fmt.Errorf("must provide pubkey when creating user")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("must provide user name when creating user")
+	}
+
+	userName, err := pr.computeUserName(name)
+	if err != nil {
+		pr.Backend.Logger.Error("could not compute username", "err", err)
+	}
+
+	var userID int64
+	row := pr.Backend.DB.QueryRow(
+		"INSERT INTO app_users (pubkey, name) VALUES (?, ?) RETURNING id",
+		pubkey,
+		userName,
+	)
+	err = row.Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+	if userID == 0 {
+		return nil, fmt.Errorf("could not create user")
+	}
+
+	user, err := pr.GetUserByID(userID)
+	return user, err
+}
+
+func (pr PrCmd) RegisterUser(pubkey, name string) (*User, error) {
+	sanName := strings.ToLower(name)
+	if pubkey == "" {
+		return nil, fmt.Errorf("must provide pubkey during upsert")
+	}
+	_, err := pr.GetUserByPubkey(pubkey)
+	if err == nil {
+		return nil, fmt.Errorf("pubkey is already registered by another user")
+	}
+	return pr.createUser(pubkey, sanName)
+}
+
+func normalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", fmt.Errorf("invalid email address: %s", email)
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// AddUserEmail claims an email address for a user and generates a new
+// verification token for it.
+func (pr PrCmd) AddUserEmail(userID int64, email string) (*UserEmail, error) {
+	addr, err := normalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing UserEmail
+	err = pr.Backend.DB.Get(
+		&existing,
+		"SELECT * FROM user_emails WHERE user_id=? AND email=?",
+		userID, addr,
+	)
+	if err == nil && existing.Verified {
+		return nil, fmt.Errorf("email has already been verified: %s", addr)
+	}
+
+	_, err = pr.Backend.DB.Exec(
+		`INSERT INTO user_emails (user_id, email, token) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, email) DO UPDATE SET token=excluded.token`,
+		userID, addr, newVerifyToken(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var userEmail UserEmail
+	err = pr.Backend.DB.Get(
+		&userEmail,
+		"SELECT * FROM user_emails WHERE user_id=? AND email=?",
+		userID, addr,
+	)
+	return &userEmail, err
+}
+
+func (pr PrCmd) VerifyUserEmail(userID int64, token string) (*UserEmail, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, fmt.Errorf("must provide verification code")
+	}
+
+	var userEmail UserEmail
+	err := pr.Backend.DB.Get(
+		&userEmail,
+		"SELECT * FROM user_emails WHERE user_id=? AND token=? AND verified=false",
+		userID, token,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid verification code")
+	}
+
+	owner, err := pr.GetUserByEmail(userEmail.Email)
+	if err == nil && owner.ID != userID {
+		return nil, fmt.Errorf("email has already been verified by another user: %s", userEmail.Email)
+	}
+
+	_, err = pr.Backend.DB.Exec(
+		"UPDATE user_emails SET verified=true, token='' WHERE id=?",
+		userEmail.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	userEmail.Verified = true
+	userEmail.Token = ""
+	return &userEmail, nil
+}
+
+func (pr PrCmd) GetUserEmails(userID int64) ([]*UserEmail, error) {
+	emails := []*UserEmail{}
+	err := pr.Backend.DB.Select(
+		&emails,
+		"SELECT * FROM user_emails WHERE user_id=? ORDER BY email ASC",
+		userID,
+	)
+	return emails, err
+}
+
+func (pr PrCmd) DeleteUserEmail(userID int64, email string) error {
+	addr, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	res, err := pr.Backend.DB.Exec(
+		"DELETE FROM user_emails WHERE user_id=? AND email=?",
+		userID, addr,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("email not found: %s", addr)
+	}
+	return nil
+}
+
+// GetUserByEmail finds the user that has verified the email address.
+func (pr PrCmd) GetUserByEmail(email string) (*User, error) {
+	addr, err := normalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	var user User
+	err = pr.Backend.DB.Get(
+		&user,
+		`SELECT app_users.* FROM app_users
+		INNER JOIN user_emails ON user_emails.user_id = app_users.id
+		WHERE user_emails.email=? AND user_emails.verified=true`,
+		addr,
+	)
+	return &user, err
+}
+
+func (pr PrCmd) CreateEmailMessages(prID, patchsetID int64, messageIDs []string) error {
+	for _, messageID := range messageIDs {
+		_, err := pr.Backend.DB.Exec(
+			"INSERT OR IGNORE INTO email_messages (message_id, patch_request_id, patchset_id) VALUES (?, ?, ?)",
+			messageID,
+			prID,
+			sql.NullInt64{Int64: patchsetID, Valid: patchsetID != 0},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPatchRequestIDByMessageIDs finds the patch request an email thread
+// belongs to.
+func (pr PrCmd) GetPatchRequestIDByMessageIDs(messageIDs []string) (int64, error) {
+	if len(messageIDs) == 0 {
+		return 0, sql.ErrNoRows
+	}
+	query, args, err := sqlx.In(
+		"SELECT * FROM email_messages WHERE message_id IN (?) ORDER BY created_at DESC LIMIT 1",
+		messageIDs,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var msg EmailMessage
+	err = pr.Backend.DB.Get(&msg, pr.Backend.DB.Rebind(query), args...)
+	return msg.PatchRequestID, err
+}
+
+var (
+	TOKEN_SCOPES         = []string{"repo:read", "pr:read", "pr:write", "user:read"}
+	DEFAULT_TOKEN_SCOPES = []string{"repo:read", "pr:read", "user:read"}
+)
+
+// CreateAccessToken returns the token in plaintext, this is the only time
+// it is available.
+func (pr PrCmd) CreateAccessToken(userID int64, name string, scopes []string, expiresAt sql.NullTime) (*AccessToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", fmt.Errorf("must provide a token name")
+	}
+	if len(scopes) == 0 {
+		scopes = DEFAULT_TOKEN_SCOPES
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(TOKEN_SCOPES, scope) {
+			return nil, "", fmt.Errorf("invalid scope %q, must be one of: %s", scope, strings.Join(TOKEN_SCOPES, ","))
+		}
+	}
+
+	token := newAccessToken()
+	var tokenID int64
+	row := pr.Backend.DB.QueryRow(
+		"INSERT INTO access_tokens (user_id, name, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		userID,
+		name,
+		hashAccessToken(token),
+		strings.Join(scopes, ","),
+		expiresAt,
+	)
+	err := row.Scan(&tokenID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var accessToken AccessToken
+	err = pr.Backend.DB.Get(&accessToken, "SELECT * FROM access_tokens WHERE id=?", tokenID)
+	return &accessToken, token, err
+}
+
+func (pr PrCmd) GetAccessTokens(userID int64) ([]*AccessToken, error) {
+	tokens := []*AccessToken{}
+	err := pr.Backend.DB.Select(
+		&tokens,
+		"SELECT * FROM access_tokens WHERE user_id=? ORDER BY id ASC",
+		userID,
+	)
+	return tokens, err
+}
+
+func (pr PrCmd) DeleteAccessToken(userID, tokenID int64) error {
+	res, err := pr.Backend.DB.Exec(
+		"DELETE FROM access_tokens WHERE user_id=? AND id=?",
+		userID, tokenID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("token not found: %d", tokenID)
+	}
+	return nil
+}
+
+// GetAccessToken finds an unexpired token and records when it was last
+// used.
+func (pr PrCmd) GetAccessToken(token string) (*AccessToken, error) {
+	var accessToken AccessToken
+	err := pr.Backend.DB.Get(
+		&accessToken,
+		"SELECT * FROM access_tokens WHERE token_hash=?",
+		hashAccessToken(token),
+	)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if accessToken.ExpiresAt.Valid && accessToken.ExpiresAt.Time.Before(now) {
+		return nil, fmt.Errorf("access token has expired")
+	}
+	_, err = pr.Backend.DB.Exec(
+		"UPDATE access_tokens SET last_used_at=? WHERE id=?",
+		now, accessToken.ID,
+	)
+	return &accessToken, err
+}
+
+func (pr PrCmd) CreateLabel(repoID, userID int64, name, color, description string) (*Label, error) {
+	err := validateLabelName(name)
+	if err != nil {
+		return nil, err
+	}
+	color, err = normalizeLabelColor(color)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer pr.rollback(tx)
+
+	var labelID int64
+	row := tx.QueryRow(
+		"INSERT INTO labels (repo_id, name, color, description) VALUES (?, ?, ?, ?) RETURNING id",
+		repoID,
+		name,
+		color,
+		strings.TrimSpace(description),
+	)
+	err = row.Scan(&labelID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, fmt.Errorf("label already exists: %s", name)
+		}
+		return nil, err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID: userID,
+		RepoID: sql.NullInt64{Int64: repoID, Valid: true},
+		Event:  "repo_label_created",
+		Data: EventData{
+			LabelsAdded: []string{name},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var label Label
+	err = tx.Get(&label, "SELECT * FROM labels WHERE id=?", labelID)
+	if err != nil {
+		return nil, err
+	}
+	return &label, pr.commit(tx)
+}
+
+// DeleteLabel removes the label from the repo and every patch request it
+// was added to.
+func (pr PrCmd) DeleteLabel(repoID, userID int64, name string) error {
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	var label Label
+	err = tx.Get(&label, "SELECT * FROM labels WHERE repo_id=? AND name=?", repoID, name)
+	if err != nil {
+		return fmt.Errorf("label not found: %s", name)
+	}
+
+	prIDs := []int64{}
+	err = tx.Select(&prIDs, "SELECT patch_request_id FROM pr_labels WHERE label_id=?", label.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM pr_labels WHERE label_id=?", label.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM labels WHERE id=?", label.ID)
+	if err != nil {
+		return err
+	}
+
+	// the label disappears from these patch requests too
+	for _, prID := range prIDs {
+		err = pr.CreateEventLog(tx, EventLog{
+			UserID:         userID,
+			RepoID:         sql.NullInt64{Int64: repoID, Valid: true},
+			PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+			Event:          "pr_labels_changed",
+			Data: EventData{
+				LabelsRemoved: []string{name},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID: userID,
+		RepoID: sql.NullInt64{Int64: repoID, Valid: true},
+		Event:  "repo_label_deleted",
+		Data: EventData{
+			LabelsRemoved: []string{name},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+func (pr PrCmd) GetLabelsByRepoID(repoID int64) ([]*Label, error) {
+	labels := []*Label{}
+	err := pr.Backend.DB.Select(
+		&labels,
+		"SELECT * FROM labels WHERE repo_id=? ORDER BY name ASC",
+		repoID,
+	)
+	return labels, err
+}
+
+func (pr PrCmd) GetLabelsByPrID(prID int64) ([]*Label, error) {
+	labels := []*Label{}
+	err := pr.Backend.DB.Select(
+		&labels,
+		`SELECT labels.* FROM labels
+		INNER JOIN pr_labels ON pr_labels.label_id=labels.id
+		WHERE pr_labels.patch_request_id=?
+		ORDER BY labels.name ASC`,
+		prID,
+	)
+	return labels, err
+}
+
+// UpdatePatchRequestLabels adds and removes labels by name.  Labels must
+// exist in the repo of the patch request.  Adding a label the patch request
+// already has, or removing one it does not, is a no-op and only actual
+// changes are recorded in the event log.
+func (pr PrCmd) UpdatePatchRequestLabels(prID, userID int64, add, rm []string) error {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+	repoLabels, err := pr.GetLabelsByRepoID(prq.RepoID)
+	if err != nil {
+		return err
+	}
+	findLabel := func(name string) (*Label, error) {
+		for _, label := range repoLabels {
+			if label.Name == name {
+				return label, nil
+			}
+		}
+		return nil, fmt.Errorf("label not found: %s", name)
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	added := []string{}
+	for _, name := range add {
+		label, err := findLabel(name)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(
+			"INSERT INTO pr_labels (patch_request_id, label_id) VALUES (?, ?) ON CONFLICT DO NOTHING",
+			prID, label.ID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added = append(added, name)
+		}
+	}
+
+	removed := []string{}
+	for _, name := range rm {
+		label, err := findLabel(name)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(
+			"DELETE FROM pr_labels WHERE patch_request_id=? AND label_id=?",
+			prID, label.ID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			removed = append(removed, name)
+		}
+	}
+
+	if len(added) == 0 && len(removed) == 0 {
+		return pr.commit(tx)
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: prq.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_labels_changed",
+		Data: EventData{
+			LabelsAdded:   added,
+			LabelsRemoved: removed,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// CreateReviewRequests asks the reviewers to review a patch request.
+// Reviewers that were already requested keep their original request so the
+// time they have been waiting is not reset.
+func (pr PrCmd) CreateReviewRequests(prID, userID int64, reviewers []*User) error {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	requested := []string{}
+	for _, reviewer := range reviewers {
+		res, err := tx.Exec(
+			"INSERT INTO review_requests (patch_request_id, user_id, requested_by) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			prID, reviewer.ID, userID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			requested = append(requested, reviewer.Name)
+		}
+	}
+
+	if len(requested) == 0 {
+		return pr.commit(tx)
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: prq.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_review_requested",
+		Data: EventData{
+			Reviewers: requested,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+func (pr PrCmd) GetReviewRequestsByPrID(prID int64) ([]*ReviewRequest, error) {
+	requests := []*ReviewRequest{}
+	err := pr.Backend.DB.Select(
+		&requests,
+		"SELECT * FROM review_requests WHERE patch_request_id=? ORDER BY created_at ASC, id ASC",
+		prID,
+	)
+	return requests, err
+}
+
+// GetReviewRequestsByUserID returns the review requests of a user, the
+// longest waiting first.
+func (pr PrCmd) GetReviewRequestsByUserID(userID int64) ([]*ReviewRequest, error) {
+	requests := []*ReviewRequest{}
+	err := pr.Backend.DB.Select(
+		&requests,
+		"SELECT * FROM review_requests WHERE user_id=? ORDER BY created_at ASC, id ASC",
+		userID,
+	)
+	return requests, err
+}
+
+// clearReviewRequest removes the review request of a user once they have
+// reviewed or accepted the patch request.
+func (pr PrCmd) clearReviewRequest(tx *sqlx.Tx, prID, userID int64) error {
+	_, err := tx.Exec(
+		"DELETE FROM review_requests WHERE patch_request_id=? AND user_id=?",
+		prID, userID,
+	)
+	return err
+}
+
+// GetCurrentPatchsetByPrID returns the latest patchset that is not a review,
+// the one approvals apply to.
+func (pr PrCmd) GetCurrentPatchsetByPrID(prID int64) (*Patchset, error) {
+	var patchset Patchset
+	err := pr.Backend.DB.Get(
+		&patchset,
+		"SELECT * FROM patchsets WHERE patch_request_id=? AND review=false ORDER BY created_at DESC, id DESC LIMIT 1",
+		prID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("no patchsets found for patch request: %d", prID)
+	}
+	return &patchset, nil
+}
+
+// ApprovePatchRequest approves the current patchset of a patch request.
+// Approving again after a new patchset moves the approval to it.
+func (pr PrCmd) ApprovePatchRequest(prID, userID int64, comment string) error {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+	current, err := pr.GetCurrentPatchsetByPrID(prID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	res, err := tx.Exec(
+		`INSERT INTO approvals (patch_request_id, patchset_id, user_id) VALUES (?, ?, ?)
+		ON CONFLICT (patch_request_id, user_id) DO UPDATE SET
+			patchset_id=excluded.patchset_id,
+			created_at=CURRENT_TIMESTAMP
+		WHERE patchset_id != excluded.patchset_id`,
+		prID, current.ID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	// already approved the current patchset
+	if n, _ := res.RowsAffected(); n == 0 {
+		return pr.commit(tx)
+	}
+
+	err = pr.clearReviewRequest(tx, prID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: prq.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		PatchsetID:     sql.NullInt64{Int64: current.ID, Valid: true},
+		Event:          "pr_approved",
+		Data: EventData{
+			Comment: comment,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// UnapprovePatchRequest withdraws a user's approval.
+func (pr PrCmd) UnapprovePatchRequest(prID, userID int64) error {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	res, err := tx.Exec(
+		"DELETE FROM approvals WHERE patch_request_id=? AND user_id=?",
+		prID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("you have not approved PR %d", prID)
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: prq.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_unapproved",
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+func (pr PrCmd) GetApprovalsByPrID(prID int64) ([]*Approval, error) {
+	approvals := []*Approval{}
+	err := pr.Backend.DB.Select(
+		&approvals,
+		"SELECT * FROM approvals WHERE patch_request_id=? ORDER BY created_at ASC, id ASC",
+		prID,
+	)
+	return approvals, err
+}
+
+// dependsOn reports whether a patch request depends on another, directly or
+// through the patch requests it depends on.
+func (pr PrCmd) dependsOn(prID, otherID int64) (bool, error) {
+	seen := map[int64]bool{}
+	queue := []int64{prID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		ids := []int64{}
+		err := pr.Backend.DB.Select(
+			&ids,
+			"SELECT depends_on_id FROM pr_dependencies WHERE patch_request_id=?",
+			id,
+		)
+		if err != nil {
+			return false, err
+		}
+		if slices.Contains(ids, otherID) {
+			return true, nil
+		}
+		queue = append(queue, ids...)
+	}
+	return false, nil
+}
+
+// AddPatchRequestDependency marks a patch request as needing another to
+// land first.  Dependencies that would form a cycle are rejected.
+func (pr PrCmd) AddPatchRequestDependency(prID, dependsOnID, userID int64) error {
+	if prID == dependsOnID {
+		return fmt.Errorf("PR cannot depend on itself")
+	}
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+	if _, err := pr.GetPatchRequestByID(dependsOnID); err != nil {
+		return err
+	}
+	cycle, err := pr.dependsOn(dependsOnID, prID)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return fmt.Errorf("PR %d already depends on PR %d, this would create a cycle", dependsOnID, prID)
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	res, err := tx.Exec(
+		"INSERT INTO pr_dependencies (patch_request_id, depends_on_id, user_id) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		prID, dependsOnID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return pr.commit(tx)
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: prq.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_dependency_added",
+		Data: EventData{
+			DependsOn: dependsOnID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+func (pr PrCmd) RemovePatchRequestDependency(prID, dependsOnID, userID int64) error {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	res, err := tx.Exec(
+		"DELETE FROM pr_dependencies WHERE patch_request_id=? AND depends_on_id=?",
+		prID, dependsOnID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("PR %d does not depend on PR %d", prID, dependsOnID)
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: prq.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_dependency_removed",
+		Data: EventData{
+			DependsOn: dependsOnID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// GetPatchRequestDependencies returns the patch requests that need to land
+// before this one.
+func (pr PrCmd) GetPatchRequestDependencies(prID int64) ([]*PatchRequest, error) {
+	prs := []*PatchRequest{}
+	err := pr.Backend.DB.Select(
+		&prs,
+		`SELECT patch_requests.* FROM patch_requests
+		JOIN pr_dependencies ON pr_dependencies.depends_on_id=patch_requests.id
+		WHERE pr_dependencies.patch_request_id=?
+		ORDER BY patch_requests.id ASC`,
+		prID,
+	)
+	return prs, err
+}
+
+// GetPatchRequestDependents returns the patch requests that depend on this
+// one.
+func (pr PrCmd) GetPatchRequestDependents(prID int64) ([]*PatchRequest, error) {
+	prs := []*PatchRequest{}
+	err := pr.Backend.DB.Select(
+		&prs,
+		`SELECT patch_requests.* FROM patch_requests
+		JOIN pr_dependencies ON pr_dependencies.patch_request_id=patch_requests.id
+		WHERE pr_dependencies.depends_on_id=?
+		ORDER BY patch_requests.id ASC`,
+		prID,
+	)
+	return prs, err
+}
+
+func (pr PrCmd) getRepoNs(repoID int64) (string, error) {
+	repo, err := pr.GetRepoByID(repoID)
+	if err != nil {
+		return "", err
+	}
+	owner, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return "", err
+	}
+	return pr.Backend.CreateRepoNs(owner.Name, repo.Name), nil
+}
+
+// MovePatchRequest transfers a patch request along with its event history
+// to another repo.  Labels are matched by name in the new repo and dropped
+// when missing.  Dependencies have to be in the same repo so they are
+// removed.  Approvals and review requests stay with the patch request.
+func (pr PrCmd) MovePatchRequest(prID, repoID, userID int64) error {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+	if prq.RepoID == repoID {
+		return fmt.Errorf("PR %d is already in this repo", prID)
+	}
+	fromNs, err := pr.getRepoNs(prq.RepoID)
+	if err != nil {
+		return err
+	}
+	toNs, err := pr.getRepoNs(repoID)
+	if err != nil {
+		return err
+	}
+	labels, err := pr.GetLabelsByPrID(prID)
+	if err != nil {
+		return err
+	}
+	repoLabels, err := pr.GetLabelsByRepoID(repoID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	_, err = tx.Exec("UPDATE patch_requests SET repo_id=? WHERE id=?", repoID, prID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE event_logs SET repo_id=? WHERE patch_request_id=?", repoID, prID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM pr_labels WHERE patch_request_id=?", prID)
+	if err != nil {
+		return err
+	}
+	dropped := []string{}
+	for _, label := range labels {
+		idx := slices.IndexFunc(repoLabels, func(l *Label) bool {
+			return l.Name == label.Name
+		})
+		if idx == -1 {
+			dropped = append(dropped, label.Name)
+			continue
+		}
+		_, err = tx.Exec(
+			"INSERT INTO pr_labels (patch_request_id, label_id) VALUES (?, ?)",
+			prID, repoLabels[idx].ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		"DELETE FROM pr_dependencies WHERE patch_request_id=? OR depends_on_id=?",
+		prID, prID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: repoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_moved",
+		Data: EventData{
+			FromRepo:      fromNs,
+			ToRepo:        toNs,
+			LabelsRemoved: dropped,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// SupersedePatchRequest closes a patch request in favor of another one.
+// With fold the patchsets of the old patch request are copied into the
+// history of the new one.  Patch requests that depended on the old one
+// depend on the new one instead.
+func (pr PrCmd) SupersedePatchRequest(oldID, newID, userID int64, fold bool) error {
+	if oldID == newID {
+		return fmt.Errorf("PR cannot supersede itself")
+	}
+	old, err := pr.GetPatchRequestByID(oldID)
+	if err != nil {
+		return err
+	}
+	next, err := pr.GetPatchRequestByID(newID)
+	if err != nil {
+		return err
+	}
+	patchsets, err := pr.GetPatchsetsByPrID(oldID)
+	if err != nil {
+		return err
+	}
+	dependents, err := pr.GetPatchRequestDependents(oldID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer pr.rollback(tx)
+
+	_, err = tx.Exec(
+		"UPDATE patch_requests SET status=?, superseded_by=? WHERE id=?",
+		StatusClosed, newID, oldID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if fold {
+		// copies keep their dates but always sort before the patchsets of
+		// the new patch request so they never become the latest
+		var before string
+		err = tx.Get(
+			&before,
+			"SELECT datetime(MIN(created_at), '-1 second') FROM patchsets WHERE patch_request_id=?",
+			newID,
+		)
+		if err != nil {
+			return err
+		}
+		for _, patchset := range patchsets {
+			var patchsetID int64
+			row := tx.QueryRow(
+				`INSERT INTO patchsets (user_id, patch_request_id, review, created_at)
+				SELECT user_id, ?, review, MIN(created_at, ?) FROM patchsets WHERE id=? RETURNING id`,
+				newID, before, patchset.ID,
+			)
+			err = row.Scan(&patchsetID)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(
+				`INSERT INTO patches (user_id, patchset_id, author_name, author_email, author_date, title, body, body_appendix, commit_sha, content_sha, base_commit_sha, raw_text, created_at)
+				SELECT user_id, ?, author_name, author_email, author_date, title, body, body_appendix, commit_sha, content_sha, base_commit_sha, raw_text, created_at
+				FROM patches WHERE patchset_id=? ORDER BY id ASC`,
+				patchsetID, patchset.ID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dependent := range dependents {
+		if dependent.ID == newID || dependent.RepoID != next.RepoID {
+			continue
+		}
+		cycle, err := pr.dependsOn(newID, dependent.ID)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			continue
+		}
+		_, err = tx.Exec(
+			"INSERT INTO pr_dependencies (patch_request_id, depends_on_id, user_id) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			dependent.ID, newID, userID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM pr_dependencies WHERE depends_on_id=?", oldID)
+	if err != nil {
+		return err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: old.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: oldID, Valid: true},
+		Event:          "pr_superseded",
+		Data: EventData{
+			Status:       StatusClosed,
+			SupersededBy: newID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: next.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: newID, Valid: true},
+		Event:          "pr_supersedes",
+		Data: EventData{
+			Supersedes: oldID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return pr.commit(tx)
+}
+
+// GetSupersededPatchRequests returns the patch requests replaced by this
+// one.
+func (pr PrCmd) GetSupersededPatchRequests(prID int64) ([]*PatchRequest, error) {
+	prs := []*PatchRequest{}
+	err := pr.Backend.DB.Select(
+		&prs,
+		"SELECT * FROM patch_requests WHERE superseded_by=? ORDER BY id ASC",
+		prID,
+	)
+	return prs, err
+}
+
+// CreateComment adds a discussion comment to a patch request.  A non-zero
+// replyToID threads it under another comment.
+func (pr PrCmd) CreateComment(prID, userID, replyToID int64, text string) (*Comment, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+
+	replyTo := sql.NullInt64{}
+	if replyToID > 0 {
+		parent, err := pr.GetCommentByID(replyToID)
+		if err != nil {
+			return nil, fmt.Errorf("comment not found: %d", replyToID)
+		}
+		if parent.PatchRequestID != prID {
+			return nil, fmt.Errorf("comment %d is not on PR %d", replyToID, prID)
+		}
+		replyTo = sql.NullInt64{Int64: replyToID, Valid: true}
+	}
+
+	tx, err := pr.Backend.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer pr.rollback(tx)
+
+	var commentID int64
+	row := tx.QueryRow(
+		"INSERT INTO comments (patch_request_id, user_id, reply_to_id, text) VALUES (?, ?, ?, ?) RETURNING id",
+		prID, userID, replyTo, text,
+	)
+	err = row.Scan(&commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pr.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: prq.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_commented",
+		Data: EventData{
+			CommentID: commentID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = pr.commit(tx)
+	if err != nil {
+		return nil, err
 	}
+	return pr.GetCommentByID(commentID)
+}
 
-	return &repo, nil
+func (pr PrCmd) GetCommentByID(commentID int64) (*Comment, error) {
+	var comment Comment
+	err := pr.Backend.DB.Get(&comment, "SELECT * FROM comments WHERE id=?", commentID)
+	return &comment, err
 }
 
-func (pr PrCmd) createUser(pubkey, name string) (*User, error) {
-	if pubkey == "" {
-		return nil, fmt.Errorf("must provide pubkey when creating user")
-	}
-	if name == "" {
-		return nil, fmt.Errorf("must provide user name when creating user")
-	}
+func (pr PrCmd) GetCommentsByPrID(prID int64) ([]*Comment, error) {
+	comments := []*Comment{}
+	err := pr.Backend.DB.Select(
+		&comments,
+		"SELECT * FROM comments WHERE patch_request_id=? ORDER BY created_at ASC, id ASC",
+		prID,
+	)
+	return comments, err
+}
 
-	userName, err := pr.computeUserName(name)
+func (pr PrCmd) UpdateComment(commentID int64, text string) error {
+	_, err := pr.Backend.DB.Exec(
+		"UPDATE comments SET text=?, updated_at=? WHERE id=?",
+		text, time.Now(), commentID,
+	)
+	return err
+}
+
+// DeleteComment removes a comment.  Replies move up to the comment it was
+// replying to so threads stay intact.
+func (pr PrCmd) DeleteComment(commentID int64) error {
+	comment, err := pr.GetCommentByID(commentID)
 	if err != nil {
-		pr.Backend.Logger.Error("could not compute username", "err", err)
+		return err
 	}
 
-	var userID int64
-	row := pr.Backend.DB.QueryRow(
-		"INSERT INTO app_users (pubkey, name) VALUES (?, ?) RETURNING id",
-		pubkey,
-		userName,
-	)
-	err = row.Scan(&userID)
+	tx, err := pr.Backend.DB.Beginx()
 	if err != nil {
-		return nil, err
-	}
-	if userID == 0 {
-		return nil, fmt.Errorf("could not create user")
+		return err
 	}
+	defer pr.rollback(tx)
 
-	user, err := pr.GetUserByID(userID)
-	return user, err
-}
-
-func (pr PrCmd) RegisterUser(pubkey, name string) (*User, error) {
-	sanName := strings.ToLower(name)
-	if pubkey == "" {
-		return nil, fmt.Errorf("must provide pubkey during upsert")
+	_, err = tx.Exec(
+		"UPDATE comments SET reply_to_id=? WHERE reply_to_id=?",
+		comment.ReplyToID, commentID,
+	)
+	if err != nil {
+		return err
 	}
-	_, err := pr.GetUserByPubkey(pubkey)
-	if err == nil {
-		return nil, fmt.Errorf("pubkey is already registered by another user")
+	_, err = tx.Exec("DELETE FROM comments WHERE id=?", commentID)
+	if err != nil {
+		return err
 	}
-	return pr.createUser(pubkey, sanName)
+	return pr.commit(tx)
 }
 
 func (pr PrCmd) GetPatchsetsByPrID(prID int64) ([]*Patchset, error) {
 	patchsets := []*Patchset{}
 	err := pr.Backend.DB.Select(
 		&patchsets,
-		"SELECT * FROM patchsets WHERE patch_request_id=? ORDER BY created_at ASC",
+		"SELECT * FROM patchsets WHERE patch_request_id=? ORDER BY created_at ASC, id ASC",
 		prID,
 	)
 	if err != nil {
@@ -263,6 +1857,16 @@ func (pr PrCmd) GetPatchesByPatchsetID(patchsetID int64) ([]*Patch, error) {
 	return patches, err
 }
 
+func (pr PrCmd) GetPatchByID(patchID int64) (*Patch, error) {
+	var patch Patch
+	err := pr.Backend.DB.Get(
+		&patch,
+		"SELECT * FROM patches WHERE id=?",
+		patchID,
+	)
+	return &patch, err
+}
+
 func (cmd PrCmd) GetPatchRequests() ([]*PatchRequest, error) {
 	prs := []*PatchRequest{}
 	err := cmd.Backend.DB.Select(
@@ -302,16 +1906,14 @@ func (cmd PrCmd) GetPatchRequestByID(prID int64) (*PatchRequest, error) {
 	return &pr, err
 }
 
-// Status types: open, closed, accepted, reviewed.
+// Status types: open, closed, accepted, reviewed, draft.
 func (cmd PrCmd) UpdatePatchRequestStatus(prID int64, userID int64, status Status, comment string) error {
 	tx, err := cmd.Backend.DB.Beginx()
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		_ = tx.Rollback()
-	}()
+	defer cmd.rollback(tx)
 
 	_, err = tx.Exec(
 		"UPDATE patch_requests SET status=? WHERE id=?",
@@ -322,6 +1924,13 @@ func (cmd PrCmd) UpdatePatchRequestStatus(prID int64, userID int64, status Statu
 		return err
 	}
 
+	if status == StatusAccepted {
+		err = cmd.clearReviewRequest(tx, prID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
 	pr, err := cmd.GetPatchRequestByID(prID)
 	if err != nil {
 		return err
@@ -341,7 +1950,47 @@ func (cmd PrCmd) UpdatePatchRequestStatus(prID int64, userID int64, status Statu
 		return err
 	}
 
-	return tx.Commit()
+	return cmd.commit(tx)
+}
+
+// MarkPatchRequestReady moves a draft patch request to open.
+func (cmd PrCmd) MarkPatchRequestReady(prID int64, userID int64) error {
+	tx, err := cmd.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	defer cmd.rollback(tx)
+
+	res, err := tx.Exec(
+		"UPDATE patch_requests SET status=? WHERE id=? AND status=?",
+		StatusOpen,
+		prID,
+		StatusDraft,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("PR is not a draft: %d", prID)
+	}
+
+	pr, err := cmd.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: pr.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_ready_for_review",
+	})
+	if err != nil {
+		return err
+	}
+
+	return cmd.commit(tx)
 }
 
 func (cmd PrCmd) UpdatePatchRequestName(prID int64, userID int64, name string) error {
@@ -349,14 +1998,17 @@ func (cmd PrCmd) UpdatePatchRequestName(prID int64, userID int64, name string) e
 		return fmt.Errorf("must provide name or text in order to update patch request")
 	}
 
+	pr, err := cmd.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+
 	tx, err := cmd.Backend.DB.Beginx()
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		_ = tx.Rollback()
-	}()
+	defer cmd.rollback(tx)
 
 	_, err = tx.Exec(
 		"UPDATE patch_requests SET name=? WHERE id=?",
@@ -367,7 +2019,7 @@ func (cmd PrCmd) UpdatePatchRequestName(prID int64, userID int64, name string) e
 		return err
 	}
 
-	pr, err := cmd.GetPatchRequestByID(prID)
+	err = cmd.recordRevision(tx, pr, userID, name, pr.Text)
 	if err != nil {
 		return err
 	}
@@ -385,7 +2037,91 @@ func (cmd PrCmd) UpdatePatchRequestName(prID int64, userID int64, name string) e
 		return err
 	}
 
-	return tx.Commit()
+	return cmd.commit(tx)
+}
+
+// UpdatePatchRequestText replaces the description of a patch request.
+func (cmd PrCmd) UpdatePatchRequestText(prID int64, userID int64, text string) error {
+	pr, err := cmd.GetPatchRequestByID(prID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := cmd.Backend.DB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	defer cmd.rollback(tx)
+
+	_, err = tx.Exec(
+		"UPDATE patch_requests SET text=? WHERE id=?",
+		text,
+		prID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.recordRevision(tx, pr, userID, pr.Name, text)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: pr.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		Event:          "pr_body_changed",
+	})
+	if err != nil {
+		return err
+	}
+
+	return cmd.commit(tx)
+}
+
+// recordRevision saves the title and description of a patch request after
+// an edit.  The first edit also saves the original so the history starts
+// with it.
+func (cmd PrCmd) recordRevision(tx *sqlx.Tx, pr *PatchRequest, userID int64, name, text string) error {
+	var count int
+	err := tx.Get(
+		&count,
+		"SELECT count(*) FROM patch_request_revisions WHERE patch_request_id=?",
+		pr.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		_, err = tx.Exec(
+			"INSERT INTO patch_request_revisions (patch_request_id, user_id, name, text, created_at) VALUES (?, ?, ?, ?, ?)",
+			pr.ID, pr.UserID, pr.Name, pr.Text, pr.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO patch_request_revisions (patch_request_id, user_id, name, text) VALUES (?, ?, ?, ?)",
+		pr.ID, userID, name, text,
+	)
+	return err
+}
+
+// GetPatchRequestRevisions returns the title and description history of a
+// patch request, oldest first.  It is empty until the first edit.
+func (cmd PrCmd) GetPatchRequestRevisions(prID int64) ([]*PatchRequestRevision, error) {
+	revs := []*PatchRequestRevision{}
+	err := cmd.Backend.DB.Select(
+		&revs,
+		"SELECT * FROM patch_request_revisions WHERE patch_request_id=? ORDER BY id ASC",
+		prID,
+	)
+	return revs, err
 }
 
 func (cmd PrCmd) CreateEventLog(tx *sqlx.Tx, eventLog EventLog) error {
@@ -406,8 +2142,9 @@ func (cmd PrCmd) CreateEventLog(tx *sqlx.Tx, eventLog EventLog) error {
 		eventLog.RepoID = sql.NullInt64{Int64: pr.RepoID, Valid: true}
 	}
 
-	_, err := tx.Exec(
-		"INSERT INTO event_logs (user_id, repo_id, patch_request_id, patchset_id, event, data) VALUES (?, ?, ?, ?, ?, ?)",
+	var eventLogID int64
+	row := tx.QueryRow(
+		"INSERT INTO event_logs (user_id, repo_id, patch_request_id, patchset_id, event, data) VALUES (?, ?, ?, ?, ?, ?) RETURNING id",
 		eventLog.UserID,
 		eventLog.RepoID,
 		eventLog.PatchRequestID.Int64,
@@ -415,13 +2152,34 @@ func (cmd PrCmd) CreateEventLog(tx *sqlx.Tx, eventLog EventLog) error {
 		eventLog.Event,
 		eventLog.Data,
 	)
+	err := row.Scan(&eventLogID)
 	if err != nil {
 		cmd.Backend.Logger.Error(
 			"could not create eventLog",
 			"err", err,
 		)
+		return err
 	}
-	return err
+
+	var created EventLog
+	err = tx.Get(&created, "SELECT * FROM event_logs WHERE id=?", eventLogID)
+	if err != nil {
+		return err
+	}
+	cmd.Backend.Cfg.Bus.stage(tx, &created)
+	return nil
+}
+
+// commit commits the transaction and then publishes any event logs created
+// within it.
+func (cmd PrCmd) commit(tx *sqlx.Tx) error {
+	return cmd.Backend.Cfg.Bus.commit(tx, tx.Commit)
+}
+
+// rollback is a no-op when the transaction has already been committed.
+func (cmd PrCmd) rollback(tx *sqlx.Tx) {
+	_ = tx.Rollback()
+	cmd.Backend.Cfg.Bus.discard(tx)
 }
 
 func (cmd PrCmd) createPatch(tx *sqlx.Tx, patch *Patch) (int64, error) {
@@ -458,14 +2216,22 @@ func (cmd PrCmd) createPatch(tx *sqlx.Tx, patch *Patch) (int64, error) {
 }
 
 func (cmd PrCmd) SubmitPatchRequest(repoID int64, userID int64, patchset io.Reader) (*PatchRequest, error) {
+	return cmd.submitPatchRequest(repoID, userID, StatusOpen, patchset)
+}
+
+// SubmitDraftPatchRequest creates a patch request that stays a draft until
+// MarkPatchRequestReady is called.
+func (cmd PrCmd) SubmitDraftPatchRequest(repoID int64, userID int64, patchset io.Reader) (*PatchRequest, error) {
+	return cmd.submitPatchRequest(repoID, userID, StatusDraft, patchset)
+}
+
+func (cmd PrCmd) submitPatchRequest(repoID int64, userID int64, status Status, patchset io.Reader) (*PatchRequest, error) {
 	tx, err := cmd.Backend.DB.Beginx()
 	if err != nil {
 		return nil, err
 	}
 
-	defer func() {
-		_ = tx.Rollback()
-	}()
+	defer cmd.rollback(tx)
 
 	patches, err := ParsePatchset(patchset)
 	if err != nil {
@@ -490,7 +2256,7 @@ func (cmd PrCmd) SubmitPatchRequest(repoID int64, userID int64, patchset io.Read
 		repoID,
 		prName,
 		prText,
-		"open",
+		status,
 		time.Now(),
 	)
 	err = row.Scan(&prID)
@@ -524,18 +2290,22 @@ func (cmd PrCmd) SubmitPatchRequest(repoID int64, userID int64, patchset io.Read
 		}
 	}
 
-	err = cmd.CreateEventLog(tx, EventLog{
+	eventLog := EventLog{
 		UserID:         userID,
 		RepoID:         sql.NullInt64{Int64: repoID, Valid: true},
 		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
 		PatchsetID:     sql.NullInt64{Int64: patchsetID, Valid: true},
 		Event:          "pr_created",
-	})
+	}
+	if status == StatusDraft {
+		eventLog.Data.Status = status
+	}
+	err = cmd.CreateEventLog(tx, eventLog)
 	if err != nil {
 		return nil, err
 	}
 
-	err = tx.Commit()
+	err = cmd.commit(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -552,9 +2322,7 @@ func (cmd PrCmd) SubmitPatchset(prID int64, userID int64, op PatchsetOp, patchse
 		return fin, err
 	}
 
-	defer func() {
-		_ = tx.Rollback()
-	}()
+	defer cmd.rollback(tx)
 
 	patches, err := ParsePatchset(patchset)
 	if err != nil {
@@ -591,30 +2359,41 @@ func (cmd PrCmd) SubmitPatchset(prID int64, userID int64, op PatchsetOp, patchse
 		}
 	}
 
-	if len(fin) > 0 {
-		event := "pr_patchset_added"
-		if op == OpReview {
-			event = "pr_reviewed"
-		}
+	// every patch already exists so we roll back the empty patchset, it
+	// would otherwise become current and make approvals stale
+	if len(fin) == 0 {
+		return fin, nil
+	}
 
-		pr, err := cmd.GetPatchRequestByID(prID)
-		if err != nil {
-			return fin, err
-		}
+	event := "pr_patchset_added"
+	if op == OpReview {
+		event = "pr_reviewed"
+	}
 
-		err = cmd.CreateEventLog(tx, EventLog{
-			UserID:         userID,
-			RepoID:         sql.NullInt64{Int64: pr.RepoID, Valid: true},
-			PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
-			PatchsetID:     sql.NullInt64{Int64: patchsetID, Valid: true},
-			Event:          event,
-		})
+	pr, err := cmd.GetPatchRequestByID(prID)
+	if err != nil {
+		return fin, err
+	}
+
+	err = cmd.CreateEventLog(tx, EventLog{
+		UserID:         userID,
+		RepoID:         sql.NullInt64{Int64: pr.RepoID, Valid: true},
+		PatchRequestID: sql.NullInt64{Int64: prID, Valid: true},
+		PatchsetID:     sql.NullInt64{Int64: patchsetID, Valid: true},
+		Event:          event,
+	})
+	if err != nil {
+		return fin, err
+	}
+
+	if op == OpReview || op == OpAccept {
+		err = cmd.clearReviewRequest(tx, prID, userID)
 		if err != nil {
 			return fin, err
 		}
 	}
 
-	err = tx.Commit()
+	err = cmd.commit(tx)
 	if err != nil {
 		return fin, err
 	}
@@ -628,9 +2407,7 @@ func (cmd PrCmd) DeletePatchsetByID(userID int64, prID int64, patchsetID int64)
 		return err
 	}
 
-	defer func() {
-		_ = tx.Rollback()
-	}()
+	defer cmd.rollback(tx)
 
 	_, err = tx.Exec(
 		"DELETE FROM patchsets WHERE id=?", patchsetID,
@@ -639,6 +2416,19 @@ func (cmd PrCmd) DeletePatchsetByID(userID int64, prID int64, patchsetID int64)
 		return err
 	}
 
+	_, err = tx.Exec(
+		"DELETE FROM range_diffs WHERE patchset_a_id=? OR patchset_b_id=?",
+		patchsetID, patchsetID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM approvals WHERE patchset_id=?", patchsetID)
+	if err != nil {
+		return err
+	}
+
 	pr, err := cmd.GetPatchRequestByID(prID)
 	if err != nil {
 		return err
@@ -655,7 +2445,7 @@ func (cmd PrCmd) DeletePatchsetByID(userID int64, prID int64, patchsetID int64)
 		return err
 	}
 
-	return tx.Commit()
+	return cmd.commit(tx)
 }
 
 func (cmd PrCmd) GetEventLogs() ([]*EventLog, error) {
@@ -673,11 +2463,15 @@ func (cmd PrCmd) GetEventLogsByRepoName(user *User, repoName string) ([]*EventLo
 		return nil, err
 	}
 
+	return cmd.GetEventLogsByRepoID(repo.ID)
+}
+
+func (cmd PrCmd) GetEventLogsByRepoID(repoID int64) ([]*EventLog, error) {
 	eventLogs := []*EventLog{}
-	err = cmd.Backend.DB.Select(
+	err := cmd.Backend.DB.Select(
 		&eventLogs,
 		"SELECT * FROM event_logs WHERE repo_id=? ORDER BY created_at DESC",
-		repo.ID,
+		repoID,
 	)
 	return eventLogs, err
 }
@@ -709,11 +2503,40 @@ func (cmd PrCmd) GetEventLogsByUserID(userID int64) ([]*EventLog, error) {
 	return eventLogs, err
 }
 
-func (cmd PrCmd) DiffPatchsets(prev *Patchset, next *Patchset) ([]*RangeDiffOutput, error) {
+func (cmd PrCmd) getRangeDiffCache(prevID, nextID int64, opts string) ([]*RangeDiffOutput, error) {
+	var cache RangeDiffCache
+	err := cmd.Backend.DB.Get(
+		&cache,
+		`SELECT * FROM range_diffs
+		WHERE patchset_a_id=? AND patchset_b_id=? AND algorithm_version=? AND opts=?`,
+		prevID, nextID, RANGE_DIFF_VERSION, opts,
+	)
+	if err != nil {
+		return nil, err
+	}
 	output := []*RangeDiffOutput{}
-	patches, err := cmd.GetPatchesByPatchsetID(next.ID)
+	err = json.Unmarshal([]byte(cache.Data), &output)
+	return output, err
+}
+
+func (cmd PrCmd) createRangeDiffCache(prevID, nextID int64, opts string, output []*RangeDiffOutput) error {
+	data, err := json.Marshal(output)
 	if err != nil {
-		return output, err
+		return err
+	}
+	_, err = cmd.Backend.DB.Exec(
+		`INSERT INTO range_diffs (patchset_a_id, patchset_b_id, algorithm_version, opts, data)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		prevID, nextID, RANGE_DIFF_VERSION, opts, string(data),
+	)
+	return err
+}
+
+func (cmd PrCmd) getParsedPatches(patchsetID int64) ([]*Patch, error) {
+	patches, err := cmd.GetPatchesByPatchsetID(patchsetID)
+	if err != nil {
+		return patches, err
 	}
 
 	for idx, patch := range patches {
@@ -727,27 +2550,66 @@ func (cmd PrCmd) DiffPatchsets(prev *Patchset, next *Patchset) ([]*RangeDiffOutp
 		}
 		patch.Files = diffFiles
 	}
+	return patches, nil
+}
+
+// getPatchsetRangeDiffOpts returns the range-diff defaults of the repo the
+// patchset was submitted to.
+func getPatchsetRangeDiffOpts(pr GitPatchRequest, patchset *Patchset) (RangeDiffOpts, error) {
+	prq, err := pr.GetPatchRequestByID(patchset.PatchRequestID)
+	if err != nil {
+		return RangeDiffOpts{}, err
+	}
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return RangeDiffOpts{}, err
+	}
+	return repo.RangeDiffOpts(), nil
+}
 
+// DiffPatchsets computes the range-diff between two patchsets.  Results are
+// cached in the database by patchset ids, algorithm version, and options,
+// unless RANGE_DIFF_TIMEOUT cut them short.
+func (cmd PrCmd) DiffPatchsets(prev *Patchset, next *Patchset, opts RangeDiffOpts) ([]*RangeDiffOutput, error) {
+	output := []*RangeDiffOutput{}
 	if prev == nil {
 		return output, nil
 	}
 
-	prevPatches, err := cmd.GetPatchesByPatchsetID(prev.ID)
+	key, err := opts.CacheKey()
 	if err != nil {
-		return output, fmt.Errorf("cannot get previous patchset patches: %w", err)
+		return output, err
+	}
+	cached, err := cmd.getRangeDiffCache(prev.ID, next.ID, key)
+	if err == nil {
+		return cached, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		cmd.Backend.Logger.Error("could not read range-diff cache", "err", err)
 	}
 
-	for idx, patch := range prevPatches {
-		patchStr := patch.RawText
-		if idx > 0 {
-			patchStr = startOfPatch + patch.RawText
-		}
-		diffFiles, _, err := ParsePatch(patchStr)
-		if err != nil {
-			continue
-		}
-		patch.Files = diffFiles
+	patches, err := cmd.getParsedPatches(next.ID)
+	if err != nil {
+		return output, err
+	}
+	prevPatches, err := cmd.getParsedPatches(prev.ID)
+	if err != nil {
+		return output, fmt.Errorf("cannot get previous patchset patches: %w", err)
 	}
 
-	return RangeDiff(prevPatches, patches), nil
+	output, expired := RangeDiffWithOpts(prevPatches, patches, opts)
+	if expired {
+		// a later request might finish in time and pair up more patches
+		cmd.Backend.Logger.Info(
+			"range-diff timed out, not caching",
+			"prev", prev.ID,
+			"next", next.ID,
+		)
+		return output, nil
+	}
+	err = cmd.createRangeDiffCache(prev.ID, next.ID, key, output)
+	if err != nil {
+		cmd.Backend.Logger.Error("could not write range-diff cache", "err", err)
+	}
+	return output, nil
 }
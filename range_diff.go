@@ -1,10 +1,12 @@
 package git
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/bluekeyes/go-gitdiff/gitdiff"
 	ha "github.com/oddg/hungarian-algorithm"
@@ -14,35 +16,183 @@ import (
 var (
 	COST_MAX                           = 65536
 	RANGE_DIFF_CREATION_FACTOR_DEFAULT = 60
+	// RANGE_DIFF_VERSION is stored with cached range-diffs and must be bumped
+	// whenever the output of RangeDiff changes.
+	RANGE_DIFF_VERSION = 4
+	// RANGE_DIFF_MAX_PATCHES is the largest patchset we try to pair up
+	// inexact matches for, above it only identical patches are matched.
+	RANGE_DIFF_MAX_PATCHES = 256
+	// RANGE_DIFF_MAX_LINES skips comparing patches with more lines than this.
+	RANGE_DIFF_MAX_LINES = 20000
+	// RANGE_DIFF_TIMEOUT is how long we spend comparing patches before
+	// treating the remaining pairs as unrelated.
+	RANGE_DIFF_TIMEOUT = 5 * time.Second
 )
 
 type PatchRange struct {
 	*Patch
 	Matching int
 	Diff     string
+	Lines    []string
+	// DiffSize is the number of lines in Diff, the same unit diffsize uses
+	// so creation costs compare against matching costs.
 	DiffSize int
 	Shown    bool
 }
 
 func NewPatchRange(patch *Patch) *PatchRange {
 	diff := patch.CalcDiff()
+	lines := strings.SplitAfter(diff, "\n")
 	return &PatchRange{
 		Patch:    patch,
 		Matching: -1,
 		Diff:     diff,
-		DiffSize: len(diff),
+		Lines:    lines,
+		DiffSize: len(lines),
 		Shown:    false,
 	}
 }
 
+// RangeDiffKind classifies how a matched pair of patches changed.
+type RangeDiffKind string
+
+const (
+	// RangeDiffEqual means the patches are identical.
+	RangeDiffEqual RangeDiffKind = "equal"
+	// RangeDiffRebasedOnly means only hunk headers or context lines changed,
+	// which happens when the same change is exported from a different base.
+	RangeDiffRebasedOnly RangeDiffKind = "rebased-only"
+	// RangeDiffMessageOnly means the +/- lines are the same but the commit
+	// message or author changed.
+	RangeDiffMessageOnly RangeDiffKind = "message-only"
+	// RangeDiffContentChanged means the +/- lines changed.
+	RangeDiffContentChanged RangeDiffKind = "content-changed"
+)
+
+// RangeDiffOpts tweaks how patchsets are compared.
+type RangeDiffOpts struct {
+	// Normalize only diffs the +/- lines of each file so hunk headers and
+	// context lines that moved because of a rebase are not rendered.
+	Normalize bool
+	// CreationFactor is the percentage of a patch's size pairing it with a
+	// different patch may cost before it is shown as removed and added
+	// instead, like `git range-diff --creation-factor`.  Zero uses
+	// RANGE_DIFF_CREATION_FACTOR_DEFAULT.
+	CreationFactor int
+	// CostMax is the cost of pairing patches that cannot be matched.  Zero
+	// uses COST_MAX.
+	CostMax int
+	// MessageOnly only shows patches whose title or body changed along with
+	// a diff of the commit message.
+	MessageOnly bool
+	// NoDualColor only colors the changes between patchsets and not the
+	// +/- lines inside of them.  It does not change the range-diff.
+	NoDualColor bool `json:"-"`
+	// NoWordDiff does not highlight the words that changed within a line.
+	// It does not change the range-diff.
+	NoWordDiff bool `json:"-"`
+}
+
+func (opts RangeDiffOpts) creationFactor() int {
+	if opts.CreationFactor <= 0 {
+		return RANGE_DIFF_CREATION_FACTOR_DEFAULT
+	}
+	return opts.CreationFactor
+}
+
+func (opts RangeDiffOpts) costMax() int {
+	if opts.CostMax <= 0 {
+		return COST_MAX
+	}
+	return opts.CostMax
+}
+
+// CacheKey identifies the options in the range-diff cache.  Every option
+// that changes the range-diff is part of the key.
+func (opts RangeDiffOpts) CacheKey() (string, error) {
+	data, err := json.Marshal(opts)
+	return string(data), err
+}
+
 type RangeDiffOutput struct {
 	Header *RangeDiffHeader
 	Order  int
 	Files  []*RangeDiffFile
 	Type   string
+	// Kind is only set for patches found in both patchsets.
+	Kind RangeDiffKind
+	// Message is the diff of the commit message when only showing message
+	// changes.
+	Message []RangeDiffDiff
+}
+
+// normalizeFiles flattens the diff of every file into a string.  Without
+// context only the file names, modes, and +/- lines are kept.
+func normalizeFiles(files []*gitdiff.File, context bool) string {
+	var result strings.Builder
+	for _, file := range files {
+		_, _ = fmt.Fprintf(
+			&result,
+			"%s->%s %s->%s\n",
+			file.OldName, file.NewName,
+			file.OldMode.String(), file.NewMode.String(),
+		)
+		if context {
+			_, _ = result.WriteString(extractAllLinesWithHeaders(file))
+		} else {
+			_, _ = result.WriteString(extractChangedLines(file))
+		}
+	}
+	return result.String()
 }
 
-func output(a []*PatchRange, b []*PatchRange) []*RangeDiffOutput {
+func classifyRangeDiff(a, b *PatchRange) RangeDiffKind {
+	if normalizeFiles(a.Files, false) != normalizeFiles(b.Files, false) {
+		return RangeDiffContentChanged
+	}
+	messageEqual := a.Title == b.Title &&
+		a.Body == b.Body &&
+		a.AuthorName == b.AuthorName &&
+		a.AuthorEmail == b.AuthorEmail
+	if !messageEqual {
+		return RangeDiffMessageOnly
+	}
+	if normalizeFiles(a.Files, true) != normalizeFiles(b.Files, true) {
+		return RangeDiffRebasedOnly
+	}
+	return RangeDiffEqual
+}
+
+func commitMessage(title, body string) string {
+	if body == "" {
+		return title + "\n"
+	}
+	return title + "\n\n" + body + "\n"
+}
+
+// outputMessages keeps the patches found in both patchsets whose commit
+// message changed and replaces their file diffs with a message diff.
+func outputMessages(outputs []*RangeDiffOutput) []*RangeDiffOutput {
+	messages := []*RangeDiffOutput{}
+	for _, out := range outputs {
+		hdr := out.Header
+		if hdr.OldIdx == 0 || hdr.NewIdx == 0 {
+			continue
+		}
+		if !hdr.TitleChanged && !hdr.BodyChanged {
+			continue
+		}
+		out.Files = nil
+		out.Message = DoDiff(
+			commitMessage(hdr.OldTitle, hdr.OldBody),
+			commitMessage(hdr.NewTitle, hdr.NewBody),
+		)
+		messages = append(messages, out)
+	}
+	return messages
+}
+
+func output(a []*PatchRange, b []*PatchRange, opts RangeDiffOpts) []*RangeDiffOutput {
 	outputs := []*RangeDiffOutput{}
 	for i, patchA := range a {
 		if patchA.Matching == -1 {
@@ -83,12 +233,13 @@ func output(a []*PatchRange, b []*PatchRange) []*RangeDiffOutput {
 				&RangeDiffOutput{
 					Header: hdr,
 					Type:   "equal",
+					Kind:   classifyRangeDiff(patchA, patchB),
 					Order:  patchA.Matching + 1,
 				},
 			)
 		} else {
 			hdr := NewRangeDiffHeader(patchA, patchB, patchB.Matching+1, patchA.Matching+1)
-			diff := outputDiff(patchA, patchB)
+			diff := outputDiff(patchA, patchB, opts.Normalize)
 			outputs = append(
 				outputs,
 				&RangeDiffOutput{
@@ -96,6 +247,7 @@ func output(a []*PatchRange, b []*PatchRange) []*RangeDiffOutput {
 					Header: hdr,
 					Files:  diff,
 					Type:   "diff",
+					Kind:   classifyRangeDiff(patchA, patchB),
 				},
 			)
 		}
@@ -103,6 +255,9 @@ func output(a []*PatchRange, b []*PatchRange) []*RangeDiffOutput {
 	sort.Slice(outputs, func(i, j int) bool {
 		return outputs[i].Order < outputs[j].Order
 	})
+	if opts.MessageOnly {
+		return outputMessages(outputs)
+	}
 	return outputs
 }
 
@@ -110,6 +265,9 @@ type RangeDiffDiff struct {
 	OuterType string
 	InnerType string
 	Text      string
+	// Words highlights the words that changed when the line was paired with
+	// a line from the other version of the patch.
+	Words []WordSegment
 }
 
 func toRangeDiffDiff(diff []diffmatchpatch.Diff) []RangeDiffDiff {
@@ -152,6 +310,7 @@ func toRangeDiffDiff(diff []diffmatchpatch.Diff) []RangeDiffDiff {
 		}
 	}
 
+	addRangeDiffWords(result)
 	return result
 }
 
@@ -178,6 +337,20 @@ func extractChangedLines(file *gitdiff.File) string {
 	return result.String()
 }
 
+// extractAllLinesWithHeaders is extractAllLines with the hunk headers so
+// line number changes are taken into account.
+func extractAllLinesWithHeaders(file *gitdiff.File) string {
+	var result strings.Builder
+	for _, frag := range file.TextFragments {
+		_, _ = result.WriteString(frag.Header())
+		_, _ = result.WriteString("\n")
+		for _, line := range frag.Lines {
+			_, _ = result.WriteString(line.String())
+		}
+	}
+	return result.String()
+}
+
 // extractAllLines extracts all lines (including context) from a file's fragments.
 // This is used for displaying the full diff with context.
 func extractAllLines(file *gitdiff.File) string {
@@ -196,7 +369,7 @@ type RangeDiffFile struct {
 	Diff    []RangeDiffDiff
 }
 
-func outputDiff(patchA, patchB *PatchRange) []*RangeDiffFile {
+func outputDiff(patchA, patchB *PatchRange, normalize bool) []*RangeDiffFile {
 	diffs := []*RangeDiffFile{}
 
 	for _, fileA := range patchA.Files {
@@ -215,9 +388,13 @@ func outputDiff(patchA, patchB *PatchRange) []*RangeDiffFile {
 					// No difference in actual changes, skip this file
 					continue
 				}
-				// Use all lines (with context) for display
+				// Use all lines (with context) for display unless normalized
 				strA := extractAllLines(fileA)
 				strB := extractAllLines(fileB)
+				if normalize {
+					strA = changedA
+					strB = changedB
+				}
 				curDiff := DoDiff(strA, strB)
 				fp := &RangeDiffFile{
 					OldFile: fileA,
@@ -388,6 +565,13 @@ func (hdr *RangeDiffHeader) String() string {
 }
 
 func RangeDiff(a []*Patch, b []*Patch) []*RangeDiffOutput {
+	output, _ := RangeDiffWithOpts(a, b, RangeDiffOpts{})
+	return output
+}
+
+// RangeDiffWithOpts also reports whether RANGE_DIFF_TIMEOUT cut the patch
+// comparisons short, in which case the output should not be cached.
+func RangeDiffWithOpts(a []*Patch, b []*Patch, opts RangeDiffOpts) ([]*RangeDiffOutput, bool) {
 	aPatches := []*PatchRange{}
 	for _, patch := range a {
 		aPatches = append(aPatches, NewPatchRange(patch))
@@ -397,14 +581,71 @@ func RangeDiff(a []*Patch, b []*Patch) []*RangeDiffOutput {
 		bPatches = append(bPatches, NewPatchRange(patch))
 	}
 	findExactMatches(aPatches, bPatches)
-	getCorrespondences(aPatches, bPatches, RANGE_DIFF_CREATION_FACTOR_DEFAULT)
-	return output(aPatches, bPatches)
+	expired := getCorrespondences(aPatches, bPatches, opts)
+	return output(aPatches, bPatches, opts), expired
 }
 
+const (
+	ansiReset  = "\033[0m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiCyan   = "\033[36m"
+)
+
 func RangeDiffToStr(diffs []*RangeDiffOutput) string {
+	return rangeDiffToStr(diffs, false)
+}
+
+// RangeDiffToColorStr is RangeDiffToStr with ansi colors for terminals.
+func RangeDiffToColorStr(diffs []*RangeDiffOutput) string {
+	return rangeDiffToStr(diffs, true)
+}
+
+// colorize wraps text in an ansi color while keeping trailing newlines
+// outside of the escape codes.
+func colorize(text, color string, enabled bool) string {
+	if !enabled || color == "" {
+		return text
+	}
+	trimmed := strings.TrimRight(text, "\n")
+	if trimmed == "" {
+		return text
+	}
+	return color + trimmed + ansiReset + text[len(trimmed):]
+}
+
+func rangeDiffLinesToStr(name string, lines []RangeDiffDiff, color bool) string {
+	output := "\n" + colorize(fmt.Sprintf("@@ %s", name), ansiCyan, color) + "\n"
+	for _, d := range lines {
+		switch d.OuterType {
+		case "equal":
+			output += d.Text
+		case "insert":
+			output += colorize(d.Text, ansiGreen, color)
+		case "delete":
+			output += colorize(d.Text, ansiRed, color)
+		}
+	}
+	return output
+}
+
+func rangeDiffToStr(diffs []*RangeDiffOutput, color bool) string {
 	output := ""
 	for _, diff := range diffs {
-		output += diff.Header.String()
+		hdrColor := ""
+		switch diff.Type {
+		case "add":
+			hdrColor = ansiGreen
+		case "rm":
+			hdrColor = ansiRed
+		case "diff":
+			hdrColor = ansiYellow
+		}
+		output += colorize(diff.Header.String(), hdrColor, color)
+		if diff.Message != nil {
+			output += rangeDiffLinesToStr("commit message", diff.Message, color)
+		}
 		for _, f := range diff.Files {
 			fileName := ""
 			if f.NewFile != nil {
@@ -412,17 +653,7 @@ func RangeDiffToStr(diffs []*RangeDiffOutput) string {
 			} else if f.OldFile != nil {
 				fileName = f.OldFile.NewName
 			}
-			output += fmt.Sprintf("\n@@ %s\n", fileName)
-			for _, d := range f.Diff {
-				switch d.OuterType {
-				case "equal":
-					output += d.Text
-				case "insert":
-					output += d.Text
-				case "delete":
-					output += d.Text
-				}
-			}
+			output += rangeDiffLinesToStr(fileName, f.Diff, color)
 		}
 	}
 	return output
@@ -447,25 +678,64 @@ func createMatrix(rows, cols int) [][]int {
 	return mat
 }
 
+// diffsize counts the lines found in only one of the patches.  Line order is
+// ignored which makes it an upper bound of the number of lines in a diff
+// between the patches that is linear to compute.
 func diffsize(a *PatchRange, b *PatchRange) int {
-	dmp := diffmatchpatch.New()
-	diffs := dmp.DiffMain(a.Diff, b.Diff, false)
-	return len(diffs)
+	counts := make(map[string]int, len(a.Lines))
+	for _, line := range a.Lines {
+		counts[line] += 1
+	}
+	for _, line := range b.Lines {
+		counts[line] -= 1
+	}
+	size := 0
+	for _, count := range counts {
+		if count < 0 {
+			count = -count
+		}
+		size += count
+	}
+	return size
 }
 
-func getCorrespondences(a []*PatchRange, b []*PatchRange, creationFactor int) {
+func getCorrespondences(a []*PatchRange, b []*PatchRange, opts RangeDiffOpts) bool {
+	creationFactor := opts.creationFactor()
+	costMax := opts.costMax()
+	if len(a) > RANGE_DIFF_MAX_PATCHES || len(b) > RANGE_DIFF_MAX_PATCHES {
+		return false
+	}
+
 	n := len(a) + len(b)
 	cost := createMatrix(n, n)
+	deadline := time.Now().Add(RANGE_DIFF_TIMEOUT)
+	skipped := false
 
 	for i, patchA := range a {
 		var c int
+		expired := time.Now().After(deadline)
+		// like git, dropping a patch costs as much as creating it
+		deletionCost := (patchA.DiffSize * creationFactor) / 100
+		if patchA.Matching >= 0 {
+			deletionCost = math.MaxInt32
+		}
+		for j := len(b); j < n; j++ {
+			cost[i][j] = deletionCost
+		}
 		for j, patchB := range b {
 			if patchA.Matching == j {
 				c = 0
-			} else if patchA.Matching == -1 && patchB.Matching == -1 {
-				c = diffsize(patchA, patchB)
+			} else if patchA.Matching == -1 && patchB.Matching == -1 &&
+				len(patchA.Lines) <= RANGE_DIFF_MAX_LINES &&
+				len(patchB.Lines) <= RANGE_DIFF_MAX_LINES {
+				if expired {
+					skipped = true
+					c = costMax
+				} else {
+					c = diffsize(patchA, patchB)
+				}
 			} else {
-				c = COST_MAX
+				c = costMax
 			}
 			cost[i][j] = c
 		}
@@ -495,4 +765,5 @@ func getCorrespondences(a []*PatchRange, b []*PatchRange, creationFactor int) {
 			b[j].Matching = i
 		}
 	}
+	return skipped
 }
@@ -15,6 +15,10 @@ func bail(err error) {
 }
 
 func cmp(afile, bfile string) string {
+	return RangeDiffToStr(rangeDiffFixtures(afile, bfile, RangeDiffOpts{}))
+}
+
+func rangeDiffFixtures(afile, bfile string, opts RangeDiffOpts) []*RangeDiffOutput {
 	a, err := fixtures.Fixtures.Open(afile)
 	bail(err)
 	b, err := fixtures.Fixtures.Open(bfile)
@@ -23,8 +27,8 @@ func cmp(afile, bfile string) string {
 	bail(err)
 	bPatches, err := ParsePatchset(b)
 	bail(err)
-	actual := RangeDiff(aPatches, bPatches)
-	return RangeDiffToStr(actual)
+	output, _ := RangeDiffWithOpts(aPatches, bPatches, opts)
+	return output
 }
 
 func fail(expected, actual string) string {
@@ -323,6 +327,79 @@ func TestRangeDiffChangedMessage(t *testing.T) {
 	}
 }
 
+func TestRangeDiffCreationFactor(t *testing.T) {
+	actual := RangeDiffToStr(rangeDiffFixtures(
+		"a_b_reorder.patch", "a_c_changed_commit.patch",
+		RangeDiffOpts{CreationFactor: 1},
+	))
+	if strings.Contains(actual, " ! ") {
+		t.Fatalf("expected low creation factor to show changed patch as removed and added:\n%s", actual)
+	}
+	if !strings.Contains(actual, " < -:  ") || !strings.Contains(actual, "-:  ------- > ") {
+		t.Fatalf("expected removed and added patches:\n%s", actual)
+	}
+}
+
+// unrelated patches cost more to match than to remove and add
+func TestRangeDiffUnrelatedCommits(t *testing.T) {
+	a, err := fixtures.Fixtures.Open("single.patch")
+	bail(err)
+	aPatches, err := ParsePatchset(a)
+	bail(err)
+	bPatches, err := ParsePatchset(strings.NewReader(`From 1111111111111111111111111111111111111111 Mon Sep 17 00:00:00 2001
+From: Eric Bower <me@erock.io>
+Date: Wed, 3 Jul 2024 15:18:47 -0400
+Subject: [PATCH] docs: add license
+
+---
+ LICENSE | 3 +++
+ 1 file changed, 3 insertions(+)
+ create mode 100644 LICENSE
+
+diff --git a/LICENSE b/LICENSE
+new file mode 100644
+index 0000000..8f3a780
+--- /dev/null
++++ b/LICENSE
+@@ -0,0 +1,3 @@
++MIT License
++
++Copyright (c) 2024 Eric Bower
+-- 
+2.45.2
+`))
+	bail(err)
+
+	output, _ := RangeDiffWithOpts(aPatches, bPatches, RangeDiffOpts{})
+	actual := RangeDiffToStr(output)
+	if strings.Contains(actual, " ! ") {
+		t.Fatalf("expected unrelated patches to not be matched:\n%s", actual)
+	}
+	if !strings.Contains(actual, " < -:  ") || !strings.Contains(actual, "-:  ------- > ") {
+		t.Fatalf("expected removed and added patches:\n%s", actual)
+	}
+}
+
+func TestRangeDiffMessageOnly(t *testing.T) {
+	diffs := rangeDiffFixtures(
+		"a_b_reorder.patch", "a_c_changed_message.patch",
+		RangeDiffOpts{MessageOnly: true},
+	)
+	if len(diffs) != 1 {
+		t.Fatalf("expected only the patch with a changed message, found: %d", len(diffs))
+	}
+	if !diffs[0].Header.TitleChanged && !diffs[0].Header.BodyChanged {
+		t.Fatal("expected title or body to have changed")
+	}
+	if len(diffs[0].Files) != 0 {
+		t.Fatal("expected file diffs to be skipped")
+	}
+	actual := RangeDiffToStr(diffs)
+	if !strings.Contains(actual, "@@ commit message") {
+		t.Fatalf("expected commit message diff:\n%s", actual)
+	}
+}
+
 func TestRangeDiffEmptyPatchset(t *testing.T) {
 	a, err := fixtures.Fixtures.Open("a_b_reorder.patch")
 	bail(err)
@@ -448,3 +525,219 @@ func TestRangeDiffNormalizesHunkHeaders(t *testing.T) {
 		t.Fatal("should not have file diff output when changes are equal")
 	}
 }
+
+func TestRangeDiffToColorStr(t *testing.T) {
+	diffs := rangeDiffFixtures("a_b_reorder.patch", "a_c_changed_commit.patch", RangeDiffOpts{})
+
+	actual := RangeDiffToColorStr(diffs)
+	if !strings.Contains(actual, ansiYellow) || !strings.Contains(actual, ansiReset+"\n") {
+		t.Fatalf("expected changed commits to be colored:\n%s", actual)
+	}
+	// stripping the colors yields the plain output
+	stripped := actual
+	for _, code := range []string{ansiReset, ansiRed, ansiGreen, ansiYellow, ansiCyan} {
+		stripped = strings.ReplaceAll(stripped, code, "")
+	}
+	if stripped != RangeDiffToStr(diffs) {
+		t.Fatal(fail(RangeDiffToStr(diffs), stripped))
+	}
+}
+
+func TestRangeDiffKind(t *testing.T) {
+	tests := []struct {
+		afile string
+		bfile string
+		kinds []RangeDiffKind
+	}{
+		{"a_b_reorder.patch", "a_b_reorder.patch", []RangeDiffKind{RangeDiffEqual, RangeDiffEqual}},
+		{"context_lines_v1.patch", "context_lines_v2.patch", []RangeDiffKind{RangeDiffRebasedOnly}},
+		{"hunk_header_v1.patch", "hunk_header_v2.patch", []RangeDiffKind{RangeDiffRebasedOnly}},
+		{"a_b_reorder.patch", "a_c_changed_message.patch", []RangeDiffKind{RangeDiffEqual, RangeDiffMessageOnly}},
+	}
+	for _, tt := range tests {
+		diffs := rangeDiffFixtures(tt.afile, tt.bfile, RangeDiffOpts{})
+		if len(diffs) != len(tt.kinds) {
+			t.Fatalf("%s..%s: expected %d entries, found %d", tt.afile, tt.bfile, len(tt.kinds), len(diffs))
+		}
+		for idx, kind := range tt.kinds {
+			if diffs[idx].Kind != kind {
+				t.Fatalf("%s..%s: entry %d expected %s, found %s", tt.afile, tt.bfile, idx, kind, diffs[idx].Kind)
+			}
+		}
+	}
+
+	diffs := rangeDiffFixtures("a_b_reorder.patch", "a_c_changed_commit.patch", RangeDiffOpts{})
+	changed := 0
+	for _, diff := range diffs {
+		if diff.Kind == RangeDiffContentChanged {
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Fatal("expected a content-changed entry")
+	}
+}
+
+func TestRangeDiffNormalize(t *testing.T) {
+	diffs := rangeDiffFixtures("a_b_reorder.patch", "a_c_changed_commit.patch", RangeDiffOpts{Normalize: true})
+	numFiles := 0
+	for _, diff := range diffs {
+		for _, file := range diff.Files {
+			numFiles++
+			if diff.Type != "diff" {
+				continue
+			}
+			for _, line := range file.Diff {
+				if line.Text != "" && line.InnerType == "equal" {
+					t.Fatalf("expected only +/- lines, found context: %q", line.Text)
+				}
+			}
+		}
+	}
+	if numFiles == 0 {
+		t.Fatal("expected changed files in normalized output")
+	}
+}
+
+func TestDiffPatchsetsCache(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	for _, fname := range []string{"a_b_reorder.patch", "a_c_changed_commit.patch"} {
+		f, err := fixtures.Fixtures.Open(fname)
+		bail(err)
+		_, err = pr.SubmitPatchset(prq.ID, owner.ID, OpNormal, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	patchsets, err := pr.GetPatchsetsByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, next := patchsets[len(patchsets)-2], patchsets[len(patchsets)-1]
+
+	numCached := func() int {
+		var count int
+		err := pr.Backend.DB.Get(&count, "SELECT count(*) FROM range_diffs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	computed, err := pr.DiffPatchsets(prev, next, RangeDiffOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numCached() != 1 {
+		t.Fatal("expected range-diff to be cached")
+	}
+	cached, err := pr.DiffPatchsets(prev, next, RangeDiffOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numCached() != 1 {
+		t.Fatal("expected cached range-diff to be reused")
+	}
+	if RangeDiffToStr(computed) != RangeDiffToStr(cached) {
+		t.Fatal(fail(RangeDiffToStr(computed), RangeDiffToStr(cached)))
+	}
+
+	_, err = pr.DiffPatchsets(prev, next, RangeDiffOpts{Normalize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numCached() != 2 {
+		t.Fatal("expected options to be part of the cache key")
+	}
+
+	err = pr.DeletePatchsetByID(owner.ID, prq.ID, next.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numCached() != 0 {
+		t.Fatal("expected cache to be removed with the patchset")
+	}
+}
+
+func TestDiffPatchsetsTimeoutNotCached(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	for _, fname := range []string{"a_b_reorder.patch", "a_c_changed_commit.patch"} {
+		f, err := fixtures.Fixtures.Open(fname)
+		bail(err)
+		_, err = pr.SubmitPatchset(prq.ID, owner.ID, OpNormal, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	patchsets, err := pr.GetPatchsetsByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, next := patchsets[len(patchsets)-2], patchsets[len(patchsets)-1]
+
+	timeout := RANGE_DIFF_TIMEOUT
+	RANGE_DIFF_TIMEOUT = -1
+	defer func() { RANGE_DIFF_TIMEOUT = timeout }()
+
+	_, err = pr.DiffPatchsets(prev, next, RangeDiffOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = pr.Backend.DB.Get(&count, "SELECT count(*) FROM range_diffs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("expected timed out range-diff to not be cached")
+	}
+}
+
+var rangeDiffBenchmarks = [][2]string{
+	{"a_b.patch", "a_c.patch"},
+	{"a_b_reorder.patch", "a_c_reorder.patch"},
+	{"a_b_reorder.patch", "a_c_changed_commit.patch"},
+	{"a_b_reorder.patch", "a_c_squashed.patch"},
+	{"a_b_reorder.patch", "a_c_split.patch"},
+	{"a_b_reorder.patch", "a_c_multi_file_change.patch"},
+	{"context_lines_v1.patch", "context_lines_v2.patch"},
+	{"hunk_header_v1.patch", "hunk_header_v2.patch"},
+}
+
+func BenchmarkRangeDiff(b *testing.B) {
+	for _, pair := range rangeDiffBenchmarks {
+		fa, err := fixtures.Fixtures.Open(pair[0])
+		bail(err)
+		fb, err := fixtures.Fixtures.Open(pair[1])
+		bail(err)
+		aPatches, err := ParsePatchset(fa)
+		bail(err)
+		bPatches, err := ParsePatchset(fb)
+		bail(err)
+
+		b.Run(pair[0]+".."+pair[1], func(b *testing.B) {
+			for range b.N {
+				RangeDiff(aPatches, bPatches)
+			}
+		})
+	}
+}
+
+func BenchmarkDiffPatchsetsCached(b *testing.B) {
+	pr, owner, prq := setupTestRepo(b)
+	for _, fname := range []string{"a_b_reorder.patch", "a_c_changed_commit.patch"} {
+		f, err := fixtures.Fixtures.Open(fname)
+		bail(err)
+		_, err = pr.SubmitPatchset(prq.ID, owner.ID, OpNormal, f)
+		bail(err)
+	}
+	patchsets, err := pr.GetPatchsetsByPrID(prq.ID)
+	bail(err)
+	prev, next := patchsets[len(patchsets)-2], patchsets[len(patchsets)-1]
+
+	b.ResetTimer()
+	for range b.N {
+		_, err := pr.DiffPatchsets(prev, next, RangeDiffOpts{})
+		bail(err)
+	}
+}
@@ -0,0 +1,732 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// The types in this file are the stable JSON schemas returned by
+// `ssh {host} --json {cmd}`.  Fields are only ever added, never renamed
+// or removed.
+
+// UserSchema is a registered user.
+type UserSchema struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Pubkey    string    `json:"pubkey,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserEmailSchema is an email address claimed by a user.
+type UserEmailSchema struct {
+	Email     string    `json:"email"`
+	Verified  bool      `json:"verified"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RepoSchema is a repo, Name is namespaced by the owner when the server is
+// multi-tenant.
+type RepoSchema struct {
+	ID                      int64  `json:"id"`
+	Name                    string `json:"name"`
+	User                    string `json:"user"`
+	RangeDiffCreationFactor int    `json:"range_diff_creation_factor"`
+	RangeDiffNormalize      bool   `json:"range_diff_normalize"`
+	RequiredApprovals       int    `json:"required_approvals"`
+	Archived                bool   `json:"archived"`
+	// TransferTo is the user a pending ownership transfer waits on.
+	TransferTo    string    `json:"transfer_to,omitempty"`
+	Description   string    `json:"description"`
+	Homepage      string    `json:"homepage"`
+	CloneURL      string    `json:"clone_url"`
+	DefaultBranch string    `json:"default_branch"`
+	Contributing  string    `json:"contributing"`
+	PrTemplate    string    `json:"pr_template"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// PatchRequestSchema is built from PatchRequest.
+type PatchRequestSchema struct {
+	ID        int64     `json:"id"`
+	Repo      string    `json:"repo"`
+	User      string    `json:"user"`
+	Name      string    `json:"name"`
+	Text      string    `json:"text"`
+	Status    Status    `json:"status"`
+	Patchsets int       `json:"patchsets"`
+	Labels    []string  `json:"labels"`
+	Reviewers []string  `json:"reviewers"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReviewQueueSchema is a patch request awaiting the user's review.
+type ReviewQueueSchema struct {
+	PatchRequest *PatchRequestSchema `json:"patch_request"`
+	RequestedBy  string              `json:"requested_by"`
+	RequestedAt  time.Time           `json:"requested_at"`
+}
+
+// LabelSchema is a label defined in a repo.
+type LabelSchema struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Color       string    `json:"color"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PatchsetSchema is built from Patchset.
+type PatchsetSchema struct {
+	ID             int64     `json:"id"`
+	PatchRequestID int64     `json:"pr_id"`
+	User           string    `json:"user"`
+	Review         bool      `json:"review"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// PatchSchema is built from Patch.  Raw is only included when printing
+// patches.
+type PatchSchema struct {
+	ID            int64     `json:"id"`
+	PatchsetID    int64     `json:"patchset_id"`
+	AuthorName    string    `json:"author_name"`
+	AuthorEmail   string    `json:"author_email"`
+	AuthorDate    time.Time `json:"author_date"`
+	Title         string    `json:"title"`
+	Body          string    `json:"body"`
+	BodyAppendix  string    `json:"body_appendix"`
+	CommitSha     string    `json:"commit_sha"`
+	ContentSha    string    `json:"content_sha"`
+	BaseCommitSha string    `json:"base_commit_sha,omitempty"`
+	Raw           string    `json:"raw,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AccessTokenSchema is a web api token.  Token is only included when the
+// token is created.
+type AccessTokenSchema struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// PatchFileSchema is a file changed by a patch.
+type PatchFileSchema struct {
+	Name      string `json:"name"`
+	OldName   string `json:"old_name,omitempty"`
+	IsNew     bool   `json:"is_new"`
+	IsDelete  bool   `json:"is_delete"`
+	IsRename  bool   `json:"is_rename"`
+	IsBinary  bool   `json:"is_binary"`
+	Additions int64  `json:"additions"`
+	Deletions int64  `json:"deletions"`
+	Diff      string `json:"diff"`
+}
+
+// DiffStatSchema sums up the files changed by a patch.
+type DiffStatSchema struct {
+	Files     int   `json:"files"`
+	Additions int64 `json:"additions"`
+	Deletions int64 `json:"deletions"`
+}
+
+// PatchDetailSchema is a patch with its parsed diff.
+type PatchDetailSchema struct {
+	PatchSchema
+	Stat  DiffStatSchema     `json:"stat"`
+	Files []*PatchFileSchema `json:"files"`
+}
+
+// PrSummarySchema is a patch request with its patchsets and the patches
+// from the latest patchset.
+type PrSummarySchema struct {
+	PatchRequestSchema
+	PatchsetList []*PatchsetSchema       `json:"patchset_list"`
+	Patches      []*PatchSchema          `json:"patches"`
+	DiffStat     *PatchsetDiffStatSchema `json:"diffstat"`
+	Approvals    *ApprovalStatusSchema   `json:"approvals"`
+	// DependsOn are the IDs of the PRs that have to land first.
+	DependsOn []int64 `json:"depends_on"`
+	// RequiredBy are the IDs of the PRs that depend on this one.
+	RequiredBy []int64 `json:"required_by"`
+	// SupersededBy is the ID of the PR replacing this one, Supersedes the
+	// IDs of the PRs this one replaced.
+	SupersededBy int64            `json:"superseded_by,omitempty"`
+	Supersedes   []int64          `json:"supersedes"`
+	Comments     []*CommentSchema `json:"comments"`
+}
+
+// CommentSchema is a discussion comment on a patch request.  ReplyTo is the
+// ID of the comment it replies to.
+type CommentSchema struct {
+	ID        int64      `json:"id"`
+	User      string     `json:"user"`
+	ReplyTo   int64      `json:"reply_to,omitempty"`
+	Text      string     `json:"text"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// ApprovalSchema is a user's approval of a patchset.
+type ApprovalSchema struct {
+	User       string    `json:"user"`
+	PatchsetID int64     `json:"patchset_id"`
+	Stale      bool      `json:"stale"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ApprovalStatusSchema is the approvals of a patch request against how many
+// its repo requires.  Count excludes stale approvals.
+type ApprovalStatusSchema struct {
+	Required  int               `json:"required"`
+	Count     int               `json:"count"`
+	Approvals []*ApprovalSchema `json:"approvals"`
+}
+
+// DiffStatFileSchema is the lines changed in a file summed across the
+// patches of a patchset.  Patches are the IDs of the patches touching it.
+type DiffStatFileSchema struct {
+	Path      string  `json:"path"`
+	OldPath   string  `json:"old_path,omitempty"`
+	IsBinary  bool    `json:"is_binary"`
+	Additions int64   `json:"additions"`
+	Deletions int64   `json:"deletions"`
+	Patches   []int64 `json:"patches"`
+}
+
+// PatchsetDiffStatSchema sums up the files changed by a patchset.
+type PatchsetDiffStatSchema struct {
+	Files     []*DiffStatFileSchema `json:"files"`
+	Additions int64                 `json:"additions"`
+	Deletions int64                 `json:"deletions"`
+}
+
+func NewPatchsetDiffStatSchema(stat *DiffStat) *PatchsetDiffStatSchema {
+	out := &PatchsetDiffStatSchema{
+		Files:     []*DiffStatFileSchema{},
+		Additions: stat.Adds,
+		Deletions: stat.Dels,
+	}
+	for _, file := range stat.Files {
+		out.Files = append(out.Files, &DiffStatFileSchema{
+			Path:      file.Path,
+			OldPath:   file.OldPath,
+			IsBinary:  file.Binary,
+			Additions: file.Adds,
+			Deletions: file.Dels,
+			Patches:   file.Patches,
+		})
+	}
+	return out
+}
+
+// RangeDiffCommitSchema is one side of a range-diff entry.
+type RangeDiffCommitSchema struct {
+	Idx         int    `json:"idx"`
+	Sha         string `json:"sha"`
+	AuthorName  string `json:"author_name"`
+	AuthorEmail string `json:"author_email"`
+	Title       string `json:"title"`
+}
+
+// RangeDiffChunkSchema is a piece of a range-diff file diff.  Type is the
+// change between patchsets and InnerType is the line type within the patch.
+type RangeDiffChunkSchema struct {
+	Type      string `json:"type"`
+	InnerType string `json:"inner_type"`
+	Text      string `json:"text"`
+}
+
+// RangeDiffFileSchema is a file changed between two versions of a patch.
+type RangeDiffFileSchema struct {
+	Name   string                  `json:"name"`
+	Chunks []*RangeDiffChunkSchema `json:"chunks"`
+}
+
+// RangeDiffSchema is built from RangeDiffOutput.  Type is one of add, rm,
+// equal or diff.  Kind classifies patches found in both patchsets as equal,
+// rebased-only, message-only or content-changed.
+type RangeDiffSchema struct {
+	Type          string                 `json:"type"`
+	Kind          string                 `json:"kind,omitempty"`
+	Order         int                    `json:"order"`
+	Title         string                 `json:"title"`
+	Old           *RangeDiffCommitSchema `json:"old,omitempty"`
+	New           *RangeDiffCommitSchema `json:"new,omitempty"`
+	ContentEqual  bool                   `json:"content_equal"`
+	AuthorChanged bool                   `json:"author_changed"`
+	TitleChanged  bool                   `json:"title_changed"`
+	BodyChanged   bool                   `json:"body_changed"`
+	Files         []*RangeDiffFileSchema `json:"files"`
+	// Message is the commit message diff when only showing message changes.
+	Message []*RangeDiffChunkSchema `json:"message,omitempty"`
+}
+
+// ErrorSchema is returned when a command fails.
+type ErrorSchema struct {
+	Error string `json:"error"`
+}
+
+func NewUserSchema(user *User) *UserSchema {
+	return &UserSchema{
+		ID:        user.ID,
+		Name:      user.Name,
+		Pubkey:    user.Pubkey,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+func NewUserEmailSchema(email *UserEmail) *UserEmailSchema {
+	return &UserEmailSchema{
+		Email:     email.Email,
+		Verified:  email.Verified,
+		CreatedAt: email.CreatedAt,
+	}
+}
+
+func NewRepoSchema(be *Backend, pr GitPatchRequest, repo *Repo) (*RepoSchema, error) {
+	repoUser, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return nil, err
+	}
+	transferTo := ""
+	if repo.TransferToID.Valid {
+		recipient, err := pr.GetUserByID(repo.TransferToID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		transferTo = recipient.Name
+	}
+	return &RepoSchema{
+		ID:         repo.ID,
+		Name:       be.CreateRepoNs(repoUser.Name, repo.Name),
+		User:       repoUser.Name,
+		Archived:   repo.Archived,
+		TransferTo: transferTo,
+		CreatedAt:  repo.CreatedAt,
+		UpdatedAt:  repo.UpdatedAt,
+
+		Description:   repo.Description,
+		Homepage:      repo.Homepage,
+		CloneURL:      repo.CloneURL,
+		DefaultBranch: repo.DefaultBranch,
+		Contributing:  repo.Contributing,
+		PrTemplate:    repo.PrTemplate,
+
+		RangeDiffCreationFactor: repo.RangeDiffCreationFactor,
+		RangeDiffNormalize:      repo.RangeDiffNormalize,
+		RequiredApprovals:       repo.RequiredApprovals,
+	}, nil
+}
+
+func NewPatchRequestSchema(be *Backend, pr GitPatchRequest, prq *PatchRequest) (*PatchRequestSchema, error) {
+	user, err := pr.GetUserByID(prq.UserID)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+	repoUser, err := pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return nil, err
+	}
+	patchsets, err := pr.GetPatchsetsByPrID(prq.ID)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := pr.GetLabelsByPrID(prq.ID)
+	if err != nil {
+		return nil, err
+	}
+	labelNames := []string{}
+	for _, label := range labels {
+		labelNames = append(labelNames, label.Name)
+	}
+	reviewers, err := getReviewerNames(pr, prq.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &PatchRequestSchema{
+		ID:        prq.ID,
+		Repo:      be.CreateRepoNs(repoUser.Name, repo.Name),
+		User:      user.Name,
+		Name:      prq.Name,
+		Text:      prq.Text,
+		Status:    prq.Status,
+		Patchsets: len(patchsets),
+		Labels:    labelNames,
+		Reviewers: reviewers,
+		URL:       fmt.Sprintf("https://%s/prs/%d", be.Cfg.Url, prq.ID),
+		CreatedAt: prq.CreatedAt,
+		UpdatedAt: prq.UpdatedAt,
+	}, nil
+}
+
+func NewReviewQueueSchema(be *Backend, pr GitPatchRequest, entry *QueueEntry) (*ReviewQueueSchema, error) {
+	prSchema, err := NewPatchRequestSchema(be, pr, entry.PatchRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &ReviewQueueSchema{
+		PatchRequest: prSchema,
+		RequestedBy:  entry.RequestedBy.Name,
+		RequestedAt:  entry.Request.CreatedAt,
+	}, nil
+}
+
+func NewLabelSchema(label *Label) *LabelSchema {
+	return &LabelSchema{
+		ID:          label.ID,
+		Name:        label.Name,
+		Color:       label.Color,
+		Description: label.Description,
+		CreatedAt:   label.CreatedAt,
+	}
+}
+
+func NewPatchsetSchema(pr GitPatchRequest, patchset *Patchset) (*PatchsetSchema, error) {
+	user, err := pr.GetUserByID(patchset.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &PatchsetSchema{
+		ID:             patchset.ID,
+		PatchRequestID: patchset.PatchRequestID,
+		User:           user.Name,
+		Review:         patchset.Review,
+		CreatedAt:      patchset.CreatedAt,
+	}, nil
+}
+
+func NewPatchSchema(patch *Patch) *PatchSchema {
+	return &PatchSchema{
+		ID:            patch.ID,
+		PatchsetID:    patch.PatchsetID,
+		AuthorName:    patch.AuthorName,
+		AuthorEmail:   patch.AuthorEmail,
+		AuthorDate:    patch.AuthorDate,
+		Title:         patch.Title,
+		Body:          patch.Body,
+		BodyAppendix:  patch.BodyAppendix,
+		CommitSha:     patch.CommitSha,
+		ContentSha:    patch.ContentSha,
+		BaseCommitSha: patch.BaseCommitSha.String,
+		CreatedAt:     patch.CreatedAt,
+	}
+}
+
+func NewAccessTokenSchema(accessToken *AccessToken) *AccessTokenSchema {
+	out := &AccessTokenSchema{
+		ID:        accessToken.ID,
+		Name:      accessToken.Name,
+		Scopes:    strings.Split(accessToken.Scopes, ","),
+		CreatedAt: accessToken.CreatedAt,
+	}
+	if accessToken.LastUsedAt.Valid {
+		out.LastUsedAt = &accessToken.LastUsedAt.Time
+	}
+	if accessToken.ExpiresAt.Valid {
+		out.ExpiresAt = &accessToken.ExpiresAt.Time
+	}
+	return out
+}
+
+func NewPatchDetailSchema(patch *Patch) (*PatchDetailSchema, error) {
+	diffFiles, _, err := ParsePatch(patch.RawText)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &PatchDetailSchema{
+		PatchSchema: *NewPatchSchema(patch),
+		Files:       []*PatchFileSchema{},
+	}
+	for _, file := range diffFiles {
+		fout := &PatchFileSchema{
+			Name:     file.NewName,
+			IsNew:    file.IsNew,
+			IsDelete: file.IsDelete,
+			IsRename: file.IsRename,
+			IsBinary: file.IsBinary,
+			Diff:     file.String(),
+		}
+		if file.IsDelete {
+			fout.Name = file.OldName
+		}
+		if file.IsRename || file.IsCopy {
+			fout.OldName = file.OldName
+		}
+		for _, frag := range file.TextFragments {
+			fout.Additions += frag.LinesAdded
+			fout.Deletions += frag.LinesDeleted
+		}
+		out.Stat.Files += 1
+		out.Stat.Additions += fout.Additions
+		out.Stat.Deletions += fout.Deletions
+		out.Files = append(out.Files, fout)
+	}
+	return out, nil
+}
+
+func NewPrSummarySchema(be *Backend, pr GitPatchRequest, prID int64) (*PrSummarySchema, error) {
+	prq, err := pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return nil, err
+	}
+	prSchema, err := NewPatchRequestSchema(be, pr, prq)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &PrSummarySchema{
+		PatchRequestSchema: *prSchema,
+		PatchsetList:       []*PatchsetSchema{},
+		Patches:            []*PatchSchema{},
+	}
+
+	patchsets, err := pr.GetPatchsetsByPrID(prID)
+	if err != nil {
+		return nil, err
+	}
+	for _, patchset := range patchsets {
+		ps, err := NewPatchsetSchema(pr, patchset)
+		if err != nil {
+			return nil, err
+		}
+		summary.PatchsetList = append(summary.PatchsetList, ps)
+	}
+
+	latest := patchsets[len(patchsets)-1]
+	patches, err := pr.GetPatchesByPatchsetID(latest.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, patch := range patches {
+		summary.Patches = append(summary.Patches, NewPatchSchema(patch))
+	}
+	stat, err := NewDiffStat(patches)
+	if err != nil {
+		return nil, err
+	}
+	summary.DiffStat = NewPatchsetDiffStatSchema(stat)
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		return nil, err
+	}
+	approvals, err := GetApprovalStatus(pr, repo, prID)
+	if err != nil {
+		return nil, err
+	}
+	summary.Approvals = NewApprovalStatusSchema(approvals)
+
+	deps, err := pr.GetPatchRequestDependencies(prID)
+	if err != nil {
+		return nil, err
+	}
+	summary.DependsOn = []int64{}
+	for _, dep := range deps {
+		summary.DependsOn = append(summary.DependsOn, dep.ID)
+	}
+	dependents, err := pr.GetPatchRequestDependents(prID)
+	if err != nil {
+		return nil, err
+	}
+	summary.RequiredBy = []int64{}
+	for _, dependent := range dependents {
+		summary.RequiredBy = append(summary.RequiredBy, dependent.ID)
+	}
+
+	summary.SupersededBy = prq.SupersededBy.Int64
+	superseded, err := pr.GetSupersededPatchRequests(prID)
+	if err != nil {
+		return nil, err
+	}
+	summary.Supersedes = []int64{}
+	for _, prev := range superseded {
+		summary.Supersedes = append(summary.Supersedes, prev.ID)
+	}
+
+	comments, err := pr.GetCommentsByPrID(prID)
+	if err != nil {
+		return nil, err
+	}
+	summary.Comments = []*CommentSchema{}
+	for _, comment := range comments {
+		cs, err := NewCommentSchema(pr, comment)
+		if err != nil {
+			return nil, err
+		}
+		summary.Comments = append(summary.Comments, cs)
+	}
+
+	return summary, nil
+}
+
+func NewCommentSchema(pr GitPatchRequest, comment *Comment) (*CommentSchema, error) {
+	user, err := pr.GetUserByID(comment.UserID)
+	if err != nil {
+		return nil, err
+	}
+	out := &CommentSchema{
+		ID:        comment.ID,
+		User:      user.Name,
+		ReplyTo:   comment.ReplyToID.Int64,
+		Text:      comment.Text,
+		CreatedAt: comment.CreatedAt,
+	}
+	if comment.UpdatedAt.Valid {
+		out.UpdatedAt = &comment.UpdatedAt.Time
+	}
+	return out, nil
+}
+
+func NewApprovalStatusSchema(status *ApprovalStatus) *ApprovalStatusSchema {
+	out := &ApprovalStatusSchema{
+		Required:  status.Required,
+		Count:     status.Count(),
+		Approvals: []*ApprovalSchema{},
+	}
+	for _, approval := range status.Approvals {
+		out.Approvals = append(out.Approvals, &ApprovalSchema{
+			User:       approval.User.Name,
+			PatchsetID: approval.PatchsetID,
+			Stale:      approval.Stale,
+			CreatedAt:  approval.CreatedAt,
+		})
+	}
+	return out
+}
+
+func newRangeDiffCommitSchema(idx int, sha, authorName, authorEmail, title string) *RangeDiffCommitSchema {
+	if idx <= 0 {
+		return nil
+	}
+	return &RangeDiffCommitSchema{
+		Idx:         idx,
+		Sha:         sha,
+		AuthorName:  authorName,
+		AuthorEmail: authorEmail,
+		Title:       title,
+	}
+}
+
+func NewRangeDiffSchema(diff *RangeDiffOutput) *RangeDiffSchema {
+	hdr := diff.Header
+	out := &RangeDiffSchema{
+		Type:          diff.Type,
+		Kind:          string(diff.Kind),
+		Order:         diff.Order,
+		Title:         hdr.Title,
+		Old:           newRangeDiffCommitSchema(hdr.OldIdx, hdr.OldSha, hdr.OldAuthorName, hdr.OldAuthorEmail, hdr.OldTitle),
+		New:           newRangeDiffCommitSchema(hdr.NewIdx, hdr.NewSha, hdr.NewAuthorName, hdr.NewAuthorEmail, hdr.NewTitle),
+		ContentEqual:  hdr.ContentEqual,
+		AuthorChanged: hdr.AuthorChanged,
+		TitleChanged:  hdr.TitleChanged,
+		BodyChanged:   hdr.BodyChanged,
+		Files:         []*RangeDiffFileSchema{},
+	}
+
+	for _, file := range diff.Files {
+		fileName := ""
+		if file.NewFile != nil {
+			fileName = file.NewFile.NewName
+		} else if file.OldFile != nil {
+			fileName = file.OldFile.NewName
+		}
+		fout := &RangeDiffFileSchema{Name: fileName, Chunks: []*RangeDiffChunkSchema{}}
+		for _, chunk := range file.Diff {
+			fout.Chunks = append(fout.Chunks, &RangeDiffChunkSchema{
+				Type:      chunk.OuterType,
+				InnerType: chunk.InnerType,
+				Text:      chunk.Text,
+			})
+		}
+		out.Files = append(out.Files, fout)
+	}
+
+	for _, chunk := range diff.Message {
+		out.Message = append(out.Message, &RangeDiffChunkSchema{
+			Type:      chunk.OuterType,
+			InnerType: chunk.InnerType,
+			Text:      chunk.Text,
+		})
+	}
+
+	return out
+}
+
+func NewRangeDiffSchemas(diffs []*RangeDiffOutput) []*RangeDiffSchema {
+	out := []*RangeDiffSchema{}
+	for _, diff := range diffs {
+		out = append(out, NewRangeDiffSchema(diff))
+	}
+	return out
+}
+
+type outputFormat string
+
+const (
+	FormatText  outputFormat = ""
+	FormatJSON  outputFormat = "json"
+	FormatJSONL outputFormat = "jsonl"
+)
+
+func getOutputFormat(cCtx *cli.Context) outputFormat {
+	if cCtx.Bool("jsonl") {
+		return FormatJSONL
+	}
+	if cCtx.Bool("json") {
+		return FormatJSON
+	}
+	return FormatText
+}
+
+func (f outputFormat) IsJSON() bool {
+	return f != FormatText
+}
+
+// writeJSON writes a single object.
+func writeJSON(w io.Writer, format outputFormat, v any) error {
+	enc := json.NewEncoder(w)
+	if format == FormatJSON {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(v)
+}
+
+// writeJSONList writes an array for json and one object per line for jsonl.
+func writeJSONList[T any](w io.Writer, format outputFormat, items []T) error {
+	if format == FormatJSON {
+		if items == nil {
+			items = []T{}
+		}
+		return writeJSON(w, format, items)
+	}
+	for _, item := range items {
+		err := writeJSON(w, format, item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func jsonError(err error) error {
+	by, _ := json.Marshal(ErrorSchema{Error: err.Error()})
+	return fmt.Errorf("%s", by)
+}
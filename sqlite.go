@@ -21,6 +21,17 @@ CREATE TABLE IF NOT EXISTS repos (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   user_id INTEGER NOT NULL,
   name TEXT NOT NULL,
+  range_diff_creation_factor INTEGER NOT NULL DEFAULT 0,
+  range_diff_normalize BOOLEAN NOT NULL DEFAULT false,
+  required_approvals INTEGER NOT NULL DEFAULT 0,
+  archived BOOLEAN NOT NULL DEFAULT false,
+  transfer_to_id INTEGER,
+  description TEXT NOT NULL DEFAULT '',
+  homepage TEXT NOT NULL DEFAULT '',
+  clone_url TEXT NOT NULL DEFAULT '',
+  default_branch TEXT NOT NULL DEFAULT 'main',
+  contributing TEXT NOT NULL DEFAULT '',
+  pr_template TEXT NOT NULL DEFAULT '',
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
   updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
   UNIQUE (user_id, name),
@@ -45,6 +56,7 @@ CREATE TABLE IF NOT EXISTS patch_requests (
   name TEXT NOT NULL,
   text TEXT NOT NULL,
   status TEXT NOT NULL,
+  superseded_by INTEGER,
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
   updated_at DATETIME NOT NULL,
   CONSTRAINT pr_user_id_fk
@@ -116,6 +128,205 @@ CREATE TABLE IF NOT EXISTS event_logs (
 		ON DELETE CASCADE
 		ON UPDATE CASCADE
 );
+
+CREATE TABLE IF NOT EXISTS user_emails (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	email TEXT NOT NULL,
+	verified BOOLEAN NOT NULL DEFAULT false,
+	token TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_id, email),
+	CONSTRAINT user_emails_user_id_fk
+		FOREIGN KEY(user_id) REFERENCES app_users(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS email_messages (
+	message_id TEXT PRIMARY KEY,
+	patch_request_id INTEGER NOT NULL,
+	patchset_id INTEGER,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT email_messages_pr_id_fk
+		FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT email_messages_patchset_id_fk
+		FOREIGN KEY(patchset_id) REFERENCES patchsets(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS access_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL DEFAULT 'repo:read,pr:read,user:read',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_used_at DATETIME,
+	expires_at DATETIME,
+	CONSTRAINT access_tokens_user_id_fk
+		FOREIGN KEY(user_id) REFERENCES app_users(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS range_diffs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	patchset_a_id INTEGER NOT NULL,
+	patchset_b_id INTEGER NOT NULL,
+	algorithm_version INTEGER NOT NULL,
+	opts TEXT NOT NULL,
+	data TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (patchset_a_id, patchset_b_id, algorithm_version, opts),
+	CONSTRAINT range_diffs_patchset_a_id_fk
+		FOREIGN KEY(patchset_a_id) REFERENCES patchsets(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT range_diffs_patchset_b_id_fk
+		FOREIGN KEY(patchset_b_id) REFERENCES patchsets(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS labels (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	repo_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	color TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (repo_id, name),
+	CONSTRAINT labels_repo_id_fk
+		FOREIGN KEY(repo_id) REFERENCES repos(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS pr_labels (
+	patch_request_id INTEGER NOT NULL,
+	label_id INTEGER NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (patch_request_id, label_id),
+	CONSTRAINT pr_labels_pr_id_fk
+		FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT pr_labels_label_id_fk
+		FOREIGN KEY(label_id) REFERENCES labels(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS review_requests (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	patch_request_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	requested_by INTEGER NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (patch_request_id, user_id),
+	CONSTRAINT review_requests_pr_id_fk
+		FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT review_requests_user_id_fk
+		FOREIGN KEY(user_id) REFERENCES app_users(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS approvals (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	patch_request_id INTEGER NOT NULL,
+	patchset_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (patch_request_id, user_id),
+	CONSTRAINT approvals_pr_id_fk
+		FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT approvals_patchset_id_fk
+		FOREIGN KEY(patchset_id) REFERENCES patchsets(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT approvals_user_id_fk
+		FOREIGN KEY(user_id) REFERENCES app_users(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS pr_dependencies (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	patch_request_id INTEGER NOT NULL,
+	depends_on_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (patch_request_id, depends_on_id),
+	CONSTRAINT pr_dependencies_pr_id_fk
+		FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT pr_dependencies_depends_on_id_fk
+		FOREIGN KEY(depends_on_id) REFERENCES patch_requests(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	patch_request_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	reply_to_id INTEGER,
+	text TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME,
+	CONSTRAINT comments_pr_id_fk
+		FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT comments_user_id_fk
+		FOREIGN KEY(user_id) REFERENCES app_users(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS patch_request_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	patch_request_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	text TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT patch_request_revisions_pr_id_fk
+		FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT patch_request_revisions_user_id_fk
+		FOREIGN KEY(user_id) REFERENCES app_users(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS repo_redirects (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	repo_id INTEGER NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_id, name),
+	CONSTRAINT repo_redirects_user_id_fk
+		FOREIGN KEY(user_id) REFERENCES app_users(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE,
+	CONSTRAINT repo_redirects_repo_id_fk
+		FOREIGN KEY(repo_id) REFERENCES repos(id)
+		ON DELETE CASCADE
+		ON UPDATE CASCADE
+);
 `
 
 var sqliteMigrations = []string{
@@ -196,6 +407,220 @@ var sqliteMigrations = []string{
 		LEFT JOIN repos ON repos.name = ev.repo_id;
 	DROP TABLE event_logs;
 	ALTER TABLE tmp_event_logs RENAME TO event_logs;`,
+	// inbound email gateway
+	`CREATE TABLE IF NOT EXISTS user_emails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		email TEXT NOT NULL,
+		verified BOOLEAN NOT NULL DEFAULT false,
+		token TEXT NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (user_id, email),
+		CONSTRAINT user_emails_user_id_fk
+			FOREIGN KEY(user_id) REFERENCES app_users(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);
+	
+	CREATE TABLE IF NOT EXISTS email_messages (
+		message_id TEXT PRIMARY KEY,
+		patch_request_id INTEGER NOT NULL,
+		patchset_id INTEGER,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT email_messages_pr_id_fk
+			FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT email_messages_patchset_id_fk
+			FOREIGN KEY(patchset_id) REFERENCES patchsets(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// web api access tokens
+	`CREATE TABLE IF NOT EXISTS access_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME,
+		CONSTRAINT access_tokens_user_id_fk
+			FOREIGN KEY(user_id) REFERENCES app_users(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// scoped access tokens
+	`ALTER TABLE access_tokens ADD COLUMN scopes TEXT NOT NULL DEFAULT 'repo:read,pr:read,user:read';
+	ALTER TABLE access_tokens ADD COLUMN expires_at DATETIME;`,
+	// range-diff cache
+	`CREATE TABLE IF NOT EXISTS range_diffs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patchset_a_id INTEGER NOT NULL,
+		patchset_b_id INTEGER NOT NULL,
+		algorithm_version INTEGER NOT NULL,
+		opts TEXT NOT NULL,
+		data TEXT NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (patchset_a_id, patchset_b_id, algorithm_version, opts),
+		CONSTRAINT range_diffs_patchset_a_id_fk
+			FOREIGN KEY(patchset_a_id) REFERENCES patchsets(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT range_diffs_patchset_b_id_fk
+			FOREIGN KEY(patchset_b_id) REFERENCES patchsets(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// per-repo range-diff defaults
+	`ALTER TABLE repos ADD COLUMN range_diff_creation_factor INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE repos ADD COLUMN range_diff_normalize BOOLEAN NOT NULL DEFAULT false;`,
+	// repo labels
+	`CREATE TABLE IF NOT EXISTS labels (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		repo_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		color TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (repo_id, name),
+		CONSTRAINT labels_repo_id_fk
+			FOREIGN KEY(repo_id) REFERENCES repos(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS pr_labels (
+		patch_request_id INTEGER NOT NULL,
+		label_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (patch_request_id, label_id),
+		CONSTRAINT pr_labels_pr_id_fk
+			FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT pr_labels_label_id_fk
+			FOREIGN KEY(label_id) REFERENCES labels(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// requested reviewers
+	`CREATE TABLE IF NOT EXISTS review_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patch_request_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		requested_by INTEGER NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (patch_request_id, user_id),
+		CONSTRAINT review_requests_pr_id_fk
+			FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT review_requests_user_id_fk
+			FOREIGN KEY(user_id) REFERENCES app_users(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// approvals and the required approvals policy
+	`ALTER TABLE repos ADD COLUMN required_approvals INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE IF NOT EXISTS approvals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patch_request_id INTEGER NOT NULL,
+		patchset_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (patch_request_id, user_id),
+		CONSTRAINT approvals_pr_id_fk
+			FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT approvals_patchset_id_fk
+			FOREIGN KEY(patchset_id) REFERENCES patchsets(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT approvals_user_id_fk
+			FOREIGN KEY(user_id) REFERENCES app_users(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// stacked patch requests
+	`CREATE TABLE IF NOT EXISTS pr_dependencies (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patch_request_id INTEGER NOT NULL,
+		depends_on_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (patch_request_id, depends_on_id),
+		CONSTRAINT pr_dependencies_pr_id_fk
+			FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT pr_dependencies_depends_on_id_fk
+			FOREIGN KEY(depends_on_id) REFERENCES patch_requests(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// discussion comments
+	`CREATE TABLE IF NOT EXISTS comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patch_request_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		reply_to_id INTEGER,
+		text TEXT NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME,
+		CONSTRAINT comments_pr_id_fk
+			FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT comments_user_id_fk
+			FOREIGN KEY(user_id) REFERENCES app_users(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// patch request title and description history
+	`CREATE TABLE IF NOT EXISTS patch_request_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patch_request_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		text TEXT NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT patch_request_revisions_pr_id_fk
+			FOREIGN KEY(patch_request_id) REFERENCES patch_requests(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT patch_request_revisions_user_id_fk
+			FOREIGN KEY(user_id) REFERENCES app_users(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// link a patch request to the one replacing it
+	"ALTER TABLE patch_requests ADD COLUMN superseded_by INTEGER",
+	// repo archival, ownership transfers, and redirects for old names
+	"ALTER TABLE repos ADD COLUMN archived BOOLEAN NOT NULL DEFAULT false",
+	"ALTER TABLE repos ADD COLUMN transfer_to_id INTEGER",
+	`CREATE TABLE IF NOT EXISTS repo_redirects (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		repo_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (user_id, name),
+		CONSTRAINT repo_redirects_user_id_fk
+			FOREIGN KEY(user_id) REFERENCES app_users(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE,
+		CONSTRAINT repo_redirects_repo_id_fk
+			FOREIGN KEY(repo_id) REFERENCES repos(id)
+			ON DELETE CASCADE
+			ON UPDATE CASCADE
+	);`,
+	// repo metadata
+	"ALTER TABLE repos ADD COLUMN description TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE repos ADD COLUMN homepage TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE repos ADD COLUMN clone_url TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE repos ADD COLUMN default_branch TEXT NOT NULL DEFAULT 'main'",
+	"ALTER TABLE repos ADD COLUMN contributing TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE repos ADD COLUMN pr_template TEXT NOT NULL DEFAULT ''",
 }
 
 // Open opens a database connection.
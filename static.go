@@ -0,0 +1,435 @@
+package git
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// STATIC_STATE_FILE records the last event log rendered by a static build
+// so the next incremental build knows where to resume.
+var STATIC_STATE_FILE = ".git-pr-static"
+
+// staticPage maps a web route to the file it is written to.
+type staticPage struct {
+	URL  string
+	File string
+}
+
+// htmlPage is written as an index.html inside a directory so links without
+// a trailing slash keep working on any static file server.
+func htmlPage(url string) staticPage {
+	return staticPage{
+		URL:  url,
+		File: path.Join(strings.TrimPrefix(url, "/"), "index.html"),
+	}
+}
+
+func filePage(url string) staticPage {
+	return staticPage{URL: url, File: strings.TrimPrefix(url, "/")}
+}
+
+// StaticSite renders the web pages into a directory by routing requests
+// through the same handlers as the web server.
+type StaticSite struct {
+	Pr      GitPatchRequest
+	Backend *Backend
+	Handler http.Handler
+	OutDir  string
+	Logger  *slog.Logger
+}
+
+func NewStaticSite(prCmd *PrCmd, outDir string) *StaticSite {
+	web := NewWebCtx(prCmd)
+	web.Live = false
+	return &StaticSite{
+		Pr:      prCmd,
+		Backend: prCmd.Backend,
+		Handler: NewWebMux(web),
+		OutDir:  outDir,
+		Logger:  prCmd.Backend.Logger,
+	}
+}
+
+// redirectHtml sends browsers on to the new location of a page since static
+// file servers cannot redirect on their own.
+func redirectHtml(url string) []byte {
+	url = html.EscapeString(url)
+	return []byte(fmt.Sprintf(
+		"<!doctype html>\n<html><head><meta charset=\"utf-8\"><meta http-equiv=\"refresh\" content=\"0; url=%s\"><link rel=\"canonical\" href=\"%s\"></head><body><a href=\"%s\">moved here</a></body></html>\n",
+		url, url, url,
+	))
+}
+
+// render writes a single page to disk.  Pages that no longer exist, like a
+// deleted patchset, are removed from the output directory.  Pages of a
+// renamed or transferred repo are replaced with a redirect, or removed when
+// they are not html.
+func (s *StaticSite) render(page staticPage) error {
+	req := httptest.NewRequest(http.MethodGet, page.URL, nil)
+	rec := httptest.NewRecorder()
+	s.Handler.ServeHTTP(rec, req)
+
+	fpath := filepath.Join(s.OutDir, filepath.FromSlash(page.File))
+	body := rec.Body.Bytes()
+	isHtml := path.Base(page.File) == "index.html"
+	switch {
+	case rec.Code == http.StatusOK:
+	case rec.Code == http.StatusMovedPermanently && isHtml:
+		body = redirectHtml(rec.Header().Get("Location"))
+	case rec.Code == http.StatusNotFound || rec.Code == http.StatusMovedPermanently:
+		err := os.Remove(fpath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: unexpected status %d", page.URL, rec.Code)
+	}
+
+	err := os.MkdirAll(filepath.Dir(fpath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fpath, body, 0644)
+}
+
+func (s *StaticSite) renderPages(pages []staticPage) ([]string, error) {
+	files := []string{}
+	for _, page := range pages {
+		if slices.Contains(files, page.File) {
+			continue
+		}
+		err := s.render(page)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, page.File)
+	}
+	return files, nil
+}
+
+func (s *StaticSite) assetPages() ([]staticPage, error) {
+	pages := []staticPage{filePage("/syntax.css")}
+	names := []string{}
+	embedFS, err := getEmbedFS(embedStaticFS, "static")
+	if err != nil {
+		return nil, err
+	}
+	fsyss := []fs.FS{embedFS}
+	if userFS := getUserDefinedFS(s.Backend.Cfg.DataDir, "static"); userFS != nil {
+		fsyss = append(fsyss, userFS)
+	}
+	for _, fsys := range fsyss {
+		entries, err := fs.ReadDir(fsys, ".")
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || slices.Contains(names, entry.Name()) {
+				continue
+			}
+			names = append(names, entry.Name())
+			pages = append(pages, filePage("/static/"+entry.Name()))
+		}
+	}
+	return pages, nil
+}
+
+func userPages(name string) []staticPage {
+	return []staticPage{
+		htmlPage("/r/" + name),
+		filePage("/rss/" + name),
+	}
+}
+
+func repoPages(username, repoName string) []staticPage {
+	url := fmt.Sprintf("/r/%s/%s", username, repoName)
+	return []staticPage{
+		htmlPage(url),
+		filePage(url + "/rss"),
+		filePage(url + "/archive.mbox"),
+	}
+}
+
+func patchsetPages(patchsetID int64) []staticPage {
+	return []staticPage{
+		htmlPage(fmt.Sprintf("/ps/%d", patchsetID)),
+		htmlPage(fmt.Sprintf("/rd/%d", patchsetID)),
+		filePage(fmt.Sprintf("/ps/%d.patch", patchsetID)),
+		filePage(fmt.Sprintf("/ps/%d.mbox", patchsetID)),
+	}
+}
+
+// prPages returns the pages for a patch request and all of its patchsets,
+// since every patchset page lists its siblings.
+func (s *StaticSite) prPages(prID int64) ([]staticPage, error) {
+	pages := []staticPage{
+		htmlPage(fmt.Sprintf("/prs/%d", prID)),
+		htmlPage(fmt.Sprintf("/prs/%d/revisions", prID)),
+		filePage(fmt.Sprintf("/prs/%d/rss", prID)),
+		filePage(fmt.Sprintf("/prs/%d.patch", prID)),
+		filePage(fmt.Sprintf("/prs/%d.mbox", prID)),
+	}
+	patchsets, err := s.Pr.GetPatchsetsByPrID(prID)
+	if err != nil {
+		return nil, err
+	}
+	for idx, patchset := range patchsets {
+		pages = append(pages, patchsetPages(patchset.ID)...)
+		if idx > 0 {
+			url := fmt.Sprintf("/rd/%d..%d.txt", patchsets[idx-1].ID, patchset.ID)
+			pages = append(pages, filePage(url))
+		}
+
+		patches, err := s.Pr.GetPatchesByPatchsetID(patchset.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, patch := range patches {
+			pages = append(pages, filePage(fmt.Sprintf("/patch/%d.patch", patch.ID)))
+		}
+	}
+	return pages, nil
+}
+
+func sitePages() []staticPage {
+	return []staticPage{
+		{URL: "/", File: "index.html"},
+		// `/rss/{user}` needs the directory so the site feed is its index
+		{URL: "/rss", File: "rss/index.xml"},
+	}
+}
+
+// pages returns every page on the site.
+func (s *StaticSite) pages() ([]staticPage, error) {
+	pages, err := s.assetPages()
+	if err != nil {
+		return nil, err
+	}
+	pages = append(pages, sitePages()...)
+
+	users, err := s.Pr.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	usernames := map[int64]string{}
+	for _, user := range users {
+		usernames[user.ID] = user.Name
+		pages = append(pages, userPages(user.Name)...)
+	}
+
+	repos, err := s.Pr.GetRepos()
+	if err != nil {
+		// GetRepos returns an error when there are no repos
+		repos = []*Repo{}
+	}
+	for _, repo := range repos {
+		pages = append(pages, repoPages(usernames[repo.UserID], repo.Name)...)
+	}
+
+	prs, err := s.Pr.GetPatchRequests()
+	if err != nil {
+		return nil, err
+	}
+	for _, prq := range prs {
+		prPages, err := s.prPages(prq.ID)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, prPages...)
+	}
+	return pages, nil
+}
+
+// movedPages returns the pages at the old location of a renamed or
+// transferred repo, or of the repo a patch request was moved out of.
+func (s *StaticSite) movedPages(eventLog *EventLog) ([]staticPage, error) {
+	if eventLog.Data.FromRepo == "" {
+		return nil, nil
+	}
+	username, repoName := s.Backend.SplitRepoNs(eventLog.Data.FromRepo)
+	if username == "" {
+		// repo namespaces leave out the owner when only admins create repos
+		if !eventLog.RepoID.Valid {
+			return nil, nil
+		}
+		repo, err := s.Pr.GetRepoByID(eventLog.RepoID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		owner, err := s.Pr.GetUserByID(repo.UserID)
+		if err != nil {
+			return nil, err
+		}
+		username = owner.Name
+	}
+	pages := []staticPage{htmlPage("/r/" + username)}
+	return append(pages, repoPages(username, repoName)...), nil
+}
+
+// pagesForEvent returns the pages whose content changes because of an
+// event log entry.
+func (s *StaticSite) pagesForEvent(eventLog *EventLog) ([]staticPage, error) {
+	pages := sitePages()
+
+	user, err := s.Pr.GetUserByID(eventLog.UserID)
+	if err != nil {
+		return nil, err
+	}
+	pages = append(pages, userPages(user.Name)...)
+
+	if eventLog.RepoID.Valid {
+		repo, err := s.Pr.GetRepoByID(eventLog.RepoID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		owner, err := s.Pr.GetUserByID(repo.UserID)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, htmlPage("/r/"+owner.Name))
+		pages = append(pages, repoPages(owner.Name, repo.Name)...)
+	}
+
+	movedPages, err := s.movedPages(eventLog)
+	if err != nil {
+		return nil, err
+	}
+	pages = append(pages, movedPages...)
+
+	if eventLog.PatchsetID.Valid {
+		// a deleted patchset is no longer part of the patch request
+		pages = append(pages, patchsetPages(eventLog.PatchsetID.Int64)...)
+	}
+
+	// repo events are stored with a zero patch request id
+	if eventLog.PatchRequestID.Valid && eventLog.PatchRequestID.Int64 != 0 {
+		prID := eventLog.PatchRequestID.Int64
+		prq, err := s.Pr.GetPatchRequestByID(prID)
+		if err != nil {
+			return nil, err
+		}
+		author, err := s.Pr.GetUserByID(prq.UserID)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, htmlPage("/r/"+author.Name))
+		prPages, err := s.prPages(prID)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, prPages...)
+	}
+
+	return pages, nil
+}
+
+func (s *StaticSite) readState() (int64, bool) {
+	data, err := os.ReadFile(filepath.Join(s.OutDir, STATIC_STATE_FILE))
+	if err != nil {
+		return 0, false
+	}
+	lastID, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return lastID, true
+}
+
+func (s *StaticSite) writeState(lastID int64) error {
+	fpath := filepath.Join(s.OutDir, STATIC_STATE_FILE)
+	return os.WriteFile(fpath, []byte(fmt.Sprintf("%d\n", lastID)), 0644)
+}
+
+// Build renders the site and returns the files it wrote.  An incremental
+// build only renders the pages affected by event logs created since the
+// previous build and falls back to a full build when there is none.
+func (s *StaticSite) Build(incremental bool) ([]string, error) {
+	err := os.MkdirAll(s.OutDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	eventLogs, err := s.Pr.GetEventLogs()
+	if err != nil {
+		return nil, err
+	}
+	// oldest first so pages are rendered in the order events happened
+	slices.SortFunc(eventLogs, func(a, b *EventLog) int {
+		return int(a.ID - b.ID)
+	})
+	var lastID int64
+	if len(eventLogs) > 0 {
+		lastID = eventLogs[len(eventLogs)-1].ID
+	}
+
+	prevID, ok := s.readState()
+	var pages []staticPage
+	if incremental && ok {
+		for _, eventLog := range eventLogs {
+			if eventLog.ID <= prevID {
+				continue
+			}
+			eventPages, err := s.pagesForEvent(eventLog)
+			if err != nil {
+				return nil, err
+			}
+			pages = append(pages, eventPages...)
+		}
+	} else {
+		pages, err = s.pages()
+		if err != nil {
+			return nil, err
+		}
+		// redirects for every location a repo was moved away from
+		for _, eventLog := range eventLogs {
+			movedPages, err := s.movedPages(eventLog)
+			if err != nil {
+				return nil, err
+			}
+			pages = append(pages, movedPages...)
+		}
+	}
+
+	files, err := s.renderPages(pages)
+	if err != nil {
+		return files, err
+	}
+	return files, s.writeState(lastID)
+}
+
+// GitStaticBuild renders the web pages to html files in outDir.
+func GitStaticBuild(cfg *GitCfg, outDir string, incremental bool) error {
+	dbpath := filepath.Join(cfg.DataDir, "pr.db?_fk=on")
+	dbh, err := SqliteOpen("file:"+dbpath, cfg.Logger)
+	if err != nil {
+		return fmt.Errorf("cannot find database file, check folder and perms: %s: %w", dbpath, err)
+	}
+	defer func() {
+		_ = dbh.Close()
+	}()
+
+	be := &Backend{
+		DB:     dbh,
+		Logger: cfg.Logger,
+		Cfg:    cfg,
+	}
+	prCmd := &PrCmd{
+		Backend: be,
+	}
+
+	site := NewStaticSite(prCmd, outDir)
+	files, err := site.Build(incremental)
+	cfg.Logger.Info("rendered static site", "out", outDir, "files", len(files))
+	return err
+}
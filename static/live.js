@@ -0,0 +1,37 @@
+// Subscribes to server-sent events and re-renders the page in place when
+// new activity lands.  Pages opt in with a `data-events` attribute on <main>.
+(function () {
+  var main = document.querySelector("main[data-events]");
+  if (!main || !window.EventSource) {
+    return;
+  }
+
+  var pending = false;
+  function refresh() {
+    pending = false;
+    fetch(window.location.href)
+      .then(function (resp) {
+        return resp.text();
+      })
+      .then(function (html) {
+        var doc = new DOMParser().parseFromString(html, "text/html");
+        ["header", "main"].forEach(function (tag) {
+          var cur = document.querySelector(tag);
+          var next = doc.querySelector(tag);
+          if (cur && next) {
+            cur.innerHTML = next.innerHTML;
+          }
+        });
+      });
+  }
+
+  var source = new EventSource(main.getAttribute("data-events"));
+  source.onmessage = function () {
+    // coalesce bursts of events into a single refresh
+    if (pending) {
+      return;
+    }
+    pending = true;
+    setTimeout(refresh, 250);
+  };
+})();
@@ -0,0 +1,245 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStaticBuild(t *testing.T) {
+	pr, _, prq := setupTestRepo(t)
+	contrib := createTestUser(t, pr, "bob")
+	outDir := t.TempDir()
+	site := NewStaticSite(pr, outDir)
+
+	files, err := site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchset, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range []string{
+		"index.html",
+		"rss/index.xml",
+		"syntax.css",
+		"static/git-pr.css",
+		"r/alice/index.html",
+		"r/bob/index.html",
+		"r/alice/test/index.html",
+		"r/alice/test/rss",
+		fmt.Sprintf("prs/%d/index.html", prq.ID),
+		fmt.Sprintf("ps/%d/index.html", patchset.ID),
+		fmt.Sprintf("rd/%d/index.html", patchset.ID),
+	} {
+		if !slices.Contains(files, fname) {
+			t.Fatalf("expected %s to be rendered, found: %v", fname, files)
+		}
+		if _, err := os.Stat(filepath.Join(outDir, fname)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// static sites have no /events endpoint to stream from
+	data, err := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "live.js") {
+		t.Fatal("expected static pages to not load live.js")
+	}
+	body := webGet(t, NewWebMux(NewWebCtx(pr)), "/").Body.String()
+	if !strings.Contains(body, "live.js") {
+		t.Fatal("expected served pages to load live.js")
+	}
+
+	files, err = site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected nothing to render without new events, found: %v", files)
+	}
+
+	_, err = pr.SubmitPatchset(prq.ID, contrib.ID, OpReview, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err = site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"index.html",
+		"r/bob/index.html",
+		fmt.Sprintf("prs/%d/index.html", prq.ID),
+		fmt.Sprintf("ps/%d/index.html", review.ID),
+	}
+	for _, fname := range expected {
+		if !slices.Contains(files, fname) {
+			t.Fatalf("expected %s to be rendered, found: %v", fname, files)
+		}
+	}
+	if slices.Contains(files, "syntax.css") {
+		t.Fatal("expected incremental build to skip unaffected pages")
+	}
+}
+
+func TestStaticBuildWithoutRepos(t *testing.T) {
+	pr := setupTestPr(t)
+	files, err := NewStaticSite(pr, t.TempDir()).Build(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(files, "index.html") {
+		t.Fatalf("expected index.html to be rendered, found: %v", files)
+	}
+}
+
+func TestStaticBuildRepoSettings(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	outDir := t.TempDir()
+	site := NewStaticSite(pr, outDir)
+	_, err := site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Set("description", "a tiny rnn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pr.UpdateRepo(repo, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(files, "r/alice/test/index.html") {
+		t.Fatalf("expected repo settings to render the repo, found: %v", files)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "r/alice/test/index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "a tiny rnn") {
+		t.Fatal("expected the new description to be rendered")
+	}
+
+	_, err = pr.CreateLabel(repo.ID, owner.ID, "bug", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pr.UpdatePatchRequestLabels(prq.ID, owner.ID, []string{"bug"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deleting a label changes every pr it was on
+	err = pr.DeleteLabel(repo.ID, owner.ID, "bug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err = site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range []string{
+		"r/alice/test/index.html",
+		fmt.Sprintf("prs/%d/index.html", prq.ID),
+	} {
+		if !slices.Contains(files, fname) {
+			t.Fatalf("expected %s to be rendered, found: %v", fname, files)
+		}
+	}
+}
+
+func TestStaticBuildMovedRepo(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	outDir := t.TempDir()
+	site := NewStaticSite(pr, outDir)
+	_, err := site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := pr.GetRepoByName(owner, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pr.RenameRepo(repo.ID, owner.ID, "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(files, "r/alice/renamed/index.html") {
+		t.Fatalf("expected the new location to be rendered, found: %v", files)
+	}
+	// the old location redirects and its feeds are gone
+	data, err := os.ReadFile(filepath.Join(outDir, "r/alice/test/index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `url=/r/alice/renamed"`) {
+		t.Fatalf("expected a redirect to the new location, found: %s", data)
+	}
+	for _, fname := range []string{"r/alice/test/rss", "r/alice/test/archive.mbox"} {
+		if _, err := os.Stat(filepath.Join(outDir, fname)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", fname)
+		}
+	}
+
+	// moving a pr out of a repo updates the page it was listed on
+	next, err := pr.CreateRepo(owner, "next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pr.MovePatchRequest(prq.ID, next.ID, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err = site.Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range []string{"r/alice/renamed/index.html", "r/alice/next/index.html"} {
+		if !slices.Contains(files, fname) {
+			t.Fatalf("expected %s to be rendered, found: %v", fname, files)
+		}
+	}
+
+	// a full build writes the redirect too
+	outDir = t.TempDir()
+	_, err = NewStaticSite(pr, outDir).Build(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(filepath.Join(outDir, "r/alice/test/index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `url=/r/alice/renamed"`) {
+		t.Fatalf("expected a redirect to the new location, found: %s", data)
+	}
+}
@@ -1,6 +1,7 @@
 package git
 
 import (
+	crand "crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
@@ -10,6 +11,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bluekeyes/go-gitdiff/gitdiff"
 	"golang.org/x/crypto/ssh"
@@ -32,6 +34,39 @@ func randSeq(n int) string {
 	return strings.ToLower(string(b))
 }
 
+// newVerifyToken generates a short code used to verify ownership of an
+// email address.
+func newVerifyToken() string {
+	return strings.ToLower(crand.Text()[:10])
+}
+
+// newAccessToken generates a token for the web api.  The prefix makes it
+// easy to spot when leaked.
+func newAccessToken() string {
+	return "gpr_" + crand.Text()
+}
+
+// parseExpires parses a token lifetime like `30d` or `12h`.
+func parseExpires(expires string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(expires, "d"); found {
+		num, err := strconv.Atoi(days)
+		if err != nil || num < 1 {
+			return 0, fmt.Errorf("invalid expiration: %s", expires)
+		}
+		return time.Duration(num) * 24 * time.Hour, nil
+	}
+	dur, err := time.ParseDuration(expires)
+	if err != nil || dur <= 0 {
+		return 0, fmt.Errorf("invalid expiration: %s", expires)
+	}
+	return dur, nil
+}
+
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func truncateSha(sha string) string {
 	if len(sha) < 7 {
 		return sha
@@ -81,6 +116,167 @@ func getPatchsetID(patchsetID string) (int64, error) {
 	return int64(psID), nil
 }
 
+// parseLabelChanges splits `+bug -wip` into labels to add and remove, a
+// label without a prefix is added.
+func parseLabelChanges(changes []string) ([]string, []string, error) {
+	add := []string{}
+	rm := []string{}
+	for _, change := range changes {
+		if name, found := strings.CutPrefix(change, "-"); found {
+			rm = append(rm, name)
+		} else {
+			add = append(add, strings.TrimPrefix(change, "+"))
+		}
+	}
+	for _, name := range append(add, rm...) {
+		if name == "" {
+			return nil, nil, fmt.Errorf("must provide label name")
+		}
+	}
+	if len(add) == 0 && len(rm) == 0 {
+		return nil, nil, fmt.Errorf("must provide labels to change, e.g. +bug -wip")
+	}
+	return add, rm, nil
+}
+
+// parseLabelFilters separates `label:name` terms from the other arguments.
+func parseLabelFilters(terms []string) ([]string, []string) {
+	labels := []string{}
+	rest := []string{}
+	for _, term := range terms {
+		if name, found := strings.CutPrefix(term, "label:"); found {
+			if name != "" {
+				labels = append(labels, name)
+			}
+			continue
+		}
+		rest = append(rest, term)
+	}
+	return labels, rest
+}
+
+// cutTrailingFlag removes `--name value` or `--name=value` from arguments.
+// Flags are only parsed before the arguments so this lets commands also
+// accept them after, e.g. `pr depends 5 --on 3`.
+func cutTrailingFlag(args []string, name string) (string, []string) {
+	flag := "--" + name
+	rest := []string{}
+	value := ""
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if v, found := strings.CutPrefix(arg, flag+"="); found {
+			value = v
+			continue
+		}
+		if arg == flag && idx+1 < len(args) {
+			value = args[idx+1]
+			idx++
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return value, rest
+}
+
+// prStatusList formats patch requests for plain text output, e.g.
+// "#2 [open], #3 [accepted]".
+func prStatusList(prs []*PatchRequest) string {
+	items := []string{}
+	for _, prq := range prs {
+		items = append(items, fmt.Sprintf("#%d [%s]", prq.ID, prq.Status))
+	}
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+// labelNames joins the label names for plain text output.
+func labelNames(labels []*Label) string {
+	names := []string{}
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// getReviewerNames returns the names of the users still requested to
+// review a patch request.
+func getReviewerNames(pr GitPatchRequest, prID int64) ([]string, error) {
+	requests, err := pr.GetReviewRequestsByPrID(prID)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, request := range requests {
+		user, err := pr.GetUserByID(request.UserID)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, user.Name)
+	}
+	return names, nil
+}
+
+// approvalSummary formats approvals for plain text output, e.g.
+// "1/2 (bob, carol stale)".
+func approvalSummary(status *ApprovalStatus) string {
+	names := []string{}
+	for _, approval := range status.Approvals {
+		name := approval.User.Name
+		if approval.Stale {
+			name += " stale"
+		}
+		names = append(names, name)
+	}
+	out := fmt.Sprintf("%d", status.Count())
+	if status.Required > 0 {
+		out = fmt.Sprintf("%d/%d", status.Count(), status.Required)
+	}
+	if len(names) > 0 {
+		out += fmt.Sprintf(" (%s)", strings.Join(names, ", "))
+	}
+	return out
+}
+
+// hasLabels reports whether every name is one of the labels.
+func hasLabels(labels []*Label, names []string) bool {
+	for _, name := range names {
+		found := false
+		for _, label := range labels {
+			if label.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePatchsetRange parses a range like `ps-3..ps-7` or `3...7` into
+// patchset IDs.
+func parsePatchsetRange(rng string) (int64, int64, error) {
+	rawFrom, rawTo, found := strings.Cut(rng, "..")
+	if !found {
+		return 0, 0, fmt.Errorf("range must be in format: ps-X..ps-Y")
+	}
+	fromID, err := getPatchsetID(rawFrom)
+	if err != nil {
+		return 0, 0, err
+	}
+	toID, err := getPatchsetID(strings.TrimPrefix(rawTo, "."))
+	if err != nil {
+		return 0, 0, err
+	}
+	return fromID, toID, nil
+}
+
 func splitPatchSet(patchset string) []string {
 	return strings.Split(patchset, "\n"+startOfPatch)
 }
@@ -229,3 +425,20 @@ func calcContentSha(diffFiles []*gitdiff.File, header *gitdiff.PatchHeader) stri
 	shaStr := hex.EncodeToString(sha[:])
 	return shaStr
 }
+
+// formatWaiting shortens how long something has been waiting to its two
+// largest units, e.g. 3d4h.
+func formatWaiting(d time.Duration) string {
+	d = max(d, 0).Round(time.Minute)
+	days := int64(d / (24 * time.Hour))
+	hours := int64(d/time.Hour) % 24
+	minutes := int64(d/time.Minute) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -67,3 +68,57 @@ func TestPatchToDiff(t *testing.T) {
 		t.Fatal("diff does not match expected")
 	}
 }
+
+func TestParsePatchsetRange(t *testing.T) {
+	for _, rng := range []string{"ps-3..ps-7", "3..7", "ps-3...ps-7", "3...7"} {
+		from, to, err := parsePatchsetRange(rng)
+		if err != nil {
+			t.Fatalf("%s: %s", rng, err)
+		}
+		if from != 3 || to != 7 {
+			t.Fatalf("%s: expected 3..7, found %d..%d", rng, from, to)
+		}
+	}
+	for _, rng := range []string{"ps-3", "3....7", "a..b"} {
+		if _, _, err := parsePatchsetRange(rng); err == nil {
+			t.Fatalf("%s: expected error", rng)
+		}
+	}
+}
+
+func TestParseLabelChanges(t *testing.T) {
+	add, rm, err := parseLabelChanges([]string{"+bug", "-wip", "docs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(add, ",") != "bug,docs" || strings.Join(rm, ",") != "wip" {
+		t.Fatalf("expected +bug +docs -wip, found: +%v -%v", add, rm)
+	}
+	for _, changes := range [][]string{{}, {"+"}, {"-"}} {
+		if _, _, err := parseLabelChanges(changes); err == nil {
+			t.Fatalf("%v: expected error", changes)
+		}
+	}
+}
+
+func TestParseLabelFilters(t *testing.T) {
+	labels, rest := parseLabelFilters([]string{"admin/test", "label:bug", "label:", "label:ui/web"})
+	if strings.Join(labels, ",") != "bug,ui/web" || strings.Join(rest, ",") != "admin/test" {
+		t.Fatalf("unexpected filters: %v %v", labels, rest)
+	}
+}
+
+func TestCutTrailingFlag(t *testing.T) {
+	value, rest := cutTrailingFlag([]string{"5", "--on", "3"}, "on")
+	if value != "3" || strings.Join(rest, ",") != "5" {
+		t.Fatalf("unexpected flag: %q %v", value, rest)
+	}
+	value, rest = cutTrailingFlag([]string{"5", "--on=3"}, "on")
+	if value != "3" || strings.Join(rest, ",") != "5" {
+		t.Fatalf("unexpected flag: %q %v", value, rest)
+	}
+	value, rest = cutTrailingFlag([]string{"5", "--on"}, "on")
+	if value != "" || strings.Join(rest, ",") != "5,--on" {
+		t.Fatalf("expected flag without a value to be kept: %q %v", value, rest)
+	}
+}
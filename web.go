@@ -3,12 +3,16 @@ package git
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"mime"
 	"net/http"
 	"net/url"
@@ -25,6 +29,7 @@ import (
 	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/bluekeyes/go-gitdiff/gitdiff"
 	"github.com/gorilla/feeds"
+	"github.com/russross/blackfriday/v2"
 )
 
 var (
@@ -33,6 +38,8 @@ var (
 	indexTmpl = getTemplate("index.html")
 	prTmpl    = getTemplate("pr.html")
 	userTmpl  = getTemplate("user.html")
+	queueTmpl = getTemplate("queue.html")
+	revsTmpl  = getTemplate("revisions.html")
 	repoTmpl  = getTemplate("repo.html")
 	toolTmpl  = getTemplate("tool.html")
 )
@@ -61,6 +68,8 @@ type WebCtx struct {
 	Formatter *formatterHtml.Formatter
 	Logger    *slog.Logger
 	Theme     *chroma.Style
+	// Live pages update through /events, static sites cannot serve it.
+	Live bool
 }
 
 type ctxWeb struct{}
@@ -78,7 +87,7 @@ func setWebCtx(ctx context.Context, web *WebCtx) context.Context {
 }
 
 // converts contents of files in git tree to pretty formatted code.
-func parseText(formatter *formatterHtml.Formatter, theme *chroma.Style, text string) (string, error) {
+func parseText(formatter *formatterHtml.Formatter, theme *chroma.Style, text string, wordDiff bool) (string, error) {
 	lexer := lexers.Get("diff")
 	iterator, err := lexer.Tokenise(nil, text)
 	if err != nil {
@@ -89,9 +98,27 @@ func parseText(formatter *formatterHtml.Formatter, theme *chroma.Style, text str
 	if err != nil {
 		return text, err
 	}
+	if wordDiff {
+		return highlightWordDiff(buf.String(), text), nil
+	}
 	return buf.String(), nil
 }
 
+// renderMarkdown renders user written markdown.  Raw html, images, and links
+// to untrusted protocols are dropped so it is safe to embed in a page.
+func renderMarkdown(text string) template.HTML {
+	renderer := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+		Flags: blackfriday.SkipHTML | blackfriday.SkipImages | blackfriday.Safelink |
+			blackfriday.NofollowLinks | blackfriday.NoreferrerLinks | blackfriday.NoopenerLinks,
+	})
+	out := blackfriday.Run(
+		[]byte(text),
+		blackfriday.WithRenderer(renderer),
+		blackfriday.WithExtensions(blackfriday.CommonExtensions),
+	)
+	return template.HTML(out)
+}
+
 func ctxMdw(ctx context.Context, handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		handler(w, r.WithContext(ctx))
@@ -119,6 +146,7 @@ type PrTableData struct {
 	NumOpen     int
 	NumAccepted int
 	NumClosed   int
+	NumDraft    int
 	MetaData
 }
 
@@ -128,9 +156,41 @@ type UserDetailData struct {
 	NumOpen     int
 	NumAccepted int
 	NumClosed   int
+	NumDraft    int
+	MetaData
+}
+
+// RevisionsData is the title and description history of a PR.
+type RevisionsData struct {
+	Repo      LinkData
+	Pr        PrData
+	Revisions []RevisionData
 	MetaData
 }
 
+type RevisionData struct {
+	UserData
+	ID       int64
+	Title    string
+	Body     template.HTML
+	Date     string
+	Original bool
+}
+
+type QueueData struct {
+	Entries  []*QueueEntryData
+	UserData UserData
+	MetaData
+}
+
+// QueueEntryData is a PR awaiting review along with who asked for it.
+type QueueEntryData struct {
+	*PrListData
+	RequestedBy UserData
+	RequestedAt string
+	Waiting     string
+}
+
 type RepoDetailData struct {
 	Name        string
 	UserID      int64
@@ -140,6 +200,19 @@ type RepoDetailData struct {
 	NumOpen     int
 	NumAccepted int
 	NumClosed   int
+	NumDraft    int
+	Labels      []LabelData
+	// Archived repos are read-only.
+	Archived bool
+	// Description, Homepage, and CloneURL are set by the repo owner,
+	// Contributing is their guide rendered from markdown.
+	Description  string
+	Homepage     string
+	CloneURL     string
+	Contributing template.HTML
+	PrTemplate   string
+	// LabelFilters are the labels the table is filtered by.
+	LabelFilters []string
 	MetaData
 }
 
@@ -200,7 +273,8 @@ func getPrTableData(web *WebCtx, prs []*PatchRequest, query url.Values) ([]*PrLi
 	title := strings.ToLower(query.Get("title"))
 	sort := strings.ToLower(query.Get("sort"))
 	sortDir := strings.ToLower(query.Get("sort_dir"))
-	hasFilter := status != "" || username != "" || title != ""
+	labelFilters := query["label"]
+	hasFilter := status != "" || username != "" || title != "" || len(labelFilters) > 0
 
 	for _, curpr := range prs {
 		user, err := web.Pr.GetUserByID(curpr.UserID)
@@ -232,7 +306,17 @@ func getPrTableData(web *WebCtx, prs []*PatchRequest, query url.Values) ([]*PrLi
 			continue
 		}
 
+		labels, err := web.Pr.GetLabelsByPrID(curpr.ID)
+		if err != nil {
+			web.Logger.Error("cannot get labels for pr", "err", err)
+			continue
+		}
+
 		if hasFilter {
+			if !hasLabels(labels, labelFilters) {
+				continue
+			}
+
 			if status != "" {
 				if status != curpr.Status {
 					continue
@@ -274,6 +358,7 @@ func getPrTableData(web *WebCtx, prs []*PatchRequest, query url.Values) ([]*PrLi
 			DateOrig:     curpr.CreatedAt,
 			Date:         curpr.CreatedAt.Format(web.Backend.Cfg.TimeFormat),
 			Status:       curpr.Status,
+			Labels:       getLabelData(repoUser.Name, repo.Name, labels),
 		}
 		prdata = append(prdata, prls)
 	}
@@ -312,6 +397,7 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	numOpen := 0
 	numAccepted := 0
 	numClosed := 0
+	numDraft := 0
 	for _, pr := range prs {
 		switch pr.Status {
 		case "open":
@@ -320,6 +406,8 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 			numAccepted += 1
 		case "closed":
 			numClosed += 1
+		case "draft":
+			numDraft += 1
 		}
 	}
 
@@ -328,10 +416,12 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		NumOpen:     numOpen,
 		NumAccepted: numAccepted,
 		NumClosed:   numClosed,
+		NumDraft:    numDraft,
 		Prs:         prdata,
 		MetaData: MetaData{
 			URL:  web.Backend.Cfg.Url,
 			Desc: template.HTML(web.Backend.Cfg.Desc),
+			Live: web.Live,
 		},
 	})
 	if err != nil {
@@ -350,6 +440,7 @@ type UserData struct {
 type MetaData struct {
 	URL  string
 	Desc template.HTML
+	Live bool
 }
 
 type PrListData struct {
@@ -363,6 +454,26 @@ type PrListData struct {
 	DateOrig     time.Time
 	Date         string
 	Status       Status
+	Labels       []LabelData
+}
+
+// LabelData is a label linking to the repo page filtered by it.
+type LabelData struct {
+	*Label
+	Url template.URL
+}
+
+func getLabelData(repoUserName, repoName string, labels []*Label) []LabelData {
+	data := []LabelData{}
+	for _, label := range labels {
+		data = append(data, LabelData{
+			Label: label,
+			Url: template.URL(fmt.Sprintf(
+				"/r/%s/%s?label=%s", repoUserName, repoName, url.QueryEscape(label.Name),
+			)),
+		})
+	}
+	return data
 }
 
 func userDetailHandler(w http.ResponseWriter, r *http.Request) {
@@ -410,6 +521,7 @@ func userDetailHandler(w http.ResponseWriter, r *http.Request) {
 	numOpen := 0
 	numAccepted := 0
 	numClosed := 0
+	numDraft := 0
 	for _, pr := range prs {
 		switch pr.Status {
 		case "open":
@@ -418,6 +530,8 @@ func userDetailHandler(w http.ResponseWriter, r *http.Request) {
 			numAccepted += 1
 		case "closed":
 			numClosed += 1
+		case "draft":
+			numDraft += 1
 		}
 	}
 
@@ -427,6 +541,7 @@ func userDetailHandler(w http.ResponseWriter, r *http.Request) {
 		NumOpen:     numOpen,
 		NumAccepted: numAccepted,
 		NumClosed:   numClosed,
+		NumDraft:    numDraft,
 		UserData: UserData{
 			UserID:    user.ID,
 			Name:      user.Name,
@@ -435,7 +550,90 @@ func userDetailHandler(w http.ResponseWriter, r *http.Request) {
 			IsAdmin:   isAdmin,
 		},
 		MetaData: MetaData{
-			URL: web.Backend.Cfg.Url,
+			URL:  web.Backend.Cfg.Url,
+			Live: web.Live,
+		},
+	})
+	if err != nil {
+		web.Backend.Logger.Error("cannot execute template", "err", err)
+	}
+}
+
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	userName := r.PathValue("user")
+
+	web, err := getWebCtx(r)
+	if err != nil {
+		web.Logger.Error("fetch web", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := web.Pr.GetUserByName(userName)
+	if err != nil {
+		web.Logger.Error("cannot find user by name", "err", err, "name", userName)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// a repo named queue predates this page so keep its url working
+	if _, err := web.Pr.GetRepoByName(user, "queue"); err == nil {
+		r.SetPathValue("repo", "queue")
+		repoDetailHandler(w, r)
+		return
+	}
+
+	queue, err := GetReviewQueue(web.Pr, user)
+	if err != nil {
+		web.Logger.Error("cannot get review queue", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	prs := []*PatchRequest{}
+	for _, entry := range queue {
+		prs = append(prs, entry.PatchRequest)
+	}
+	prdata, err := getPrTableData(web, prs, url.Values{})
+	if err != nil {
+		web.Logger.Error("cannot get pr table data", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	byID := map[int64]*PrListData{}
+	for _, data := range prdata {
+		byID[data.ID] = data
+	}
+
+	entries := []*QueueEntryData{}
+	for _, entry := range queue {
+		data, ok := byID[entry.PatchRequest.ID]
+		if !ok {
+			continue
+		}
+		entries = append(entries, &QueueEntryData{
+			PrListData: data,
+			RequestedBy: UserData{
+				UserID: entry.RequestedBy.ID,
+				Name:   entry.RequestedBy.Name,
+				Pubkey: entry.RequestedBy.Pubkey,
+			},
+			RequestedAt: entry.Request.CreatedAt.Format(web.Backend.Cfg.TimeFormat),
+			Waiting:     formatWaiting(time.Since(entry.Request.CreatedAt)),
+		})
+	}
+
+	w.Header().Set("content-type", "text/html")
+	err = queueTmpl.Execute(w, QueueData{
+		Entries: entries,
+		UserData: UserData{
+			UserID: user.ID,
+			Name:   user.Name,
+			Pubkey: user.Pubkey,
+		},
+		MetaData: MetaData{
+			URL:  web.Backend.Cfg.Url,
+			Live: web.Live,
 		},
 	})
 	if err != nil {
@@ -463,6 +661,9 @@ func repoDetailHandler(w http.ResponseWriter, r *http.Request) {
 
 	repo, err := web.Pr.GetRepoByName(user, repoName)
 	if err != nil {
+		if redirectRepo(web, w, r, user, repoName) {
+			return
+		}
 		web.Logger.Error("cannot find repo", "user", user, "err", err)
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -475,6 +676,13 @@ func repoDetailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	labels, err := web.Pr.GetLabelsByRepoID(repo.ID)
+	if err != nil {
+		web.Logger.Error("cannot get labels", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	prdata, err := getPrTableData(web, prs, r.URL.Query())
 	if err != nil {
 		web.Logger.Error("cannot get pr table data", "err", err)
@@ -485,6 +693,7 @@ func repoDetailHandler(w http.ResponseWriter, r *http.Request) {
 	numOpen := 0
 	numAccepted := 0
 	numClosed := 0
+	numDraft := 0
 	for _, pr := range prs {
 		switch pr.Status {
 		case "open":
@@ -493,21 +702,33 @@ func repoDetailHandler(w http.ResponseWriter, r *http.Request) {
 			numAccepted += 1
 		case "closed":
 			numClosed += 1
+		case "draft":
+			numDraft += 1
 		}
 	}
 
 	w.Header().Set("content-type", "text/html")
 	err = repoTmpl.Execute(w, RepoDetailData{
-		Name:        repo.Name,
-		UserID:      user.ID,
-		Username:    userName,
-		Branch:      "main",
-		Prs:         prdata,
-		NumOpen:     numOpen,
-		NumAccepted: numAccepted,
-		NumClosed:   numClosed,
+		Name:         repo.Name,
+		UserID:       user.ID,
+		Username:     userName,
+		Branch:       repo.DefaultBranch,
+		Description:  repo.Description,
+		Homepage:     repo.Homepage,
+		CloneURL:     repo.CloneURL,
+		Contributing: renderMarkdown(repo.Contributing),
+		PrTemplate:   repo.PrTemplate,
+		Prs:          prdata,
+		NumOpen:      numOpen,
+		NumAccepted:  numAccepted,
+		NumClosed:    numClosed,
+		NumDraft:     numDraft,
+		Labels:       getLabelData(user.Name, repo.Name, labels),
+		Archived:     repo.Archived,
+		LabelFilters: r.URL.Query()["label"],
 		MetaData: MetaData{
-			URL: web.Backend.Cfg.Url,
+			URL:  web.Backend.Cfg.Url,
+			Live: web.Live,
 		},
 	})
 	if err != nil {
@@ -521,6 +742,36 @@ type PrData struct {
 	Title  string
 	Date   string
 	Status Status
+	Labels []LabelData
+	// Body is the description rendered from markdown, Edited is set once
+	// the title or description has revisions.
+	Body   template.HTML
+	Edited bool
+	// Reviewers are the users still requested to review.
+	Reviewers []string
+	// DependsOn are the PRs that have to land first, RequiredBy the PRs
+	// waiting on this one.
+	DependsOn  []PrLinkData
+	RequiredBy []PrLinkData
+	// SupersededBy is the PR replacing this one, Supersedes the PRs it
+	// replaced.
+	SupersededBy *PrLinkData
+	Supersedes   []PrLinkData
+}
+
+// PrLinkData links to a related PR along with its status.
+type PrLinkData struct {
+	ID     int64
+	Title  string
+	Status Status
+}
+
+func getPrLinkData(prs []*PatchRequest) []PrLinkData {
+	links := []PrLinkData{}
+	for _, prq := range prs {
+		links = append(links, PrLinkData{ID: prq.ID, Title: prq.Name, Status: prq.Status})
+	}
+	return links
 }
 
 type PatchFile struct {
@@ -528,6 +779,7 @@ type PatchFile struct {
 	Adds     int64
 	Dels     int64
 	DiffText template.HTML
+	Split    []*SplitDiffRow
 }
 
 type PatchData struct {
@@ -546,40 +798,106 @@ type EventLogData struct {
 	FormattedPatchsetID string
 	Date                string
 	RangeDiff           []*RangeDiffOutput
+	Comment             *CommentData
+}
+
+// CommentData is a discussion comment rendered from markdown.
+type CommentData struct {
+	*Comment
+	Html template.HTML
 }
 
 type PatchsetData struct {
 	*Patchset
 	UserData
+	PrevID      int64
 	FormattedID string
 	Date        string
 	RangeDiff   []*RangeDiffOutput
 }
 
 type PrDetailData struct {
-	Page         string
-	Tab          string
-	Repo         LinkData
-	Pr           PrData
-	Patchset     *Patchset
-	PatchsetData *PatchsetData
-	Patches      []PatchData
-	Branch       string
-	Logs         []EventLogData
-	Patchsets    []PatchsetData
-	IsRangeDiff  bool
+	Page          string
+	Tab           string
+	Repo          LinkData
+	Pr            PrData
+	Patchset      *Patchset
+	PatchsetData  *PatchsetData
+	Patches       []PatchData
+	Branch        string
+	Logs          []EventLogData
+	Patchsets     []PatchsetData
+	IsRangeDiff   bool
+	RangeDiffOpts RangeDiffOpts
+	// View is "split" for the side-by-side diff view.
+	View string
+	// FileFilter only shows the patches touching this path.
+	FileFilter  string
+	AllFilesUrl string
+	// WordDiffUrl toggles word highlighting, keeping the other query params.
+	WordDiffUrl string
+	DiffStat    *DiffStat
+	FileTree    []*FileTreeNode
+	Approvals   ApprovalsData
 	MetaData
 }
 
+// ApprovalsData is the approval state shown in the PR header.
+type ApprovalsData struct {
+	*ApprovalStatus
+	Users []ApprovalUserData
+}
+
+type ApprovalUserData struct {
+	UserData
+	Stale bool
+}
+
 type ToolData struct {
-	Patchset     *Patchset
-	PatchsetData *PatchsetData
+	Patchset      *Patchset
+	PatchsetData  *PatchsetData
+	RangeDiffOpts RangeDiffOpts
 	MetaData
 }
 
+// getRangeDiffOpts reads the range-diff options from the query string or
+// the submitted form.  Options that are not provided keep their default.
+func getRangeDiffOpts(r *http.Request, opts RangeDiffOpts) RangeDiffOpts {
+	if normalize, err := strconv.ParseBool(r.FormValue("normalize")); err == nil {
+		opts.Normalize = normalize
+	}
+	if factor, err := strconv.Atoi(r.FormValue("creation_factor")); err == nil && factor >= 0 {
+		opts.CreationFactor = factor
+	}
+	if costMax, err := strconv.Atoi(r.FormValue("cost_max")); err == nil && costMax >= 0 {
+		opts.CostMax = costMax
+	}
+	if messageOnly, err := strconv.ParseBool(r.FormValue("message_only")); err == nil {
+		opts.MessageOnly = messageOnly
+	}
+	if dualColor, err := strconv.ParseBool(r.FormValue("dual_color")); err == nil {
+		opts.NoDualColor = !dualColor
+	}
+	if wordDiff, err := strconv.ParseBool(r.FormValue("word_diff")); err == nil {
+		opts.NoWordDiff = !wordDiff
+	}
+	return opts
+}
+
 func createPrDetail(page string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
+		// range-diff between any two patchsets: `/rd/{from}...{to}`
+		var rangeFromID int64
+		if page == "rd" && strings.Contains(id, "..") {
+			fromID, toID, err := parsePatchsetRange(id)
+			if err != nil {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			rangeFromID = fromID
+			id = strconv.FormatInt(toID, 10)
+		}
 		prID, err := strconv.Atoi(id)
 		if err != nil {
 			w.WriteHeader(http.StatusUnprocessableEntity)
@@ -598,7 +916,10 @@ func createPrDetail(page string) http.HandlerFunc {
 		case "pr":
 			{
 				pr, err = web.Pr.GetPatchRequestByID(int64(prID))
-				if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				} else if err != nil {
 					web.Pr.Backend.Logger.Error("cannot get prs", "err", err)
 					w.WriteHeader(http.StatusInternalServerError)
 					return
@@ -609,7 +930,10 @@ func createPrDetail(page string) http.HandlerFunc {
 		case "rd":
 			{
 				ps, err = web.Pr.GetPatchsetByID(int64(prID))
-				if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				} else if err != nil {
 					web.Pr.Backend.Logger.Error("cannot get patchset", "err", err)
 					w.WriteHeader(http.StatusInternalServerError)
 					return
@@ -631,7 +955,15 @@ func createPrDetail(page string) http.HandlerFunc {
 			return
 		}
 
+		repo, err := web.Pr.GetRepoByID(pr.RepoID)
+		if err != nil {
+			web.Logger.Error("cannot get repo for pr", "err", err)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
 		// get patchsets and diff from previous patchset
+		rdOpts := getRangeDiffOpts(r, repo.RangeDiffOpts())
 		patchsetsData := []PatchsetData{}
 		var selectedPatchsetData *PatchsetData
 		for idx, patchset := range patchsets {
@@ -642,13 +974,15 @@ func createPrDetail(page string) http.HandlerFunc {
 			}
 
 			var prevPatchset *Patchset
+			var prevID int64
 			if idx > 0 {
 				prevPatchset = patchsets[idx-1]
+				prevID = prevPatchset.ID
 			}
 
 			var rangeDiff []*RangeDiffOutput
 			if idx > 0 {
-				rangeDiff, err = web.Pr.DiffPatchsets(prevPatchset, patchset)
+				rangeDiff, err = web.Pr.DiffPatchsets(prevPatchset, patchset, rdOpts)
 				if err != nil {
 					web.Logger.Error("could not diff patchset", "err", err)
 					continue
@@ -669,6 +1003,7 @@ func createPrDetail(page string) http.HandlerFunc {
 
 			data := PatchsetData{
 				Patchset:    patchset,
+				PrevID:      prevID,
 				FormattedID: getFormattedPatchsetID(patchset.ID),
 				UserData: UserData{
 					UserID:    user.ID,
@@ -686,6 +1021,34 @@ func createPrDetail(page string) http.HandlerFunc {
 			}
 		}
 
+		if rangeFromID > 0 && selectedPatchsetData != nil {
+			rangeFrom, err := web.Pr.GetPatchsetByID(rangeFromID)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && rangeFrom.PatchRequestID != pr.ID) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			} else if err != nil {
+				web.Logger.Error("cannot get patchset", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			rangeDiff, err := web.Pr.DiffPatchsets(rangeFrom, ps, rdOpts)
+			if err != nil {
+				web.Logger.Error("could not diff patchset", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			selectedPatchsetData.PrevID = rangeFrom.ID
+			selectedPatchsetData.RangeDiff = rangeDiff
+		}
+
+		view := r.URL.Query().Get("view")
+		fileFilter := r.URL.Query().Get("file")
+		wordDiffQuery := r.URL.Query()
+		wordDiffQuery.Set("word_diff", strconv.FormatBool(rdOpts.NoWordDiff))
+		wordDiffUrl := "?" + wordDiffQuery.Encode()
+		var diffStat *DiffStat
+		var fileTree []*FileTreeNode
+		allFilesUrl := ""
 		patchesData := []PatchData{}
 		if len(patchsetsData) >= 1 {
 			psID := ps.ID
@@ -696,6 +1059,26 @@ func createPrDetail(page string) http.HandlerFunc {
 				return
 			}
 
+			diffStat, err = NewDiffStat(patches)
+			if err != nil {
+				web.Logger.Error("cannot parse patch", "err", err)
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			fileTree = diffStat.FileTree()
+			query := r.URL.Query()
+			setFileTreeUrls(fileTree, query)
+			query.Del("file")
+			allFilesUrl = "?" + query.Encode()
+			var filtered *DiffStatFile
+			if fileFilter != "" {
+				filtered = diffStat.File(fileFilter)
+				if filtered == nil {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+			}
+
 			// TODO: a little hacky
 			reviewIDs := []int64{}
 			for _, data := range patchsetsData {
@@ -721,6 +1104,9 @@ func createPrDetail(page string) http.HandlerFunc {
 			}
 
 			for _, patch := range patches {
+				if filtered != nil && !slices.Contains(filtered.Patches, patch.ID) {
+					continue
+				}
 				diffFiles, preamble, err := ParsePatch(patch.RawText)
 				if err != nil {
 					web.Logger.Error("cannot parse patch", "err", err)
@@ -738,7 +1124,10 @@ func createPrDetail(page string) http.HandlerFunc {
 				isReview := slices.Contains(reviewIDs, patch.ID)
 
 				patchFiles := []*PatchFile{}
-				for _, file := range diffFiles {
+				for fileIdx, file := range diffFiles {
+					if filtered != nil && !fileMatches(file, filtered) {
+						continue
+					}
 					var adds int64 = 0
 					var dels int64 = 0
 					for _, frag := range file.TextFragments {
@@ -746,19 +1135,25 @@ func createPrDetail(page string) http.HandlerFunc {
 						dels += frag.LinesDeleted
 					}
 
-					diffStr, err := parseText(web.Formatter, web.Theme, file.String())
-					if err != nil {
-						web.Logger.Error("cannot parse patch", "err", err)
-						w.WriteHeader(http.StatusUnprocessableEntity)
-						return
+					anchorPrefix := lineAnchorPrefix(patch.ID, fileIdx)
+					patchFile := &PatchFile{
+						File: file,
+						Adds: adds,
+						Dels: dels,
 					}
-
-					patchFiles = append(patchFiles, &PatchFile{
-						File:     file,
-						Adds:     adds,
-						Dels:     dels,
-						DiffText: template.HTML(diffStr),
-					})
+					if view == "split" {
+						patchFile.Split = splitDiff(file, anchorPrefix)
+					} else {
+						formatter := newDiffFormatter(anchorPrefix)
+						diffStr, err := parseText(formatter, web.Theme, file.String(), !rdOpts.NoWordDiff)
+						if err != nil {
+							web.Logger.Error("cannot parse patch", "err", err)
+							w.WriteHeader(http.StatusUnprocessableEntity)
+							return
+						}
+						patchFile.DiffText = template.HTML(diffStr)
+					}
+					patchFiles = append(patchFiles, patchFile)
 				}
 
 				timestamp := patch.AuthorDate.Format(web.Backend.Cfg.TimeFormat)
@@ -797,8 +1192,27 @@ func createPrDetail(page string) http.HandlerFunc {
 			return a.CreatedAt.Compare(b.CreatedAt)
 		})
 
+		comments, err := web.Pr.GetCommentsByPrID(pr.ID)
+		if err != nil {
+			web.Logger.Error("cannot get comments for pr", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		commentData := map[int64]*CommentData{}
+		for _, comment := range comments {
+			commentData[comment.ID] = &CommentData{
+				Comment: comment,
+				Html:    renderMarkdown(comment.Text),
+			}
+		}
+
 		logData := []EventLogData{}
 		for _, eventlog := range logs {
+			comment := commentData[eventlog.Data.CommentID]
+			// deleted comments leave their event behind
+			if eventlog.Event == "pr_commented" && comment == nil {
+				continue
+			}
 			user, _ := web.Pr.GetUserByID(eventlog.UserID)
 			pk, err := web.Backend.PubkeyToPublicKey(user.Pubkey)
 			if err != nil {
@@ -828,6 +1242,7 @@ func createPrDetail(page string) http.HandlerFunc {
 				FormattedPatchsetID: getFormattedPatchsetID(eventlog.PatchsetID.Int64),
 				Patchset:            logps,
 				RangeDiff:           rangeDiff,
+				Comment:             comment,
 				UserData: UserData{
 					UserID:    user.ID,
 					Name:      user.Name,
@@ -839,20 +1254,82 @@ func createPrDetail(page string) http.HandlerFunc {
 			})
 		}
 
-		repo, err := web.Pr.GetRepoByID(pr.RepoID)
+		repoOwner, err := web.Pr.GetUserByID(repo.UserID)
 		if err != nil {
 			web.Logger.Error("cannot get repo for pr", "err", err)
 			w.WriteHeader(http.StatusUnprocessableEntity)
 			return
 		}
 
-		repoOwner, err := web.Pr.GetUserByID(repo.UserID)
+		prLabels, err := web.Pr.GetLabelsByPrID(pr.ID)
 		if err != nil {
-			web.Logger.Error("cannot get repo for pr", "err", err)
-			w.WriteHeader(http.StatusUnprocessableEntity)
+			web.Logger.Error("cannot get labels for pr", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		reviewers, err := getReviewerNames(web.Pr, pr.ID)
+		if err != nil {
+			web.Logger.Error("cannot get reviewers for pr", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		deps, err := web.Pr.GetPatchRequestDependencies(pr.ID)
+		if err != nil {
+			web.Logger.Error("cannot get dependencies for pr", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		dependents, err := web.Pr.GetPatchRequestDependents(pr.ID)
+		if err != nil {
+			web.Logger.Error("cannot get dependents for pr", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		superseded, err := web.Pr.GetSupersededPatchRequests(pr.ID)
+		if err != nil {
+			web.Logger.Error("cannot get superseded prs", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var supersededBy *PrLinkData
+		if pr.SupersededBy.Valid {
+			next, err := web.Pr.GetPatchRequestByID(pr.SupersededBy.Int64)
+			if err != nil {
+				web.Logger.Error("cannot get superseding pr", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			supersededBy = &PrLinkData{ID: next.ID, Title: next.Name, Status: next.Status}
+		}
+
+		revs, err := web.Pr.GetPatchRequestRevisions(pr.ID)
+		if err != nil {
+			web.Logger.Error("cannot get revisions for pr", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		approvals, err := GetApprovalStatus(web.Pr, repo, pr.ID)
+		if err != nil {
+			web.Logger.Error("cannot get approvals for pr", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		approvalsData := ApprovalsData{ApprovalStatus: approvals}
+		for _, approval := range approvals.Approvals {
+			approvalsData.Users = append(approvalsData.Users, ApprovalUserData{
+				UserData: UserData{
+					UserID: approval.User.ID,
+					Name:   approval.User.Name,
+					Pubkey: approval.User.Pubkey,
+				},
+				Stale: approval.Stale,
+			})
+		}
+
 		repoNs := web.Backend.CreateRepoNs(repoOwner.Name, repo.Name)
 		url := fmt.Sprintf("/r/%s/%s", repoOwner.Name, repo.Name)
 		tab := "timeline"
@@ -867,13 +1344,21 @@ func createPrDetail(page string) http.HandlerFunc {
 				Url:  template.URL(url),
 				Text: repoNs,
 			},
-			Branch:       "main",
-			Patchset:     ps,
-			PatchsetData: selectedPatchsetData,
-			IsRangeDiff:  page == "rd",
-			Patches:      patchesData,
-			Patchsets:    patchsetsData,
-			Logs:         logData,
+			Branch:        repo.DefaultBranch,
+			Patchset:      ps,
+			PatchsetData:  selectedPatchsetData,
+			IsRangeDiff:   page == "rd",
+			RangeDiffOpts: rdOpts,
+			View:          view,
+			FileFilter:    fileFilter,
+			AllFilesUrl:   allFilesUrl,
+			WordDiffUrl:   wordDiffUrl,
+			DiffStat:      diffStat,
+			FileTree:      fileTree,
+			Approvals:     approvalsData,
+			Patches:       patchesData,
+			Patchsets:     patchsetsData,
+			Logs:          logData,
 			Pr: PrData{
 				ID: pr.ID,
 				UserData: UserData{
@@ -883,12 +1368,21 @@ func createPrDetail(page string) http.HandlerFunc {
 					Pubkey:    user.Pubkey,
 					CreatedAt: user.CreatedAt.Format(time.RFC3339),
 				},
-				Title:  pr.Name,
-				Date:   pr.CreatedAt.Format(web.Backend.Cfg.TimeFormat),
-				Status: pr.Status,
+				Title:        pr.Name,
+				Date:         pr.CreatedAt.Format(web.Backend.Cfg.TimeFormat),
+				Status:       pr.Status,
+				Labels:       getLabelData(repoOwner.Name, repo.Name, prLabels),
+				Body:         renderMarkdown(pr.Text),
+				Edited:       len(revs) > 0,
+				Reviewers:    reviewers,
+				DependsOn:    getPrLinkData(deps),
+				RequiredBy:   getPrLinkData(dependents),
+				SupersededBy: supersededBy,
+				Supersedes:   getPrLinkData(superseded),
 			},
 			MetaData: MetaData{
-				URL: web.Backend.Cfg.Url,
+				URL:  web.Backend.Cfg.Url,
+				Live: web.Live,
 			},
 		})
 		if err != nil {
@@ -897,6 +1391,116 @@ func createPrDetail(page string) http.HandlerFunc {
 	}
 }
 
+// rangeDiffSelectHandler sends the from/to selector on the patchsets tab to
+// the range-diff page.
+func revisionsHandler(w http.ResponseWriter, r *http.Request) {
+	prID, err := getPrID(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	web, err := getWebCtx(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	pr, err := web.Pr.GetPatchRequestByID(prID)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		web.Logger.Error("cannot get pr", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	repo, err := web.Pr.GetRepoByID(pr.RepoID)
+	if err != nil {
+		web.Logger.Error("cannot get repo for pr", "err", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	repoOwner, err := web.Pr.GetUserByID(repo.UserID)
+	if err != nil {
+		web.Logger.Error("cannot get repo owner for pr", "err", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	revs, err := web.Pr.GetPatchRequestRevisions(pr.ID)
+	if err != nil {
+		web.Logger.Error("cannot get revisions for pr", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// prs that were never edited only have their original
+	if len(revs) == 0 {
+		revs = append(revs, &PatchRequestRevision{
+			UserID:    pr.UserID,
+			Name:      pr.Name,
+			Text:      pr.Text,
+			CreatedAt: pr.CreatedAt,
+		})
+	}
+
+	revData := []RevisionData{}
+	for idx, rev := range revs {
+		user, err := web.Pr.GetUserByID(rev.UserID)
+		if err != nil {
+			web.Logger.Error("cannot get user for revision", "err", err)
+			continue
+		}
+		revData = append(revData, RevisionData{
+			UserData: UserData{
+				UserID: user.ID,
+				Name:   user.Name,
+				Pubkey: user.Pubkey,
+			},
+			ID:       rev.ID,
+			Title:    rev.Name,
+			Body:     renderMarkdown(rev.Text),
+			Date:     rev.CreatedAt.Format(web.Backend.Cfg.TimeFormat),
+			Original: idx == 0,
+		})
+	}
+	// newest first
+	slices.Reverse(revData)
+
+	w.Header().Set("content-type", "text/html")
+	err = revsTmpl.Execute(w, RevisionsData{
+		Repo: LinkData{
+			Url:  template.URL(fmt.Sprintf("/r/%s/%s", repoOwner.Name, repo.Name)),
+			Text: web.Backend.CreateRepoNs(repoOwner.Name, repo.Name),
+		},
+		Pr: PrData{
+			ID:     pr.ID,
+			Title:  pr.Name,
+			Status: pr.Status,
+		},
+		Revisions: revData,
+		MetaData: MetaData{
+			URL:  web.Backend.Cfg.Url,
+			Live: web.Live,
+		},
+	})
+	if err != nil {
+		web.Backend.Logger.Error("cannot execute template", "err", err)
+	}
+}
+
+func rangeDiffSelectHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fromID, ferr := getPatchsetID(query.Get("from"))
+	toID, terr := getPatchsetID(query.Get("to"))
+	if ferr != nil || terr != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/rd/%d...%d", fromID, toID), http.StatusSeeOther)
+}
+
 func toolHandlerGet(w http.ResponseWriter, r *http.Request) {
 	web, err := getWebCtx(r)
 	if err != nil {
@@ -906,7 +1510,8 @@ func toolHandlerGet(w http.ResponseWriter, r *http.Request) {
 
 	err = toolTmpl.Execute(w, ToolData{
 		MetaData: MetaData{
-			URL: web.Backend.Cfg.Url,
+			URL:  web.Backend.Cfg.Url,
+			Live: web.Live,
 		},
 		Patchset: &Patchset{
 			ID: 0,
@@ -951,11 +1556,13 @@ func toolHandlerPost(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
-	rangeDiff := RangeDiff(prevPatchset, nextPatchset)
+	rdOpts := getRangeDiffOpts(r, RangeDiffOpts{})
+	rangeDiff, _ := RangeDiffWithOpts(prevPatchset, nextPatchset, rdOpts)
 
 	err = toolTmpl.Execute(w, ToolData{
 		MetaData: MetaData{
-			URL: web.Backend.Cfg.Url,
+			URL:  web.Backend.Cfg.Url,
+			Live: web.Live,
 		},
 		Patchset: &Patchset{
 			ID: 0,
@@ -963,6 +1570,7 @@ func toolHandlerPost(w http.ResponseWriter, r *http.Request) {
 		PatchsetData: &PatchsetData{
 			RangeDiff: rangeDiff,
 		},
+		RangeDiffOpts: rdOpts,
 	})
 	if err != nil {
 		web.Backend.Logger.Error("cannot execute template", "err", err)
@@ -1009,20 +1617,28 @@ func rssHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		eventLogs, err = web.Pr.GetEventLogsByUserID(user.ID)
-	} else if username != "" {
+	} else if repoName != "" {
 		user, perr := web.Pr.GetUserByName(username)
 		if perr != nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		eventLogs, err = web.Pr.GetEventLogsByUserID(user.ID)
-	} else if repoName != "" {
+		repo, perr := web.Pr.GetRepoByName(user, repoName)
+		if perr != nil {
+			if redirectRepo(web, w, r, user, repoName) {
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		eventLogs, err = web.Pr.GetEventLogsByRepoID(repo.ID)
+	} else if username != "" {
 		user, perr := web.Pr.GetUserByName(username)
 		if perr != nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		eventLogs, err = web.Pr.GetEventLogsByRepoName(user, repoName)
+		eventLogs, err = web.Pr.GetEventLogsByUserID(user.ID)
 	} else {
 		eventLogs, err = web.Pr.GetEventLogs()
 	}
@@ -1033,8 +1649,30 @@ func rssHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	labelFilters := r.URL.Query()["label"]
+	prLabels := map[int64][]*Label{}
+
 	var feedItems []*feeds.Item
 	for _, eventLog := range eventLogs {
+		if len(labelFilters) > 0 {
+			if !eventLog.PatchRequestID.Valid {
+				continue
+			}
+			prID := eventLog.PatchRequestID.Int64
+			labels, ok := prLabels[prID]
+			if !ok {
+				labels, err = web.Pr.GetLabelsByPrID(prID)
+				if err != nil {
+					web.Logger.Error("labels not found for event log", "id", eventLog.ID, "err", err)
+					continue
+				}
+				prLabels[prID] = labels
+			}
+			if !hasLabels(labels, labelFilters) {
+				continue
+			}
+		}
+
 		user, err := web.Pr.GetUserByID(eventLog.UserID)
 		if err != nil {
 			web.Logger.Error("user not found for event log", "id", eventLog.ID, "err", err)
@@ -1050,6 +1688,16 @@ func rssHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if eventLog.PatchRequestID.Int64 == 0 {
+			item, err := repoEventFeedItem(web, eventLog, user, repo)
+			if err != nil {
+				web.Logger.Error("repo owner not found for event log", "id", eventLog.ID, "err", err)
+				continue
+			}
+			feedItems = append(feedItems, item)
+			continue
+		}
+
 		realUrl := fmt.Sprintf("%s/prs/%d", web.Backend.Cfg.Url, eventLog.PatchRequestID.Int64)
 		content := fmt.Sprintf(
 			"<div><div>RepoID: %s</div><div>PatchRequestID: %d</div><div>Event: %s</div><div>Created: %s</div><div>Data: %s</div></div>",
@@ -1098,6 +1746,308 @@ func rssHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// repoEventFeedItem builds a feed item for events about the repo itself,
+// like renames and transfers, that link to the repo instead of a PR.
+func repoEventFeedItem(web *WebCtx, eventLog *EventLog, user *User, repo *Repo) (*feeds.Item, error) {
+	owner, err := web.Pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return nil, err
+	}
+	repoNs := web.Backend.CreateRepoNs(owner.Name, repo.Name)
+	title := fmt.Sprintf("%s for repo %s", eventLog.Event, repoNs)
+	content := fmt.Sprintf(
+		"<div><div>RepoID: %s</div><div>Event: %s</div><div>Created: %s</div><div>Data: %s</div></div>",
+		repoNs,
+		eventLog.Event,
+		eventLog.CreatedAt.Format(time.RFC3339Nano),
+		eventLog.Data,
+	)
+	return &feeds.Item{
+		Id:          fmt.Sprintf("%d", eventLog.ID),
+		Title:       title,
+		Link:        &feeds.Link{Href: fmt.Sprintf("%s/r/%s/%s", web.Backend.Cfg.Url, owner.Name, repo.Name)},
+		Content:     content,
+		Created:     eventLog.CreatedAt,
+		Description: title,
+		Author:      &feeds.Author{Name: user.Name},
+	}, nil
+}
+
+// redirectRepo sends requests for a repo that was renamed or transferred
+// to its current location.  It reports whether a redirect was written.
+func redirectRepo(web *WebCtx, w http.ResponseWriter, r *http.Request, user *User, repoName string) bool {
+	repo, err := web.Pr.GetRepoRedirect(user, repoName)
+	if err != nil {
+		return false
+	}
+	owner, err := web.Pr.GetUserByID(repo.UserID)
+	if err != nil {
+		return false
+	}
+	rest := strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/r/%s/%s", user.Name, repoName))
+	url := fmt.Sprintf("/r/%s/%s%s", owner.Name, repo.Name, rest)
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, url, http.StatusMovedPermanently)
+	return true
+}
+
+// archiveHandler serves the threaded mbox archive of a repo.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	userName := r.PathValue("user")
+	repoName := r.PathValue("repo")
+
+	web, err := getWebCtx(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := web.Pr.GetUserByName(userName)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	repo, err := web.Pr.GetRepoByName(user, repoName)
+	if err != nil {
+		if redirectRepo(web, w, r, user, repoName) {
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/mbox; charset=utf-8")
+	err = WriteRepoArchive(w, web.Backend, web.Pr, repo)
+	if err != nil {
+		web.Logger.Error("could not write archive", "err", err)
+	}
+}
+
+// withRawFormats serves the plain text variants of a page, like
+// `/prs/1.patch`, since a route wildcard cannot be followed by an extension.
+func withRawFormats(page string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		ext := filepath.Ext(id)
+		switch page + ext {
+		case "pr.patch", "pr.mbox", "ps.patch", "ps.mbox", "patch.patch", "rd.txt":
+			rawHandler(w, r, page, strings.TrimSuffix(id, ext), ext)
+		default:
+			handler(w, r)
+		}
+	}
+}
+
+func writeRaw(w http.ResponseWriter, contentType, disposition, fname string, write func(io.Writer) error) error {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fname}))
+	buf := &bytes.Buffer{}
+	err := write(buf)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func rawPatches(w http.ResponseWriter, fname string, patches []*Patch) error {
+	return writeRaw(w, "text/plain; charset=utf-8", "attachment", fname, func(out io.Writer) error {
+		writePatches(out, patches)
+		return nil
+	})
+}
+
+func rawHandler(w http.ResponseWriter, r *http.Request, page, id, ext string) {
+	web, err := getWebCtx(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch page {
+	case "pr":
+		prID, perr := getPrID(id)
+		if perr != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		prq, perr := web.Pr.GetPatchRequestByID(prID)
+		if perr != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fname := fmt.Sprintf("pr-%d%s", prq.ID, ext)
+		if ext == ".mbox" {
+			err = writeRaw(w, "application/mbox", "attachment", fname, func(out io.Writer) error {
+				return WritePatchRequestMbox(out, web.Backend, web.Pr, prq)
+			})
+			break
+		}
+		patchset, perr := web.Pr.GetLatestPatchsetByPrID(prq.ID)
+		if perr != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		patches, perr := web.Pr.GetPatchesByPatchsetID(patchset.ID)
+		if perr != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = rawPatches(w, fname, patches)
+	case "ps":
+		psID, perr := getPatchsetID(id)
+		if perr != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		patchset, perr := web.Pr.GetPatchsetByID(psID)
+		if perr != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fname := fmt.Sprintf("ps-%d%s", patchset.ID, ext)
+		if ext == ".mbox" {
+			err = writeRaw(w, "application/mbox", "attachment", fname, func(out io.Writer) error {
+				return WritePatchsetMbox(out, web.Backend, web.Pr, patchset)
+			})
+			break
+		}
+		patches, perr := web.Pr.GetPatchesByPatchsetID(patchset.ID)
+		if perr != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = rawPatches(w, fname, patches)
+	case "patch":
+		patchID, perr := strconv.ParseInt(id, 10, 64)
+		if perr != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		patch, perr := web.Pr.GetPatchByID(patchID)
+		if perr != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		err = rawPatches(w, fmt.Sprintf("patch-%d.patch", patch.ID), []*Patch{patch})
+	case "rd":
+		fromID, toID, perr := parsePatchsetRange(id)
+		if perr != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		from, ferr := web.Pr.GetPatchsetByID(fromID)
+		to, terr := web.Pr.GetPatchsetByID(toID)
+		if ferr != nil || terr != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rdOpts, derr := getPatchsetRangeDiffOpts(web.Pr, to)
+		if derr != nil {
+			web.Logger.Error("could not get range-diff options", "err", derr)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		diffs, derr := web.Pr.DiffPatchsets(from, to, getRangeDiffOpts(r, rdOpts))
+		if derr != nil {
+			web.Logger.Error("could not diff patchsets", "err", derr)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fname := fmt.Sprintf("ps-%d..ps-%d.txt", from.ID, to.ID)
+		err = writeRaw(w, "text/plain; charset=utf-8", "inline", fname, func(out io.Writer) error {
+			_, err := io.WriteString(out, RangeDiffToStr(diffs))
+			return err
+		})
+	}
+
+	if err != nil {
+		web.Logger.Error("could not write raw page", "page", page, "id", id, "err", err)
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "could not generate "+ext, http.StatusInternalServerError)
+	}
+}
+
+// eventsHandler streams event logs as Server-Sent Events.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	web, err := getWebCtx(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	filter := EventFilter{}
+	query := r.URL.Query()
+	if id := query.Get("pr"); id != "" {
+		prID, err := getPrID(id)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		filter.PrID = prID
+	}
+	if repoNs := query.Get("repo"); repoNs != "" {
+		userName, repoName := web.Backend.SplitRepoNs(repoNs)
+		user, err := web.Pr.GetUserByName(userName)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		repo, err := web.Pr.GetRepoByName(user, repoName)
+		if err != nil {
+			repo, err = web.Pr.GetRepoRedirect(user, repoName)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		filter.RepoID = repo.ID
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	sub := web.Backend.Cfg.Bus.Subscribe(filter)
+	defer sub.Close()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case eventLog, ok := <-sub.C:
+			if !ok {
+				web.Logger.Info("event stream closed", "err", sub.Err())
+				return
+			}
+			var data []byte
+			data, err = json.Marshal(NewEventMessage(eventLog))
+			if err != nil {
+				web.Logger.Error("cannot marshal event", "err", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", eventLog.ID, data)
+		}
+		if err != nil {
+			return
+		}
+		if err = rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
 func chromaStyleHandler(w http.ResponseWriter, r *http.Request) {
 	web, err := getWebCtx(r)
 	if err != nil {
@@ -1194,44 +2144,90 @@ func GitWebServer(cfg *GitCfg) http.Handler {
 	prCmd := &PrCmd{
 		Backend: be,
 	}
-	formatter := formatterHtml.New(
+	return NewWebMux(NewWebCtx(prCmd))
+}
+
+// setFileTreeUrls links every file in the tree to the patchset view filtered
+// down to that file, keeping the other query params.
+func setFileTreeUrls(nodes []*FileTreeNode, query url.Values) {
+	for _, node := range nodes {
+		if node.File != nil {
+			q := maps.Clone(query)
+			q.Set("file", node.File.Path)
+			node.Url = "?" + q.Encode()
+			node.Selected = query.Get("file") == node.File.Path
+		}
+		setFileTreeUrls(node.Children, query)
+	}
+}
+
+// fileMatches reports whether a file in a patch is the diffstat entry,
+// either by its path or by the name it had before a rename.
+func fileMatches(file *gitdiff.File, entry *DiffStatFile) bool {
+	names := []string{entry.Path}
+	if entry.OldPath != "" {
+		names = append(names, entry.OldPath)
+	}
+	return slices.Contains(names, file.NewName) || slices.Contains(names, file.OldName)
+}
+
+// newDiffFormatter renders diffs with line numbers linked by anchors that
+// start with the prefix.
+func newDiffFormatter(anchorPrefix string) *formatterHtml.Formatter {
+	return formatterHtml.New(
 		formatterHtml.WithLineNumbers(true),
 		formatterHtml.LineNumbersInTable(true),
 		formatterHtml.WithClasses(true),
-		formatterHtml.WithLinkableLineNumbers(true, "gitpr"),
+		formatterHtml.WithLinkableLineNumbers(true, anchorPrefix),
 	)
-	web := &WebCtx{
+}
+
+func NewWebCtx(prCmd *PrCmd) *WebCtx {
+	be := prCmd.Backend
+	return &WebCtx{
 		Pr:        prCmd,
 		Backend:   be,
-		Logger:    cfg.Logger,
-		Formatter: formatter,
-		Theme:     styles.Get(cfg.Theme),
+		Logger:    be.Logger,
+		Formatter: newDiffFormatter("gitpr"),
+		Theme:     styles.Get(be.Cfg.Theme),
+		Live:      true,
 	}
+}
 
+// NewWebMux registers every web route.  It is shared by the web server and
+// the static site builder.
+func NewWebMux(web *WebCtx) *http.ServeMux {
 	ctx := context.Background()
 	ctx = setWebCtx(ctx, web)
 
 	// ensure legacy router is disabled
 	// GODEBUG=httpmuxgo121=0
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /prs/{id}", ctxMdw(ctx, createPrDetail("pr")))
+	mux.HandleFunc("GET /prs/{id}", ctxMdw(ctx, withRawFormats("pr", createPrDetail("pr"))))
 	mux.HandleFunc("GET /prs/{id}/rss", ctxMdw(ctx, rssHandler))
-	mux.HandleFunc("GET /ps/{id}", ctxMdw(ctx, createPrDetail("ps")))
-	mux.HandleFunc("GET /rd/{id}", ctxMdw(ctx, createPrDetail("rd")))
+	mux.HandleFunc("GET /prs/{id}/revisions", ctxMdw(ctx, revisionsHandler))
+	mux.HandleFunc("GET /ps/{id}", ctxMdw(ctx, withRawFormats("ps", createPrDetail("ps"))))
+	mux.HandleFunc("GET /rd/{id}", ctxMdw(ctx, withRawFormats("rd", createPrDetail("rd"))))
+	mux.HandleFunc("GET /rd", rangeDiffSelectHandler)
+	mux.HandleFunc("GET /patch/{id}", ctxMdw(ctx, withRawFormats("patch", http.NotFound)))
 	mux.HandleFunc("GET /r/{user}/{repo}/rss", ctxMdw(ctx, rssHandler))
+	mux.HandleFunc("GET /r/{user}/{repo}/archive.mbox", ctxMdw(ctx, archiveHandler))
+	mux.HandleFunc("GET /r/{user}/queue", ctxMdw(ctx, queueHandler))
 	mux.HandleFunc("GET /r/{user}/{repo}", ctxMdw(ctx, repoDetailHandler))
 	mux.HandleFunc("GET /r/{user}", ctxMdw(ctx, userDetailHandler))
 	mux.HandleFunc("GET /rss/{user}", ctxMdw(ctx, rssHandler))
 	mux.HandleFunc("GET /rss", ctxMdw(ctx, rssHandler))
+	mux.HandleFunc("GET /events", ctxMdw(ctx, eventsHandler))
 	mux.HandleFunc("GET /tool", ctxMdw(ctx, toolHandlerGet))
 	mux.HandleFunc("POST /tool", ctxMdw(ctx, toolHandlerPost))
 	mux.HandleFunc("GET /", ctxMdw(ctx, indexHandler))
 	mux.HandleFunc("GET /syntax.css", ctxMdw(ctx, chromaStyleHandler))
+	registerApiRoutes(ctx, mux)
 	embedFS, err := getEmbedFS(embedStaticFS, "static")
 	if err != nil {
 		panic(err)
 	}
-	userFS := getUserDefinedFS(cfg.DataDir, "static")
+	userFS := getUserDefinedFS(web.Backend.Cfg.DataDir, "static")
 
 	mux.HandleFunc("GET /static/{file}", ctxMdw(ctx, serveFile(userFS, embedFS)))
 	return mux
@@ -0,0 +1,735 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func webGet(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRawEndpoints(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+
+	first, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pr.SubmitPatchset(prq.ID, owner.ID, OpReview, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := pr.GetPatchesByPatchsetID(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path        string
+		contentType string
+		disposition string
+		contains    string
+	}{
+		{
+			path:        fmt.Sprintf("/prs/%d.patch", prq.ID),
+			contentType: "text/plain; charset=utf-8",
+			disposition: fmt.Sprintf(`attachment; filename=pr-%d.patch`, prq.ID),
+			contains:    "Subject: [PATCH] feat: lets build an rnn",
+		},
+		{
+			path:        fmt.Sprintf("/prs/%d.mbox", prq.ID),
+			contentType: "application/mbox",
+			disposition: fmt.Sprintf(`attachment; filename=pr-%d.mbox`, prq.ID),
+			contains:    "[PATCH v2 1/1] feat: lets build an rnn",
+		},
+		{
+			path:        fmt.Sprintf("/ps/%d.patch", first.ID),
+			contentType: "text/plain; charset=utf-8",
+			disposition: fmt.Sprintf(`attachment; filename=ps-%d.patch`, first.ID),
+			contains:    "diff --git a/README.md b/README.md",
+		},
+		{
+			path:        fmt.Sprintf("/ps/%d.mbox", second.ID),
+			contentType: "application/mbox",
+			disposition: fmt.Sprintf(`attachment; filename=ps-%d.mbox`, second.ID),
+			contains:    fmt.Sprintf("In-Reply-To: <pr-%d@pr.test>", prq.ID),
+		},
+		{
+			path:        fmt.Sprintf("/patch/%d.patch", patches[0].ID),
+			contentType: "text/plain; charset=utf-8",
+			disposition: fmt.Sprintf(`attachment; filename=patch-%d.patch`, patches[0].ID),
+			contains:    "Subject: [PATCH] feat: lets build an rnn",
+		},
+		{
+			path:        fmt.Sprintf("/rd/ps-%d..ps-%d.txt", first.ID, second.ID),
+			contentType: "text/plain; charset=utf-8",
+			disposition: fmt.Sprintf(`inline; filename=ps-%d..ps-%d.txt`, first.ID, second.ID),
+			contains:    "feat: lets build an rnn",
+		},
+	}
+
+	for _, tt := range tests {
+		rec := webGet(t, handler, tt.path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: wrong status: %d", tt.path, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Fatalf("%s: wrong content type: %s", tt.path, ct)
+		}
+		if cd := rec.Header().Get("Content-Disposition"); cd != tt.disposition {
+			t.Fatalf("%s: wrong content disposition: %s", tt.path, cd)
+		}
+		if !strings.Contains(rec.Body.String(), tt.contains) {
+			t.Fatalf("%s: expected %q in body:\n%s", tt.path, tt.contains, rec.Body.String())
+		}
+	}
+
+	// the raw patches can be applied with `git am`
+	rec := webGet(t, handler, fmt.Sprintf("/prs/%d.patch", prq.ID))
+	parsed, err := ParsePatchset(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 || parsed[0].ContentSha != patches[0].ContentSha {
+		t.Fatalf("expected raw patch to match stored patch, found: %+v", parsed)
+	}
+
+	for _, path := range []string{"/prs/999.patch", "/ps/999.mbox", "/patch/999.patch", "/prs/999"} {
+		if rec := webGet(t, handler, path); rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected not found, found: %d", path, rec.Code)
+		}
+	}
+}
+
+func TestRangeDiffBetweenPatchsets(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+
+	first, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		_, err = pr.SubmitPatchset(prq.ID, owner.ID, OpNormal, strings.NewReader(singlePatch(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	last, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := webGet(t, handler, fmt.Sprintf("/rd/%d...%d", first.ID, last.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status: %d", rec.Code)
+	}
+	heading := fmt.Sprintf("ps-%d...ps-%d", first.ID, last.ID)
+	if !strings.Contains(rec.Body.String(), heading) {
+		t.Fatalf("expected range-diff heading %s", heading)
+	}
+	if !strings.Contains(rec.Body.String(), `action="/rd"`) {
+		t.Fatal("expected patchset range selector")
+	}
+
+	rec = webGet(t, handler, fmt.Sprintf("/rd?from=ps-%d&to=%d", first.ID, last.ID))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != fmt.Sprintf("/rd/%d...%d", first.ID, last.ID) {
+		t.Fatalf("expected redirect to range-diff, found: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// both patchsets must belong to the same pr
+	other, err := pr.SubmitPatchRequest(prq.RepoID, owner.ID, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPs, err := pr.GetLatestPatchsetByPrID(other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = webGet(t, handler, fmt.Sprintf("/rd/%d...%d", otherPs.ID, last.ID))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected not found, found: %d", rec.Code)
+	}
+}
+
+func TestRangeDiffCollapsesRebasedOnly(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+
+	v1, err := os.ReadFile("fixtures/hunk_header_v1.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := os.ReadFile("fixtures/hunk_header_v2.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, patchset := range [][]byte{v1, v2} {
+		_, err = pr.SubmitPatchset(prq.ID, owner.ID, OpNormal, bytes.NewReader(patchset))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	last, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := webGet(t, handler, fmt.Sprintf("/rd/%d", last.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status: %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "Patch rebased only") {
+		t.Fatal("expected entry to be classified as rebased only")
+	}
+	if !strings.Contains(body, `<details class="details-min" id="1-1">`) {
+		t.Fatal("expected rebased only entry to be collapsed")
+	}
+}
+
+func TestRangeDiffRepoDefaults(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+
+	_, err := pr.SubmitPatchset(prq.ID, owner.ID, OpNormal, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Set("range_diff_normalize", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pr.UpdateRepo(repo, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	normalized := `<option value="true" selected>show +/- lines only</option>`
+	rec := webGet(t, handler, fmt.Sprintf("/rd/%d", last.ID))
+	if !strings.Contains(rec.Body.String(), normalized) {
+		t.Fatal("expected repo default to normalize range-diff")
+	}
+	rec = webGet(t, handler, fmt.Sprintf("/rd/%d?normalize=false", last.ID))
+	if strings.Contains(rec.Body.String(), normalized) {
+		t.Fatal("expected query param to override repo default")
+	}
+	rec = webGet(t, handler, fmt.Sprintf("/rd/%d?dual_color=false", last.ID))
+	if !strings.Contains(rec.Body.String(), `<option value="false" selected>plain</option>`) {
+		t.Fatal("expected plain range-diff")
+	}
+}
+
+func TestRepoMetadata(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.DefaultBranch != "main" {
+		t.Fatalf("expected main as the default branch, found: %s", repo.DefaultBranch)
+	}
+	for key, value := range map[string]string{
+		"description":    "a tiny rnn",
+		"homepage":       "https://pico.sh",
+		"clone_url":      "git@github.com:picosh/test.git",
+		"default_branch": "trunk",
+		"contributing":   "Run **make test** first.\n\n<script>alert(1)</script>",
+		"pr_template":    "## Why\n\n## How",
+	} {
+		if err := repo.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pr.UpdateRepo(repo, owner.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	body := webGet(t, handler, "/r/alice/test").Body.String()
+	for _, expected := range []string{
+		"a tiny rnn",
+		`href="https://pico.sh"`,
+		"git clone git@github.com:picosh/test.git",
+		"git format-patch trunk --stdout",
+		"<strong>make test</strong>",
+		"## Why",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q on the repo page", expected)
+		}
+	}
+	if strings.Contains(body, "<script>alert") {
+		t.Fatal("expected the contributing guide to be sanitized")
+	}
+	body = webGet(t, handler, fmt.Sprintf("/prs/%d", prq.ID)).Body.String()
+	if !strings.Contains(body, "git format-patch trunk --stdout") {
+		t.Fatal("expected the default branch in the pr help")
+	}
+}
+
+func TestSplitView(t *testing.T) {
+	pr, _, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+
+	ps, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := pr.GetPatchesByPatchsetID(ps.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchID := patches[0].ID
+
+	rec := webGet(t, handler, fmt.Sprintf("/ps/%d?view=split", ps.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status: %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `class="split-diff chroma"`) {
+		t.Fatal("expected split diff table")
+	}
+	anchor := fmt.Sprintf(`id="%s`, lineAnchorPrefix(patchID, 0))
+	if !strings.Contains(body, anchor) {
+		t.Fatalf("expected line anchor %s", anchor)
+	}
+
+	rec = webGet(t, handler, fmt.Sprintf("/ps/%d", ps.ID))
+	body = rec.Body.String()
+	if strings.Contains(body, `class="split-diff chroma"`) {
+		t.Fatal("expected unified diff by default")
+	}
+	if !strings.Contains(body, anchor) {
+		t.Fatalf("expected unified line anchor %s", anchor)
+	}
+}
+
+func TestFileFilter(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+
+	by, err := os.ReadFile("fixtures/a_c_renamed_file.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pr.SubmitPatchset(prq.ID, owner.ID, OpNormal, bytes.NewReader(by))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := pr.GetLatestPatchsetByPrID(prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := webGet(t, handler, fmt.Sprintf("/ps/%d", ps.ID))
+	body := rec.Body.String()
+	for _, expected := range []string{"3 file(s) changed", `href="?file=train.py"`, "Here is some more readme information"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q in patchset page", expected)
+		}
+	}
+
+	rec = webGet(t, handler, fmt.Sprintf("/ps/%d?file=train.py", ps.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status: %d", rec.Code)
+	}
+	body = rec.Body.String()
+	if !strings.Contains(body, "torch.rand") {
+		t.Fatal("expected patch touching the file")
+	}
+	if strings.Contains(body, "Here is some more readme information") {
+		t.Fatal("expected patches not touching the file to be hidden")
+	}
+	if strings.Contains(body, "torch==2.3.1") {
+		t.Fatal("expected other files in the patch to be hidden")
+	}
+	// toggling word highlighting keeps the file filter
+	if !strings.Contains(body, `href="?file=train.py&amp;word_diff=false"`) {
+		t.Fatal("expected word diff toggle to keep the query")
+	}
+	body = webGet(t, handler, fmt.Sprintf("/ps/%d?file=train.py&word_diff=false", ps.ID)).Body.String()
+	if !strings.Contains(body, `href="?file=train.py&amp;word_diff=true"`) {
+		t.Fatal("expected word diff toggle to keep the query")
+	}
+
+	rec = webGet(t, handler, fmt.Sprintf("/ps/%d?file=nope.go", ps.ID))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown file, got %d", rec.Code)
+	}
+}
+
+func TestLabelFilters(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+
+	repo, err := pr.GetRepoByID(prq.RepoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pr.CreateLabel(repo.ID, owner.ID, "bug", "#d73a4a", "something is broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pr.CreateLabel(repo.ID, owner.ID, "docs", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlabeled, err := pr.SubmitPatchRequest(repo.ID, owner.ID, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pr.UpdatePatchRequestName(unlabeled.ID, owner.ID, "unlabeled pr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pr.UpdatePatchRequestLabels(prq.ID, owner.ID, []string{"bug"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := webGet(t, handler, "/r/alice/test").Body.String()
+	for _, expected := range []string{`href="/r/alice/test?label=bug"`, `--label-color: #d73a4a`, "unlabeled pr"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q in repo page", expected)
+		}
+	}
+
+	body = webGet(t, handler, "/r/alice/test?label=bug").Body.String()
+	if strings.Contains(body, "unlabeled pr") {
+		t.Fatal("expected label filter to hide prs without the label")
+	}
+	body = webGet(t, handler, "/r/alice/test?label=docs").Body.String()
+	if strings.Contains(body, fmt.Sprintf(`href="/prs/%d"`, prq.ID)) {
+		t.Fatal("expected label filter to hide prs without the label")
+	}
+
+	body = webGet(t, handler, fmt.Sprintf("/prs/%d", prq.ID)).Body.String()
+	if !strings.Contains(body, "added labels <code>bug</code>") {
+		t.Fatal("expected label event in timeline")
+	}
+
+	body = webGet(t, handler, "/r/alice/test/rss?label=bug").Body.String()
+	if !strings.Contains(body, "pr_labels_changed") || strings.Contains(body, "unlabeled pr") {
+		t.Fatal("expected rss to only contain events of labeled prs")
+	}
+}
+
+func TestReviewQueue(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+	bob := createTestUser(t, pr, "bob")
+	createTestUser(t, pr, "carol")
+
+	err := pr.UpdatePatchRequestName(prq.ID, owner.ID, "needs eyes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RequestReview(pr.Backend, pr, owner, prq.ID, []string{"bob", "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := webGet(t, handler, "/r/bob/queue").Body.String()
+	if !strings.Contains(body, "needs eyes") {
+		t.Fatal("expected pr in bob's queue")
+	}
+	body = webGet(t, handler, "/prs/"+fmt.Sprint(prq.ID)).Body.String()
+	if !strings.Contains(body, `href="/r/carol/queue"`) {
+		t.Fatal("expected requested reviewers in pr header")
+	}
+
+	_, err = pr.SubmitPatchset(prq.ID, bob.ID, OpReview, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = webGet(t, handler, "/r/bob/queue").Body.String()
+	if strings.Contains(body, "needs eyes") {
+		t.Fatal("expected reviewed pr to leave the queue")
+	}
+
+	// a repo named queue keeps its url
+	_, err = pr.CreateRepo(owner, "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = webGet(t, handler, "/r/alice/queue").Body.String()
+	if strings.Contains(body, "awaiting review") {
+		t.Fatal("expected repo page for a repo named queue")
+	}
+}
+
+func TestApprovalHeader(t *testing.T) {
+	pr, owner, _ := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+	repo, err := pr.GetRepoByName(owner, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := createTestUser(t, pr, "bob")
+	prq, err := pr.SubmitPatchRequest(repo.ID, bob.ID, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.RequiredApprovals = 1
+	err = pr.UpdateRepo(repo, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := webGet(t, handler, fmt.Sprintf("/prs/%d", prq.ID)).Body.String()
+	if !strings.Contains(body, `<code class="pill-review">0/1</code>`) {
+		t.Fatal("expected missing approvals in pr header")
+	}
+	_, err = ApprovePatchRequest(pr.Backend, pr, owner, prq.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = webGet(t, handler, fmt.Sprintf("/prs/%d", prq.ID)).Body.String()
+	if !strings.Contains(body, `<code class="pill-success">1/1</code>`) {
+		t.Fatal("expected approval state in pr header")
+	}
+	_, err = pr.SubmitPatchset(prq.ID, bob.ID, OpNormal, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = webGet(t, handler, fmt.Sprintf("/prs/%d", prq.ID)).Body.String()
+	if !strings.Contains(body, `<code class="pill-review">0/1</code>`) || !strings.Contains(body, "(stale)") {
+		t.Fatal("expected stale approvals in pr header")
+	}
+}
+
+func TestDependencyHeader(t *testing.T) {
+	pr, _, first := setupTestRepo(t)
+	bob := createTestUser(t, pr, "bob")
+	second, err := CreatePatchRequest(pr.Backend, pr, bob, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := CreatePatchRequest(pr.Backend, pr, bob, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ChangePatchRequestDependency(pr.Backend, pr, bob, second.ID, first.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ChangePatchRequestDependency(pr.Backend, pr, bob, third.ID, second.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := webGet(t, NewWebMux(NewWebCtx(pr)), fmt.Sprintf("/prs/%d", second.ID)).Body.String()
+	for _, link := range []string{"depends on", "required by", fmt.Sprintf(`href="/prs/%d"`, first.ID), fmt.Sprintf(`href="/prs/%d"`, third.ID)} {
+		if !strings.Contains(body, link) {
+			t.Fatalf("expected %q in pr header", link)
+		}
+	}
+}
+
+func TestCommentTimeline(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+	bob := createTestUser(t, pr, "bob")
+
+	question, err := CreateComment(pr.Backend, pr, bob, prq.ID, 0, "does this handle **unicode**?\n<script>alert(1)</script>\n[x](javascript:alert(1))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := CreateComment(pr.Backend, pr, owner, prq.ID, question.ID, "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = EditComment(pr.Backend, pr, owner, answer.ID, "yes, see `utf8.go`")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := webGet(t, handler, fmt.Sprintf("/prs/%d", prq.ID)).Body.String()
+	for _, expected := range []string{"<strong>unicode</strong>", "<code>utf8.go</code>", "(edited)", fmt.Sprintf(`href="#comment-%d"`, question.ID)} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q in timeline", expected)
+		}
+	}
+	for _, unexpected := range []string{"<script>alert", "javascript:"} {
+		if strings.Contains(body, unexpected) {
+			t.Fatalf("expected %q to be sanitized", unexpected)
+		}
+	}
+
+	_, err = DeleteComment(pr.Backend, pr, bob, question.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = webGet(t, handler, fmt.Sprintf("/prs/%d", prq.ID)).Body.String()
+	if strings.Contains(body, "unicode") {
+		t.Fatal("expected the deleted comment to leave the timeline")
+	}
+}
+
+func TestEditBodyPages(t *testing.T) {
+	pr, owner, prq := setupTestRepo(t)
+	mux := NewWebMux(NewWebCtx(pr))
+	_, err := EditPatchRequestBody(pr.Backend, pr, owner, prq.ID, "Fixes **unicode**\n\n<script>alert(1)</script>\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = EditPatchRequest(pr.Backend, pr, owner, prq.ID, "unicode fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := webGet(t, mux, fmt.Sprintf("/prs/%d", prq.ID)).Body.String()
+	for _, expected := range []string{"<strong>unicode</strong>", fmt.Sprintf(`href="/prs/%d/revisions">edited</a>`, prq.ID)} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q on the pr page", expected)
+		}
+	}
+	if strings.Contains(body, "<script>alert") {
+		t.Fatal("expected the description to be sanitized")
+	}
+	body = webGet(t, mux, fmt.Sprintf("/prs/%d/revisions", prq.ID)).Body.String()
+	if !strings.Contains(body, "unicode fix") || !strings.Contains(body, "opened pr") {
+		t.Fatal("expected every revision on the revisions page")
+	}
+}
+
+func TestSupersededHeader(t *testing.T) {
+	pr, owner, first := setupTestRepo(t)
+	second, err := CreatePatchRequest(pr.Backend, pr, owner, "alice/test", false, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = SupersedePatchRequest(pr.Backend, pr, owner, first.ID, second.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := webGet(t, NewWebMux(NewWebCtx(pr)), fmt.Sprintf("/prs/%d", first.ID)).Body.String()
+	if !strings.Contains(body, "superseded by") || !strings.Contains(body, fmt.Sprintf(`href="/prs/%d"`, second.ID)) {
+		t.Fatal("expected a link to the new pr")
+	}
+}
+
+func TestRepoRedirects(t *testing.T) {
+	pr, owner, _ := setupTestRepo(t)
+	mux := NewWebMux(NewWebCtx(pr))
+	bob := createTestUser(t, pr, "bob")
+
+	_, err := RenameRepo(pr.Backend, pr, owner, "alice/test", "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{
+		"/r/alice/test":                 "/r/alice/renamed",
+		"/r/alice/test/rss?label=bug":   "/r/alice/renamed/rss?label=bug",
+		"/r/alice/test/archive.mbox":    "/r/alice/renamed/archive.mbox",
+		"/r/alice/renamed/rss":          "",
+		"/r/alice/renamed/archive.mbox": "",
+	} {
+		rec := webGet(t, mux, path)
+		if expected == "" {
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: expected the repo to be served, found: %d", path, rec.Code)
+			}
+			continue
+		}
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != expected {
+			t.Fatalf("%s: expected a redirect to %s, found: %d %s", path, expected, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+
+	_, err = TransferRepo(pr.Backend, pr, owner, "alice/renamed", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = AcceptRepoTransfer(pr.Backend, pr, bob, "alice/renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := webGet(t, mux, "/r/alice/test")
+	if rec.Header().Get("Location") != "/r/bob/renamed" {
+		t.Fatalf("expected old urls to follow the repo, found: %s", rec.Header().Get("Location"))
+	}
+	feed := webGet(t, mux, "/r/bob/renamed/rss").Body.String()
+	if !strings.Contains(feed, "repo_transferred for repo bob/renamed") {
+		t.Fatal("expected repo events in the repo feed")
+	}
+
+	_, err = ArchiveRepo(pr.Backend, pr, bob, "bob/renamed", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := webGet(t, mux, "/r/bob/renamed").Body.String()
+	if !strings.Contains(body, "archived") {
+		t.Fatal("expected the repo page to show the repo is archived")
+	}
+
+	if _, err := pr.CreateRepo(owner, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := webGet(t, mux, "/r/alice/test"); rec.Code != http.StatusOK {
+		t.Fatalf("expected a new repo to take over the old name, found: %d", rec.Code)
+	}
+}
+
+func TestDraftFilters(t *testing.T) {
+	pr, _, _ := setupTestRepo(t)
+	handler := NewWebMux(NewWebCtx(pr))
+	bob := createTestUser(t, pr, "bob")
+
+	prq, err := CreatePatchRequest(pr.Backend, pr, bob, "alice/test", true, strings.NewReader(singlePatch(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pr.UpdatePatchRequestName(prq.ID, bob.ID, "work in progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := webGet(t, handler, "/r/alice/test").Body.String()
+	if strings.Contains(body, "work in progress") {
+		t.Fatal("expected drafts to be hidden from the open filter")
+	}
+	if !strings.Contains(body, `draft</a> <code>1</code>`) || !strings.Contains(body, `open</a> <code>1</code>`) {
+		t.Fatal("expected drafts to be counted apart from open prs")
+	}
+	body = webGet(t, handler, "/r/alice/test?status=draft").Body.String()
+	if !strings.Contains(body, "work in progress") {
+		t.Fatal("expected draft filter to show drafts")
+	}
+
+	_, err = MarkPatchRequestReady(pr.Backend, pr, bob, prq.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = webGet(t, handler, "/r/alice/test").Body.String()
+	if !strings.Contains(body, "work in progress") {
+		t.Fatal("expected ready pr in the open filter")
+	}
+}
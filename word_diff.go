@@ -0,0 +1,214 @@
+package git
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+var (
+	wordRe      = regexp.MustCompile(`\w+|\s+|[^\w\s]`)
+	wordCharsRe = regexp.MustCompile(`\w`)
+)
+
+// WordSegment is a piece of a line that either changed or stayed the same
+// compared to the line it was paired with.
+type WordSegment struct {
+	Text    string
+	Changed bool
+}
+
+// wordsToRunes maps every distinct word to a rune so lines can be diffed one
+// word at a time, like diffmatchpatch does for lines.
+func wordsToRunes(a, b string) ([]rune, []rune) {
+	index := map[string]rune{}
+	toRunes := func(text string) []rune {
+		words := wordRe.FindAllString(text, -1)
+		runes := make([]rune, len(words))
+		for i, word := range words {
+			r, ok := index[word]
+			if !ok {
+				r = rune(len(index))
+				index[word] = r
+			}
+			runes[i] = r
+		}
+		return runes
+	}
+	return toRunes(a), toRunes(b)
+}
+
+func appendSegment(segments []WordSegment, text string, changed bool) []WordSegment {
+	if text == "" {
+		return segments
+	}
+	last := len(segments) - 1
+	if last >= 0 && segments[last].Changed == changed {
+		segments[last].Text += text
+		return segments
+	}
+	return append(segments, WordSegment{Text: text, Changed: changed})
+}
+
+// wordDiff splits a pair of lines into the words that changed and the words
+// both have in common.  Lines without a word in common are not worth
+// highlighting so nil is returned instead.
+func wordDiff(a, b string) ([]WordSegment, []WordSegment) {
+	runesA, runesB := wordsToRunes(a, b)
+	wordsA := wordRe.FindAllString(a, -1)
+	wordsB := wordRe.FindAllString(b, -1)
+
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMainRunes(runesA, runesB, false)
+
+	segA := []WordSegment{}
+	segB := []WordSegment{}
+	common := false
+	idxA, idxB := 0, 0
+	for _, diff := range diffs {
+		size := len([]rune(diff.Text))
+		switch diff.Type {
+		case diffmatchpatch.DiffEqual:
+			text := strings.Join(wordsA[idxA:idxA+size], "")
+			if wordCharsRe.MatchString(text) {
+				common = true
+			}
+			segA = appendSegment(segA, text, false)
+			segB = appendSegment(segB, text, false)
+			idxA += size
+			idxB += size
+		case diffmatchpatch.DiffDelete:
+			segA = appendSegment(segA, strings.Join(wordsA[idxA:idxA+size], ""), true)
+			idxA += size
+		case diffmatchpatch.DiffInsert:
+			segB = appendSegment(segB, strings.Join(wordsB[idxB:idxB+size], ""), true)
+			idxB += size
+		}
+	}
+
+	if !common {
+		return nil, nil
+	}
+	return segA, segB
+}
+
+// pairChangedLines pairs every deleted line with the added line in the same
+// position when a run of deleted lines is directly followed by a run of
+// added lines of the same length, like git's diff-highlight.
+func pairChangedLines(kinds []byte) [][2]int {
+	pairs := [][2]int{}
+	for i := 0; i < len(kinds); {
+		if kinds[i] != '-' {
+			i += 1
+			continue
+		}
+		dels := i
+		for i < len(kinds) && kinds[i] == '-' {
+			i += 1
+		}
+		adds := i
+		for i < len(kinds) && kinds[i] == '+' {
+			i += 1
+		}
+		if adds-dels != i-adds {
+			continue
+		}
+		for j := 0; j < adds-dels; j += 1 {
+			pairs = append(pairs, [2]int{dels + j, adds + j})
+		}
+	}
+	return pairs
+}
+
+// wordSegmentsHTML escapes the segments and wraps the changed ones so they
+// can be styled.
+func wordSegmentsHTML(segments []WordSegment) string {
+	var out strings.Builder
+	for _, seg := range segments {
+		text := html.EscapeString(seg.Text)
+		if seg.Changed {
+			_, _ = out.WriteString(`<span class="gs word-diff">` + text + `</span>`)
+		} else {
+			_, _ = out.WriteString(text)
+		}
+	}
+	return out.String()
+}
+
+// WordsHTML renders the words of a range-diff line with the changed words
+// highlighted.
+func (d RangeDiffDiff) WordsHTML() template.HTML {
+	return template.HTML(wordSegmentsHTML(d.Words))
+}
+
+// addRangeDiffWords computes word segments for lines that changed between
+// two versions of a patch.
+func addRangeDiffWords(lines []RangeDiffDiff) {
+	kinds := make([]byte, len(lines))
+	for i, line := range lines {
+		switch line.OuterType {
+		case "delete":
+			kinds[i] = '-'
+		case "insert":
+			kinds[i] = '+'
+		}
+	}
+	for _, pair := range pairChangedLines(kinds) {
+		a, b := &lines[pair[0]], &lines[pair[1]]
+		a.Words, b.Words = wordDiff(a.Text, b.Text)
+	}
+}
+
+// highlightWordDiff adds word level highlights to a diff formatted by chroma.
+// The diff lexer renders every -/+ line as a single token so the escaped line
+// is found inside of its `gd` or `gi` span and replaced with the segments.
+func highlightWordDiff(formatted, text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	kinds := make([]byte, len(lines))
+	inHunk := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, "@@") {
+			inHunk = true
+		} else if strings.HasPrefix(line, "diff ") {
+			inHunk = false
+		}
+		if inHunk && line != "" {
+			kinds[i] = line[0]
+		}
+	}
+
+	var out strings.Builder
+	pos := 0
+	for _, pair := range pairChangedLines(kinds) {
+		a, b := lines[pair[0]], lines[pair[1]]
+		segA, segB := wordDiff(a[1:], b[1:])
+		if segA == nil {
+			continue
+		}
+		for _, line := range []struct {
+			text     string
+			segments []WordSegment
+			class    string
+		}{
+			{a, segA, "gd"},
+			{b, segB, "gi"},
+		} {
+			open := `<span class="` + line.class + `">`
+			find := open + html.EscapeString(line.text) + "</span>"
+			idx := strings.Index(formatted[pos:], find)
+			if idx < 0 {
+				continue
+			}
+			_, _ = out.WriteString(formatted[pos : pos+idx])
+			_, _ = out.WriteString(open + html.EscapeString(line.text[:1]))
+			_, _ = out.WriteString(wordSegmentsHTML(line.segments))
+			_, _ = out.WriteString("</span>")
+			pos += idx + len(find)
+		}
+	}
+	_, _ = out.WriteString(formatted[pos:])
+	return out.String()
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	formatterHtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+func TestWordDiff(t *testing.T) {
+	segA, segB := wordDiff("return a + b\n", "return a - b\n")
+	expectedA := []WordSegment{{"return a ", false}, {"+", true}, {" b\n", false}}
+	expectedB := []WordSegment{{"return a ", false}, {"-", true}, {" b\n", false}}
+	if !reflect.DeepEqual(segA, expectedA) {
+		t.Fatalf("unexpected old segments: %+v", segA)
+	}
+	if !reflect.DeepEqual(segB, expectedB) {
+		t.Fatalf("unexpected new segments: %+v", segB)
+	}
+
+	segA, segB = wordDiff("# test\n", "# Let's build an RNN\n")
+	if segA != nil || segB != nil {
+		t.Fatal("expected lines without a word in common to not be highlighted")
+	}
+}
+
+func TestPairChangedLines(t *testing.T) {
+	actual := pairChangedLines([]byte(" --++ -+- -++"))
+	expected := [][2]int{{1, 3}, {2, 4}, {6, 7}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("unexpected pairs: %v", actual)
+	}
+}
+
+func TestHighlightWordDiff(t *testing.T) {
+	text := `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -1,3 +1,3 @@
+ func add(a, b int) int {
+-	return a + b
++	return a - b
+ }
+`
+	formatter := formatterHtml.New(
+		formatterHtml.WithLineNumbers(true),
+		formatterHtml.LineNumbersInTable(true),
+		formatterHtml.WithClasses(true),
+	)
+	theme := styles.Get("dracula")
+
+	actual, err := parseText(formatter, theme, text, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(actual, `<span class="gd">-	return a <span class="gs word-diff">+</span> b`) {
+		t.Fatalf("expected deleted word to be highlighted:\n%s", actual)
+	}
+	if !strings.Contains(actual, `<span class="gi">+	return a <span class="gs word-diff">-</span> b`) {
+		t.Fatalf("expected inserted word to be highlighted:\n%s", actual)
+	}
+	if strings.Count(actual, "word-diff") != 2 {
+		t.Fatal("expected file headers to not be highlighted")
+	}
+
+	actual, err = parseText(formatter, theme, text, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(actual, "word-diff") {
+		t.Fatal("expected word diff to be disabled")
+	}
+}
+
+func TestRangeDiffWords(t *testing.T) {
+	lines := DoDiff("+	return a + b\n", "+	return a - b\n")
+	highlighted := 0
+	for _, line := range lines {
+		if len(line.Words) > 0 {
+			highlighted++
+		}
+	}
+	if highlighted != 2 {
+		t.Fatalf("expected both changed lines to have words, found: %+v", lines)
+	}
+	if !strings.Contains(string(lines[0].WordsHTML()), `<span class="gs word-diff">+</span>`) {
+		t.Fatalf("unexpected html: %s", lines[0].WordsHTML())
+	}
+}